@@ -0,0 +1,101 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// maxHeaderLen is the longest packet header written by
+// packet.OpaquePacket.Serialize: a tag byte and a five-octet length.
+const maxHeaderLen = 6
+
+// packetArena is a per-key buffer into which all packets of a keyring are
+// serialized, so that the packet bytes of a parsed key share a single
+// allocation rather than one (or several) per packet.
+//
+// Slices handed out by the arena are capped at their own length, so an append
+// to one of them copies rather than overwriting a neighbouring packet.
+type packetArena struct {
+	buf   []byte
+	start int
+}
+
+// newPacketArena returns an arena large enough to hold all the given packets
+// without growing.
+func newPacketArena(ops []*packet.OpaquePacket) *packetArena {
+	var size int
+	for _, op := range ops {
+		size += maxHeaderLen + len(op.Contents)
+	}
+	return &packetArena{buf: make([]byte, 0, size)}
+}
+
+// Write implements io.Writer. If the arena is full, the packet currently being
+// written is moved to a new buffer; slices already handed out keep referring
+// to the old one.
+func (a *packetArena) Write(p []byte) (int, error) {
+	if len(a.buf)+len(p) > cap(a.buf) {
+		pending := a.buf[a.start:]
+		buf := make([]byte, len(pending), 2*(len(pending)+len(p)))
+		copy(buf, pending)
+		a.buf, a.start = buf, 0
+	}
+	a.buf = append(a.buf, p...)
+	return len(p), nil
+}
+
+// serialize writes the packet into the arena and returns its framed bytes.
+// A nil arena allocates a separate buffer for the packet.
+func (a *packetArena) serialize(op *packet.OpaquePacket) ([]byte, error) {
+	if a == nil {
+		var buf bytes.Buffer
+		if err := op.Serialize(&buf); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return buf.Bytes(), nil
+	}
+	a.start = len(a.buf)
+	if err := op.Serialize(a); err != nil {
+		a.buf = a.buf[:a.start]
+		return nil, errgo.Mask(err)
+	}
+	end := len(a.buf)
+	return a.buf[a.start:end:end], nil
+}
+
+// Detach copies the packet contents out of the buffer shared with the rest of
+// the key it was read with, so that retaining this packet does not keep the
+// whole key's packet data alive.
+func (p *Packet) Detach() {
+	if !p.shared {
+		return
+	}
+	p.Packet = append([]byte(nil), p.Packet...)
+	p.shared = false
+}
+
+// Detach copies all packets of the key out of their shared parse buffer.
+func (pubkey *PrimaryKey) Detach() {
+	for _, node := range pubkey.contents() {
+		node.packet().Detach()
+	}
+}
@@ -0,0 +1,172 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// KeyBundle is a key together with a keyserver's attestation of it: a
+// detached signature by the keyserver's operational key over the key's
+// canonical digest at a point in time. Mirrors and clients can use it to prove
+// what a keyserver served, and when.
+type KeyBundle struct {
+	Key *PrimaryKey
+
+	// Timestamp is the time of the attestation, which is also the creation
+	// time of its signature.
+	Timestamp time.Time
+
+	// Signature is the serialized detached signature packet.
+	Signature []byte
+}
+
+// attestation returns the statement signed for a key bundle.
+func attestation(key *PrimaryKey, t time.Time) ([]byte, error) {
+	digest, err := SksDigest(key, sha256.New())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return []byte(fmt.Sprintf("openpgp key attestation v1\nfingerprint: %s\nsha256: %s\ntime: %d\n",
+		key.Fingerprint(), digest, t.Unix())), nil
+}
+
+// NewKeyBundle attests to the key, as it is at time t, with the signer's
+// private key.
+func NewKeyBundle(key *PrimaryKey, signer *openpgp.Entity, t time.Time) (*KeyBundle, error) {
+	t = time.Unix(t.Unix(), 0)
+	msg, err := attestation(key, t)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var buf bytes.Buffer
+	err = openpgp.DetachSign(&buf, signer, bytes.NewReader(msg), &packet.Config{
+		Time: func() time.Time { return t },
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &KeyBundle{Key: key, Timestamp: t, Signature: buf.Bytes()}, nil
+}
+
+// Verify checks the attestation against the key as it is now, returning the
+// entity in keyring which signed it.
+func (b *KeyBundle) Verify(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	p, err := packet.Read(bytes.NewReader(b.Signature))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, errgo.Newf("expected signature packet, got %T", p)
+	}
+	if !sig.CreationTime.Equal(b.Timestamp) {
+		return nil, errgo.Newf("signature created at %v, not attestation time %v",
+			sig.CreationTime, b.Timestamp)
+	}
+	msg, err := attestation(b.Key, b.Timestamp)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(msg), bytes.NewReader(b.Signature))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return signer, nil
+}
+
+var sigArmorBegin = "-----BEGIN " + openpgp.SignatureType + "-----"
+
+// WriteKeyBundle writes a key bundle as an armored public key block followed
+// by an armored signature block.
+func WriteKeyBundle(w io.Writer, b *KeyBundle) error {
+	err := WriteArmoredPackets(w, []*PrimaryKey{b.Key})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	_, err = io.WriteString(w, "\n")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	armw, err := armor.Encode(w, openpgp.SignatureType, nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	_, err = armw.Write(b.Signature)
+	if err != nil {
+		armw.Close()
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(armw.Close())
+}
+
+// ReadKeyBundle reads a key bundle written by WriteKeyBundle. The bundle must
+// be verified before it is relied upon.
+func ReadKeyBundle(r io.Reader) (*KeyBundle, error) {
+	text, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	keys, err := ReadKeyText(string(text))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrNoKeyText))
+	}
+	if len(keys) != 1 {
+		return nil, errgo.Newf("expected one key in bundle, found %d", len(keys))
+	}
+	if keys[0].Error != nil {
+		return nil, errgo.Mask(keys[0].Error, errgo.Any)
+	}
+
+	start := strings.Index(string(text), sigArmorBegin)
+	if start < 0 {
+		return nil, errgo.New("bundle has no signature")
+	}
+	block, err := armor.Decode(bytes.NewReader(text[start:]))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sigData, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	p, err := packet.Read(bytes.NewReader(sigData))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, errgo.Newf("expected signature packet, got %T", p)
+	}
+	return &KeyBundle{
+		Key:       keys[0].PrimaryKey,
+		Timestamp: sig.CreationTime,
+		Signature: sigData,
+	}, nil
+}
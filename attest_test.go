@@ -0,0 +1,59 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	gc "gopkg.in/check.v1"
+)
+
+type AttestSuite struct{}
+
+var _ = gc.Suite(&AttestSuite{})
+
+func (s *AttestSuite) TestKeyBundle(c *gc.C) {
+	server := newTestEntity(c, "Keyserver")
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	bundle, err := NewKeyBundle(key, server, t)
+	c.Assert(err, gc.IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(WriteKeyBundle(&buf, bundle), gc.IsNil)
+	read, err := ReadKeyBundle(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(read.Timestamp.Equal(t), gc.Equals, true)
+	c.Assert(read.Key.MD5, gc.Equals, key.MD5)
+	signer, err := read.Verify(openpgp.EntityList{server})
+	c.Assert(err, gc.IsNil)
+	c.Assert(signer.PrimaryKey.KeyId, gc.Equals, server.PrimaryKey.KeyId)
+
+	// Any change to the key invalidates the attestation.
+	read.Key.UserIDs = nil
+	_, err = read.Verify(openpgp.EntityList{server})
+	c.Assert(err, gc.NotNil)
+
+	read.Timestamp = t.Add(time.Second)
+	_, err = bundle.Verify(openpgp.EntityList{server})
+	c.Assert(err, gc.IsNil)
+	_, err = read.Verify(openpgp.EntityList{server})
+	c.Assert(err, gc.NotNil)
+}
@@ -0,0 +1,181 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+	"gopkg.in/errgo.v1"
+)
+
+// lenientCritical holds the types of subpackets which this package
+// understands but the packet library does not, and so rejects when marked
+// critical.
+var lenientCritical = map[byte]bool{
+	37: true, // attested certifications
+}
+
+// parseSignaturePacket parses a signature packet as op.Parse, but accepts
+// critical subpackets of the types in lenientCritical. The packet library
+// rejects the signatures bearing them, so a copy of the packet with the
+// subpackets unmarked is parsed in their place; the hashed data of the parsed
+// signature is then restored from the original, against which it is
+// verified.
+func parseSignaturePacket(op *packet.OpaquePacket) (packet.Packet, error) {
+	if len(op.Contents) == 0 || op.Contents[0] != 4 {
+		return op.Parse()
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return op.Parse()
+	}
+	hashedLen := 6 + len(areas[0])
+	contents := append([]byte(nil), op.Contents...)
+	var changed bool
+	for area := contents[6:hashedLen]; len(area) > 0; {
+		n, hdr, err := subpacketLen(area)
+		if err != nil {
+			break
+		}
+		if typ := area[hdr]; typ&0x80 != 0 && lenientCritical[typ&0x7f] {
+			area[hdr] = typ & 0x7f
+			changed = true
+		}
+		area = area[hdr+n:]
+	}
+	if !changed {
+		return op.Parse()
+	}
+	p, err := (&packet.OpaquePacket{Tag: op.Tag, Reason: op.Reason, Contents: contents}).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := p.(*packet.Signature); ok && len(s.HashSuffix) == hashedLen+6 {
+		copy(s.HashSuffix, op.Contents[:hashedLen])
+	}
+	return p, nil
+}
+
+// IsAttestation returns whether the signature is an attestation key
+// signature, by which the owner of a key attests the third-party
+// certifications of one of its user IDs or user attributes that may be
+// distributed with it.
+func (sig *Signature) IsAttestation() bool {
+	return sig.SigType == 0x16
+}
+
+// AttestedDigests returns the digests listed by the attested certifications
+// subpackets of an attestation key signature, made with the hash algorithm of
+// the attestation.
+func (sig *Signature) AttestedDigests() [][]byte {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) || len(op.Contents) < 4 {
+		return nil
+	}
+	h, ok := s2k.HashIdToHash(op.Contents[3])
+	if !ok {
+		return nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil
+	}
+	var result [][]byte
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ != 37 || len(data)%h.Size() != 0 { // attested certifications
+			return
+		}
+		for ; len(data) > 0; data = data[h.Size():] {
+			result = append(result, append([]byte(nil), data[:h.Size()]...))
+		}
+	})
+	return result
+}
+
+// AttestationDigest returns the digest of the signature by which an
+// attestation lists it, made with the given hash function: that of the
+// signature packet as hashed by a third-party confirmation signature, without
+// its unhashed subpackets.
+func (sig *Signature) AttestationDigest(hashFunc crypto.Hash) ([]byte, error) {
+	if !hashFunc.Available() {
+		return nil, errgo.Newf("hash function %v unavailable", hashFunc)
+	}
+	op, err := sig.opaquePacket()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !hasSubpacketAreas(op.Contents) {
+		return nil, errgo.Newf("cannot attest version %d signature", op.Contents[0])
+	}
+	areas, rest, err := splitSignature(op.Contents)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var body bytes.Buffer
+	body.Write(op.Contents[:4])
+	lenSize := 2
+	if op.Contents[0] == 6 {
+		lenSize = 4
+	}
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(areas[0])))
+	body.Write(n[4-lenSize:])
+	body.Write(areas[0])
+	body.Write(make([]byte, lenSize))
+	body.Write(rest)
+
+	h := hashFunc.New()
+	binary.BigEndian.PutUint32(n[:], uint32(body.Len()))
+	h.Write([]byte{0x88})
+	h.Write(n[:])
+	h.Write(body.Bytes())
+	return h.Sum(nil), nil
+}
+
+// Attests returns whether the most recent valid attestation among the
+// self-signatures of a user ID or user attribute attests sig. Each
+// attestation replaces those before it, so that a key owner may withdraw an
+// attestation by making another without it.
+func (s *SelfSigs) Attests(sig *Signature) bool {
+	if len(s.Attestations) == 0 {
+		return false
+	}
+	attestation := s.Attestations[0].Signature
+	op, err := attestation.opaquePacket()
+	if err != nil || len(op.Contents) < 4 {
+		return false
+	}
+	h, ok := s2k.HashIdToHash(op.Contents[3])
+	if !ok {
+		return false
+	}
+	digest, err := sig.AttestationDigest(h)
+	if err != nil {
+		return false
+	}
+	for _, attested := range attestation.AttestedDigests() {
+		if bytes.Equal(attested, digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Backend verifies signatures on behalf of this package. Each method is given
+// the packets concerned as they were read, and returns nil if the signature
+// is valid. Packets are parsed, stored and digested by this package itself,
+// so UUIDs and key digests are the same whichever backend verifies them.
+//
+// The built-in backend uses golang.org/x/crypto/openpgp, which is frozen and
+// will not gain newer algorithms or key versions. Another, built on a
+// maintained OpenPGP implementation, may be installed with SetBackend.
+type Backend interface {
+	// VerifyKeySignature verifies a signature made by the primary key over
+	// itself alone: a direct-key signature or key revocation.
+	VerifyKeySignature(pk, sig *packet.OpaquePacket) error
+
+	// VerifyBindingSignature verifies a signature made by the primary key
+	// over itself and another public key packet: a sub-key binding or
+	// revocation.
+	VerifyBindingSignature(pk, signed, sig *packet.OpaquePacket) error
+
+	// VerifyUserIDSignature and VerifyUserAttributeSignature verify a
+	// certification or revocation made by the primary key over itself and a
+	// user ID or user attribute.
+	VerifyUserIDSignature(pk, uid, sig *packet.OpaquePacket) error
+	VerifyUserAttributeSignature(pk, uat, sig *packet.OpaquePacket) error
+
+	// VerifyUserIDCertification and VerifyUserAttributeCertification verify
+	// a certification or revocation made by the primary key issuer over
+	// another primary key, pk, and one of its user IDs or user attributes.
+	VerifyUserIDCertification(issuer, pk, uid, sig *packet.OpaquePacket) error
+	VerifyUserAttributeCertification(issuer, pk, uat, sig *packet.OpaquePacket) error
+
+	// VerifyStandaloneSignature verifies a standalone signature made by the
+	// primary key.
+	VerifyStandaloneSignature(pk, sig *packet.OpaquePacket) error
+}
+
+var backend Backend
+
+// SetBackend sets the backend by which signatures are verified, or restores
+// the built-in backend if b is nil. Like SetMetrics, it should be called
+// during initialization, before any keys are checked. A cache set with
+// SetVerifyCache is not cleared, so outcomes found by one backend would be
+// kept by the next.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// opaquePackets returns the opaque packets of the given nodes, for a Backend.
+func opaquePackets(nodes ...interface {
+	opaquePacket() (*packet.OpaquePacket, error)
+}) ([]*packet.OpaquePacket, error) {
+	result := make([]*packet.OpaquePacket, len(nodes))
+	for i, node := range nodes {
+		op, err := node.opaquePacket()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = op
+	}
+	return result, nil
+}
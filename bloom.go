@@ -0,0 +1,121 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+)
+
+// bloomFilter is a probabilistic set of digests. It never reports a digest
+// added to it as absent, but may report one not added as present.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+// newBloomFilter returns a filter sized to hold n digests with the given
+// probability of false positives.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.001
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Ceil(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// add adds the hex digest to the filter, returning whether it may have been
+// added already.
+func (f *bloomFilter) add(digest string) bool {
+	b, err := hex.DecodeString(digest)
+	if err != nil || len(b) < 16 {
+		// Not a digest; hash it instead.
+		b = []byte(digest)
+		h1, h2 := fnv64(b, 14695981039346656037), fnv64(b, 1099511628211)
+		return f.addHashes(h1, h2)
+	}
+	return f.addHashes(binary.BigEndian.Uint64(b), binary.BigEndian.Uint64(b[8:]))
+}
+
+// addHashes sets the bits given by double hashing of h1 and h2.
+func (f *bloomFilter) addHashes(h1, h2 uint64) bool {
+	h1 = mix64(h1 ^ mix64(h2))
+	h2 = mix64(h2+h1) | 1
+	present := true
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint64(i)*h2) % f.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			present = false
+			f.bits[word] |= mask
+		}
+	}
+	return present
+}
+
+// mix64 is the finalizer of SplitMix64, so that digests which are not
+// uniformly distributed still set well-spread bits.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+func fnv64(b []byte, h uint64) uint64 {
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// DropSeenKeys passes on the keys received from c, except those with the same
+// MD5 digest as one already passed on, as when several dumps containing the
+// same keys are concatenated. Seen digests are held in a probabilistic set
+// sized for n keys, so memory use is bounded, but with probability p a key
+// not seen before is dropped as well. Keys without a digest, such as partial
+// keys, and read errors are always passed on.
+func DropSeenKeys(c PrimaryKeyChan, n int, p float64) PrimaryKeyChan {
+	out := make(PrimaryKeyChan)
+	seen := newBloomFilter(n, p)
+	go func() {
+		defer close(out)
+		for readKey := range c {
+			if readKey.PrimaryKey != nil && readKey.MD5 != "" && seen.add(readKey.MD5) {
+				continue
+			}
+			out <- readKey
+		}
+	}()
+	return out
+}
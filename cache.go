@@ -0,0 +1,154 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// KeyCache is a least-recently-used cache of parsed and de-duplicated keys,
+// indexed by digest. Resubmissions of an unchanged key can be recognized from
+// the digest of their packets, and so skip parsing and merging entirely.
+//
+// Keys stored in or returned by a KeyCache are shared, and must not be
+// modified. A KeyCache is safe for concurrent use.
+type KeyCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type keyCacheEntry struct {
+	key     *PrimaryKey
+	digests []string
+}
+
+// NewKeyCache returns a new KeyCache holding at most size keys.
+func NewKeyCache(size int) *KeyCache {
+	return &KeyCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Len returns the number of keys in the cache.
+func (c *KeyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Get returns the cached key with the given MD5 or SHA256 digest, if any.
+func (c *KeyCache) Get(digest string) (*PrimaryKey, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[digest]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*keyCacheEntry).key, true
+}
+
+// Add adds a key to the cache, indexed by its MD5 and SHA256 digests, and by
+// any additional digests given, such as that of the key as submitted prior to
+// de-duplication.
+func (c *KeyCache) Add(key *PrimaryKey, digests ...string) {
+	if key.MD5 != "" {
+		digests = append(digests, key.MD5)
+	}
+	if key.SHA256 != "" {
+		digests = append(digests, key.SHA256)
+	}
+	if len(digests) == 0 || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, digest := range digests {
+		if el, ok := c.entries[digest]; ok {
+			c.remove(el)
+		}
+	}
+	el := c.lru.PushFront(&keyCacheEntry{key: key, digests: digests})
+	for _, digest := range digests {
+		c.entries[digest] = el
+	}
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *KeyCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*keyCacheEntry)
+	for _, digest := range entry.digests {
+		if c.entries[digest] == el {
+			delete(c.entries, digest)
+		}
+	}
+}
+
+// Parse returns the cached key matching the SHA-256 digest of the opaque
+// keyring, as read, if there is one. Otherwise the keyring is parsed, its
+// duplicate packets are dropped, its signatures are expanded, and the result
+// is added to the cache, indexed by that digest too. The keyring is not
+// trusted, so it is not indexed by its MD5 digest, with which another key's
+// could be forged.
+// Keyrings read with IndexOnly or SkipTags are refused with an error caused by
+// ErrPartialKeyring.
+func (c *KeyCache) Parse(okr *OpaqueKeyring) (*PrimaryKey, error) {
+	if okr.partial {
+		return nil, errgo.WithCausef(nil, ErrPartialKeyring, "cannot cache partial keyring")
+	}
+	h := sha256.New()
+	for _, op := range okr.Packets {
+		err := op.Serialize(h)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	if key, ok := c.Get(digest); ok {
+		return key, nil
+	}
+
+	key, err := okr.Parse()
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	err = DropDuplicates(key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	// Expand the signatures before the key is shared, so that reading
+	// them does not modify it.
+	err = expandSignatures(key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	c.Add(key, digest)
+	return key, nil
+}
@@ -0,0 +1,160 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"golang.org/x/crypto/openpgp/armor"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"github.com/schmorrison/testing"
+)
+
+type CacheSuite struct{}
+
+var _ = gc.Suite(&CacheSuite{})
+
+func (s *CacheSuite) TestEviction(c *gc.C) {
+	cache := NewKeyCache(2)
+	k1 := &PrimaryKey{MD5: "1"}
+	k2 := &PrimaryKey{MD5: "2", SHA256: "two"}
+	k3 := &PrimaryKey{MD5: "3"}
+	cache.Add(k1)
+	cache.Add(k2, "submitted")
+	for _, digest := range []string{"2", "two", "submitted"} {
+		key, ok := cache.Get(digest)
+		c.Assert(ok, gc.Equals, true)
+		c.Assert(key, gc.Equals, k2)
+	}
+
+	// k1 is now least recently used.
+	cache.Add(k3)
+	c.Assert(cache.Len(), gc.Equals, 2)
+	_, ok := cache.Get("1")
+	c.Assert(ok, gc.Equals, false)
+	_, ok = cache.Get("3")
+	c.Assert(ok, gc.Equals, true)
+
+	// Re-adding a key under the same digest replaces it.
+	k3b := &PrimaryKey{MD5: "3"}
+	cache.Add(k3b)
+	c.Assert(cache.Len(), gc.Equals, 2)
+	key, _ := cache.Get("3")
+	c.Assert(key, gc.Equals, k3b)
+}
+
+func (s *CacheSuite) TestParse(c *gc.C) {
+	f := testing.MustInput("sksdigest.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	var okr *OpaqueKeyring
+	for opkr := range ReadOpaqueKeyrings(block.Body) {
+		okr = opkr
+	}
+	cache := NewKeyCache(10)
+	key1, err := cache.Parse(okr)
+	c.Assert(err, gc.IsNil)
+	key2, err := cache.Parse(okr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(key2, gc.Equals, key1)
+	c.Assert(cache.Len(), gc.Equals, 1)
+
+	// The keyring is indexed by the SHA-256 digest of its packets as read.
+	h := sha256.New()
+	for _, op := range okr.Packets {
+		c.Assert(op.Serialize(h), gc.IsNil)
+	}
+	key, ok := cache.Get(hex.EncodeToString(h.Sum(nil)))
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(key, gc.Equals, key1)
+}
+
+func (s *CacheSuite) TestParseShared(c *gc.C) {
+	f := testing.MustInput("sksdigest.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	var okr *OpaqueKeyring
+	for opkr := range ReadOpaqueKeyrings(block.Body) {
+		okr = opkr
+	}
+	// Run with -race: the cached key is shared among the goroutines.
+	cache := NewKeyCache(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := cache.Parse(okr)
+			c.Check(err, gc.IsNil)
+			if err != nil {
+				return
+			}
+			for _, uid := range key.UserIDs {
+				uid.SelfSigs(key)
+			}
+			for _, sig := range key.Signatures {
+				c.Check(sig.Creation.IsZero(), gc.Equals, false)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(cache.Len(), gc.Equals, 1)
+}
+
+func (s *CacheSuite) TestVerifyCache(c *gc.C) {
+	cache := NewVerifyCache(3)
+	SetVerifyCache(cache)
+	defer SetVerifyCache(nil)
+
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	uid, subkey := key.UserIDs[0], key.SubKeys[0]
+	c.Assert(uid.SelfSigs(key).Certifications, gc.HasLen, 1)
+	c.Assert(subkey.SelfSigs(key).Certifications, gc.HasLen, 1)
+	c.Assert(cache.Len(), gc.Equals, 2)
+
+	// Outcomes are taken from the cache rather than verified again.
+	sig := uid.Signatures[0]
+	cached := packetDigest(sig.Packet.Packet) + "_" + key.RFingerprint + "_" + uid.UUID
+	cache.add(cached, errgo.New("cached failure"))
+	ss := uid.SelfSigs(key)
+	c.Assert(ss.Certifications, gc.HasLen, 0)
+	c.Assert(ss.Errors, gc.HasLen, 1)
+	c.Assert(ss.Errors[0].Error, gc.ErrorMatches, ".*cached failure")
+	c.Assert(cache.Len(), gc.Equals, 2)
+
+	// The same signature over another user ID is verified separately.
+	other := *uid
+	other.UUID = "other"
+	c.Assert(other.SelfSigs(key).Certifications, gc.HasLen, 1)
+	c.Assert(cache.Len(), gc.Equals, 3)
+
+	// The least recently used outcome, for the subkey, is evicted.
+	other.UUID = "another"
+	c.Assert(other.SelfSigs(key).Certifications, gc.HasLen, 1)
+	c.Assert(cache.Len(), gc.Equals, 3)
+	_, ok := cache.get(cached)
+	c.Assert(ok, gc.Equals, true)
+	_, ok = cache.get(packetDigest(subkey.Signatures[0].Packet.Packet) + "_" + key.RFingerprint + "_" + subkey.UUID)
+	c.Assert(ok, gc.Equals, false)
+}
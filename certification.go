@@ -0,0 +1,125 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import "strings"
+
+// controllingSignature returns the most recent certification or certification
+// revocation among sigs issued by the given key, which is identified by its
+// hex-encoded key ID or fingerprint. If verify is not nil, signatures for
+// which it fails are disregarded.
+func controllingSignature(sigs []*Signature, issuer string, verify func(*Signature) error) *Signature {
+	issuer = strings.ToLower(issuer)
+	var result *Signature
+	for _, sig := range sigs {
+		if sig.RIssuerKeyID == "" || !strings.HasSuffix(issuer, sig.IssuerKeyID()) {
+			continue
+		}
+		_, isCert := sig.CertificationClass()
+		isRevocation := sig.SigType == 0x30 // packet.SigTypeCertRevocation
+		if !isCert && !isRevocation {
+			continue
+		}
+		if result != nil {
+			creation, prev := sig.Creation.Unix(), result.Creation.Unix()
+			if creation < prev || (creation == prev && (!isRevocation || result.SigType == 0x30)) {
+				continue
+			}
+		}
+		if verify != nil && verify(sig) != nil {
+			continue
+		}
+		result = sig
+	}
+	return result
+}
+
+// ControllingSignature returns the signature claiming to be by issuer which
+// determines whether it certifies the user ID: the most recent of its
+// certifications and certification revocations, each of which supersedes
+// those made before it. A revocation takes precedence over a certification
+// made at the same time. The issuer is given by its hex-encoded key ID or
+// fingerprint.
+//
+// The result is nil if the user ID bears no certification by issuer, and is a
+// revocation if issuer has withdrawn its certification. Signatures are
+// attributed by the issuer they claim, and are not verified, so anyone may
+// add one which controls the result; VerifiedControllingSignature considers
+// only those made by the issuer's key.
+func (uid *UserID) ControllingSignature(issuer string) *Signature {
+	return controllingSignature(uid.Signatures, issuer, nil)
+}
+
+// ControllingSignature is as for UserID.ControllingSignature, for the
+// certifications of a user attribute, and is likewise unverified.
+func (uat *UserAttribute) ControllingSignature(issuer string) *Signature {
+	return controllingSignature(uat.Signatures, issuer, nil)
+}
+
+// VerifiedControllingSignature is as ControllingSignature, but considers only
+// the signatures which verify as made by the primary key of issuer over that
+// of pubkey, to which the user ID belongs. V3 signatures are not verified,
+// and so are not considered.
+func (uid *UserID) VerifiedControllingSignature(pubkey, issuer *PrimaryKey) *Signature {
+	return controllingSignature(uid.Signatures, issuer.Fingerprint(), func(sig *Signature) error {
+		return pubkey.verifyCertification(issuer, uid, sig)
+	})
+}
+
+// VerifiedControllingSignature is as for
+// UserID.VerifiedControllingSignature, for the certifications of a user
+// attribute.
+func (uat *UserAttribute) VerifiedControllingSignature(pubkey, issuer *PrimaryKey) *Signature {
+	return controllingSignature(uat.Signatures, issuer.Fingerprint(), func(sig *Signature) error {
+		return pubkey.verifyCertification(issuer, uat, sig)
+	})
+}
+
+// CertifiedBy returns whether issuer claims to certify the user ID; that is,
+// whether its controlling signature, which is not verified, is a
+// certification which has not expired.
+func (uid *UserID) CertifiedBy(issuer string) bool {
+	return certifiedBy(uid.ControllingSignature(issuer))
+}
+
+// CertifiedBy returns whether issuer claims to certify the user attribute.
+func (uat *UserAttribute) CertifiedBy(issuer string) bool {
+	return certifiedBy(uat.ControllingSignature(issuer))
+}
+
+// CertifiedByKey returns whether the primary key of issuer certifies the user
+// ID of pubkey, as CertifiedBy, by its verified controlling signature.
+func (uid *UserID) CertifiedByKey(pubkey, issuer *PrimaryKey) bool {
+	return certifiedBy(uid.VerifiedControllingSignature(pubkey, issuer))
+}
+
+// CertifiedByKey returns whether the primary key of issuer certifies the user
+// attribute of pubkey.
+func (uat *UserAttribute) CertifiedByKey(pubkey, issuer *PrimaryKey) bool {
+	return certifiedBy(uat.VerifiedControllingSignature(pubkey, issuer))
+}
+
+func certifiedBy(sig *Signature) bool {
+	if sig == nil || sig.SigType == 0x30 { // packet.SigTypeCertRevocation
+		return false
+	}
+	if err := sig.Expand(); err != nil {
+		return false
+	}
+	return sig.Expiration.IsZero() || sig.Expiration.Unix() > now().Unix()
+}
@@ -0,0 +1,222 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// KeyChange records a change to a stored key, as an entry in an append-only
+// log from which the history of the key database can be replayed.
+type KeyChange struct {
+	Fingerprint string
+
+	// OldDigest and NewDigest are the hex-encoded MD5 digests of the key
+	// before and after the change. OldDigest is empty for a new key.
+	OldDigest string
+	NewDigest string
+
+	// Added lists the hex-encoded SHA-256 digests of the packets added to
+	// the key, as serialized.
+	Added []string
+
+	Timestamp time.Time
+}
+
+// MergeChange merges src into dst, as Merge, and reports the change to dst.
+func MergeChange(dst, src *PrimaryKey) (*KeyChange, error) {
+	before := make(map[string]bool)
+	for _, node := range dst.contents() {
+		before[packetDigest(node.packet().Packet)] = true
+	}
+	change := &KeyChange{
+		Fingerprint: dst.Fingerprint(),
+		OldDigest:   dst.MD5,
+		Timestamp:   now(),
+	}
+	err := Merge(dst, src)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	change.NewDigest = dst.MD5
+	for _, node := range dst.contents() {
+		digest := packetDigest(node.packet().Packet)
+		if !before[digest] {
+			before[digest] = true
+			change.Added = append(change.Added, digest)
+		}
+	}
+	return change, nil
+}
+
+// keyChangeVersion is the version of the KeyChange encoding.
+const keyChangeVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding is a version
+// octet, the fingerprint and digests each as a length octet followed by their
+// binary value, the timestamp in seconds as a big-endian 64-bit integer, and
+// the number of added packets as a big-endian 32-bit integer followed by their
+// digests.
+func (c *KeyChange) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(keyChangeVersion)
+	for _, s := range []string{c.Fingerprint, c.OldDigest, c.NewDigest} {
+		err := writeHexField(&buf, s)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, c.Timestamp.Unix())
+	binary.Write(&buf, binary.BigEndian, uint32(len(c.Added)))
+	for _, digest := range c.Added {
+		err := writeHexField(&buf, digest)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHexField(buf *bytes.Buffer, s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(b) > 255 {
+		return errgo.Newf("field too long: %d octets", len(b))
+	}
+	buf.WriteByte(byte(len(b)))
+	buf.Write(b)
+	return nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (c *KeyChange) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if version != keyChangeVersion {
+		return errgo.Newf("unsupported key change version %d", version)
+	}
+	var fields [3]string
+	for i := range fields {
+		fields[i], err = readHexField(r)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	}
+	var timestamp int64
+	var n uint32
+	err = binary.Read(r, binary.BigEndian, &timestamp)
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &n)
+	}
+	if err != nil {
+		return errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if int64(n) > int64(r.Len()) {
+		return errgo.Newf("invalid number of added packets %d", n)
+	}
+	var added []string
+	for i := uint32(0); i < n; i++ {
+		digest, err := readHexField(r)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		added = append(added, digest)
+	}
+	if r.Len() > 0 {
+		return errgo.Newf("%d trailing octets in key change", r.Len())
+	}
+	*c = KeyChange{
+		Fingerprint: fields[0],
+		OldDigest:   fields[1],
+		NewDigest:   fields[2],
+		Added:       added,
+		Timestamp:   time.Unix(timestamp, 0),
+	}
+	return nil
+}
+
+func readHexField(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", noEOF(err)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return "", noEOF(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WriteKeyChange appends a change to a log, as its encoding prefixed with its
+// length as a big-endian 32-bit integer.
+func WriteKeyChange(w io.Writer, c *KeyChange) error {
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(data)))
+	_, err = w.Write(append(hdr[:], data...))
+	return errgo.Mask(err)
+}
+
+// ReadKeyChange reads the next change from a log written with
+// WriteKeyChange, failing if its encoding is longer than maxLen octets. It
+// returns io.EOF at the end of the log. Memory is allocated for the change as
+// it is read, not according to the length it claims.
+func ReadKeyChange(r io.Reader, maxLen int) (*KeyChange, error) {
+	var hdr [4]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[:]))
+	if n > int64(maxLen) {
+		return nil, errgo.Newf("key change of %d octets exceeds limit of %d", n, maxLen)
+	}
+	var buf bytes.Buffer
+	if n > maxPreallocLen {
+		buf.Grow(maxPreallocLen)
+	} else {
+		buf.Grow(int(n))
+	}
+	_, err = io.CopyN(&buf, r, n)
+	if err != nil {
+		return nil, errgo.Mask(noEOF(err), errgo.Any)
+	}
+	c := &KeyChange{}
+	err = c.UnmarshalBinary(buf.Bytes())
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return c, nil
+}
@@ -0,0 +1,140 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Checkpoint records how far a dump has been read, so that reading can be
+// resumed from that point with ReadCheckpointedKeyrings.
+type Checkpoint struct {
+	// Offset is the position in the dump following the last keyring read.
+	Offset int64
+
+	// Keyrings is the number of keyrings read so far.
+	Keyrings int
+
+	// Digest is a running SHA-256 digest of the packets of all keyrings
+	// read so far, in hex. A dump read in several resumed passes has the
+	// same final digest as one read in a single pass.
+	Digest string
+}
+
+// next returns the checkpoint following okr.
+func (cp Checkpoint) next(okr *OpaqueKeyring) Checkpoint {
+	h := sha256.New()
+	prev, _ := hex.DecodeString(cp.Digest)
+	h.Write(prev)
+	for _, op := range okr.Packets {
+		binary.Write(h, binary.BigEndian, int32(op.Tag))
+		binary.Write(h, binary.BigEndian, int32(len(op.Contents)))
+		h.Write(op.Contents)
+	}
+	cp.Keyrings++
+	cp.Digest = hex.EncodeToString(h.Sum(nil))
+	return cp
+}
+
+// CheckpointedKeyring is a keyring read by ReadCheckpointedKeyrings.
+type CheckpointedKeyring struct {
+	*OpaqueKeyring
+
+	// Checkpoint is set on some keyrings, and on the last, to the state of
+	// reading following this keyring. Callers should save it once the
+	// keyring has been processed, and resume from the saved checkpoint
+	// should processing be interrupted.
+	Checkpoint *Checkpoint
+}
+
+type CheckpointedKeyringChan chan *CheckpointedKeyring
+
+// ReadCheckpointedKeyrings reads keyrings from a dump in the same manner as
+// ReadOpaqueKeyrings, attaching a checkpoint about every n keyrings. If from
+// is not nil, r must be an io.Seeker, and reading resumes at the checkpoint.
+// Keyring positions are relative to the start of the dump, whether resumed or
+// not.
+func ReadCheckpointedKeyrings(r io.Reader, from *Checkpoint, n int, opts ...ReadOption) CheckpointedKeyringChan {
+	c := make(CheckpointedKeyringChan)
+	go func() {
+		defer close(c)
+		var cp Checkpoint
+		if from != nil {
+			cp = *from
+			s, ok := r.(io.Seeker)
+			if !ok {
+				c <- &CheckpointedKeyring{OpaqueKeyring: &OpaqueKeyring{
+					Error: errgo.New("cannot resume from checkpoint: input is not seekable"),
+				}}
+				return
+			}
+			_, err := s.Seek(cp.Offset, io.SeekStart)
+			if err != nil {
+				c <- &CheckpointedKeyring{OpaqueKeyring: &OpaqueKeyring{Error: errgo.Mask(err)}}
+				return
+			}
+		}
+		base := cp.Offset
+		// Positions of keyrings read from or are relative to base, as it
+		// is not seen to be a file.
+		or := &offsetReader{r: r}
+		var prev *OpaqueKeyring
+		var since int
+		send := func(end int64, last bool) {
+			cp = cp.next(prev)
+			since++
+			ckr := &CheckpointedKeyring{OpaqueKeyring: prev}
+			if end >= 0 && (since >= n || last) {
+				point := cp
+				point.Offset = end
+				ckr.Checkpoint = &point
+				since = 0
+			}
+			c <- ckr
+		}
+		for okr := range ReadOpaqueKeyrings(or, opts...) {
+			started := len(okr.Packets) > 0 && okr.Position >= 0 && okr.Error == nil &&
+				(okr.Packets[0].Tag == 6 || okr.Packets[0].Tag == 5)
+			if started {
+				okr.Position += base
+			}
+			if prev != nil {
+				if started {
+					send(okr.Position, false)
+				} else {
+					// The end of the previous keyring is not known.
+					send(-1, false)
+				}
+			}
+			if okr.Error != nil {
+				c <- &CheckpointedKeyring{OpaqueKeyring: okr}
+				return
+			}
+			prev = okr
+		}
+		if prev != nil {
+			send(base+or.n, true)
+		}
+	}()
+	return c
+}
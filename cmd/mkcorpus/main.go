@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/errgo.v1"
+
+	log "gopkg.in/schmorrison/logrus.v0"
+	"gopkg.in/schmorrison/openpgp.v1"
+)
+
+var (
+	outDir = flag.String("out", "corpus", "directory to write corpus files into")
+	count  = flag.Int("n", 100, "number of mutations of each key")
+	seed   = flag.Int64("seed", 1, "random seed")
+)
+
+// mkcorpus reads unarmored keys from standard input and writes them, along
+// with malformed variants of each, as a fuzzing corpus.
+func main() {
+	flag.Parse()
+	err := os.MkdirAll(*outDir, 0755)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var n int
+	for opkr := range openpgp.ReadOpaqueKeyrings(os.Stdin) {
+		err := writeCorpus(opkr, *seed+int64(n))
+		if err != nil {
+			log.Errorf("key#%d: %v", n, errgo.Details(err))
+		}
+		n++
+	}
+	log.Infof("keys=%d", n)
+}
+
+func writeCorpus(opkr *openpgp.OpaqueKeyring, seed int64) error {
+	if opkr.Error != nil {
+		return errgo.Mask(opkr.Error, errgo.Any)
+	}
+	var buf bytes.Buffer
+	for _, op := range opkr.Packets {
+		err := op.Serialize(&buf)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	corpus, err := openpgp.MalformedCorpus(buf.Bytes(), *count, seed)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	for _, data := range append(corpus, buf.Bytes()) {
+		sum := sha1.Sum(data)
+		path := filepath.Join(*outDir, hex.EncodeToString(sum[:]))
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"gopkg.in/errgo.v1"
+)
+
+// deltaBlockLen is the length of the blocks of the old data indexed when
+// encoding a delta. Matches shorter than twice this may be missed.
+const deltaBlockLen = 16
+
+const (
+	deltaVersion = 1
+	deltaCopy    = 0
+	deltaAdd     = 1
+)
+
+// EncodeDelta returns a delta from which newData can be reconstructed given
+// oldData. It is compact when newData mostly consists of runs copied from
+// oldData, as when packets are added to a large key.
+//
+// The delta starts with a version octet, the SHA-256 digest of oldData and the
+// length of newData as an unsigned varint. A sequence of instructions
+// follows, each either a copy from oldData, an octet of zero followed by the
+// offset and length as unsigned varints, or literal data, an octet of one
+// followed by the length as an unsigned varint and the data.
+func EncodeDelta(oldData, newData []byte) []byte {
+	index := make(map[string]int)
+	for i := 0; i+deltaBlockLen <= len(oldData); i += deltaBlockLen {
+		block := string(oldData[i : i+deltaBlockLen])
+		if _, ok := index[block]; !ok {
+			index[block] = i
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(deltaVersion)
+	digest := sha256.Sum256(oldData)
+	buf.Write(digest[:])
+	writeUvarint(&buf, uint64(len(newData)))
+
+	var literal int // start of data not yet written
+	for i := 0; i+deltaBlockLen <= len(newData); {
+		j, ok := index[string(newData[i:i+deltaBlockLen])]
+		if !ok {
+			i++
+			continue
+		}
+		// Extend the match backwards into pending literal data, and
+		// forwards as far as it goes.
+		start, oldStart := i, j
+		for start > literal && oldStart > 0 && newData[start-1] == oldData[oldStart-1] {
+			start--
+			oldStart--
+		}
+		end, oldEnd := i+deltaBlockLen, j+deltaBlockLen
+		for end < len(newData) && oldEnd < len(oldData) && newData[end] == oldData[oldEnd] {
+			end++
+			oldEnd++
+		}
+		if start > literal {
+			writeDeltaAdd(&buf, newData[literal:start])
+		}
+		buf.WriteByte(deltaCopy)
+		writeUvarint(&buf, uint64(oldStart))
+		writeUvarint(&buf, uint64(end-start))
+		i, literal = end, end
+	}
+	if literal < len(newData) {
+		writeDeltaAdd(&buf, newData[literal:])
+	}
+	return buf.Bytes()
+}
+
+func writeUvarint(buf *bytes.Buffer, n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func writeDeltaAdd(buf *bytes.Buffer, data []byte) {
+	buf.WriteByte(deltaAdd)
+	writeUvarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// DecodeDelta reconstructs the data from which a delta was encoded against
+// oldData. It fails if oldData is not what the delta was encoded against, or
+// if the data would be longer than maxLen octets.
+func DecodeDelta(oldData, delta []byte, maxLen int) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if version != deltaVersion {
+		return nil, errgo.Newf("unsupported delta version %d", version)
+	}
+	var digest [sha256.Size]byte
+	_, err = io.ReadFull(r, digest[:])
+	if err != nil {
+		return nil, errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if digest != sha256.Sum256(oldData) {
+		return nil, errgo.New("delta does not apply to this data")
+	}
+	newLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if newLen > uint64(maxLen) {
+		return nil, errgo.Newf("delta output of %d octets exceeds limit of %d", newLen, maxLen)
+	}
+	// The length is not trusted for allocation until the output reaches it.
+	size := uint64(len(oldData) + len(delta))
+	if newLen < size {
+		size = newLen
+	}
+	result := make([]byte, 0, size)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		switch op {
+		case deltaCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errgo.Mask(noEOF(err), errgo.Any)
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errgo.Mask(noEOF(err), errgo.Any)
+			}
+			if offset > uint64(len(oldData)) || length > uint64(len(oldData))-offset {
+				return nil, errgo.New("delta copy out of range")
+			}
+			if length > newLen-uint64(len(result)) {
+				return nil, errgo.New("delta output too long")
+			}
+			result = append(result, oldData[offset:offset+length]...)
+		case deltaAdd:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errgo.Mask(noEOF(err), errgo.Any)
+			}
+			if length > uint64(r.Len()) {
+				return nil, errgo.New("truncated delta")
+			}
+			if length > newLen-uint64(len(result)) {
+				return nil, errgo.New("delta output too long")
+			}
+			data := make([]byte, length)
+			r.Read(data)
+			result = append(result, data...)
+		default:
+			return nil, errgo.Newf("invalid delta instruction %d", op)
+		}
+	}
+	if uint64(len(result)) != newLen {
+		return nil, errgo.Newf("delta output is %d octets, expected %d", len(result), newLen)
+	}
+	return result, nil
+}
+
+// KeyDelta returns a delta encoding the serialized form of key, as written by
+// WritePackets, against that of base, an earlier version of the key.
+func KeyDelta(base, key *PrimaryKey) ([]byte, error) {
+	var oldBuf, newBuf bytes.Buffer
+	err := WritePackets(&oldBuf, base)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	err = WritePackets(&newBuf, key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return EncodeDelta(oldBuf.Bytes(), newBuf.Bytes()), nil
+}
+
+// ApplyKeyDelta returns the key encoded by a delta against base. It fails if
+// the serialized key would be longer than maxLen octets.
+func ApplyKeyDelta(base *PrimaryKey, delta []byte, maxLen int) (*PrimaryKey, error) {
+	var oldBuf bytes.Buffer
+	err := WritePackets(&oldBuf, base)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	newData, err := DecodeDelta(oldBuf.Bytes(), delta, maxLen)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	key, err := readStoredKey(bytes.NewReader(newData))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return key, nil
+}
@@ -0,0 +1,91 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Describe returns a concise summary of the key, in the manner of gpg
+// --list-keys: a line for the primary key with its fingerprint, then one for
+// each user ID, user attribute and sub-key. Each gives the algorithm and size,
+// creation and expiration dates and revocation or validity state as
+// applicable, as of the current time. It is intended for diagnostics and
+// administration tools, and its format should not be parsed.
+func Describe(key *PrimaryKey) string {
+	var buf bytes.Buffer
+	var expiration time.Time
+	if uid, ss := key.primaryUserID(); uid != nil {
+		expiration, _ = ss.ExpiresAt()
+	} else if !key.Expiration.IsZero() {
+		expiration = key.Expiration
+	}
+	fmt.Fprintf(&buf, "pub  %s\n", describeKey(&key.PublicKey, key.SelfSigs(), expiration))
+	fmt.Fprintf(&buf, "     %s\n", key.Fingerprint())
+	for _, uid := range key.UserIDs {
+		fmt.Fprintf(&buf, "uid  %q%s\n", uid.Keywords, describeState(uid.SelfSigs(key)))
+	}
+	for _, uat := range key.UserAttributes {
+		fmt.Fprintf(&buf, "uat  %s%s\n", uat.UUID, describeState(uat.SelfSigs(key)))
+	}
+	for _, subkey := range key.SubKeys {
+		ss := subkey.SelfSigs(key)
+		expiration, _ := subkey.effectiveExpiration(ss)
+		fmt.Fprintf(&buf, "sub  %s\n", describeKey(&subkey.PublicKey, ss, expiration))
+	}
+	return buf.String()
+}
+
+// describeKey returns the algorithm, size, key ID and dates of a primary key
+// or sub-key.
+func describeKey(pk *PublicKey, ss *SelfSigs, expiration time.Time) string {
+	s := fmt.Sprintf("%s%d/%s created %s", AlgorithmName(pk.Algorithm), pk.BitLen,
+		pk.KeyID(), describeDate(pk.Creation))
+	if !expiration.IsZero() {
+		verb := "expires"
+		if !expiration.After(now()) {
+			verb = "expired"
+		}
+		s += fmt.Sprintf(" %s %s", verb, describeDate(expiration))
+	}
+	if t, ok := ss.RevokedSince(); ok {
+		s += fmt.Sprintf(" [revoked %s]", describeDate(t))
+	}
+	return s
+}
+
+// describeState returns the revocation or validity state of a user ID or user
+// attribute, or an empty string if it is valid.
+func describeState(ss *SelfSigs) string {
+	if t, ok := ss.RevokedSince(); ok {
+		return fmt.Sprintf(" [revoked %s]", describeDate(t))
+	}
+	if !ss.Valid() {
+		if len(ss.Certifications) > 0 {
+			return " [expired]"
+		}
+		return " [unverified]"
+	}
+	return ""
+}
+
+func describeDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
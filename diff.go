@@ -0,0 +1,173 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// KeyDiff describes the differences between two versions of a key. Packets are
+// identified by their UUIDs, so that a packet which has been changed in any way
+// appears as removed and added.
+type KeyDiff struct {
+	AddedUserIDs          []*UserID
+	RemovedUserIDs        []*UserID
+	AddedUserAttributes   []*UserAttribute
+	RemovedUserAttributes []*UserAttribute
+	AddedSubKeys          []*SubKey
+	RemovedSubKeys        []*SubKey
+
+	// AddedSignatures and RemovedSignatures list the signature changes on
+	// packets present in both versions of the key. Signatures on added or
+	// removed packets are not listed separately.
+	AddedSignatures   []*SignatureDiff
+	RemovedSignatures []*SignatureDiff
+}
+
+// SignatureDiff is a signature added to or removed from a key.
+type SignatureDiff struct {
+	// Target describes the packet the signature is on, such as the primary
+	// key, a user ID or a sub-key.
+	Target string
+
+	Signature *Signature
+}
+
+// Diff compares two versions of a key, returning what has been added to and
+// removed from a to give b.
+func Diff(a, b *PrimaryKey) *KeyDiff {
+	d := &KeyDiff{}
+	d.diffSigs("key", a.Signatures, b.Signatures)
+
+	uidsA := make(map[string]*UserID)
+	for _, uid := range a.UserIDs {
+		uidsA[uid.UUID] = uid
+	}
+	uidsB := make(map[string]bool)
+	for _, uid := range b.UserIDs {
+		uidsB[uid.UUID] = true
+		if prev, ok := uidsA[uid.UUID]; ok {
+			d.diffSigs(fmt.Sprintf("uid %q", uid.Keywords), prev.Signatures, uid.Signatures)
+		} else {
+			d.AddedUserIDs = append(d.AddedUserIDs, uid)
+		}
+	}
+	for _, uid := range a.UserIDs {
+		if !uidsB[uid.UUID] {
+			d.RemovedUserIDs = append(d.RemovedUserIDs, uid)
+		}
+	}
+
+	uatsA := make(map[string]*UserAttribute)
+	for _, uat := range a.UserAttributes {
+		uatsA[uat.UUID] = uat
+	}
+	uatsB := make(map[string]bool)
+	for _, uat := range b.UserAttributes {
+		uatsB[uat.UUID] = true
+		if prev, ok := uatsA[uat.UUID]; ok {
+			d.diffSigs("uat "+uat.UUID, prev.Signatures, uat.Signatures)
+		} else {
+			d.AddedUserAttributes = append(d.AddedUserAttributes, uat)
+		}
+	}
+	for _, uat := range a.UserAttributes {
+		if !uatsB[uat.UUID] {
+			d.RemovedUserAttributes = append(d.RemovedUserAttributes, uat)
+		}
+	}
+
+	subkeysA := make(map[string]*SubKey)
+	for _, subkey := range a.SubKeys {
+		subkeysA[subkey.UUID] = subkey
+	}
+	subkeysB := make(map[string]bool)
+	for _, subkey := range b.SubKeys {
+		subkeysB[subkey.UUID] = true
+		if prev, ok := subkeysA[subkey.UUID]; ok {
+			d.diffSigs("sub "+subkey.KeyID(), prev.Signatures, subkey.Signatures)
+		} else {
+			d.AddedSubKeys = append(d.AddedSubKeys, subkey)
+		}
+	}
+	for _, subkey := range a.SubKeys {
+		if !subkeysB[subkey.UUID] {
+			d.RemovedSubKeys = append(d.RemovedSubKeys, subkey)
+		}
+	}
+	return d
+}
+
+func (d *KeyDiff) diffSigs(target string, a, b []*Signature) {
+	inA := make(map[string]bool)
+	for _, sig := range a {
+		inA[sig.UUID] = true
+	}
+	inB := make(map[string]bool)
+	for _, sig := range b {
+		inB[sig.UUID] = true
+		if !inA[sig.UUID] {
+			d.AddedSignatures = append(d.AddedSignatures, &SignatureDiff{Target: target, Signature: sig})
+		}
+	}
+	for _, sig := range a {
+		if !inB[sig.UUID] {
+			d.RemovedSignatures = append(d.RemovedSignatures, &SignatureDiff{Target: target, Signature: sig})
+		}
+	}
+}
+
+// Empty returns whether the two versions of the key are the same.
+func (d *KeyDiff) Empty() bool {
+	return len(d.AddedUserIDs) == 0 && len(d.RemovedUserIDs) == 0 &&
+		len(d.AddedUserAttributes) == 0 && len(d.RemovedUserAttributes) == 0 &&
+		len(d.AddedSubKeys) == 0 && len(d.RemovedSubKeys) == 0 &&
+		len(d.AddedSignatures) == 0 && len(d.RemovedSignatures) == 0
+}
+
+// String returns the differences one per line, each prefixed with "+" if added
+// or "-" if removed.
+func (d *KeyDiff) String() string {
+	var buf bytes.Buffer
+	for _, uid := range d.AddedUserIDs {
+		fmt.Fprintf(&buf, "+uid %q\n", uid.Keywords)
+	}
+	for _, uid := range d.RemovedUserIDs {
+		fmt.Fprintf(&buf, "-uid %q\n", uid.Keywords)
+	}
+	for _, uat := range d.AddedUserAttributes {
+		fmt.Fprintf(&buf, "+uat %s\n", uat.UUID)
+	}
+	for _, uat := range d.RemovedUserAttributes {
+		fmt.Fprintf(&buf, "-uat %s\n", uat.UUID)
+	}
+	for _, subkey := range d.AddedSubKeys {
+		fmt.Fprintf(&buf, "+sub %s\n", subkey.KeyID())
+	}
+	for _, subkey := range d.RemovedSubKeys {
+		fmt.Fprintf(&buf, "-sub %s\n", subkey.KeyID())
+	}
+	for _, sd := range d.AddedSignatures {
+		fmt.Fprintf(&buf, "+sig 0x%02x by %s on %s\n", sd.Signature.SigType, sd.Signature.IssuerKeyID(), sd.Target)
+	}
+	for _, sd := range d.RemovedSignatures {
+		fmt.Fprintf(&buf, "-sig 0x%02x by %s on %s\n", sd.Signature.SigType, sd.Signature.IssuerKeyID(), sd.Target)
+	}
+	return buf.String()
+}
@@ -0,0 +1,112 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"runtime"
+)
+
+// DigestResult is the SKS digest of a keyring read from a stream of key
+// material.
+type DigestResult struct {
+	// Index is the position of the keyring in the input stream, counting
+	// from zero.
+	Index int
+
+	// Position is the Position of the keyring read.
+	Position int64
+
+	RFingerprint string
+	MD5          string
+	Error        error
+}
+
+type DigestResultChan chan *DigestResult
+
+// DigestKeyrings parses each keyring received from c and calculates its SKS
+// digest, using a pool of workers. Results are sent in the same order as
+// keyrings are received. If workers is less than one, the number of CPUs is
+// used. Keyrings read with IndexOnly or SkipTags are not digested, and their
+// results carry an error caused by ErrPartialKeyring.
+//
+// This makes verifying a full dump against its expected digests a matter of
+// minutes rather than hours:
+//
+//	for result := range DigestKeyrings(ReadOpaqueKeyrings(f), 0) {
+//		...
+//	}
+//
+// Caller must receive all results until the channel is closed.
+func DigestKeyrings(c OpaqueKeyringChan, workers int) DigestResultChan {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	type job struct {
+		index  int
+		okr    *OpaqueKeyring
+		result chan *DigestResult
+	}
+	jobs := make(chan job, workers)
+	// pending holds the result channels of in-flight jobs, in input order.
+	pending := make(chan chan *DigestResult, workers)
+
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		var i int
+		for okr := range c {
+			result := make(chan *DigestResult, 1)
+			pending <- result
+			jobs <- job{index: i, okr: okr, result: result}
+			i++
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.result <- digestKeyring(j.index, j.okr)
+			}
+		}()
+	}
+
+	out := make(DigestResultChan)
+	go func() {
+		defer close(out)
+		for result := range pending {
+			out <- <-result
+		}
+	}()
+	return out
+}
+
+func digestKeyring(index int, okr *OpaqueKeyring) *DigestResult {
+	result := &DigestResult{Index: index, Position: okr.Position}
+	if okr.Error != nil {
+		result.Error = okr.Error
+		return result
+	}
+	key, err := okr.Parse()
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.RFingerprint = key.RFingerprint
+	result.MD5 = key.MD5
+	return result
+}
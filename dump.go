@@ -0,0 +1,209 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// DumpFile is a binary keyring dump file held in memory. Where the platform
+// supports it, the file is memory-mapped rather than read into the heap.
+//
+// Opaque packets read from a DumpFile refer directly to its contents, and
+// must not be used after the DumpFile is closed. Keys parsed from them hold
+// their own copies of their packets, and remain valid.
+type DumpFile struct {
+	data  []byte
+	unmap func() error
+
+	mu      sync.Mutex
+	readers int
+	closed  bool
+}
+
+// Len returns the size of the dump file in bytes.
+func (d *DumpFile) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.data)
+}
+
+// Close releases the dump file contents. Readers still running stop at the
+// next packet, and the contents are only released once the last of them has
+// finished, so that none reads from memory which has been unmapped.
+func (d *DumpFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.readers > 0 {
+		return nil
+	}
+	return d.release()
+}
+
+// acquire registers a reader of the dump file contents, which are returned.
+// The contents are retained until the reader calls done. acquire returns
+// false if the file is closed.
+func (d *DumpFile) acquire() ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, false
+	}
+	d.readers++
+	return d.data, true
+}
+
+// done ends a read begun with acquire, releasing the contents if the file
+// was closed meanwhile.
+func (d *DumpFile) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.readers--
+	if d.closed && d.readers == 0 {
+		d.release()
+	}
+}
+
+// isClosed returns whether Close has been called.
+func (d *DumpFile) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+// release unmaps or drops the contents. d.mu must be held.
+func (d *DumpFile) release() error {
+	d.data = nil
+	unmap := d.unmap
+	d.unmap = nil
+	if unmap != nil {
+		return errgo.Mask(unmap())
+	}
+	return nil
+}
+
+// ReadOpaqueKeyrings reads the keyrings in the dump file, in the same manner
+// as the package-level ReadOpaqueKeyrings. Packet contents are not copied,
+// except for packets encoded with partial body lengths. Keyring Position is
+// the offset of the primary public key packet in the file.
+//
+// If the dump file is closed while it is being read, reading stops with an
+// error at the next packet.
+func (d *DumpFile) ReadOpaqueKeyrings(opts ...ReadOption) OpaqueKeyringChan {
+	c := make(OpaqueKeyringChan)
+	kc := newKeyringCollector(c, opts)
+	data, ok := d.acquire()
+	go func() {
+		defer close(c)
+		if !ok {
+			kc.finish(ErrDumpFileClosed, 0)
+			return
+		}
+		defer d.done()
+		r := bytes.NewReader(data)
+		for {
+			offset := int64(len(data) - r.Len())
+			if d.isClosed() {
+				// The keyring in progress is incomplete.
+				kc.current = nil
+				kc.finish(ErrDumpFileClosed, offset)
+				return
+			}
+			op, h, err := readFramedPacketAt(data, r)
+			if err != nil {
+				kc.finish(err, offset)
+				return
+			}
+			origin := newPacketOrigin(h, offset, int64(len(data)-r.Len()))
+			if started := kc.add(op, origin); started != nil {
+				started.Position = offset
+			}
+			if kc.err != nil {
+				kc.finish(nil, offset)
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// ReadKeys parses the keys in the dump file, as the package-level ReadKeys.
+func (d *DumpFile) ReadKeys(opts ...ReadOption) PrimaryKeyChan {
+	c := make(PrimaryKeyChan)
+	ro := newReadOptions(opts)
+	// Keyrings refer to the dump file contents until they are parsed.
+	_, ok := d.acquire()
+	go func() {
+		defer close(c)
+		if ok {
+			defer d.done()
+		}
+		for opkr := range d.ReadOpaqueKeyrings(opts...) {
+			c <- opkr.result(ro)
+		}
+	}()
+	return c
+}
+
+// readOpaquePacketAt reads the next packet from r, which reads data. The
+// packet contents are a sub-slice of data if the packet has a definite
+// length.
+func readOpaquePacketAt(data []byte, r *bytes.Reader) (*packet.OpaquePacket, error) {
+	op, _, err := readFramedPacketAt(data, r)
+	return op, err
+}
+
+// readFramedPacketAt is like readOpaquePacketAt, but also returns the header
+// the packet was read with.
+func readFramedPacketAt(data []byte, r *bytes.Reader) (*packet.OpaquePacket, *packetHeader, error) {
+	h, err := readPacketHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	op := &packet.OpaquePacket{Tag: h.tag}
+	start := len(data) - r.Len()
+	switch {
+	case h.length < 0:
+		op.Contents = data[start:len(data):len(data)]
+		_, err = r.Seek(0, io.SeekEnd)
+	case !h.partial:
+		end := int64(start) + h.length
+		if end > int64(len(data)) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		op.Contents = data[start:end:end]
+		_, err = r.Seek(end, io.SeekStart)
+	default:
+		var buf bytes.Buffer
+		err = copyPacketBody(&buf, r, h)
+		op.Contents = buf.Bytes()
+	}
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	return op, h, nil
+}
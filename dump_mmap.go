@@ -0,0 +1,87 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"os"
+	"syscall"
+
+	"gopkg.in/errgo.v1"
+)
+
+// OpenDumpFile memory-maps the binary keyring dump file at path. Changes made
+// to the file while it is open may be visible through the mapping.
+//
+// Reading a mapped page beyond the end of a file faults the process, so the
+// file must not be truncated while it is open. A shared lock is taken on the
+// file with flock(2) before its size is read, and held until the dump file is
+// closed: writers which take an exclusive lock before changing the file wait
+// for readers to finish, and readers wait for them in turn. Writers which do
+// not lock the file are not kept out.
+func OpenDumpFile(path string) (*DumpFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH)
+	if err != nil {
+		f.Close()
+		return nil, errgo.Mask(err)
+	}
+	d, err := openDumpFile(f)
+	if err != nil || d.unmap == nil {
+		f.Close()
+		return d, err
+	}
+	// Closing the file releases the lock.
+	unmap := d.unmap
+	d.unmap = func() error {
+		err := unmap()
+		f.Close()
+		return err
+	}
+	return d, nil
+}
+
+// openDumpFile memory-maps the open dump file f. The mapping outlives f.
+func openDumpFile(f *os.File) (*DumpFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return &DumpFile{}, nil
+	}
+	if int64(int(size)) != size {
+		return nil, errgo.Newf("dump file %q too large to map", f.Name())
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &DumpFile{
+		data: data,
+		unmap: func() error {
+			return syscall.Munmap(data)
+		},
+	}, nil
+}
@@ -0,0 +1,64 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	gc "gopkg.in/check.v1"
+)
+
+func (s *SamplePacketSuite) TestDumpFileLock(c *gc.C) {
+	key := MustInputAscKey("sksdigest.asc")
+	packets, err := key.Packets()
+	c.Assert(err, gc.IsNil)
+	path := filepath.Join(c.MkDir(), "dump.pgp")
+	f, err := os.Create(path)
+	c.Assert(err, gc.IsNil)
+	for _, op := range packets {
+		c.Assert(op.Serialize(f), gc.IsNil)
+	}
+	c.Assert(f.Close(), gc.IsNil)
+
+	d, err := OpenDumpFile(path)
+	c.Assert(err, gc.IsNil)
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	c.Assert(err, gc.IsNil)
+	defer w.Close()
+	// A writer cannot lock the file while it is mapped, but can once it is
+	// closed.
+	err = syscall.Flock(int(w.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	c.Assert(err, gc.Equals, syscall.EWOULDBLOCK)
+	c.Assert(d.ReadKeys().MustParse(), gc.HasLen, 1)
+	c.Assert(d.Close(), gc.IsNil)
+	c.Assert(syscall.Flock(int(w.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), gc.IsNil)
+	c.Assert(syscall.Flock(int(w.Fd()), syscall.LOCK_UN), gc.IsNil)
+
+	// Empty files are not mapped, and are not left locked.
+	c.Assert(ioutil.WriteFile(path, nil, 0644), gc.IsNil)
+	d, err = OpenDumpFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(syscall.Flock(int(w.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), gc.IsNil)
+	c.Assert(d.Close(), gc.IsNil)
+}
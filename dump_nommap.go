@@ -0,0 +1,48 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/errgo.v1"
+)
+
+// OpenDumpFile reads the binary keyring dump file at path into memory.
+// Memory-mapping is not supported on this platform.
+func OpenDumpFile(path string) (*DumpFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	defer f.Close()
+	return openDumpFile(f)
+}
+
+// openDumpFile reads the open dump file f into memory.
+func openDumpFile(f *os.File) (*DumpFile, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return &DumpFile{data: data}, nil
+}
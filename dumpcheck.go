@@ -0,0 +1,164 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"io"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// DumpFailureReason classifies a keyring which failed dump validation.
+type DumpFailureReason string
+
+const (
+	// DumpUnreadable means the packets of the keyring could not be read
+	// from the dump. Validation stops at the first such failure.
+	DumpUnreadable DumpFailureReason = "unreadable"
+
+	// DumpUnparseable means the keyring could not be parsed into a key.
+	DumpUnparseable DumpFailureReason = "unparseable"
+
+	// DumpDigestMismatch means the digest of the parsed key differs from
+	// that of the packets read from the dump.
+	DumpDigestMismatch DumpFailureReason = "digest-mismatch"
+
+	// DumpDuplicatePackets means the keyring contains duplicate packets, and
+	// so its digest differs from that of the same key in canonical form.
+	DumpDuplicatePackets DumpFailureReason = "duplicate-packets"
+
+	// DumpLimitExceeded means the key exceeds the limits of the validation
+	// policy.
+	DumpLimitExceeded DumpFailureReason = "limit-exceeded"
+)
+
+// DumpFailure describes a keyring which failed dump validation.
+type DumpFailure struct {
+	// Index is the position of the keyring in the dump, counting from zero.
+	Index int `json:"index"`
+
+	// Position is the offset in octets at which the keyring starts.
+	Position int64 `json:"position"`
+
+	// RFingerprint identifies the key, if it could be parsed.
+	RFingerprint string `json:"rfingerprint,omitempty"`
+
+	Reason  DumpFailureReason `json:"reason"`
+	Message string            `json:"message"`
+}
+
+// DumpReport is the result of validating a dump. It is encoded as JSON by
+// WriteJSON, for consumption by other tools.
+type DumpReport struct {
+	// Keyrings is the number of keyrings read from the dump, and Valid the
+	// number of those which passed validation.
+	Keyrings int `json:"keyrings"`
+	Valid    int `json:"valid"`
+
+	// Revocations is the number of keyrings made up of standalone key
+	// revocation certificates rather than keys. They have no digest to
+	// check, and are counted here rather than as valid.
+	Revocations int `json:"revocations"`
+
+	Failures []*DumpFailure `json:"failures"`
+}
+
+// OK returns whether every keyring in the dump passed validation.
+func (r *DumpReport) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// WriteJSON writes the report to w as a JSON object.
+func (r *DumpReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ValidateDump reads a dump of binary keyrings from r, checking that each
+// parses into a key, that its digest is unchanged by parsing and by dropping
+// duplicate packets, and that it is within the limits of policy. A nil policy
+// imposes no limits. Each keyring is discarded once checked, so dumps of any
+// size may be validated. Options such as MaxPacketLen and SecretKeys apply as
+// for ReadKeys; IndexOnly and SkipTags are refused, as digests cannot be
+// checked on partial keys.
+func ValidateDump(r io.Reader, policy *SubmissionPolicy, opts ...ReadOption) (*DumpReport, error) {
+	ro := newReadOptions(opts)
+	if ro.partial() {
+		return nil, errgo.New("cannot validate partial keys")
+	}
+	if policy == nil {
+		policy = &SubmissionPolicy{}
+	}
+	report := &DumpReport{}
+	for okr := range ReadOpaqueKeyrings(r, opts...) {
+		index := report.Keyrings
+		report.Keyrings++
+		fail := func(rfp string, reason DumpFailureReason, message string) {
+			report.Failures = append(report.Failures, &DumpFailure{
+				Index:        index,
+				Position:     okr.Position,
+				RFingerprint: rfp,
+				Reason:       reason,
+				Message:      message,
+			})
+		}
+		if okr.Error != nil {
+			fail("", DumpUnreadable, okr.Error.Error())
+			continue
+		}
+		if okr.standalone() && len(okr.revocationCerts()) > 0 {
+			report.Revocations++
+			continue
+		}
+
+		// sksDigestOpaque sorts the packets it is given, but parsing
+		// depends on their original order.
+		packets := make([]*packet.OpaquePacket, len(okr.Packets))
+		copy(packets, okr.Packets)
+		digest := sksDigestOpaque(packets, md5.New())
+
+		key, _, err := okr.parse(ro)
+		if err != nil {
+			fail("", DumpUnparseable, err.Error())
+			continue
+		}
+		if key.MD5 != digest {
+			fail(key.RFingerprint, DumpDigestMismatch, "parsed key digest "+key.MD5+" differs from dump "+digest)
+			continue
+		}
+		err = DropDuplicates(key)
+		if err != nil {
+			fail(key.RFingerprint, DumpUnparseable, err.Error())
+			continue
+		}
+		if key.MD5 != digest {
+			fail(key.RFingerprint, DumpDuplicatePackets, "canonical key digest "+key.MD5+" differs from dump "+digest)
+			continue
+		}
+		if err := policy.checkLimits(key); err != nil {
+			fail(key.RFingerprint, DumpLimitExceeded, err.Error())
+			continue
+		}
+		report.Valid++
+	}
+	return report, nil
+}
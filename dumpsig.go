@@ -0,0 +1,129 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/errgo.v1"
+)
+
+// KeyRing returns the keys as a keyring against which signatures can be
+// checked with golang.org/x/crypto/openpgp.
+func KeyRing(keys ...*PrimaryKey) (openpgp.EntityList, error) {
+	var buf bytes.Buffer
+	for _, key := range keys {
+		err := WritePackets(&buf, key)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	keyring, err := openpgp.ReadKeyRing(&buf)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return keyring, nil
+}
+
+// Verify checks a detached signature over the contents of the dump file,
+// either armored or binary, against the keys in keyring. The entity which made
+// the signature is returned.
+//
+// A memory-mapped dump file may be changed after it is verified, by writing
+// to the file; OpenSignedDumpFile reads from a private copy instead.
+func (d *DumpFile) Verify(keyring openpgp.KeyRing, sig io.Reader) (*openpgp.Entity, error) {
+	data, ok := d.acquire()
+	if !ok {
+		return nil, errgo.Mask(ErrDumpFileClosed, errgo.Any)
+	}
+	defer d.done()
+	return checkDetachedSignature(keyring, bytes.NewReader(data), sig)
+}
+
+// checkDetachedSignature checks a detached signature, either armored or
+// binary, over the contents read from signed.
+func checkDetachedSignature(keyring openpgp.KeyRing, signed, sig io.Reader) (*openpgp.Entity, error) {
+	br := bufio.NewReader(sig)
+	check := openpgp.CheckDetachedSignature
+	if prefix, _ := br.Peek(5); string(prefix) == "-----" {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	signer, err := check(keyring, signed, br)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return signer, nil
+}
+
+// OpenSignedDumpFile opens the dump file at path, and checks the detached
+// signature read from sig as by Verify. The dump file is only returned if the
+// signature is good. The file is streamed through the signature check into a
+// private temporary copy, which is opened in its place, so that the keys read
+// from it are those which were verified, even if the file is changed or
+// truncated afterwards, and so that it is never held in memory whole where
+// it can be mapped.
+func OpenSignedDumpFile(path string, keyring openpgp.KeyRing, sig io.Reader) (*DumpFile, *openpgp.Entity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	defer f.Close()
+	tmp, err := ioutil.TempFile("", "openpgp-dump-")
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	signer, err := checkDetachedSignature(keyring, io.TeeReader(f, tmp), sig)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	d, err := openDumpFile(tmp)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	return d, signer, nil
+}
+
+// ReadSignedDump verifies the detached signature over the dump file at path,
+// as OpenSignedDumpFile, and if it is good reads the keys in the dump. The
+// dump file is closed once all keys have been received.
+func ReadSignedDump(path string, keyring openpgp.KeyRing, sig io.Reader, opts ...ReadOption) (PrimaryKeyChan, *openpgp.Entity, error) {
+	d, signer, err := OpenSignedDumpFile(path, keyring, sig)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Any)
+	}
+	c := make(PrimaryKeyChan)
+	go func() {
+		defer close(c)
+		defer d.Close()
+		for readKey := range d.ReadKeys(opts...) {
+			c <- readKey
+		}
+	}()
+	return c, signer, nil
+}
@@ -0,0 +1,79 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+)
+
+// Equal returns whether two keys have the same packets, in the same order,
+// and so the same structure. Keys which differ only in the order or number of
+// repeats of their packets, and so have the same digest, are not Equal; use
+// SemanticEqual to compare those.
+func Equal(a, b *PrimaryKey) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	an, bn := a.contents(), b.contents()
+	if len(an) != len(bn) {
+		return false
+	}
+	for i := range an {
+		if an[i].uuid() != bn[i].uuid() || !bytes.Equal(an[i].packet().Packet, bn[i].packet().Packet) {
+			return false
+		}
+	}
+	return true
+}
+
+// SemanticEqual returns whether two keys have the same packets beneath the
+// same parents, regardless of the order in which they appear and of how many
+// times each is repeated.
+func SemanticEqual(a, b *PrimaryKey) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil {
+		return false
+	}
+	am, bm := packetsByUUID(a), packetsByUUID(b)
+	if len(am) != len(bm) {
+		return false
+	}
+	for uuid, packet := range am {
+		other, ok := bm[uuid]
+		if !ok || !bytes.Equal(packet, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// packetsByUUID returns the packets of a key, indexed by UUID. As the UUIDs
+// of packets other than keys are derived from those of their parents, packets
+// repeated under different parents remain distinct.
+func packetsByUUID(key *PrimaryKey) map[string][]byte {
+	result := make(map[string][]byte)
+	for _, node := range key.contents() {
+		result[node.uuid()] = node.packet().Packet
+	}
+	return result
+}
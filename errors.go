@@ -0,0 +1,152 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"errors"
+	"fmt"
+
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// Error values which may be the cause, as reported by errgo.Cause, of errors
+// returned when reading key material.
+var (
+	ErrNoPrimaryKey         = errors.New("primary public key not found")
+	ErrMultiplePrimaryKeys  = errors.New("multiple public keys in keyring")
+	ErrBadSelfSignature     = errors.New("invalid self-signature")
+	ErrPacketTooLarge       = errors.New("packet too large")
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrSecretKeyMaterial    = errors.New("secret key material")
+	ErrTruncated            = errors.New("input truncated")
+	ErrParserPanic          = errors.New("panic while parsing")
+	ErrNestingTooDeep       = errors.New("nesting too deep")
+	ErrUnknownPacket        = errors.New("unknown packet type")
+	ErrFingerprintMismatch  = errors.New("fingerprint does not match key material")
+	ErrDumpFileClosed       = errors.New("dump file closed")
+	ErrPartialKeyring       = errors.New("keyring read with packets skipped")
+)
+
+// PacketError describes a failure to process a particular packet in a
+// keyring. Its cause is one of the package error values.
+type PacketError struct {
+	// Err is the package error value describing the class of failure.
+	Err error
+
+	// Tag is the OpenPGP packet tag of the offending packet.
+	Tag uint8
+
+	// Offset is the position in octets of the packet in the input it was
+	// read from, or from the start of its keyring, as serialized, if the
+	// keyring was constructed rather than read.
+	Offset int64
+
+	// Underlying is the lower-level error encountered, if any.
+	Underlying error
+}
+
+// Error implements error.
+func (e *PacketError) Error() string {
+	msg := fmt.Sprintf("%v: tag %d at offset %d", e.Err, e.Tag, e.Offset)
+	if e.Underlying != nil {
+		msg += ": " + e.Underlying.Error()
+	}
+	return msg
+}
+
+// Cause implements errgo.Causer.
+func (e *PacketError) Cause() error {
+	return e.Err
+}
+
+// TruncationError reports input which ended partway through a packet.
+type TruncationError struct {
+	// Offset is the position in octets of the incomplete packet from the
+	// start of the input.
+	Offset int64
+
+	// Underlying is the read error which ended the input.
+	Underlying error
+}
+
+// Error implements error.
+func (e *TruncationError) Error() string {
+	return fmt.Sprintf("%v at offset %d", ErrTruncated, e.Offset)
+}
+
+// Cause implements errgo.Causer.
+func (e *TruncationError) Cause() error {
+	return ErrTruncated
+}
+
+// PanicError reports a panic recovered while parsing a keyring, so that one
+// pathological key does not bring down a long-running import.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Tag, Length and Digest describe the packet being parsed when the panic
+	// occurred. Length is the length of the packet contents, and Digest the
+	// hex-encoded SHA-256 digest of the serialized packet. They are unset if
+	// the panic occurred after all packets had been parsed.
+	Tag    uint8
+	Length int
+	Digest string
+
+	// Offset is the position of the packet, as in PacketError.
+	Offset int64
+}
+
+func newPanicError(value interface{}, op *packet.OpaquePacket, offset int64) *PanicError {
+	e := &PanicError{Value: value}
+	if op != nil {
+		e.Tag = op.Tag
+		e.Length = len(op.Contents)
+		e.Digest = opaqueDigest(op)
+		e.Offset = offset
+	}
+	return e
+}
+
+// Error implements error.
+func (e *PanicError) Error() string {
+	if e.Digest == "" {
+		return fmt.Sprintf("%v: %v", ErrParserPanic, e.Value)
+	}
+	return fmt.Sprintf("%v: tag %d length %d digest %s at offset %d: %v",
+		ErrParserPanic, e.Tag, e.Length, e.Digest, e.Offset, e.Value)
+}
+
+// Cause implements errgo.Causer.
+func (e *PanicError) Cause() error {
+	return ErrParserPanic
+}
+
+// classifyError gives errors from the underlying OpenPGP library a package
+// error value as their cause, where one applies.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := errgo.Cause(err).(pgperrors.UnsupportedError); ok {
+		return errgo.WithCausef(err, ErrUnsupportedAlgorithm, "")
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	log "gopkg.in/schmorrison/logrus.v0"
+)
+
+// EventKind classifies the conditions reported to an EventLogger.
+type EventKind string
+
+const (
+	// EventSkippedPacket is reported for a packet of a key which was not
+	// accepted as key material, such as an unparseable user ID or a
+	// signature out of context. The packet is kept in the Others of the
+	// key, or of the user ID, user attribute or sub-key it follows.
+	EventSkippedPacket EventKind = "skipped-packet"
+
+	// EventBadSelfSignature is reported for a self-signature which failed
+	// verification.
+	EventBadSelfSignature EventKind = "bad-self-signature"
+
+	// EventUnreadableSecretKey is reported for a secret key packet from
+	// which the public key could not be extracted.
+	EventUnreadableSecretKey EventKind = "unreadable-secret-key"
+)
+
+// Event describes a notable condition encountered while reading or checking
+// a key.
+type Event struct {
+	Kind EventKind
+
+	// RFingerprint identifies the key concerned, if it is known.
+	RFingerprint string
+
+	// Tag and Digest identify the packet concerned. Digest is the
+	// hex-encoded SHA-256 digest of the serialized packet.
+	Tag    uint8
+	Digest string
+
+	// Message describes the condition, and Err is the error encountered,
+	// if any.
+	Message string
+	Err     error
+}
+
+// EventLogger receives events about notable conditions, which would
+// otherwise be dropped silently. Implementations must be safe for concurrent
+// use.
+type EventLogger interface {
+	LogEvent(e *Event)
+}
+
+// debugLogger is the default EventLogger, which logs events at debug level.
+type debugLogger struct{}
+
+func (debugLogger) LogEvent(e *Event) {
+	entry := log.WithFields(log.Fields{
+		"event":       e.Kind,
+		"fingerprint": Reverse(e.RFingerprint),
+		"tag":         e.Tag,
+		"digest":      e.Digest,
+	})
+	if e.Err != nil {
+		entry.Debugf("%s: %v", e.Message, e.Err)
+	} else {
+		entry.Debug(e.Message)
+	}
+}
+
+var eventLogger EventLogger = debugLogger{}
+
+// SetEventLogger sets the receiver of events, or restores the default of
+// logging them at debug level if l is nil. It should be called during
+// initialization, before keys are read.
+func SetEventLogger(l EventLogger) {
+	if l == nil {
+		l = debugLogger{}
+	}
+	eventLogger = l
+}
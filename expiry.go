@@ -0,0 +1,138 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"time"
+)
+
+// ExpiringKey identifies a primary key or sub-key due to expire.
+type ExpiringKey struct {
+	// RFingerprint identifies the primary key.
+	RFingerprint string
+
+	// SubKey is the sub-key which expires, or nil if it is the primary key.
+	SubKey *SubKey
+
+	Expiration time.Time
+
+	// UserID and Email are those of the key's primary user ID, if it has
+	// one, so that its owner can be warned.
+	UserID string
+	Email  string
+}
+
+// primaryUserID returns the user ID of the key which is marked primary by the
+// most recent self-signature, or failing that the most recently certified
+// valid user ID. It returns nil if the key has no valid user ID.
+func (pubkey *PrimaryKey) primaryUserID() (*UserID, *SelfSigs) {
+	var best *UserID
+	var bestSigs *SelfSigs
+	for _, uid := range pubkey.UserIDs {
+		ss := uid.SelfSigs(pubkey)
+		if !ss.Valid() {
+			continue
+		}
+		if best == nil {
+			best, bestSigs = uid, ss
+			continue
+		}
+		if less, ok := lessSelfSigs(ss, bestSigs); ok && less {
+			best, bestSigs = uid, ss
+		}
+	}
+	return best, bestSigs
+}
+
+// effectiveExpiration returns the time at which the primary key expires: its
+// creation time plus the key lifetime given by the more recent of its latest
+// valid direct-key signature, in ss, and the latest valid self-signature on
+// its primary user ID, in uidSigs, which may be nil. It returns false if the
+// key does not expire.
+func (pubkey *PrimaryKey) effectiveExpiration(ss, uidSigs *SelfSigs) (time.Time, bool) {
+	var latest *Signature
+	if len(ss.DirectKeys) > 0 {
+		latest = ss.DirectKeys[0].Signature
+	}
+	if uidSigs != nil && len(uidSigs.Certifications) > 0 {
+		if sig := uidSigs.Certifications[0].Signature; latest == nil || sig.Creation.After(latest.Creation) {
+			latest = sig
+		}
+	}
+	if latest == nil {
+		return zeroTime, false
+	}
+	lifetime, ok := latest.KeyLifetime()
+	if !ok || lifetime == 0 {
+		return zeroTime, false
+	}
+	return pubkey.Creation.Add(lifetime), true
+}
+
+// ExpiringKeys returns the valid primary keys and sub-keys received from c
+// which expire within window from the given time. A primary key expires as
+// given by its latest direct-key signature or self-signature on its primary
+// user ID, whichever is more recent. Keys which could not be read are
+// ignored.
+func ExpiringKeys(c PrimaryKeyChan, from time.Time, window time.Duration) []*ExpiringKey {
+	until := from.Add(window)
+	expiring := func(t time.Time, ok bool) bool {
+		return ok && !t.Before(from) && t.Before(until)
+	}
+	var result []*ExpiringKey
+	for readKey := range c {
+		key := readKey.PrimaryKey
+		if readKey.Error != nil || key == nil {
+			continue
+		}
+		keySigs := key.SelfSigs()
+		if _, revoked := keySigs.RevokedSince(); revoked {
+			continue
+		}
+		uid, uidSigs := key.primaryUserID()
+		var userID, email string
+		if uid != nil {
+			userID = uid.Keywords
+			if u, err := uid.userIDPacket(); err == nil {
+				email = u.Email
+			}
+		}
+		newEntry := func(subkey *SubKey, t time.Time) *ExpiringKey {
+			return &ExpiringKey{
+				RFingerprint: key.RFingerprint,
+				SubKey:       subkey,
+				Expiration:   t,
+				UserID:       userID,
+				Email:        email,
+			}
+		}
+		if t, ok := key.effectiveExpiration(keySigs, uidSigs); expiring(t, ok) {
+			result = append(result, newEntry(nil, t))
+		}
+		for _, subkey := range key.SubKeys {
+			ss := subkey.SelfSigs(key)
+			if subkey.statusAt(ss, now()) != SubKeyValid {
+				continue
+			}
+			if t, ok := subkey.effectiveExpiration(ss); expiring(t, ok) {
+				result = append(result, newEntry(subkey, t))
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// FormatFingerprint returns a hex fingerprint in upper case, in groups of
+// four digits separated by spaces, as displayed by GnuPG. A v4 fingerprint is
+// split into two halves of five groups each by a double space.
+func FormatFingerprint(fp string) string {
+	fp = strings.ToUpper(fp)
+	var buf strings.Builder
+	for i := 0; i < len(fp); i += 4 {
+		if i > 0 {
+			buf.WriteByte(' ')
+			if len(fp) == 40 && i == 20 {
+				buf.WriteByte(' ')
+			}
+		}
+		end := i + 4
+		if end > len(fp) {
+			end = len(fp)
+		}
+		buf.WriteString(fp[i:end])
+	}
+	return buf.String()
+}
+
+// FormattedFingerprint returns the fingerprint of the key as
+// FormatFingerprint.
+func (pk *PublicKey) FormattedFingerprint() string {
+	return FormatFingerprint(pk.Fingerprint())
+}
+
+// LongKeyID returns the 64-bit key ID of the key in upper case, prefixed by
+// "0x".
+func (pk *PublicKey) LongKeyID() string {
+	return "0x" + strings.ToUpper(pk.KeyID())
+}
+
+// FingerprintURI returns the fingerprint of the key as an openpgp4fpr: URI,
+// as encoded in QR codes for key exchange.
+func (pk *PublicKey) FingerprintURI() string {
+	return "openpgp4fpr:" + strings.ToUpper(pk.Fingerprint())
+}
+
+// ZBase32Fingerprint returns the fingerprint of the key in z-base-32.
+func (pk *PublicKey) ZBase32Fingerprint() string {
+	fp, _ := hex.DecodeString(pk.Fingerprint())
+	return ZBase32(fp)
+}
+
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+// ZBase32 returns data in the human-oriented base-32 encoding, z-base-32, as
+// used by the Web Key Directory. Bits which do not fill a final character are
+// padded with zeroes.
+func ZBase32(data []byte) string {
+	var buf strings.Builder
+	var acc uint
+	var bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			buf.WriteByte(zbase32Alphabet[(acc>>bits)&0x1f])
+		}
+		acc &= 1<<bits - 1
+	}
+	if bits > 0 {
+		buf.WriteByte(zbase32Alphabet[(acc<<(5-bits))&0x1f])
+	}
+	return buf.String()
+}
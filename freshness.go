@@ -0,0 +1,151 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"math"
+	"time"
+)
+
+// FreshnessPolicy sets how keys are scored by Freshness.
+type FreshnessPolicy struct {
+	// HalfLife is the age at which a signature counts for half as much as
+	// one made now.
+	HalfLife time.Duration
+
+	// SelfSigWeight is the share of the score given by the key's
+	// self-signatures, between zero and one; certifications by other keys
+	// give the rest.
+	SelfSigWeight float64
+
+	// Certifications is the number of new, strong certifications by others
+	// which earn the key their full share of the score.
+	Certifications int
+
+	// Issuers returns the keys which may have issued a certification, such
+	// as KeyringIndex.Issuers. Certifications count only if verified by
+	// the primary key of one of them, and none count if it is nil.
+	Issuers func(sig *Signature) []*PrimaryKey
+}
+
+// DefaultFreshnessPolicy is the policy used by Freshness when none is given.
+var DefaultFreshnessPolicy = &FreshnessPolicy{
+	HalfLife:       2 * 365 * 24 * time.Hour,
+	SelfSigWeight:  0.8,
+	Certifications: 5,
+}
+
+// FreshnessScore is the freshness of a key, as scored by Freshness. Each
+// score is between zero and one.
+type FreshnessScore struct {
+	Score float64
+
+	// SelfSigs is the score of the best self-signature, and Certifications
+	// that of the certifications of the key by others, combined by the
+	// policy into Score.
+	SelfSigs       float64
+	Certifications float64
+}
+
+// hashStrength rates the hash algorithm of a signature: broken algorithms
+// count for little or nothing.
+func hashStrength(sig *Signature) float64 {
+	hash, ok := sig.hashAlgorithm()
+	if !ok {
+		return 0.5
+	}
+	switch hash {
+	case 1: // MD5
+		return 0
+	case 2, 3: // SHA-1, RIPEMD-160
+		return 0.25
+	case 8, 9, 10, 11, 12, 14: // SHA-2, SHA3
+		return 1
+	}
+	return 0.5
+}
+
+// signatureScore rates a signature by its age and hash algorithm.
+func (p *FreshnessPolicy) signatureScore(sig *Signature, t time.Time) float64 {
+	score := hashStrength(sig)
+	if age := t.Sub(sig.Creation); age > 0 && p.HalfLife > 0 {
+		score *= math.Exp2(-float64(age) / float64(p.HalfLife))
+	}
+	return score
+}
+
+// Freshness scores a key by the age and hash strength of its signatures, for
+// ranking search results: keys which their owners have recently re-signed,
+// and which others have recently certified, rank higher. Self-signatures are
+// verified, and only the best of those on the primary key and its user IDs
+// counts. Certifications by others count once for each issuer found by the
+// policy which has verifiably made them, and only if it has not revoked them.
+// Revoked keys, and revoked user IDs, score zero. A nil policy uses
+// DefaultFreshnessPolicy, which finds no issuers.
+func Freshness(key *PrimaryKey, p *FreshnessPolicy) *FreshnessScore {
+	if p == nil {
+		p = DefaultFreshnessPolicy
+	}
+	result := &FreshnessScore{}
+	ss := key.SelfSigs()
+	if len(ss.Revocations) > 0 {
+		return result
+	}
+	t := now()
+	best := func(checkSigs []*CheckSig) {
+		for _, checkSig := range checkSigs {
+			if score := p.signatureScore(checkSig.Signature, t); score > result.SelfSigs {
+				result.SelfSigs = score
+			}
+		}
+	}
+	best(ss.DirectKeys)
+
+	var certs float64
+	for _, uid := range key.UserIDs {
+		uss := uid.SelfSigs(key)
+		if len(uss.Revocations) > 0 {
+			continue
+		}
+		best(uss.Certifications)
+		if p.Issuers == nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, sig := range uid.Signatures {
+			if seen[sig.RIssuerKeyID] || sig.IsWildcardIssuer() || key.isSelfSig(sig) {
+				continue
+			}
+			seen[sig.RIssuerKeyID] = true
+			for _, issuer := range p.Issuers(sig) {
+				if issuer.RFingerprint == key.RFingerprint {
+					continue
+				}
+				cert := uid.VerifiedControllingSignature(key, issuer)
+				if cert != nil && cert.SigType != 0x30 { // packet.SigTypeCertRevocation
+					certs += p.signatureScore(cert, t)
+				}
+			}
+		}
+	}
+	if p.Certifications > 0 {
+		result.Certifications = math.Min(1, certs/float64(p.Certifications))
+	}
+	result.Score = p.SelfSigWeight*result.SelfSigs + (1-p.SelfSigWeight)*result.Certifications
+	return result
+}
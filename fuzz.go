@@ -0,0 +1,54 @@
+//go:build gofuzz
+// +build gofuzz
+
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+)
+
+// Fuzz is an entry point for go-fuzz and libFuzzer. It reads keys from data,
+// checks their self-signatures and merges each of them with a second copy read
+// from the same input. Build with the gofuzz tag.
+func Fuzz(data []byte) int {
+	keys := fuzzReadKeys(data)
+	if len(keys) == 0 {
+		return 0
+	}
+	copies := fuzzReadKeys(data)
+	for i, key := range keys {
+		key.SelfSigs()
+		err := Merge(key, copies[i])
+		if err != nil {
+			return 0
+		}
+	}
+	return 1
+}
+
+func fuzzReadKeys(data []byte) []*PrimaryKey {
+	var result []*PrimaryKey
+	for readKey := range ReadKeys(bytes.NewReader(data)) {
+		if readKey.Error == nil && readKey.PrimaryKey != nil {
+			result = append(result, readKey.PrimaryKey)
+		}
+	}
+	return result
+}
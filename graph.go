@@ -0,0 +1,419 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Certification is an edge of a Graph: a certification by one key of a user
+// ID of another.
+type Certification struct {
+	// IssuerKeyID is the reversed key ID of the issuer, as given by the
+	// signature. Issuer is the reversed fingerprint of the issuing key, by
+	// which the certification was verified.
+	IssuerKeyID string
+	Issuer      string
+
+	// Target is the reversed fingerprint of the key certified, and UserID
+	// the user ID certified.
+	Target string
+	UserID string
+
+	Created    time.Time
+	Expiration time.Time
+	Class      CertificationClass
+
+	// TrustLevel and TrustAmount are those of the trust signature subpacket
+	// of the certification, if any, and TrustScope the regular expression
+	// limiting it, if any.
+	TrustLevel  int
+	TrustAmount int
+	TrustScope  string
+}
+
+// Graph is a web-of-trust graph of the certifications among a set of keys.
+// Each edge is the controlling certification, as given by
+// UserID.VerifiedControllingSignature, by an issuer of a user ID;
+// certifications which have been revoked are not included. Only
+// certifications verified by the primary key of an issuer in the graph are
+// edges, and those of issuers not in the graph are dropped. The
+// self-signatures of each key are verified too, to find whether it has been
+// revoked.
+//
+// Edges are held in a single slice, referred to from the keys by index, and
+// user IDs and issuer key IDs are interned, so that graphs of large keyrings
+// remain compact. Certifications by issuers not yet in the graph are retained
+// until the end, to be verified should their issuer be added. A Graph may be
+// read concurrently once built, but must not be added to while being read.
+type Graph struct {
+	nodes   []graphNode
+	edges   []graphEdge
+	byFP    map[string]int32
+	byKeyID map[string][]int32
+
+	strings   []string
+	stringIDs map[string]int32
+
+	// pending maps reversed issuer key IDs to the certifications claiming
+	// to be issued by them, which are verified as keys with that key ID are
+	// added.
+	pending map[string][]pendingCert
+
+	// verified records, for each claim, whether a key in the graph with the
+	// key ID it gives has verified its controlling signature.
+	verified []bool
+
+	// claims maps reversed fingerprints to the keys naming them as their
+	// predecessor, and supersessions to the keys naming them as their
+	// replacement.
+	claims        map[string][]int32
+	supersessions map[string][]int32
+}
+
+type graphNode struct {
+	rfp     string
+	rkeyid  string
+	created uint32
+	revoked bool
+
+	// in and out are the edges certifying the key and issued by it. pk is
+	// the primary key packet by which the certifications it issued are
+	// verified, or nil if they cannot be.
+	in  []int32
+	out []int32
+	pk  *packet.OpaquePacket
+
+	// claims are the certifications of its user IDs, one for each user ID
+	// and issuer key ID, indexing Graph.verified.
+	claims []int32
+
+	// uids are the interned user IDs of the key. predecessors are the
+	// reversed fingerprints of the keys it names as its predecessors, and
+	// supersededBy that of the key it names as its replacement.
+	uids         []int32
+	predecessors []string
+	supersededBy string
+}
+
+type graphEdge struct {
+	// issuer is the interned issuer key ID given by the certification, and
+	// from the key whose primary key verified it.
+	issuer  int32
+	from    int32
+	target  int32
+	uid     int32
+	created uint32
+	expires uint32
+	class   uint8
+	level   uint8
+	amount  uint8
+
+	// scope is the interned regular expression limiting the trust
+	// signature plus one, or zero if it is unlimited.
+	scope int32
+}
+
+// pendingCert holds the certifications and certification revocations of a
+// user ID claiming to be issued by one key ID, with what is needed to verify
+// them.
+type pendingCert struct {
+	claim     int32
+	target    int32
+	uid       int32
+	uidPacket *packet.OpaquePacket
+	signed    *packet.OpaquePacket
+	sigs      []*Signature
+}
+
+// NewGraph returns a new, empty Graph.
+func NewGraph() *Graph {
+	return &Graph{
+		byFP:          make(map[string]int32),
+		byKeyID:       make(map[string][]int32),
+		stringIDs:     make(map[string]int32),
+		pending:       make(map[string][]pendingCert),
+		claims:        make(map[string][]int32),
+		supersessions: make(map[string][]int32),
+	}
+}
+
+// BuildGraph returns the graph of certifications among all keys received from
+// c. Keys which could not be read are skipped.
+func BuildGraph(c PrimaryKeyChan) *Graph {
+	g := NewGraph()
+	for readKey := range c {
+		if readKey.Error == nil && readKey.PrimaryKey != nil {
+			g.Add(readKey.PrimaryKey)
+		}
+	}
+	return g
+}
+
+func (g *Graph) intern(s string) int32 {
+	if id, ok := g.stringIDs[s]; ok {
+		return id
+	}
+	id := int32(len(g.strings))
+	g.strings = append(g.strings, s)
+	g.stringIDs[s] = id
+	return id
+}
+
+// Len returns the number of keys in the graph.
+func (g *Graph) Len() int {
+	return len(g.nodes)
+}
+
+// Edges returns the number of certifications in the graph.
+func (g *Graph) Edges() int {
+	return len(g.edges)
+}
+
+// Add adds a key to the graph, with the certifications of its user IDs by
+// other keys. A key already in the graph is not added again.
+func (g *Graph) Add(key *PrimaryKey) {
+	if _, ok := g.byFP[key.RFingerprint]; ok {
+		return
+	}
+	target := int32(len(g.nodes))
+	selfSigs := key.SelfSigs()
+	g.nodes = append(g.nodes, graphNode{
+		rfp:     key.RFingerprint,
+		rkeyid:  key.RKeyID,
+		created: uint32(key.Creation.Unix()),
+		revoked: len(selfSigs.Revocations) > 0,
+	})
+	g.byFP[key.RFingerprint] = target
+	g.byKeyID[key.RKeyID] = append(g.byKeyID[key.RKeyID], target)
+	g.nodes[target].predecessors = selfSigs.predecessors()
+	for _, rfp := range g.nodes[target].predecessors {
+		g.claims[rfp] = append(g.claims[rfp], target)
+	}
+	if rfp, ok := selfSigs.SupersededBy(); ok {
+		g.nodes[target].supersededBy = rfp
+		g.supersessions[rfp] = append(g.supersessions[rfp], target)
+	}
+
+	// The built-in backend can only verify certifications of and by V4
+	// keys.
+	if op, err := key.opaquePacket(); err == nil {
+		if _, err := key.PublicKey.publicKeyPacket(); err == nil || backend != nil {
+			g.nodes[target].pk = op
+		}
+	}
+	for _, pc := range g.pending[key.RKeyID] {
+		g.verify(target, pc)
+	}
+
+	signed := g.nodes[target].pk
+	for _, uid := range key.UserIDs {
+		uidID := g.intern(uid.Keywords)
+		g.nodes[target].uids = append(g.nodes[target].uids, uidID)
+		if signed == nil {
+			continue
+		}
+		u, err := uid.opaquePacket()
+		if err != nil {
+			continue
+		}
+		byIssuer := make(map[string]*pendingCert)
+		var issuers []string
+		for _, sig := range uid.Signatures {
+			if len(sig.RIssuerKeyID) < len(key.RKeyID) || sig.IsWildcardIssuer() || strings.HasPrefix(key.UUID, sig.RIssuerKeyID) {
+				continue
+			}
+			_, isCert := sig.CertificationClass()
+			if !isCert && sig.SigType != 0x30 { // packet.SigTypeCertRevocation
+				continue
+			}
+			rkeyid := sig.RIssuerKeyID[:len(key.RKeyID)]
+			pc, ok := byIssuer[rkeyid]
+			if !ok {
+				pc = &pendingCert{claim: int32(len(g.verified)), target: target, uid: uidID, uidPacket: u, signed: signed}
+				g.verified = append(g.verified, false)
+				g.nodes[target].claims = append(g.nodes[target].claims, pc.claim)
+				byIssuer[rkeyid] = pc
+				issuers = append(issuers, rkeyid)
+			}
+			pc.sigs = append(pc.sigs, sig)
+		}
+		for _, rkeyid := range issuers {
+			pc := *byIssuer[rkeyid]
+			for _, issuer := range g.byKeyID[rkeyid] {
+				g.verify(issuer, pc)
+			}
+			g.pending[rkeyid] = append(g.pending[rkeyid], pc)
+		}
+	}
+}
+
+// verify adds the controlling certification of pc by the key at issuer as an
+// edge, if it verifies and has not been revoked.
+func (g *Graph) verify(issuer int32, pc pendingCert) {
+	signer := g.nodes[issuer].pk
+	if signer == nil || issuer == pc.target {
+		return
+	}
+	cert := controllingSignature(pc.sigs, Reverse(g.nodes[issuer].rfp), func(sig *Signature) error {
+		return verifyUserIDCertification(signer, pc.signed, pc.uidPacket, sig)
+	})
+	if cert == nil {
+		return
+	}
+	g.verified[pc.claim] = true
+	if cert.SigType == 0x30 { // packet.SigTypeCertRevocation
+		return
+	}
+	class, _ := cert.CertificationClass()
+	level, amount, _ := cert.TrustSignature()
+	e := graphEdge{
+		issuer:  g.intern(cert.RIssuerKeyID),
+		from:    issuer,
+		target:  pc.target,
+		uid:     pc.uid,
+		created: uint32(cert.Creation.Unix()),
+		class:   uint8(class),
+		level:   uint8(level),
+		amount:  uint8(amount),
+	}
+	if scope := trustScope(cert.TrustRegexps()); scope != "" {
+		e.scope = g.intern(scope) + 1
+	}
+	if cert.Expand() == nil && !cert.Expiration.IsZero() {
+		e.expires = uint32(cert.Expiration.Unix())
+	}
+	edge := int32(len(g.edges))
+	g.edges = append(g.edges, e)
+	g.nodes[pc.target].in = append(g.nodes[pc.target].in, edge)
+	g.nodes[issuer].out = append(g.nodes[issuer].out, edge)
+}
+
+func (g *Graph) certification(i int32) *Certification {
+	e := g.edges[i]
+	cert := &Certification{
+		IssuerKeyID: g.strings[e.issuer],
+		Issuer:      g.nodes[e.from].rfp,
+		Target:      g.nodes[e.target].rfp,
+		UserID:      g.strings[e.uid],
+		Created:     time.Unix(int64(e.created), 0),
+		Class:       CertificationClass(e.class),
+		TrustLevel:  int(e.level),
+		TrustAmount: int(e.amount),
+	}
+	if e.scope != 0 {
+		cert.TrustScope = g.strings[e.scope-1]
+	}
+	if e.expires != 0 {
+		cert.Expiration = time.Unix(int64(e.expires), 0)
+	}
+	return cert
+}
+
+// Certifiers returns the certifications made of the user IDs of the key with
+// the given reversed fingerprint.
+func (g *Graph) Certifiers(rfp string) []*Certification {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	var result []*Certification
+	for _, i := range g.nodes[node].in {
+		result = append(result, g.certification(i))
+	}
+	return result
+}
+
+// Certifications returns the certifications issued by the key with the given
+// reversed fingerprint.
+func (g *Graph) Certifications(rfp string) []*Certification {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	var result []*Certification
+	for _, i := range g.nodes[node].out {
+		result = append(result, g.certification(i))
+	}
+	return result
+}
+
+// CertificationStats summarizes the certifications of a key in a Graph.
+type CertificationStats struct {
+	// Certifiers is the number of distinct issuers certifying any user ID of
+	// the key, and Received the number of certifications they made; a
+	// certifier of several user IDs is counted once by Certifiers, and once
+	// per user ID by Received.
+	Certifiers int
+	Received   int
+
+	// Issued is the number of certifications made by the key, of user IDs of
+	// other keys in the graph. Certifications are attributed by verifying
+	// them, so keys sharing a key ID are each credited with their own.
+	Issued int
+
+	// Unverified is the number of issuers claimed by certifications of the
+	// key, counted once per user ID, which no key in the graph has been
+	// found to have made: those of issuers not in the graph, and those
+	// forged, or made by another key sharing the issuer's key ID.
+	Unverified int
+
+	// Oldest and Newest are the creation times of the earliest and latest
+	// certifications received, or zero if there are none.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Stats returns the certification statistics of the key with the given
+// reversed fingerprint, or nil if it is not in the graph. Only the
+// controlling certification of each issuer and user ID is counted.
+func (g *Graph) Stats(rfp string) *CertificationStats {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	stats := &CertificationStats{}
+	certifiers := make(map[int32]bool)
+	var oldest, newest uint32
+	for i, ei := range g.nodes[node].in {
+		e := g.edges[ei]
+		certifiers[e.from] = true
+		if i == 0 || e.created < oldest {
+			oldest = e.created
+		}
+		if i == 0 || e.created > newest {
+			newest = e.created
+		}
+	}
+	stats.Certifiers = len(certifiers)
+	stats.Received = len(g.nodes[node].in)
+	if stats.Received > 0 {
+		stats.Oldest = time.Unix(int64(oldest), 0)
+		stats.Newest = time.Unix(int64(newest), 0)
+	}
+	stats.Issued = len(g.nodes[node].out)
+	for _, claim := range g.nodes[node].claims {
+		if !g.verified[claim] {
+			stats.Unverified++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,182 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Neighborhood returns the reversed fingerprints of the keys within the given
+// number of certifications of the key with reversed fingerprint rfp, in either
+// direction, starting with the key itself. It returns nil if the key is not
+// in the graph.
+func (g *Graph) Neighborhood(rfp string, hops int) []string {
+	start, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	dist := map[int32]int{start: 0}
+	queue := []int32{start}
+	for i := 0; i < len(queue); i++ {
+		node := queue[i]
+		if dist[node] >= hops {
+			continue
+		}
+		visit := func(other int32) {
+			if _, ok := dist[other]; !ok {
+				dist[other] = dist[node] + 1
+				queue = append(queue, other)
+			}
+		}
+		for _, ei := range g.nodes[node].out {
+			visit(g.edges[ei].target)
+		}
+		for _, ei := range g.nodes[node].in {
+			visit(g.edges[ei].from)
+		}
+	}
+	result := make([]string, len(queue))
+	for i, node := range queue {
+		result[i] = g.nodes[node].rfp
+	}
+	return result
+}
+
+// exportNodes returns the keys with the given reversed fingerprints, or all
+// keys if rfps is nil, and the edges among them.
+func (g *Graph) exportNodes(rfps []string) ([]int32, []int32) {
+	var nodes []int32
+	include := make(map[int32]bool)
+	if rfps == nil {
+		for i := range g.nodes {
+			nodes = append(nodes, int32(i))
+			include[int32(i)] = true
+		}
+	} else {
+		for _, rfp := range rfps {
+			if node, ok := g.byFP[rfp]; ok && !include[node] {
+				nodes = append(nodes, node)
+				include[node] = true
+			}
+		}
+	}
+	var edges []int32
+	for _, node := range nodes {
+		for _, ei := range g.nodes[node].in {
+			if include[g.edges[ei].from] {
+				edges = append(edges, ei)
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// nodeLabel returns the key ID of the key at node, followed by its first user
+// ID, if any.
+func (g *Graph) nodeLabel(node int32) string {
+	label := strings.ToUpper(Reverse(g.nodes[node].rkeyid))
+	if uids := g.nodes[node].uids; len(uids) > 0 {
+		label += "\n" + g.strings[uids[0]]
+	}
+	return label
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", "")
+
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// WriteDOT renders the keys with the given reversed fingerprints, or the whole
+// graph if rfps is nil, and the certifications among them as a Graphviz DOT
+// digraph. Keys are identified by fingerprint and labelled with their key ID
+// and first user ID; certifications are labelled with the user ID certified.
+func (g *Graph) WriteDOT(w io.Writer, rfps []string) error {
+	nodes, edges := g.exportNodes(rfps)
+	var buf bytes.Buffer
+	buf.WriteString("digraph wot {\n")
+	for _, node := range nodes {
+		fmt.Fprintf(&buf, "\t%s [label=%s];\n",
+			dotQuote(Reverse(g.nodes[node].rfp)), dotQuote(g.nodeLabel(node)))
+	}
+	for _, ei := range edges {
+		e := g.edges[ei]
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n",
+			dotQuote(Reverse(g.nodes[e.from].rfp)), dotQuote(Reverse(g.nodes[e.target].rfp)),
+			dotQuote(g.strings[e.uid]))
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return errgo.Mask(err)
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// WriteGraphML renders the keys with the given reversed fingerprints, or the
+// whole graph if rfps is nil, and the certifications among them as GraphML.
+// Keys are identified by fingerprint, with their key ID and first user ID as
+// data; certifications carry the user ID certified, their creation time in
+// seconds since the epoch, their class and their trust level.
+func (g *Graph) WriteGraphML(w io.Writer, rfps []string) error {
+	nodes, edges := g.exportNodes(rfps)
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, key := range []struct{ id, domain, typ string }{
+		{"keyid", "node", "string"},
+		{"uid", "node", "string"},
+		{"certified", "edge", "string"},
+		{"created", "edge", "long"},
+		{"class", "edge", "string"},
+		{"trust", "edge", "int"},
+	} {
+		fmt.Fprintf(&buf, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", key.id, key.domain, key.id, key.typ)
+	}
+	buf.WriteString(`  <graph id="wot" edgedefault="directed">` + "\n")
+	for _, node := range nodes {
+		n := g.nodes[node]
+		fmt.Fprintf(&buf, "    <node id=%q>\n", Reverse(n.rfp))
+		fmt.Fprintf(&buf, "      <data key=\"keyid\">%s</data>\n", strings.ToUpper(Reverse(n.rkeyid)))
+		if len(n.uids) > 0 {
+			fmt.Fprintf(&buf, "      <data key=\"uid\">%s</data>\n", xmlEscape(g.strings[n.uids[0]]))
+		}
+		buf.WriteString("    </node>\n")
+	}
+	for _, ei := range edges {
+		e := g.edges[ei]
+		fmt.Fprintf(&buf, "    <edge source=%q target=%q>\n", Reverse(g.nodes[e.from].rfp), Reverse(g.nodes[e.target].rfp))
+		fmt.Fprintf(&buf, "      <data key=\"certified\">%s</data>\n", xmlEscape(g.strings[e.uid]))
+		fmt.Fprintf(&buf, "      <data key=\"created\">%d</data>\n", e.created)
+		fmt.Fprintf(&buf, "      <data key=\"class\">%s</data>\n", CertificationClass(e.class))
+		fmt.Fprintf(&buf, "      <data key=\"trust\">%d</data>\n", e.level)
+		buf.WriteString("    </edge>\n")
+	}
+	buf.WriteString("  </graph>\n</graphml>\n")
+	_, err := w.Write(buf.Bytes())
+	return errgo.Mask(err)
+}
@@ -0,0 +1,150 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrNoKeyText is the cause of errors for HKP submissions which contain no
+// armored key material.
+var ErrNoKeyText = errors.New("no armored key material submitted")
+
+// AddRequest is a key submission to an HKP /pks/add endpoint.
+type AddRequest struct {
+	// Options are the HKP options given with the submission, such as "mr".
+	Options []string
+
+	// Keys holds the result of reading each key submitted, in order.
+	Keys []*ReadKeyResult
+}
+
+// ParseAddForm parses the urlencoded body of an HKP /pks/add request.
+func ParseAddForm(body string, opts ...ReadOption) (*AddRequest, error) {
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return ParseAddValues(form, opts...)
+}
+
+// ParseAddValues parses the form values of an HKP /pks/add request, already
+// decoded, for example by http.Request.ParseForm.
+func ParseAddValues(form url.Values, opts ...ReadOption) (*AddRequest, error) {
+	req := &AddRequest{}
+	for _, option := range strings.Split(form.Get("options"), ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			req.Options = append(req.Options, option)
+		}
+	}
+	keys, err := ReadKeyText(form.Get("keytext"), opts...)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrNoKeyText))
+	}
+	req.Keys = keys
+	return req, nil
+}
+
+var (
+	armorBegin = "-----BEGIN " + openpgp.PublicKeyType + "-----"
+	armorEnd   = "-----END " + openpgp.PublicKeyType + "-----"
+)
+
+// ReadKeyText reads the keys in each armored public key block found in
+// keytext, which may be surrounded by other text. A block which cannot be
+// decoded gives a single result with its error.
+func ReadKeyText(keytext string, opts ...ReadOption) ([]*ReadKeyResult, error) {
+	var result []*ReadKeyResult
+	var blocks int
+	for {
+		start := strings.Index(keytext, armorBegin)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(keytext[start:], armorEnd)
+		if end < 0 {
+			break
+		}
+		end += start + len(armorEnd)
+		block := keytext[start:end]
+		keytext = keytext[end:]
+		blocks++
+
+		armorBlock, err := armor.Decode(strings.NewReader(block + "\n"))
+		if err != nil {
+			result = append(result, &ReadKeyResult{Error: errgo.Mask(err)})
+			continue
+		}
+		// Read the whole block first, so that one with a bad checksum is
+		// rejected before any of it is parsed.
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(armorBlock.Body)
+		if err != nil {
+			result = append(result, &ReadKeyResult{Error: errgo.Mask(err)})
+			continue
+		}
+		for readKey := range ReadKeys(&buf, opts...) {
+			result = append(result, readKey)
+		}
+	}
+	if blocks == 0 {
+		return nil, ErrNoKeyText
+	}
+	return result, nil
+}
+
+// WriteGetResponse writes keys as the response to an HKP op=get request: as a
+// single armored public key block with the given armor headers, such as
+// "Comment", or as concatenated binary packets if the options include
+// "binary". Keys are written in the order given, as search results are ranked,
+// except that repeats of a key already written are omitted. Only the user IDs
+// and user attributes of each key served by the policy are written.
+func WriteGetResponse(w io.Writer, keys []*PrimaryKey, options []string, headers map[string]string, policy *ServePolicy) error {
+	var unique []*PrimaryKey
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key.RFingerprint] {
+			continue
+		}
+		seen[key.RFingerprint] = true
+		unique = append(unique, key)
+	}
+
+	for _, option := range options {
+		if option == "binary" {
+			for _, key := range unique {
+				err := WriteServedPackets(w, key, policy)
+				if err != nil {
+					return errgo.Mask(err)
+				}
+			}
+			return nil
+		}
+	}
+	return writeArmoredPackets(w, unique, headers, func(w io.Writer, key *PrimaryKey) error {
+		return WriteServedPackets(w, key, policy)
+	})
+}
@@ -0,0 +1,169 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+)
+
+type HKPSuite struct{}
+
+var _ = gc.Suite(&HKPSuite{})
+
+func armoredEntity(c *gc.C, name string) (string, *openpgp.Entity) {
+	entity, err := openpgp.NewEntity(name, "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Serialize(w), gc.IsNil)
+	c.Assert(w.Close(), gc.IsNil)
+	return buf.String(), entity
+}
+
+func (s *HKPSuite) TestParseAddForm(c *gc.C) {
+	alice, aliceEntity := armoredEntity(c, "Alice")
+	bob, bobEntity := armoredEntity(c, "Bob")
+	keytext := "Alice's key:\r\n" + alice + "\r\nand Bob's:\r\n" + bob
+	body := url.Values{"keytext": {keytext}, "options": {"mr, nm"}}.Encode()
+
+	req, err := ParseAddForm(body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(req.Options, gc.DeepEquals, []string{"mr", "nm"})
+	c.Assert(req.Keys, gc.HasLen, 2)
+	for i, entity := range []*openpgp.Entity{aliceEntity, bobEntity} {
+		c.Assert(req.Keys[i].Error, gc.IsNil)
+		c.Assert(req.Keys[i].KeyID(), gc.Equals, strings.ToLower(entity.PrimaryKey.KeyIdString()))
+	}
+
+	_, err = ParseAddForm(url.Values{"keytext": {"not a key"}}.Encode())
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNoKeyText)
+}
+
+func (s *HKPSuite) TestReadKeyTextBadBlock(c *gc.C) {
+	alice, _ := armoredEntity(c, "Alice")
+	bad := armorBegin + "\n\nnot base64\n" + armorEnd
+	keys, err := ReadKeyText(bad + "\n" + alice)
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.HasLen, 2)
+	c.Assert(keys[0].Error, gc.NotNil)
+	c.Assert(keys[1].Error, gc.IsNil)
+}
+
+func (s *HKPSuite) TestWriteGetResponse(c *gc.C) {
+	alice, _ := armoredEntity(c, "Alice")
+	bob, _ := armoredEntity(c, "Bob")
+	keys, err := ReadKeyText(alice + bob + alice)
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.HasLen, 3)
+	var input []*PrimaryKey
+	for _, key := range keys {
+		input = append(input, key.PrimaryKey)
+	}
+
+	var buf bytes.Buffer
+	err = WriteGetResponse(&buf, input, []string{"mr"}, map[string]string{"Comment": "Hostname: example.com"}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(strings.Count(buf.String(), armorBegin), gc.Equals, 1)
+	c.Assert(buf.String(), gc.Matches, "(?s).*\nComment: Hostname: example.com\n.*")
+	output, err := ReadKeyText(buf.String())
+	c.Assert(err, gc.IsNil)
+	c.Assert(output, gc.HasLen, 2)
+	c.Assert(output[0].RFingerprint, gc.Equals, input[0].RFingerprint)
+	c.Assert(output[1].RFingerprint, gc.Equals, input[1].RFingerprint)
+	c.Assert(output[0].MD5, gc.Equals, input[0].MD5)
+
+	buf.Reset()
+	err = WriteGetResponse(&buf, input, []string{"binary"}, nil, nil)
+	c.Assert(err, gc.IsNil)
+	binary := ReadKeys(&buf).MustParse()
+	c.Assert(binary, gc.HasLen, 2)
+	c.Assert(binary[1].MD5, gc.Equals, input[1].MD5)
+}
+
+func (s *HKPSuite) TestRequireValidSelfSigs(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	// A user ID without a self-signature, and one whose only
+	// self-signature has expired.
+	c.Assert(packet.NewUserId("Mallory", "", "").Serialize(&buf), gc.IsNil)
+	old := packet.NewUserId("Old", "", "old@example.com")
+	c.Assert(old.Serialize(&buf), gc.IsNil)
+	hour := uint32(60 * 60)
+	sig := &packet.Signature{
+		SigType:         packet.SigTypePositiveCert,
+		PubKeyAlgo:      alice.PrimaryKey.PubKeyAlgo,
+		Hash:            crypto.SHA256,
+		CreationTime:    time.Now().Add(-2 * time.Hour),
+		IssuerKeyId:     &alice.PrimaryKey.KeyId,
+		SigLifetimeSecs: &hour,
+	}
+	c.Assert(sig.SignUserId(old.Id, alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+	c.Assert(sig.Serialize(&buf), gc.IsNil)
+	// A revoked user ID, whose revocation supersedes its certification.
+	revoked := packet.NewUserId("Revoked", "", "revoked@example.com")
+	c.Assert(revoked.Serialize(&buf), gc.IsNil)
+	for i, sigType := range []packet.SignatureType{packet.SigTypePositiveCert, packet.SignatureType(0x30)} {
+		sig := &packet.Signature{
+			SigType:      sigType,
+			PubKeyAlgo:   alice.PrimaryKey.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: time.Now().Add(time.Duration(i-2) * time.Hour),
+			IssuerKeyId:  &alice.PrimaryKey.KeyId,
+		}
+		c.Assert(sig.SignUserId(revoked.Id, alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+		c.Assert(sig.Serialize(&buf), gc.IsNil)
+	}
+	key := ReadKeys(&buf).MustParse()[0]
+	c.Assert(key.UserIDs, gc.HasLen, 4)
+	c.Assert(key.ServedUserIDs(nil), gc.HasLen, 4)
+
+	policy := &ServePolicy{RequireValidSelfSigs: true}
+	served := key.ServedUserIDs(policy)
+	c.Assert(served, gc.HasLen, 2)
+	c.Assert(served[0].Keywords, gc.Equals, "Alice")
+	c.Assert(served[1].Keywords, gc.Equals, revoked.Id)
+	c.Assert(key.UserIDs, gc.HasLen, 4)
+
+	buf.Reset()
+	c.Assert(WriteGetResponse(&buf, []*PrimaryKey{key}, []string{"binary"}, nil, policy), gc.IsNil)
+	output := ReadKeys(&buf).MustParse()
+	c.Assert(output, gc.HasLen, 1)
+	c.Assert(output[0].UserIDs, gc.HasLen, 2)
+	c.Assert(output[0].MD5, gc.Not(gc.Equals), key.MD5)
+	// The revoked user ID is served with its revocation.
+	c.Assert(output[0].UserIDs[1].Signatures, gc.HasLen, 2)
+	c.Assert(output[0].UserIDs[1].SelfSigs(output[0]).Revocations, gc.HasLen, 1)
+
+	// The key itself, and so its digest, is unchanged.
+	buf.Reset()
+	c.Assert(WritePackets(&buf, key), gc.IsNil)
+	c.Assert(ReadKeys(&buf).MustParse()[0].MD5, gc.Equals, key.MD5)
+	c.Assert(storageKeywords(key, policy), gc.DeepEquals, []string{"alice", "revoked <revoked@example.com>", "revoked@example.com"})
+}
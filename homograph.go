@@ -0,0 +1,296 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/errgo.v1"
+)
+
+// userIDEmail returns the email address in a user ID, which is either
+// enclosed in angle brackets or the whole user ID.
+func userIDEmail(keywords string) string {
+	if i := strings.LastIndex(keywords, "<"); i >= 0 {
+		if j := strings.Index(keywords[i:], ">"); j > 0 {
+			return keywords[i+1 : i+j]
+		}
+		return ""
+	}
+	if strings.Count(keywords, "@") == 1 && !strings.ContainsAny(keywords, " \t") {
+		return keywords
+	}
+	return ""
+}
+
+func lintHomographEmail(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, uid := range key.UserIDs {
+		email := userIDEmail(uid.Keywords)
+		at := strings.LastIndex(email, "@")
+		if at < 0 {
+			continue
+		}
+		if problem := homograph(email[at+1:]); problem != "" {
+			result = append(result, &LintWarning{
+				Code:    LintHomographEmail,
+				UUID:    uid.UUID,
+				Message: fmt.Sprintf("email domain %q %s", email[at+1:], problem),
+			})
+		}
+	}
+	return result
+}
+
+// homograph returns why an email domain may be an IDN homograph of another
+// domain, or the empty string if there is no reason to think so. Punycode
+// labels are decoded, and the domain is then checked for characters which IDNA
+// maps to ASCII, for labels mixing scripts, and for labels written entirely
+// in characters confusable with ASCII letters.
+func homograph(domain string) string {
+	labels := strings.FieldsFunc(strings.ToLower(domain), isIDNADot)
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := decodePunycode(label[4:])
+		if err != nil {
+			return fmt.Sprintf("has an invalid punycode label %q", label)
+		}
+		labels[i] = decoded
+	}
+	unicodeDomain := strings.Join(labels, ".")
+
+	if !isASCII(unicodeDomain) {
+		mapped := strings.Map(idnaMapASCII, unicodeDomain)
+		if isASCII(mapped) {
+			return fmt.Sprintf("maps to ASCII domain %q", mapped)
+		}
+	}
+	for _, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if scripts := labelScripts(label); !allowedScripts(scripts) {
+			return fmt.Sprintf("mixes %s scripts in label %q", strings.Join(scripts, " and "), label)
+		}
+		if skeleton, ok := asciiSkeleton(label); ok {
+			return fmt.Sprintf("label %q looks like %q", label, skeleton)
+		}
+	}
+	return ""
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isIDNADot returns whether r separates domain labels under IDNA.
+func isIDNADot(r rune) bool {
+	return r == '.' || r == '。' || r == '．' || r == '｡'
+}
+
+// idnaMapASCII maps the fullwidth forms of ASCII characters to ASCII, as IDNA
+// mapping does.
+func idnaMapASCII(r rune) rune {
+	if r >= '！' && r <= '～' {
+		return unicode.ToLower(r - 0xfee0)
+	}
+	return r
+}
+
+// labelScripts returns the names of the scripts of the letters in a label,
+// ignoring characters common to all scripts, such as digits and hyphens.
+func labelScripts(label string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, r := range label {
+		for name, table := range unicode.Scripts {
+			if name == "Common" || name == "Inherited" || !unicode.Is(table, r) {
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+			break
+		}
+	}
+	return names
+}
+
+// scriptCombinations are the combinations of scripts which may be mixed in a
+// label, as in Unicode's "highly restrictive" profile: Latin may be written
+// with Chinese, Japanese or Korean.
+var scriptCombinations = [][]string{
+	{"Latin", "Han", "Hiragana", "Katakana"},
+	{"Latin", "Han", "Bopomofo"},
+	{"Latin", "Han", "Hangul"},
+}
+
+func allowedScripts(scripts []string) bool {
+	if len(scripts) <= 1 {
+		return true
+	}
+	for _, combination := range scriptCombinations {
+		allowed := true
+		for _, script := range scripts {
+			allowed = allowed && containsString(combination, script)
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// asciiConfusables maps Cyrillic and Greek letters to the ASCII letters they
+// are easily mistaken for.
+var asciiConfusables = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ԁ': 'd', 'ӏ': 'l', 'ѕ': 's', 'һ': 'h', 'ԛ': 'q',
+	'ԝ': 'w', 'ь': 'b',
+	'ο': 'o', 'α': 'a', 'ν': 'v', 'ι': 'i', 'κ': 'k', 'υ': 'u', 'ρ': 'p',
+	'χ': 'x',
+}
+
+// asciiSkeleton returns the ASCII label which a label is confusable with, if
+// all its letters are confusable with ASCII letters.
+func asciiSkeleton(label string) (string, bool) {
+	skeleton := make([]rune, 0, len(label))
+	for _, r := range label {
+		if r < utf8.RuneSelf {
+			skeleton = append(skeleton, r)
+		} else if c, ok := asciiConfusables[r]; ok {
+			skeleton = append(skeleton, c)
+		} else {
+			return "", false
+		}
+	}
+	return string(skeleton), true
+}
+
+// Punycode parameters, from RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+	punyMaxRune     = unicode.MaxRune
+)
+
+// decodePunycode decodes a punycode label, without its "xn--" prefix, as
+// specified in RFC 3492.
+func decodePunycode(s string) (string, error) {
+	var output []rune
+	if pos := strings.LastIndex(s, "-"); pos >= 0 {
+		for i := 0; i < pos; i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", errgo.New("non-basic code point")
+			}
+			output = append(output, rune(s[i]))
+		}
+		s = s[pos+1:]
+	}
+	n, bias, i := punyInitialN, punyInitialBias, 0
+	for k := 0; k < len(s); {
+		oldi, w := i, 1
+		for t := punyBase; ; t += punyBase {
+			if k >= len(s) {
+				return "", errgo.New("truncated punycode")
+			}
+			digit, ok := punyDigit(s[k])
+			k++
+			if !ok {
+				return "", errgo.Newf("invalid punycode digit %q", s[k-1])
+			}
+			if digit > (punyMaxRune-i)/w {
+				return "", errgo.New("punycode overflow")
+			}
+			i += digit * w
+			tt := t - bias
+			if tt < punyTMin {
+				tt = punyTMin
+			} else if tt > punyTMax {
+				tt = punyTMax
+			}
+			if digit < tt {
+				break
+			}
+			w *= punyBase - tt
+		}
+		bias = punyAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > punyMaxRune {
+			return "", errgo.New("punycode overflow")
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
+
+func punyDigit(b byte) (int, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return int(b-'0') + 26, true
+	case b >= 'a' && b <= 'z':
+		return int(b - 'a'), true
+	case b >= 'A' && b <= 'Z':
+		return int(b - 'A'), true
+	}
+	return 0, false
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -31,14 +31,16 @@ import (
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 	"gopkg.in/errgo.v1"
-
-	log "gopkg.in/schmorrison/logrus.v0"
 )
 
 var ErrMissingSignature = fmt.Errorf("Key material missing an expected signature")
 
 func WritePackets(w io.Writer, key *PrimaryKey) error {
-	for _, node := range key.contents() {
+	return writeNodes(w, key.contents())
+}
+
+func writeNodes(w io.Writer, nodes []packetNode) error {
+	for _, node := range nodes {
 		op, err := newOpaquePacket(node.packet().Packet)
 		if err != nil {
 			return errgo.Mask(err)
@@ -51,19 +53,57 @@ func WritePackets(w io.Writer, key *PrimaryKey) error {
 	return nil
 }
 
+// WriteTo implements io.WriterTo, writing the packets of the key as
+// WritePackets.
+func (pubkey *PrimaryKey) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := WritePackets(cw, pubkey)
+	return cw.n, errgo.Mask(err, errgo.Any)
+}
+
+// ReadFrom implements io.ReaderFrom, replacing the key with one read from the
+// binary packets in r, which must hold exactly one key. As when reading a
+// stored key, packets of unknown types are retained.
+func (pubkey *PrimaryKey) ReadFrom(r io.Reader) (int64, error) {
+	or := &offsetReader{r: r}
+	key, err := readStoredKey(or)
+	if err != nil {
+		return or.n, errgo.Mask(err, errgo.Any)
+	}
+	*pubkey = *key
+	return or.n, nil
+}
+
+// countingWriter keeps track of the number of bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 func WriteArmoredPackets(w io.Writer, roots []*PrimaryKey) error {
-	armw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
-	defer armw.Close()
+	return writeArmoredPackets(w, roots, nil, WritePackets)
+}
+
+func writeArmoredPackets(w io.Writer, roots []*PrimaryKey, headers map[string]string,
+	write func(io.Writer, *PrimaryKey) error) error {
+	armw, err := armor.Encode(w, openpgp.PublicKeyType, headers)
 	if err != nil {
 		return errgo.Mask(err)
 	}
 	for _, node := range roots {
-		err = WritePackets(armw, node)
+		err = write(armw, node)
 		if err != nil {
+			armw.Close()
 			return errgo.Mask(err)
 		}
 	}
-	return nil
+	return errgo.Mask(armw.Close())
 }
 
 type OpaqueKeyring struct {
@@ -73,82 +113,197 @@ type OpaqueKeyring struct {
 	Sha256       string
 	Error        error
 	Position     int64
+
+	// strings interns identifiers across all keyrings read from the same
+	// stream.
+	strings *stringTable
+
+	// Origins records where each of the Packets was read from, if the
+	// keyring was read from input rather than constructed.
+	Origins []*PacketOrigin
+
+	// dropped records packets other than key material which were discarded
+	// while reading the keyring.
+	dropped []*SkippedPacket
+
+	// partial records that the keyring was read with IndexOnly or SkipTags,
+	// and so may be missing packets.
+	partial bool
+
+	// base is the difference between the Position of the keyring and the
+	// stream offset at which it started, by which the offsets of its
+	// packets are adjusted.
+	base int64
+}
+
+// appendPacket appends a packet read with the given origin to the keyring.
+func (okr *OpaqueKeyring) appendPacket(op *packet.OpaquePacket, origin *PacketOrigin) {
+	if origin != nil {
+		origin.Offset += okr.base
+	}
+	okr.Packets = append(okr.Packets, op)
+	okr.Origins = append(okr.Origins, origin)
 }
 
-func (okr *OpaqueKeyring) setPosition(r io.Reader) {
-	f, ok := r.(*os.File)
-	if ok {
+// origin returns the origin of the i'th packet of the keyring, or nil if it
+// is not known.
+func (okr *OpaqueKeyring) origin(i int) *PacketOrigin {
+	if i < len(okr.Origins) {
+		return okr.Origins[i]
+	}
+	return nil
+}
+
+// setPosition sets the keyring position, given the offset at which it started
+// among the n octets read so far from r. The position is relative to the start
+// of the file if r is one, otherwise to the start of the stream.
+func (okr *OpaqueKeyring) setPosition(r io.Reader, offset, n int64) {
+	okr.Position = offset
+	if f, ok := r.(*os.File); ok {
 		pos, err := f.Seek(0, 1)
 		if err == nil {
-			okr.Position = pos
-			return
+			okr.Position = pos - (n - offset)
+		}
+	}
+	okr.base = okr.Position - offset
+	for _, origin := range okr.Origins {
+		if origin != nil {
+			origin.Offset += okr.base
 		}
 	}
-	okr.Position = -1
 }
 
+// Parse parses the keyring into a primary key, and calculates its digest. It
+// fails with an error caused by ErrPartialKeyring if the keyring was read with
+// IndexOnly or SkipTags, as its digest would not be that of the key.
 func (ok *OpaqueKeyring) Parse() (*PrimaryKey, error) {
-	var err error
-	var pubkey *PrimaryKey
+	if ok.partial {
+		return nil, errgo.WithCausef(nil, ErrPartialKeyring, "cannot digest partial keyring")
+	}
+	pubkey, _, err := ok.parse(&readOptions{})
+	return pubkey, err
+}
+
+// parse parses the keyring into a primary key, also returning the packets
+// which were not accepted as key material. A panic while parsing is recovered
+// and returned as a PanicError.
+func (ok *OpaqueKeyring) parse(opts *readOptions) (pubkey *PrimaryKey, skipped []*SkippedPacket, err error) {
+	var current *packet.OpaquePacket
+	// at is the offset of the current packet in the input, where it is
+	// known, for reporting errors.
+	var offset, nextOffset, at int64
+	defer func() {
+		if r := recover(); r != nil {
+			pubkey, skipped, err = nil, nil, newPanicError(r, current, at)
+		}
+		if err != nil {
+			metrics.ParseFailed(errgo.Cause(err))
+		} else {
+			metrics.KeyParsed()
+		}
+	}()
+
+	if errgo.Cause(ok.Error) == ErrPacketTooLarge {
+		return nil, nil, ok.Error
+	}
 	var signablePacket signable
-	for _, opkt := range ok.Packets {
+	skipped = append([]*SkippedPacket(nil), ok.dropped...)
+	arena := newPacketArena(ok.Packets)
+	for i, opkt := range ok.Packets {
+		current = opkt
+		origin := ok.origin(i)
+		offset, nextOffset = nextOffset, nextOffset+serializedLen(opkt)
+		at = offset
+		if origin != nil {
+			at = origin.Offset
+		}
+		if opts.observer != nil {
+			opts.observer.ObservePacket(opkt.Tag, len(opkt.Contents))
+		}
+		if isSecretKeyTag(opkt.Tag) {
+			return nil, nil, &PacketError{Err: ErrSecretKeyMaterial, Tag: opkt.Tag, Offset: at}
+		}
 		var badPacket *packet.OpaquePacket
+		var badReason SkipReason
+		var badErr error
 		if opkt.Tag == 6 { //packet.PacketTypePublicKey:
 			if pubkey != nil {
-				return nil, errgo.Newf("multiple public keys in keyring")
+				return nil, nil, &PacketError{Err: ErrMultiplePrimaryKeys, Tag: opkt.Tag, Offset: at}
 			}
-			pubkey, err = ParsePrimaryKey(opkt)
+			pubkey, err = parsePrimaryKey(opkt, arena)
 			if err != nil {
-				return nil, errgo.Notef(err, "invalid public key packet type")
+				return nil, nil, &PacketError{Err: ErrInvalidPacketType, Tag: opkt.Tag, Offset: at, Underlying: err}
 			}
+			pubkey.Origin = origin
 			signablePacket = pubkey
 		} else if pubkey != nil {
+			if opts.skip(opkt.Tag) {
+				continue
+			}
+			if isPaddingTag(opkt.Tag) {
+				skipped = append(skipped, &SkippedPacket{
+					Tag:    opkt.Tag,
+					Offset: offset,
+					Reason: SkipPadding,
+					Digest: opaqueDigest(opkt),
+				})
+				continue
+			}
 			switch opkt.Tag {
 			case 14: //packet.PacketTypePublicSubKey:
 				signablePacket = nil
-				subkey, err := ParseSubKey(opkt)
+				subkey, err := parseSubKey(opkt, arena)
 				if err != nil {
-					log.Debugf("unreadable subkey packet: %v", err)
-					badPacket = opkt
+					badPacket, badReason, badErr = opkt, SkipUnparseable, err
 				} else {
+					subkey.Origin = origin
 					pubkey.SubKeys = append(pubkey.SubKeys, subkey)
 					signablePacket = subkey
 				}
 			case 13: //packet.PacketTypeUserId:
 				signablePacket = nil
-				uid, err := ParseUserID(opkt, pubkey.UUID)
+				uid, err := parseUserID(opkt, pubkey.UUID, arena)
 				if err != nil {
-					log.Debugf("unreadable user id packet: %v", err)
-					badPacket = opkt
+					badPacket, badReason, badErr = opkt, SkipUnparseable, err
 				} else {
+					if opts.replaceUTF8 {
+						err = uid.repairKeywords()
+						if err != nil {
+							return nil, nil, errgo.Mask(err)
+						}
+					}
+					uid.Origin = origin
 					pubkey.UserIDs = append(pubkey.UserIDs, uid)
 					signablePacket = uid
 				}
 			case 17: //packet.PacketTypeUserAttribute:
 				signablePacket = nil
-				uat, err := ParseUserAttribute(opkt, pubkey.UUID)
+				uat, err := parseUserAttribute(opkt, pubkey.UUID, arena)
 				if err != nil {
-					log.Debugf("unreadable user attribute packet: %v", err)
-					badPacket = opkt
+					badPacket, badReason, badErr = opkt, SkipUnparseable, err
 				} else {
+					uat.Origin = origin
 					pubkey.UserAttributes = append(pubkey.UserAttributes, uat)
 					signablePacket = uat
 				}
 			case 2: //packet.PacketTypeSignature:
 				if signablePacket == nil {
-					log.Debugf("signature out of context")
-					badPacket = opkt
+					badPacket, badReason = opkt, SkipOutOfContext
 				} else {
-					sig, err := ParseSignature(opkt, pubkey.UUID, signablePacket.uuid())
+					sig, err := parseSignature(opkt, pubkey.UUID, signablePacket.uuid(), arena, true)
 					if err != nil {
-						log.Debugf("unreadable signature packet: %v", err)
-						badPacket = opkt
+						badPacket, badReason, badErr = opkt, SkipUnparseable, err
 					} else {
+						sig.RIssuerKeyID = ok.strings.intern(sig.RIssuerKeyID)
+						sig.Origin = origin
 						signablePacket.appendSignature(sig)
 					}
 				}
 			default:
-				badPacket = opkt
+				if isUnknownTag(opkt.Tag) && opts.unknown == RejectUnknownPackets {
+					return nil, nil, &PacketError{Err: ErrUnknownPacket, Tag: opkt.Tag, Offset: at}
+				}
+				badPacket, badReason = opkt, SkipUnknownTag
 			}
 
 			if badPacket != nil {
@@ -158,79 +313,286 @@ func (ok *OpaqueKeyring) Parse() (*PrimaryKey, error) {
 				} else {
 					badParent = pubkey.uuid()
 				}
-				other, err := ParseOther(badPacket, badParent)
+				other, err := parseOther(badPacket, badParent, arena)
 				if err != nil {
-					return nil, errgo.Mask(err)
+					return nil, nil, errgo.Mask(err)
+				}
+				other.Origin = origin
+				// Packets are kept with the user ID, user attribute or
+				// sub-key they follow, so that they are written back
+				// out in place.
+				switch parent := signablePacket.(type) {
+				case *UserID:
+					parent.Others = append(parent.Others, other)
+				case *UserAttribute:
+					parent.Others = append(parent.Others, other)
+				case *SubKey:
+					parent.Others = append(parent.Others, other)
+				default:
+					pubkey.Others = append(pubkey.Others, other)
+				}
+				skip := &SkippedPacket{
+					Tag:      badPacket.Tag,
+					Offset:   offset,
+					Reason:   badReason,
+					Digest:   packetDigest(other.Packet),
+					Err:      badErr,
+					Retained: true,
 				}
-				pubkey.Others = append(pubkey.Others, other)
+				skipped = append(skipped, skip)
+				eventLogger.LogEvent(&Event{
+					Kind:         EventSkippedPacket,
+					RFingerprint: pubkey.RFingerprint,
+					Tag:          skip.Tag,
+					Digest:       skip.Digest,
+					Message:      "skipped packet: " + skip.Reason.String(),
+					Err:          skip.Err,
+				})
 			}
 		}
 	}
+	current = nil
 	if pubkey == nil {
-		return nil, errgo.New("primary public key not found")
+		return nil, nil, ErrNoPrimaryKey
+	}
+	if opts.partial() {
+		return pubkey, skipped, nil
 	}
 	pubkey.MD5, err = SksDigest(pubkey, md5.New())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return pubkey, skipped, nil
+}
+
+// result parses the keyring into a ReadKeyResult.
+func (ok *OpaqueKeyring) result(opts *readOptions) *ReadKeyResult {
+	if ok.Error != nil && len(ok.Packets) == 0 {
+		return &ReadKeyResult{Error: ok.Error}
+	}
+	truncated, _ := ok.Error.(*TruncationError)
+	pubkey, skipped, err := ok.parse(opts)
+	if err == ErrNoPrimaryKey {
+		if revs := ok.revocationCerts(); len(revs) > 0 {
+			return &ReadKeyResult{Revocations: revs}
+		}
+	}
+	if err != nil {
+		return &ReadKeyResult{Error: err}
+	}
+	result := &ReadKeyResult{
+		PrimaryKey: pubkey,
+		Partial:    opts.partial(),
+		Skipped:    skipped,
+		Truncated:  truncated,
 	}
-	return pubkey, nil
+	if opts.lint {
+		result.Warnings = Lint(pubkey)
+	}
+	return result
 }
 
 type OpaqueKeyringChan chan *OpaqueKeyring
 
-func ReadOpaqueKeyrings(r io.Reader) OpaqueKeyringChan {
+// ReadOpaqueKeyrings reads packets from input, grouped into keyrings by primary
+// public key, and sends them on a channel. Only the SkipTags, IndexOnly,
+// SecretKeys, UnknownPackets, MaxPacketLen and WithProgress options have an
+// effect on opaque reading.
+//
+// The body of a packet longer than MaxPacketLen allows is skipped over as it
+// is read, without being held in memory. The packet is left in its keyring
+// with empty contents, and the keyring carries an error caused by
+// ErrPacketTooLarge.
+func ReadOpaqueKeyrings(r io.Reader, opts ...ReadOption) OpaqueKeyringChan {
 	c := make(OpaqueKeyringChan)
-	or := packet.NewOpaqueReader(r)
+	kc := newKeyringCollector(c, opts)
+	or := &offsetReader{r: r}
+	pr := &OpaqueReader{r: or, MaxLength: int64(kc.opts.maxPacketLen)}
 	go func() {
 		defer close(c)
-		var op *packet.OpaquePacket
-		var err error
-		var current *OpaqueKeyring
-		for op, err = or.Next(); err == nil; op, err = or.Next() {
-			switch op.Tag {
-			case 6: //packet.PacketTypePublicKey:
-				if current != nil {
-					c <- current
-					current = nil
-				}
-				current = &OpaqueKeyring{}
-				current.setPosition(r)
-				fallthrough
-			case 2, 13, 14, 17:
-				//packet.PacketTypeUserId,
-				//packet.PacketTypeUserAttribute,
-				//packet.PacketTypePublicSubKey,
-				//packet.PacketTypeSignature
-				if current != nil {
-					current.Packets = append(current.Packets, op)
-				}
+		for kc.err == nil {
+			offset := or.n
+			op, h, err := pr.next()
+			tooLarge := errgo.Cause(err) == ErrPacketTooLarge
+			if tooLarge {
+				op, err = &packet.OpaquePacket{Tag: h.tag}, nil
 			}
-		}
-		if err == io.EOF && current != nil {
-			c <- current
-		} else if err != nil {
-			if current == nil {
-				current = &OpaqueKeyring{}
+			if err != nil {
+				kc.finish(err, offset)
+				return
+			}
+			origin := newPacketOrigin(h, offset, or.n)
+			if started := kc.add(op, origin); started != nil {
+				started.setPosition(r, offset, or.n)
+			}
+			if tooLarge {
+				kc.reject(op, &PacketError{Err: ErrPacketTooLarge, Tag: op.Tag, Offset: origin.Offset})
 			}
-			current.Error = errgo.Mask(err)
-			c <- current
 		}
+		kc.finish(nil, or.n)
 	}()
 	return c
 }
 
+// keyringCollector groups a stream of opaque packets into keyrings, one per
+// primary public key, and sends them on a channel.
+type keyringCollector struct {
+	c       OpaqueKeyringChan
+	opts    *readOptions
+	strings *stringTable
+	current *OpaqueKeyring
+
+	// err is set when reading must stop before the end of the input.
+	err error
+
+	// sent is the number of keyrings sent so far.
+	sent int
+}
+
+func newKeyringCollector(c OpaqueKeyringChan, opts []ReadOption) *keyringCollector {
+	return &keyringCollector{
+		c:       c,
+		opts:    newReadOptions(opts),
+		strings: newStringTable(),
+	}
+}
+
+// add adds a packet, read with the given origin, to the current keyring. If
+// the packet is a primary public key, the previous keyring is sent and the
+// newly started one is returned.
+func (kc *keyringCollector) add(op *packet.OpaquePacket, origin *PacketOrigin) *OpaqueKeyring {
+	offset := origin.Offset
+	if isSecretKeyTag(op.Tag) && kc.opts.secretKeys == AbortOnSecretKeys {
+		kc.current = nil
+		kc.err = errgo.WithCausef(nil, ErrSecretKeyMaterial,
+			"input rejected: secret key material in packet with tag %d", op.Tag)
+		return nil
+	}
+	if isSecretKeyTag(op.Tag) && kc.opts.secretKeys == ExtractPublicKeys {
+		pub, err := publicKeyPacket(op)
+		if err != nil {
+			// Leave the secret packet in place, so that the key is
+			// rejected when parsed.
+			eventLogger.LogEvent(&Event{
+				Kind:    EventUnreadableSecretKey,
+				Tag:     op.Tag,
+				Digest:  opaqueDigest(op),
+				Message: "unreadable secret key packet",
+				Err:     err,
+			})
+		} else {
+			op = pub
+		}
+	}
+	if kc.opts.skip(op.Tag) {
+		return nil
+	}
+	var started *OpaqueKeyring
+	switch op.Tag {
+	case 6, 5: //packet.PacketTypePublicKey, packet.PacketTypePrivateKey:
+		if kc.current != nil {
+			kc.send(kc.current, offset)
+			kc.current = nil
+		}
+		kc.current = &OpaqueKeyring{strings: kc.strings}
+		started = kc.current
+		fallthrough
+	case 2, 7, 13, 14, 17:
+		//packet.PacketTypeUserId,
+		//packet.PacketTypeUserAttribute,
+		//packet.PacketTypePublicSubKey,
+		//packet.PacketTypePrivateSubkey,
+		//packet.PacketTypeSignature
+		if kc.current != nil && !kc.current.standalone() {
+			kc.current.appendPacket(op, origin)
+		} else if op.Tag == 2 && isKeyRevocation(op) {
+			// Signatures preceding any key may be standalone
+			// revocation certificates. Other stray packets are
+			// ignored, as they belong to no key.
+			if kc.current == nil {
+				kc.current = &OpaqueKeyring{strings: kc.strings}
+			}
+			kc.current.appendPacket(op, origin)
+		}
+	default:
+		if isUnknownTag(op.Tag) && kc.opts.unknown != DropUnknownPackets {
+			// Left for parsing to retain or reject.
+			if kc.current != nil {
+				kc.current.appendPacket(op, origin)
+			}
+			break
+		}
+		// Trust packets and the like, which are not key material.
+		if kc.current != nil {
+			reason := SkipNotKeyMaterial
+			if isPaddingTag(op.Tag) {
+				reason = SkipPadding
+			} else if isUnknownTag(op.Tag) {
+				reason = SkipUnknownTag
+			}
+			kc.current.dropped = append(kc.current.dropped, &SkippedPacket{
+				Tag:    op.Tag,
+				Offset: -1,
+				Reason: reason,
+				Digest: opaqueDigest(op),
+			})
+		}
+	}
+	return started
+}
+
+// reject sets the error of the current keyring, if op was added to it and it
+// has no error yet.
+func (kc *keyringCollector) reject(op *packet.OpaquePacket, err error) {
+	okr := kc.current
+	if okr == nil || okr.Error != nil || len(okr.Packets) == 0 || okr.Packets[len(okr.Packets)-1] != op {
+		return
+	}
+	okr.Error = err
+}
+
+// finish sends the last keyring, given the error which ended the stream and
+// the offset at which it occurred. If reading was aborted, a keyring carrying
+// only the reason is sent instead.
+func (kc *keyringCollector) finish(err error, offset int64) {
+	if kc.err != nil {
+		kc.send(&OpaqueKeyring{Error: kc.err}, offset)
+		return
+	}
+	if err == io.EOF && kc.current != nil {
+		kc.send(kc.current, offset)
+	} else if err != nil {
+		if kc.current == nil {
+			kc.current = &OpaqueKeyring{}
+		}
+		if errgo.Cause(err) == io.ErrUnexpectedEOF {
+			kc.current.Error = &TruncationError{Offset: offset, Underlying: err}
+		} else {
+			kc.current.Error = errgo.Mask(err, errgo.Any)
+		}
+		kc.send(kc.current, offset)
+	}
+}
+
+// send sends a keyring, given the number of octets of input consumed up to
+// its end, and reports progress.
+func (kc *keyringCollector) send(okr *OpaqueKeyring, n int64) {
+	okr.partial = kc.opts.partial()
+	kc.c <- okr
+	kc.sent++
+	if kc.opts.progress != nil {
+		kc.opts.progress(ReadProgress{Bytes: n, Keyrings: kc.sent})
+	}
+}
+
 // SksDigest calculates a cumulative message digest on all OpenPGP packets for
 // a given primary public key, using the same ordering as SKS, the
 // Synchronizing Key Server. Use MD5 for matching digest values with SKS.
 func SksDigest(key *PrimaryKey, h hash.Hash) (string, error) {
 	var fail string
-	var packets opaquePacketSlice
-	for _, node := range key.contents() {
-		op, err := newOpaquePacket(node.packet().Packet)
-		if err != nil {
-			return fail, errgo.Mask(err)
-		}
-		packets = append(packets, op)
+	packets, err := key.opaquePackets(false)
+	if err != nil {
+		return fail, errgo.Mask(err)
 	}
 	if len(packets) == 0 {
 		return fail, errgo.New("no packets found")
@@ -238,6 +600,43 @@ func SksDigest(key *PrimaryKey, h hash.Hash) (string, error) {
 	return sksDigestOpaque(packets, h), nil
 }
 
+// Packets returns the packets of the key in canonical order, the order in
+// which SksDigest digests them. A packet whose Count records duplicates
+// dropped from the key is repeated accordingly, so that the result is the
+// keyring as it was before de-duplication; SksDigest digests each packet only
+// once, so the digest of the result may differ from that of the key. Each
+// packet is a copy, which may be modified without altering the key.
+func (pubkey *PrimaryKey) Packets() ([]*packet.OpaquePacket, error) {
+	packets, err := pubkey.opaquePackets(true)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for i, op := range packets {
+		packets[i] = &packet.OpaquePacket{Tag: op.Tag, Reason: op.Reason, Contents: append([]byte(nil), op.Contents...)}
+	}
+	sort.Sort(opaquePacketSlice(packets))
+	return packets, nil
+}
+
+// opaquePackets returns the packets of the key in key order, optionally
+// repeating each as many more times as its Count.
+func (pubkey *PrimaryKey) opaquePackets(expand bool) ([]*packet.OpaquePacket, error) {
+	var packets []*packet.OpaquePacket
+	for _, node := range pubkey.contents() {
+		op, err := newOpaquePacket(node.packet().Packet)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		packets = append(packets, op)
+		if expand {
+			for i := 0; i < node.packet().Count; i++ {
+				packets = append(packets, op)
+			}
+		}
+	}
+	return packets, nil
+}
+
 func sksDigestOpaque(packets []*packet.OpaquePacket, h hash.Hash) string {
 	sort.Sort(opaquePacketSlice(packets))
 	for _, opkt := range packets {
@@ -251,6 +650,28 @@ func sksDigestOpaque(packets []*packet.OpaquePacket, h hash.Hash) string {
 type ReadKeyResult struct {
 	*PrimaryKey
 	Error error
+
+	// Revocations is set, instead of PrimaryKey, when the input consisted
+	// solely of key revocation signatures without the key they revoke.
+	Revocations []*RevocationCert
+
+	// Skipped lists the packets in the input which were not accepted as key
+	// material, so that submitters can be told which parts of their key
+	// were ignored.
+	Skipped []*SkippedPacket
+
+	// Warnings holds the results of Lint on the key, if read with the
+	// WithLint option.
+	Warnings []*LintWarning
+
+	// Truncated is set when the input ended partway through a packet of
+	// this key. The key is salvaged from the packets preceding it.
+	Truncated *TruncationError
+
+	// Partial indicates that packets were skipped while reading the key, with
+	// the IndexOnly or SkipTags options, and so it is not complete enough to
+	// be digested, merged or stored.
+	Partial bool
 }
 
 type PrimaryKeyChan chan *ReadKeyResult
@@ -261,6 +682,9 @@ func (c PrimaryKeyChan) MustParse() []*PrimaryKey {
 		if readKey.Error != nil {
 			panic(readKey.Error)
 		}
+		if readKey.PrimaryKey == nil {
+			continue
+		}
 		result = append(result, readKey.PrimaryKey)
 	}
 	return result
@@ -268,11 +692,11 @@ func (c PrimaryKeyChan) MustParse() []*PrimaryKey {
 
 // ReadKeys reads public key material from input and sends them on a channel.
 // Caller must receive all keys until the channel is closed.
-func ReadKeys(r io.Reader) PrimaryKeyChan {
+func ReadKeys(r io.Reader, opts ...ReadOption) PrimaryKeyChan {
 	c := make(PrimaryKeyChan)
 	go func() {
 		defer close(c)
-		for keyRead := range readKeys(r) {
+		for keyRead := range readKeys(r, opts...) {
 			c <- keyRead
 		}
 		if closer, ok := r.(io.Closer); ok {
@@ -282,32 +706,28 @@ func ReadKeys(r io.Reader) PrimaryKeyChan {
 	return c
 }
 
-func readKeys(r io.Reader) PrimaryKeyChan {
+func readKeys(r io.Reader, opts ...ReadOption) PrimaryKeyChan {
 	c := make(PrimaryKeyChan)
+	ro := newReadOptions(opts)
 	go func() {
 		defer close(c)
-		for opkr := range ReadOpaqueKeyrings(r) {
-			pubkey, err := opkr.Parse()
-			if err != nil {
-				c <- &ReadKeyResult{Error: err}
-			} else {
-				c <- &ReadKeyResult{PrimaryKey: pubkey}
-			}
+		for opkr := range ReadOpaqueKeyrings(r, opts...) {
+			c <- opkr.result(ro)
 		}
 	}()
 	return c
 }
 
-func ReadArmorKeys(r io.Reader) (PrimaryKeyChan, error) {
+func ReadArmorKeys(r io.Reader, opts ...ReadOption) (PrimaryKeyChan, error) {
 	block, err := armor.Decode(r)
 	if err != nil {
 		return nil, err
 	}
-	return ReadKeys(block.Body), nil
+	return ReadKeys(block.Body, opts...), nil
 }
 
-func MustReadArmorKeys(r io.Reader) PrimaryKeyChan {
-	c, err := ReadArmorKeys(r)
+func MustReadArmorKeys(r io.Reader, opts ...ReadOption) PrimaryKeyChan {
+	c, err := ReadArmorKeys(r, opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -19,15 +19,24 @@ package openpgp
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/md5"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	stdtesting "testing"
+	"time"
 
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 
 	"github.com/schmorrison/testing"
 )
@@ -256,8 +265,1201 @@ func (s *SamplePacketSuite) TestRevocationCert(c *gc.C) {
 	ch := MustReadArmorKeys(testing.MustInput("revok_cert.asc"))
 	count := 0
 	for readKey := range ch {
-		c.Assert(readKey.Error, gc.ErrorMatches, "primary public key not found")
+		c.Assert(readKey.Error, gc.IsNil)
+		c.Assert(readKey.PrimaryKey, gc.IsNil)
+		c.Assert(readKey.Revocations, gc.HasLen, 1)
+		rev := readKey.Revocations[0]
+		c.Assert(rev.Signature.SigType, gc.Equals, 0x20)
+		c.Assert(rev.RIssuerKeyID, gc.Equals, rev.Signature.RIssuerKeyID)
+		c.Assert(rev.RIssuerKeyID, gc.Not(gc.Equals), "")
 		count++
 	}
 	c.Assert(count, gc.Equals, 1)
 }
+
+func (s *SamplePacketSuite) TestStraySignatures(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var stray, key bytes.Buffer
+	for _, id := range alice.Identities {
+		c.Assert(id.SelfSignature.Serialize(&stray), gc.IsNil)
+	}
+	c.Assert(alice.Serialize(&key), gc.IsNil)
+
+	// Signatures preceding the first key which are not revocations are
+	// ignored.
+	keys := ReadKeys(io.MultiReader(bytes.NewReader(stray.Bytes()), bytes.NewReader(key.Bytes()))).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].UserIDs, gc.HasLen, 1)
+
+	rev := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   alice.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &alice.PrimaryKey.KeyId,
+	}
+	c.Assert(rev.SignKey(alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+	var input bytes.Buffer
+	c.Assert(rev.Serialize(&input), gc.IsNil)
+	input.Write(stray.Bytes())
+	input.Write(key.Bytes())
+	var results []*ReadKeyResult
+	for readKey := range ReadKeys(&input) {
+		c.Assert(readKey.Error, gc.IsNil)
+		results = append(results, readKey)
+	}
+	c.Assert(results, gc.HasLen, 2)
+	c.Assert(results[0].Revocations, gc.HasLen, 1)
+	c.Assert(results[1].PrimaryKey, gc.NotNil)
+}
+
+func (s *SamplePacketSuite) TestLazySignature(c *gc.C) {
+	for _, name := range []string{"sksdigest.asc", "lp1195901.asc", "0xd46b7c827be290fe4d1f9291b1ebc61a.asc"} {
+		key := MustInputAscKey(name)
+		for _, node := range key.contents() {
+			sig, ok := node.(*Signature)
+			if !ok {
+				continue
+			}
+			op, err := sig.opaquePacket()
+			c.Assert(err, gc.IsNil)
+			full, err := ParseSignature(op, key.UUID, "")
+			c.Assert(err, gc.IsNil)
+			c.Check(sig.SigType, gc.Equals, full.SigType)
+			c.Check(sig.RIssuerKeyID, gc.Equals, full.RIssuerKeyID)
+			c.Check(sig.Creation.Equal(full.Creation), gc.Equals, true)
+
+			err = sig.Expand()
+			c.Assert(err, gc.IsNil)
+			c.Check(sig.Expiration.Equal(full.Expiration), gc.Equals, true)
+			c.Check(sig.Primary, gc.Equals, full.Primary)
+		}
+	}
+}
+
+func (s *SamplePacketSuite) TestExpandConcurrent(c *gc.C) {
+	key := MustInputAscKey("sksdigest.asc")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, uid := range key.UserIDs {
+				ss := uid.SelfSigs(key)
+				c.Check(ss.Errors, gc.HasLen, 0)
+			}
+			for _, sig := range key.UserIDs[0].Signatures {
+				c.Check(sig.Creation.IsZero(), gc.Equals, false)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *SamplePacketSuite) TestInternIssuers(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("User %d", i)
+		entity := newTestEntity(c, name)
+		c.Assert(entity.SignIdentity(name, alice, nil), gc.IsNil)
+		c.Assert(entity.Serialize(&buf), gc.IsNil)
+	}
+	var okrs []*OpaqueKeyring
+	for okr := range ReadOpaqueKeyrings(&buf) {
+		c.Assert(okr.Error, gc.IsNil)
+		key, err := okr.Parse()
+		c.Assert(err, gc.IsNil)
+		c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 2)
+		okrs = append(okrs, okr)
+	}
+	c.Assert(okrs, gc.HasLen, 3)
+	// The table is shared by the stream, and holds one copy of each of the
+	// four issuer key IDs, Alice's among them, but none of the keywords.
+	table := okrs[0].strings
+	c.Assert(okrs[2].strings, gc.Equals, table)
+	c.Assert(table.m, gc.HasLen, 4)
+	_, ok := table.m[ReverseKeyID(alice.PrimaryKey.KeyId)]
+	c.Assert(ok, gc.Equals, true)
+	for s := range table.m {
+		c.Assert(strings.HasPrefix(s, "User"), gc.Equals, false)
+	}
+}
+
+func (s *SamplePacketSuite) TestIndexOnly(c *gc.C) {
+	f := testing.MustInput("uat.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	var keys []*ReadKeyResult
+	for keyRead := range ReadKeys(block.Body, IndexOnly()) {
+		c.Assert(keyRead.Error, gc.IsNil)
+		keys = append(keys, keyRead)
+	}
+	c.Assert(keys, gc.HasLen, 1)
+	key := keys[0]
+	c.Assert(key.Partial, gc.Equals, true)
+	c.Assert(key.MD5, gc.Equals, "")
+	c.Assert(key.UserIDs, gc.HasLen, 2)
+	c.Assert(key.UserAttributes, gc.HasLen, 0)
+	c.Assert(key.SubKeys, gc.HasLen, 3)
+	for _, node := range key.contents() {
+		_, ok := node.(*Signature)
+		c.Assert(ok, gc.Equals, false)
+	}
+}
+
+func (s *SamplePacketSuite) TestSkipTags(c *gc.C) {
+	f := testing.MustInput("uat.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	n := 0
+	for opkr := range ReadOpaqueKeyrings(block.Body, SkipTags(2, 17)) {
+		c.Assert(opkr.Error, gc.IsNil)
+		for _, op := range opkr.Packets {
+			c.Assert(op.Tag, gc.Not(gc.Equals), uint8(2))
+			c.Assert(op.Tag, gc.Not(gc.Equals), uint8(17))
+		}
+		n++
+
+		// Keyrings missing packets are neither digested nor cached.
+		_, err := opkr.Parse()
+		c.Assert(errgo.Cause(err), gc.Equals, ErrPartialKeyring)
+		_, err = NewKeyCache(1).Parse(opkr)
+		c.Assert(errgo.Cause(err), gc.Equals, ErrPartialKeyring)
+	}
+	c.Assert(n, gc.Equals, 1)
+
+	f2 := testing.MustInput("uat.asc")
+	defer f2.Close()
+	block, err = armor.Decode(f2)
+	c.Assert(err, gc.IsNil)
+	n = 0
+	for result := range DigestKeyrings(ReadOpaqueKeyrings(block.Body, IndexOnly()), 1) {
+		c.Assert(errgo.Cause(result.Error), gc.Equals, ErrPartialKeyring)
+		c.Assert(result.MD5, gc.Equals, "")
+		n++
+	}
+	c.Assert(n, gc.Equals, 1)
+}
+
+func (s *SamplePacketSuite) TestScanKeyHeaders(c *gc.C) {
+	f := testing.MustInput("sksdigest.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	buf, err := ioutil.ReadAll(block.Body)
+	c.Assert(err, gc.IsNil)
+
+	var headers []*KeyHeader
+	for kh := range ScanKeyHeaders(bytes.NewBuffer(buf)) {
+		c.Assert(kh.Error, gc.IsNil)
+		headers = append(headers, kh)
+	}
+	c.Assert(headers, gc.HasLen, 1)
+	kh := headers[0]
+	c.Assert(kh.Offset, gc.Equals, int64(0))
+	c.Assert(kh.Length, gc.Equals, int64(len(buf)))
+
+	key := MustInputAscKey("sksdigest.asc")
+	c.Assert(kh.PrimaryKey.RFingerprint, gc.Equals, key.RFingerprint)
+	counts := make(map[uint8]int)
+	for _, node := range key.contents()[1:] {
+		counts[node.packet().Tag]++
+	}
+	c.Assert(kh.Counts, gc.DeepEquals, counts)
+}
+
+func (s *SamplePacketSuite) TestDumpFile(c *gc.C) {
+	f := testing.MustInput("sksdigest.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	buf, err := ioutil.ReadAll(block.Body)
+	c.Assert(err, gc.IsNil)
+	path := filepath.Join(c.MkDir(), "dump.pgp")
+	err = ioutil.WriteFile(path, append(buf, buf...), 0644)
+	c.Assert(err, gc.IsNil)
+
+	var expect []*OpaqueKeyring
+	for opkr := range ReadOpaqueKeyrings(bytes.NewBuffer(append(buf, buf...))) {
+		expect = append(expect, opkr)
+	}
+
+	d, err := OpenDumpFile(path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Len(), gc.Equals, 2*len(buf))
+	var i int
+	for opkr := range d.ReadOpaqueKeyrings() {
+		c.Assert(opkr.Error, gc.IsNil)
+		c.Assert(opkr.Packets, gc.DeepEquals, expect[i].Packets)
+		c.Assert(opkr.Position, gc.Equals, int64(i*len(buf)))
+		i++
+	}
+	c.Assert(i, gc.Equals, 2)
+
+	var keys []*PrimaryKey
+	for keyRead := range d.ReadKeys() {
+		c.Assert(keyRead.Error, gc.IsNil)
+		keys = append(keys, keyRead.PrimaryKey)
+	}
+	c.Assert(d.Close(), gc.IsNil)
+	c.Assert(keys, gc.HasLen, 2)
+	c.Assert(keys[1].MD5, gc.Equals, "da84f40d830a7be2a3c0b7f2e146bfaa")
+}
+
+func (s *SamplePacketSuite) TestDumpFileCloseWhileReading(c *gc.C) {
+	f := testing.MustInput("sksdigest.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	buf, err := ioutil.ReadAll(block.Body)
+	c.Assert(err, gc.IsNil)
+	path := filepath.Join(c.MkDir(), "dump.pgp")
+	err = ioutil.WriteFile(path, bytes.Repeat(buf, 10), 0644)
+	c.Assert(err, gc.IsNil)
+
+	d, err := OpenDumpFile(path)
+	c.Assert(err, gc.IsNil)
+	keys := d.ReadKeys()
+	first := <-keys
+	c.Assert(first.Error, gc.IsNil)
+	c.Assert(d.Close(), gc.IsNil)
+	// The contents are retained until the reader stops.
+	var closed bool
+	for keyRead := range keys {
+		if keyRead.Error != nil {
+			c.Assert(errgo.Cause(keyRead.Error), gc.Equals, ErrDumpFileClosed)
+			closed = true
+		}
+	}
+	c.Assert(closed, gc.Equals, true)
+	c.Assert(d.Len(), gc.Equals, 0)
+
+	for keyRead := range d.ReadKeys() {
+		c.Assert(errgo.Cause(keyRead.Error), gc.Equals, ErrDumpFileClosed)
+	}
+}
+
+func (s *SamplePacketSuite) TestDigestKeyrings(c *gc.C) {
+	names := []string{"sksdigest.asc", "alice_signed.asc", "uat.asc", "lp1195901.asc"}
+	var expect []*PrimaryKey
+	var okrs []*OpaqueKeyring
+	for _, name := range names {
+		expect = append(expect, MustInputAscKey(name))
+		f := testing.MustInput(name)
+		block, err := armor.Decode(f)
+		c.Assert(err, gc.IsNil)
+		for opkr := range ReadOpaqueKeyrings(block.Body) {
+			okrs = append(okrs, opkr)
+		}
+		f.Close()
+	}
+	c.Assert(okrs, gc.HasLen, len(names))
+	in := make(OpaqueKeyringChan)
+	go func() {
+		defer close(in)
+		for _, okr := range okrs {
+			in <- okr
+		}
+	}()
+
+	var i int
+	for result := range DigestKeyrings(in, 3) {
+		c.Assert(result.Error, gc.IsNil)
+		c.Assert(result.Index, gc.Equals, i)
+		c.Assert(result.RFingerprint, gc.Equals, expect[i].RFingerprint)
+		c.Assert(result.MD5, gc.Equals, expect[i].MD5)
+		i++
+	}
+	c.Assert(i, gc.Equals, len(names))
+}
+
+func (s *SamplePacketSuite) TestErrorCauses(c *gc.C) {
+	f := testing.MustInput("uat.asc")
+	defer f.Close()
+	block, err := armor.Decode(f)
+	c.Assert(err, gc.IsNil)
+	var okr *OpaqueKeyring
+	for opkr := range ReadOpaqueKeyrings(block.Body) {
+		okr = opkr
+	}
+	// Drop the primary key and its direct signatures.
+	for len(okr.Packets) > 0 && okr.Packets[0].Tag != 13 {
+		okr.Packets = okr.Packets[1:]
+	}
+	_, err = okr.Parse()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNoPrimaryKey)
+
+	f2 := testing.MustInput("uat.asc")
+	defer f2.Close()
+	ch, err := ReadArmorKeys(f2, MaxPacketLen(1024))
+	c.Assert(err, gc.IsNil)
+	for readKey := range ch {
+		c.Assert(errgo.Cause(readKey.Error), gc.Equals, ErrPacketTooLarge)
+		perr, ok := readKey.Error.(*PacketError)
+		c.Assert(ok, gc.Equals, true)
+		c.Assert(perr.Tag, gc.Equals, uint8(17))
+		c.Assert(perr.Offset > 0, gc.Equals, true)
+	}
+}
+
+func (s *SamplePacketSuite) TestSecretKeys(c *gc.C) {
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var secret, public bytes.Buffer
+	c.Assert(entity.SerializePrivate(&secret, nil), gc.IsNil)
+	c.Assert(entity.Serialize(&public), gc.IsNil)
+	expect := ReadKeys(bytes.NewReader(public.Bytes())).MustParse()
+	c.Assert(expect, gc.HasLen, 1)
+
+	n := 0
+	for readKey := range ReadKeys(bytes.NewReader(secret.Bytes())) {
+		c.Assert(errgo.Cause(readKey.Error), gc.Equals, ErrSecretKeyMaterial)
+		c.Assert(readKey.PrimaryKey, gc.IsNil)
+		n++
+	}
+	c.Assert(n, gc.Equals, 1)
+
+	keys := ReadKeys(bytes.NewReader(secret.Bytes()), SecretKeys(ExtractPublicKeys)).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].RFingerprint, gc.Equals, expect[0].RFingerprint)
+	c.Assert(keys[0].SubKeys, gc.HasLen, 1)
+	c.Assert(keys[0].MD5, gc.Equals, expect[0].MD5)
+
+	input := append(append([]byte(nil), public.Bytes()...), secret.Bytes()...)
+	var results []*ReadKeyResult
+	for readKey := range ReadKeys(bytes.NewReader(input), SecretKeys(AbortOnSecretKeys)) {
+		results = append(results, readKey)
+	}
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].PrimaryKey, gc.IsNil)
+	c.Assert(errgo.Cause(results[0].Error), gc.Equals, ErrSecretKeyMaterial)
+
+	// Keyrings completed before the secret packet are not withdrawn.
+	var bob bytes.Buffer
+	c.Assert(newTestEntity(c, "Bob").Serialize(&bob), gc.IsNil)
+	input = append(bob.Bytes(), input...)
+	results = nil
+	for readKey := range ReadKeys(bytes.NewReader(input), SecretKeys(AbortOnSecretKeys)) {
+		results = append(results, readKey)
+	}
+	c.Assert(results, gc.HasLen, 2)
+	c.Assert(results[0].Error, gc.IsNil)
+	c.Assert(results[0].PrimaryKey, gc.NotNil)
+	c.Assert(errgo.Cause(results[1].Error), gc.Equals, ErrSecretKeyMaterial)
+}
+
+func (s *SamplePacketSuite) TestTruncated(c *gc.C) {
+	var buf bytes.Buffer
+	for _, name := range []string{"Alice", "Bob"} {
+		entity, err := openpgp.NewEntity(name, "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&buf), gc.IsNil)
+	}
+	whole := ReadKeys(bytes.NewReader(buf.Bytes())).MustParse()
+	c.Assert(whole, gc.HasLen, 2)
+
+	// Cut the input partway through the last packet, a sub-key signature.
+	sig := whole[1].SubKeys[0].Signatures[0]
+	offset := int64(buf.Len() - len(sig.Packet.Packet))
+	input := buf.Bytes()[:buf.Len()-10]
+
+	var results []*ReadKeyResult
+	for readKey := range ReadKeys(bytes.NewReader(input)) {
+		c.Assert(readKey.Error, gc.IsNil)
+		results = append(results, readKey)
+	}
+	c.Assert(results, gc.HasLen, 2)
+	c.Assert(results[0].Truncated, gc.IsNil)
+	c.Assert(results[0].MD5, gc.Equals, whole[0].MD5)
+	c.Assert(results[1].Truncated, gc.NotNil)
+	c.Assert(results[1].Truncated.Offset, gc.Equals, offset)
+	c.Assert(errgo.Cause(results[1].Truncated), gc.Equals, ErrTruncated)
+	c.Assert(results[1].RFingerprint, gc.Equals, whole[1].RFingerprint)
+	c.Assert(results[1].SubKeys, gc.HasLen, 1)
+	c.Assert(results[1].SubKeys[0].Signatures, gc.HasLen, 0)
+}
+
+func (s *SamplePacketSuite) TestMalformedCorpus(c *gc.C) {
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+
+	corpus, err := MalformedCorpus(buf.Bytes(), 200, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(corpus, gc.HasLen, 200)
+	again, err := MalformedCorpus(buf.Bytes(), 200, 1)
+	c.Assert(err, gc.IsNil)
+	c.Assert(again, gc.DeepEquals, corpus)
+
+	for _, data := range corpus {
+		c.Assert(bytes.Equal(data, buf.Bytes()), gc.Equals, false)
+		for readKey := range ReadKeys(bytes.NewReader(data)) {
+			if readKey.Error != nil || readKey.PrimaryKey == nil {
+				continue
+			}
+			readKey.SelfSigs()
+			for other := range ReadKeys(bytes.NewReader(data)) {
+				if other.Error == nil && other.PrimaryKey != nil {
+					c.Assert(Merge(readKey.PrimaryKey, other.PrimaryKey), gc.IsNil)
+				}
+			}
+		}
+	}
+}
+
+func (s *SamplePacketSuite) TestParsePanic(c *gc.C) {
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	var okr *OpaqueKeyring
+	for opkr := range ReadOpaqueKeyrings(bytes.NewReader(buf.Bytes())) {
+		okr = opkr
+	}
+	c.Assert(okr, gc.NotNil)
+
+	// A nil packet can't be read from a stream, but stands in for any
+	// input which makes the packet library panic.
+	okr.Packets = append(okr.Packets, nil)
+	_, err = okr.Parse()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrParserPanic)
+	_, ok := err.(*PanicError)
+	c.Assert(ok, gc.Equals, true)
+}
+
+func (s *SamplePacketSuite) TestSplitDump(c *gc.C) {
+	var dump bytes.Buffer
+	for i := 0; i < 4; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+	}
+	expect := ReadKeys(bytes.NewReader(dump.Bytes())).MustParse()
+	c.Assert(expect, gc.HasLen, 4)
+
+	outputs := make([]bytes.Buffer, 3)
+	writers := []io.Writer{&outputs[0], &outputs[1], &outputs[2]}
+	err := SplitDump(ReadOpaqueKeyrings(bytes.NewReader(dump.Bytes())), int64(dump.Len()), writers)
+	c.Assert(err, gc.IsNil)
+	var keys []*PrimaryKey
+	for i := range outputs {
+		c.Assert(outputs[i].Len(), gc.Not(gc.Equals), 0)
+		keys = append(keys, ReadKeys(&outputs[i]).MustParse()...)
+	}
+	c.Assert(keys, gc.HasLen, 4)
+	for i := range keys {
+		c.Assert(keys[i].RFingerprint, gc.Equals, expect[i].RFingerprint)
+	}
+
+	outputs = make([]bytes.Buffer, 2)
+	writers = []io.Writer{&outputs[0], &outputs[1]}
+	err = ShardDump(ReadOpaqueKeyrings(bytes.NewReader(dump.Bytes())), writers)
+	c.Assert(err, gc.IsNil)
+	n := 0
+	for i := range outputs {
+		if outputs[i].Len() == 0 {
+			continue
+		}
+		for _, key := range ReadKeys(&outputs[i]).MustParse() {
+			c.Assert(key.Fingerprint()[0] >= '8', gc.Equals, i == 1)
+			n++
+		}
+	}
+	c.Assert(n, gc.Equals, 4)
+}
+
+func (s *SamplePacketSuite) TestCheckpoints(c *gc.C) {
+	var dump bytes.Buffer
+	for i := 0; i < 3; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+	}
+
+	var points []*Checkpoint
+	var rfps []string
+	for ckr := range ReadCheckpointedKeyrings(bytes.NewReader(dump.Bytes()), nil, 2) {
+		c.Assert(ckr.Error, gc.IsNil)
+		rfps = append(rfps, ckr.RFingerprint)
+		if ckr.Checkpoint != nil {
+			points = append(points, ckr.Checkpoint)
+		}
+	}
+	c.Assert(rfps, gc.HasLen, 3)
+	c.Assert(points, gc.HasLen, 2)
+	c.Assert(points[0].Keyrings, gc.Equals, 2)
+	c.Assert(points[1].Keyrings, gc.Equals, 3)
+	c.Assert(points[1].Offset, gc.Equals, int64(dump.Len()))
+
+	var resumed []*CheckpointedKeyring
+	for ckr := range ReadCheckpointedKeyrings(bytes.NewReader(dump.Bytes()), points[0], 2) {
+		c.Assert(ckr.Error, gc.IsNil)
+		resumed = append(resumed, ckr)
+	}
+	c.Assert(resumed, gc.HasLen, 1)
+	c.Assert(resumed[0].Position, gc.Equals, points[0].Offset)
+	c.Assert(resumed[0].Checkpoint, gc.DeepEquals, points[1])
+}
+
+func (s *SamplePacketSuite) TestReadSignedDump(c *gc.C) {
+	var dump bytes.Buffer
+	for i := 0; i < 2; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+	}
+	path := filepath.Join(c.MkDir(), "dump.pgp")
+	c.Assert(ioutil.WriteFile(path, dump.Bytes(), 0644), gc.IsNil)
+
+	distributor, err := openpgp.NewEntity("Distributor", "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var pub bytes.Buffer
+	c.Assert(distributor.Serialize(&pub), gc.IsNil)
+	keyring, err := KeyRing(ReadKeys(&pub).MustParse()...)
+	c.Assert(err, gc.IsNil)
+	var sig bytes.Buffer
+	c.Assert(openpgp.ArmoredDetachSign(&sig, distributor, bytes.NewReader(dump.Bytes()), nil), gc.IsNil)
+
+	keys, signer, err := ReadSignedDump(path, keyring, bytes.NewReader(sig.Bytes()))
+	c.Assert(err, gc.IsNil)
+	c.Assert(signer.PrimaryKey.Fingerprint, gc.DeepEquals, distributor.PrimaryKey.Fingerprint)
+	c.Assert(keys.MustParse(), gc.HasLen, 2)
+
+	// Changes to the file after it is verified are not read.
+	d, _, err := OpenSignedDumpFile(path, keyring, bytes.NewReader(sig.Bytes()))
+	c.Assert(err, gc.IsNil)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	c.Assert(err, gc.IsNil)
+	_, err = f.WriteAt(bytes.Repeat([]byte{0xff}, dump.Len()), 0)
+	c.Assert(err, gc.IsNil)
+	c.Assert(f.Truncate(1), gc.IsNil)
+	c.Assert(f.Close(), gc.IsNil)
+	c.Assert(d.ReadKeys().MustParse(), gc.HasLen, 2)
+	c.Assert(d.Close(), gc.IsNil)
+	c.Assert(ioutil.WriteFile(path, dump.Bytes(), 0644), gc.IsNil)
+
+	c.Assert(ioutil.WriteFile(path, dump.Bytes()[:dump.Len()-1], 0644), gc.IsNil)
+	_, _, err = ReadSignedDump(path, keyring, bytes.NewReader(sig.Bytes()))
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *SamplePacketSuite) TestKeyStats(c *gc.C) {
+	var dump bytes.Buffer
+	for i := 0; i < 2; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+	}
+	stats := CollectKeyStats(ReadKeys(&dump))
+	c.Assert(stats.Keys, gc.Equals, 2)
+	c.Assert(stats.Errors, gc.Equals, 0)
+	c.Assert(stats.Versions, gc.DeepEquals, map[int]int{4: 2})
+	c.Assert(stats.Algorithms, gc.DeepEquals, map[string]int{"rsa": 2})
+	c.Assert(stats.KeySizes, gc.DeepEquals, map[string]int{"rsa1024": 2})
+	c.Assert(stats.CreationYears[time.Now().UTC().Year()], gc.Equals, 2)
+	c.Assert(stats.Revoked, gc.Equals, 0)
+	c.Assert(stats.Packets, gc.DeepEquals, map[uint8]int{6: 2, 13: 2, 14: 2, 2: 4})
+
+	// Years are counted in UTC, whatever the zone of the creation time.
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	key.Creation = time.Date(2020, 12, 31, 23, 30, 0, 0, time.FixedZone("", -3600))
+	stats = NewKeyStats()
+	stats.Add(key)
+	c.Assert(stats.CreationYears, gc.DeepEquals, map[int]int{2021: 1})
+}
+
+func (s *SamplePacketSuite) TestDropSeenKeys(c *gc.C) {
+	var dump bytes.Buffer
+	var keys [3]bytes.Buffer
+	for i := range keys {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&keys[i]), gc.IsNil)
+	}
+	for _, i := range []int{0, 1, 0, 2, 1} {
+		dump.Write(keys[i].Bytes())
+	}
+	var rfps []string
+	for _, key := range DropSeenKeys(ReadKeys(&dump), 100, 0.001).MustParse() {
+		rfps = append(rfps, key.RFingerprint)
+	}
+	c.Assert(rfps, gc.HasLen, 3)
+	c.Assert(rfps[0], gc.Not(gc.Equals), rfps[1])
+	c.Assert(rfps[1], gc.Not(gc.Equals), rfps[2])
+
+	f := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.add(fmt.Sprintf("%032x", i))
+	}
+	for i := 0; i < 1000; i++ {
+		c.Assert(f.add(fmt.Sprintf("%032x", i)), gc.Equals, true)
+	}
+	var fp int
+	for i := 1000; i < 2000; i++ {
+		// Check each digest against a copy, so as not to fill the filter.
+		g := *f
+		g.bits = append([]uint64(nil), f.bits...)
+		if g.add(fmt.Sprintf("%032x", i*7919)) {
+			fp++
+		}
+	}
+	c.Assert(fp < 50, gc.Equals, true)
+}
+
+func (s *SamplePacketSuite) TestMergeDumps(c *gc.C) {
+	var entities []*openpgp.Entity
+	for i := 0; i < 3; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		entities = append(entities, entity)
+	}
+	serializeRevocation := func(w io.Writer, entity *openpgp.Entity) {
+		op, err := keyRevocation(c, entity).Signature.opaquePacket()
+		c.Assert(err, gc.IsNil)
+		c.Assert(op.Serialize(w), gc.IsNil)
+	}
+	var a, b bytes.Buffer
+	c.Assert(entities[0].Serialize(&a), gc.IsNil)
+	c.Assert(entities[1].Serialize(&a), gc.IsNil)
+	c.Assert(entities[1].SignIdentity("User 1", entities[2], nil), gc.IsNil)
+	// A revocation of a key from another dump is applied to it, and one of
+	// a key in neither is written through.
+	serializeRevocation(&b, entities[0])
+	serializeRevocation(&b, newTestEntity(c, "Unknown"))
+	c.Assert(entities[1].Serialize(&b), gc.IsNil)
+	c.Assert(entities[2].Serialize(&b), gc.IsNil)
+
+	var out bytes.Buffer
+	result, err := MergeDumps(&out, []io.Reader{&a, &b})
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.DeepEquals, &MergeDumpsResult{Keys: 3, Merged: 1, Revocations: 1})
+
+	var keys []*PrimaryKey
+	var revs []*RevocationCert
+	for readKey := range ReadKeys(&out) {
+		c.Assert(readKey.Error, gc.IsNil)
+		if readKey.PrimaryKey != nil {
+			keys = append(keys, readKey.PrimaryKey)
+		}
+		revs = append(revs, readKey.Revocations...)
+	}
+	c.Assert(keys, gc.HasLen, 3)
+	c.Assert(revs, gc.HasLen, 1)
+	for i := 1; i < len(keys); i++ {
+		c.Assert(keys[i-1].Fingerprint() < keys[i].Fingerprint(), gc.Equals, true)
+	}
+	for _, key := range keys {
+		switch key.UserIDs[0].Keywords {
+		case "User 0":
+			c.Assert(key.SelfSigs().Revocations, gc.HasLen, 1)
+		case "User 1":
+			c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 2)
+			c.Assert(key.SelfSigs().Revocations, gc.HasLen, 0)
+		}
+	}
+}
+
+func (s *SamplePacketSuite) TestCreationHistogram(c *gc.C) {
+	var dump bytes.Buffer
+	for i, year := range []int{2010, 2010, 2015} {
+		t := time.Date(year, time.Month(3+i), 1, 0, 0, 0, 0, time.UTC)
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{
+			RSABits: 1024,
+			Time:    func() time.Time { return t },
+		})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+	}
+	input := dump.Bytes()
+
+	h := CollectCreationHistogram(ReadKeys(bytes.NewReader(input)), ByYear)
+	c.Assert(h.Keys, gc.DeepEquals, map[string]int{"2010": 2, "2015": 1})
+	c.Assert(h.Signatures, gc.DeepEquals, map[string]int{"2010": 4, "2015": 2})
+	c.Assert(h.Labels(), gc.DeepEquals, []string{"2010", "2015"})
+
+	h = CollectCreationHistogram(ReadKeys(bytes.NewReader(input)), ByMonth)
+	c.Assert(h.Labels(), gc.DeepEquals, []string{"2010-03", "2010-04", "2015-05"})
+}
+
+func (s *SamplePacketSuite) TestObservePackets(c *gc.C) {
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+
+	counts := make(map[uint8]int)
+	var total int
+	obs := PacketObserverFunc(func(tag uint8, length int) {
+		counts[tag]++
+		total += length
+	})
+	keys := ReadKeys(bytes.NewReader(buf.Bytes()), ObservePackets(obs)).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(counts, gc.DeepEquals, map[uint8]int{6: 1, 13: 1, 2: 2, 14: 1})
+	var expect int
+	for _, node := range keys[0].contents() {
+		op, err := node.packet().opaquePacket()
+		c.Assert(err, gc.IsNil)
+		expect += len(op.Contents)
+	}
+	c.Assert(total, gc.Equals, expect)
+}
+
+func (s *SamplePacketSuite) TestProgress(c *gc.C) {
+	var dump bytes.Buffer
+	var ends []int64
+	for i := 0; i < 3; i++ {
+		entity, err := openpgp.NewEntity(fmt.Sprintf("User %d", i), "", "", &packet.Config{RSABits: 1024})
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.Serialize(&dump), gc.IsNil)
+		ends = append(ends, int64(dump.Len()))
+	}
+	path := filepath.Join(c.MkDir(), "dump.pgp")
+	c.Assert(ioutil.WriteFile(path, dump.Bytes(), 0644), gc.IsNil)
+
+	var progress []ReadProgress
+	opt := WithProgress(func(p ReadProgress) {
+		progress = append(progress, p)
+	})
+	c.Assert(ReadKeys(bytes.NewReader(dump.Bytes()), opt).MustParse(), gc.HasLen, 3)
+	c.Assert(progress, gc.DeepEquals, []ReadProgress{{ends[0], 1}, {ends[1], 2}, {ends[2], 3}})
+
+	progress = nil
+	d, err := OpenDumpFile(path)
+	c.Assert(err, gc.IsNil)
+	defer d.Close()
+	c.Assert(d.ReadKeys(opt).MustParse(), gc.HasLen, 3)
+	c.Assert(progress, gc.DeepEquals, []ReadProgress{{ends[0], 1}, {ends[1], 2}, {ends[2], 3}})
+}
+
+type countingMetrics struct {
+	mu         sync.Mutex
+	parsed     int
+	failed     map[error]int
+	merged     int
+	duplicates int
+}
+
+func (m *countingMetrics) KeyParsed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parsed++
+}
+
+func (m *countingMetrics) ParseFailed(cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[cause]++
+}
+
+func (m *countingMetrics) KeyMerged() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.merged++
+}
+
+func (m *countingMetrics) DuplicatesDropped(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicates += n
+}
+
+func (s *SamplePacketSuite) TestMetrics(c *gc.C) {
+	m := &countingMetrics{failed: make(map[error]int)}
+	SetMetrics(m)
+	defer SetMetrics(nil)
+
+	entity, err := openpgp.NewEntity("Alice", "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	for _, ident := range entity.Identities {
+		c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	}
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	c.Assert(entity.SerializePrivate(&buf, nil), gc.IsNil)
+	var keys []*PrimaryKey
+	for readKey := range ReadKeys(&buf) {
+		if readKey.Error == nil {
+			keys = append(keys, readKey.PrimaryKey)
+		}
+	}
+	c.Assert(keys, gc.HasLen, 2)
+	c.Assert(m.parsed, gc.Equals, 2)
+	c.Assert(m.failed, gc.DeepEquals, map[error]int{ErrSecretKeyMaterial: 1})
+
+	c.Assert(DropDuplicates(keys[0]), gc.IsNil)
+	c.Assert(m.duplicates, gc.Equals, 2)
+	c.Assert(Merge(keys[0], keys[1]), gc.IsNil)
+	c.Assert(m.merged, gc.Equals, 1)
+	c.Assert(m.duplicates, gc.Equals, 2+len(keys[1].contents())-1)
+}
+
+type recordingLogger struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (l *recordingLogger) LogEvent(e *Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (s *SamplePacketSuite) TestEventLogger(c *gc.C) {
+	l := &recordingLogger{}
+	SetEventLogger(l)
+	defer SetEventLogger(nil)
+
+	entity, err := openpgp.NewEntity("Alice", "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	// A user ID bearing another user ID's self-signature.
+	c.Assert(packet.NewUserId("Mallory", "", "").Serialize(&buf), gc.IsNil)
+	for _, ident := range entity.Identities {
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	}
+
+	var okrs []*OpaqueKeyring
+	for okr := range ReadOpaqueKeyrings(&buf) {
+		okrs = append(okrs, okr)
+	}
+	c.Assert(okrs, gc.HasLen, 1)
+	okrs[0].Packets = append(okrs[0].Packets, &packet.OpaquePacket{Tag: 60, Contents: []byte("unknown")})
+	key, err := okrs[0].Parse()
+	c.Assert(err, gc.IsNil)
+	c.Assert(l.events, gc.HasLen, 1)
+	c.Assert(l.events[0].Kind, gc.Equals, EventSkippedPacket)
+	c.Assert(l.events[0].RFingerprint, gc.Equals, key.RFingerprint)
+	c.Assert(l.events[0].Tag, gc.Equals, uint8(60))
+
+	var mallory *UserID
+	for _, uid := range key.UserIDs {
+		if uid.Keywords == "Mallory" {
+			mallory = uid
+		}
+	}
+	c.Assert(mallory, gc.NotNil)
+	c.Assert(l.events[0].Digest, gc.Equals, packetDigest(mallory.Others[0].Packet))
+	c.Assert(mallory.SelfSigs(key).Errors, gc.HasLen, 1)
+	c.Assert(l.events, gc.HasLen, 2)
+	c.Assert(l.events[1].Kind, gc.Equals, EventBadSelfSignature)
+	c.Assert(l.events[1].RFingerprint, gc.Equals, key.RFingerprint)
+	c.Assert(l.events[1].Digest, gc.Equals, packetDigest(mallory.Signatures[0].Packet.Packet))
+	c.Assert(errgo.Cause(l.events[1].Err), gc.Equals, ErrBadSelfSignature)
+}
+
+func (s *SamplePacketSuite) TestKeyringIndex(c *gc.C) {
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	c.Assert(alice.SignIdentity("Alice", bob, nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	c.Assert(bob.Serialize(&buf), gc.IsNil)
+	idx := IndexKeys(ReadKeys(&buf))
+	c.Assert(idx.Len(), gc.Equals, 2)
+
+	bobID := fmt.Sprintf("%016X", bob.PrimaryKey.KeyId)
+	keys := idx.Lookup("0x" + bobID)
+	c.Assert(keys, gc.HasLen, 1)
+	bobKey := keys[0]
+	c.Assert(bobKey.KeyID(), gc.Equals, strings.ToLower(bobID))
+	c.Assert(idx.Lookup(bobKey.Fingerprint()), gc.DeepEquals, keys)
+	c.Assert(idx.Lookup(bobKey.SubKeys[0].KeyID()), gc.DeepEquals, keys)
+	c.Assert(idx.Lookup(bobKey.SubKeys[0].Fingerprint()), gc.DeepEquals, keys)
+	c.Assert(idx.Lookup(bobKey.ShortID()), gc.HasLen, 0)
+
+	aliceKey := idx.Lookup(fmt.Sprintf("%x", alice.PrimaryKey.Fingerprint))[0]
+	var issuers []*PrimaryKey
+	for _, sig := range aliceKey.UserIDs[0].Signatures {
+		issuers = append(issuers, idx.Issuers(sig)...)
+	}
+	c.Assert(issuers, gc.HasLen, 2)
+
+	// Re-adding a key replaces it.
+	idx.Add(entityKey(c, bob))
+	c.Assert(idx.Len(), gc.Equals, 2)
+	c.Assert(idx.Lookup(bobID), gc.HasLen, 1)
+	c.Assert(idx.Lookup(bobID)[0], gc.Not(gc.Equals), bobKey)
+}
+
+func (s *SamplePacketSuite) TestSecretKeyS2K(c *gc.C) {
+	entity := newTestEntity(c, "Alice")
+	var secret, public bytes.Buffer
+	c.Assert(entity.SerializePrivate(&secret, nil), gc.IsNil)
+	c.Assert(entity.Serialize(&public), gc.IsNil)
+	expect := ReadKeys(bytes.NewReader(public.Bytes())).MustParse()[0]
+
+	// Replace the secret material of the primary key with an Argon2,
+	// AEAD-protected blob, and that of the subkey with a GnuPG stub, neither
+	// of which the packet library can parse.
+	argon2 := append([]byte{253, 9, 2, 4}, bytes.Repeat([]byte{0x42}, 16+3+16+64)...)
+	gnuDummy := []byte{254, 7, 101, 2, 'G', 'N', 'U', 1}
+	var input bytes.Buffer
+	r := packet.NewOpaqueReader(&secret)
+	for {
+		op, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		if isSecretKeyTag(op.Tag) {
+			n, err := publicKeyLen(op.Contents)
+			c.Assert(err, gc.IsNil)
+			protection := argon2
+			if op.Tag == 7 {
+				protection = gnuDummy
+			}
+			op.Contents = append(op.Contents[:n:n], protection...)
+		}
+		c.Assert(op.Serialize(&input), gc.IsNil)
+	}
+
+	var infos []*SecretKeyInfo
+	r = packet.NewOpaqueReader(bytes.NewReader(input.Bytes()))
+	for {
+		op, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, gc.IsNil)
+		if isSecretKeyTag(op.Tag) {
+			_, err := op.Parse()
+			c.Assert(err, gc.NotNil)
+			info, err := InspectSecretKey(op)
+			c.Assert(err, gc.IsNil)
+			infos = append(infos, info)
+		} else {
+			_, err := InspectSecretKey(op)
+			c.Assert(errgo.Cause(err), gc.Equals, ErrInvalidPacketType)
+		}
+	}
+	c.Assert(infos, gc.DeepEquals, []*SecretKeyInfo{
+		{Usage: 253, AEAD: true, Protection: ProtectionArgon2},
+		{Usage: 254, Protection: ProtectionGNUDummy},
+	})
+
+	keys := ReadKeys(bytes.NewReader(input.Bytes()), SecretKeys(ExtractPublicKeys)).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].RFingerprint, gc.Equals, expect.RFingerprint)
+	c.Assert(keys[0].SubKeys, gc.HasLen, 1)
+	c.Assert(keys[0].MD5, gc.Equals, expect.MD5)
+}
+
+func (s *SamplePacketSuite) TestOpaqueReader(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert((&packet.OpaquePacket{Tag: 13, Contents: []byte("Alice")}).Serialize(&buf), gc.IsNil)
+	c.Assert((&packet.OpaquePacket{Tag: 13, Contents: bytes.Repeat([]byte("x"), 100)}).Serialize(&buf), gc.IsNil)
+	// A partial length packet: a 32-octet chunk, then a final 3 octets.
+	buf.Write([]byte{0xc0 | 63, 0xe0 | 5})
+	buf.Write(bytes.Repeat([]byte("y"), 32))
+	buf.Write([]byte{3, 'e', 'n', 'd'})
+	c.Assert((&packet.OpaquePacket{Tag: 63, Contents: []byte("private")}).Serialize(&buf), gc.IsNil)
+	buf.Write([]byte{0xc0, 1, 0})
+
+	r := NewOpaqueReader(&buf)
+	r.MaxLength = 40
+	op, err := r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(op.Tag, gc.Equals, uint8(13))
+	c.Assert(string(op.Contents), gc.Equals, "Alice")
+	_, err = r.Next()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrPacketTooLarge)
+	op, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(op.Tag, gc.Equals, uint8(63))
+	c.Assert(string(op.Contents), gc.Equals, strings.Repeat("y", 32)+"end")
+	op, err = r.Next()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(op.Contents), gc.Equals, "private")
+	_, err = r.Next()
+	c.Assert(err, gc.ErrorMatches, "reserved packet tag 0")
+
+	// A bogus length fails at the end of the input, without allocating it.
+	r = NewOpaqueReader(bytes.NewReader([]byte{0xc0 | 13, 0xff, 0x7f, 0xff, 0xff, 0xff, 'x'}))
+	_, err = r.Next()
+	c.Assert(errgo.Cause(err), gc.Equals, io.ErrUnexpectedEOF)
+	_, err = NewOpaqueReader(bytes.NewReader(nil)).Next()
+	c.Assert(err, gc.Equals, io.EOF)
+}
+
+func (s *SamplePacketSuite) TestMaxPacketLen(c *gc.C) {
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	at := int64(buf.Len())
+	c.Assert((&packet.OpaquePacket{Tag: 17, Contents: bytes.Repeat([]byte("x"), 100000)}).Serialize(&buf), gc.IsNil)
+	c.Assert(bob.Serialize(&buf), gc.IsNil)
+	input := buf.Bytes()
+
+	// The oversized packet is left empty, rather than buffered and then
+	// rejected, and the keys after it are read.
+	var okrs []*OpaqueKeyring
+	for okr := range ReadOpaqueKeyrings(bytes.NewReader(input), MaxPacketLen(10000)) {
+		okrs = append(okrs, okr)
+	}
+	c.Assert(okrs, gc.HasLen, 2)
+	c.Assert(errgo.Cause(okrs[0].Error), gc.Equals, ErrPacketTooLarge)
+	last := okrs[0].Packets[len(okrs[0].Packets)-1]
+	c.Assert(last.Tag, gc.Equals, uint8(17))
+	c.Assert(last.Contents, gc.HasLen, 0)
+	c.Assert(okrs[1].Error, gc.IsNil)
+
+	var results []*ReadKeyResult
+	for readKey := range ReadKeys(bytes.NewReader(input), MaxPacketLen(10000)) {
+		results = append(results, readKey)
+	}
+	c.Assert(results, gc.HasLen, 2)
+	perr, ok := results[0].Error.(*PacketError)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(perr.Err, gc.Equals, ErrPacketTooLarge)
+	c.Assert(perr.Tag, gc.Equals, uint8(17))
+	c.Assert(perr.Offset, gc.Equals, at)
+	c.Assert(results[1].Error, gc.IsNil)
+	c.Assert(results[1].UserIDs[0].Keywords, gc.Equals, "Bob")
+}
+
+func (s *SamplePacketSuite) TestWriterToReaderFrom(c *gc.C) {
+	entity, err := openpgp.NewEntity("Alice", "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	var input bytes.Buffer
+	c.Assert(entity.Serialize(&input), gc.IsNil)
+	keys := ReadKeys(bytes.NewReader(input.Bytes())).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+
+	var buf bytes.Buffer
+	n, err := keys[0].WriteTo(&buf)
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, int64(buf.Len()))
+
+	var key PrimaryKey
+	var rf io.ReaderFrom = &key
+	m, err := rf.ReadFrom(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.IsNil)
+	c.Assert(m, gc.Equals, n)
+	c.Assert(key.RFingerprint, gc.Equals, keys[0].RFingerprint)
+	c.Assert(key.MD5, gc.Equals, keys[0].MD5)
+
+	buf.Write(input.Bytes())
+	_, err = key.ReadFrom(&buf)
+	c.Assert(err, gc.ErrorMatches, "more than one key found")
+	_, err = key.ReadFrom(bytes.NewReader(nil))
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNoPrimaryKey)
+}
+
+func (s *SamplePacketSuite) TestSerializedSize(c *gc.C) {
+	for _, key := range []*PrimaryKey{
+		entityKey(c, newTestEntity(c, "Alice")),
+		MustInputAscKey("sksdigest.asc"),
+	} {
+		var buf bytes.Buffer
+		c.Assert(WritePackets(&buf, key), gc.IsNil)
+		c.Assert(key.SerializedSize(), gc.Equals, int64(buf.Len()))
+
+		sum := key.PublicKey.Packet.SerializedSize()
+		for _, sig := range key.Signatures {
+			sum += sig.SerializedSize()
+		}
+		for _, uid := range key.UserIDs {
+			sum += uid.SerializedSize()
+		}
+		for _, subkey := range key.SubKeys {
+			sum += subkey.SerializedSize()
+		}
+		c.Assert(sum, gc.Equals, key.SerializedSize())
+	}
+
+	// A packet with an old format header is rewritten with a new one.
+	p := &Packet{Packet: []byte{0xb4, 0x03, 'B', 'o', 'b'}}
+	c.Assert(p.SerializedSize(), gc.Equals, int64(5))
+	p = &Packet{Packet: []byte{0xb5, 0x00, 0x03, 'B', 'o', 'b'}}
+	c.Assert(p.SerializedSize(), gc.Equals, int64(5))
+}
+
+func (s *SamplePacketSuite) TestPackets(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	packets, err := key.Packets()
+	c.Assert(err, gc.IsNil)
+	c.Assert(packets, gc.HasLen, 5)
+	c.Assert(sort.IsSorted(opaquePacketSlice(packets)), gc.Equals, true)
+	c.Assert(sksDigestOpaque(packets, md5.New()), gc.Equals, key.MD5)
+
+	// The packets are copies, which do not alias the key.
+	for _, op := range packets {
+		for i := range op.Contents {
+			op.Contents[i] = 0
+		}
+	}
+	digest, err := SksDigest(key, md5.New())
+	c.Assert(err, gc.IsNil)
+	c.Assert(digest, gc.Equals, key.MD5)
+
+	// Duplicates recorded by CollectDuplicates are restored.
+	var buf bytes.Buffer
+	c.Assert(WritePackets(&buf, key), gc.IsNil)
+	for _, p := range []*Packet{&key.UserIDs[0].Packet, &key.UserIDs[0].Signatures[0].Packet} {
+		op, err := p.opaquePacket()
+		c.Assert(err, gc.IsNil)
+		c.Assert(op.Serialize(&buf), gc.IsNil)
+	}
+	dup := ReadKeys(bytes.NewReader(buf.Bytes())).MustParse()[0]
+	submitted := dup.MD5
+	c.Assert(CollectDuplicates(dup), gc.IsNil)
+	c.Assert(dup.MD5, gc.Equals, key.MD5)
+	packets, err = dup.Packets()
+	c.Assert(err, gc.IsNil)
+	c.Assert(packets, gc.HasLen, 7)
+	c.Assert(sksDigestOpaque(packets, md5.New()), gc.Equals, submitted)
+}
+
+func (s *SamplePacketSuite) TestPacketOrigins(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert(newTestEntity(c, "Alice").Serialize(&buf), gc.IsNil)
+	second := int64(buf.Len())
+	c.Assert(newTestEntity(c, "Bob").Serialize(&buf), gc.IsNil)
+	// An old format user ID with a one-octet length.
+	uidOffset := int64(buf.Len())
+	buf.Write([]byte{0xb4, 0x03, 'B', 'o', 'b'})
+	data := buf.Bytes()
+
+	var okrs []*OpaqueKeyring
+	for okr := range ReadOpaqueKeyrings(bytes.NewReader(data)) {
+		c.Assert(okr.Error, gc.IsNil)
+		c.Assert(okr.Origins, gc.HasLen, len(okr.Packets))
+		okrs = append(okrs, okr)
+	}
+	c.Assert(okrs, gc.HasLen, 2)
+	c.Assert(okrs[1].Position, gc.Equals, second)
+	origin := okrs[1].Origins[0]
+	c.Assert(origin.Offset, gc.Equals, second)
+	c.Assert(origin.NewFormat, gc.Equals, true)
+	c.Assert(origin.Length, gc.Equals, int64(1+origin.LengthOctets+len(okrs[1].Packets[0].Contents)))
+	origin = okrs[1].Origins[len(okrs[1].Origins)-1]
+	c.Assert(*origin, gc.Equals, PacketOrigin{Offset: uidOffset, Length: 5, LengthOctets: 1})
+
+	keys := ReadKeys(bytes.NewReader(data)).MustParse()
+	c.Assert(keys, gc.HasLen, 2)
+	c.Assert(keys[1].Origin.Offset, gc.Equals, second)
+	c.Assert(keys[1].UserIDs, gc.HasLen, 2)
+	c.Assert(keys[1].UserIDs[1].Origin.String(), gc.Equals, fmt.Sprintf("offset %d", uidOffset))
+	sig := keys[1].UserIDs[0].Signatures[0]
+	c.Assert(data[sig.Origin.Offset:sig.Origin.Offset+sig.Origin.Length], gc.DeepEquals, sig.Packet.Packet)
+
+	// Dump files record origins alike.
+	dumpKeys := (&DumpFile{data: data}).ReadKeys().MustParse()
+	c.Assert(dumpKeys[1].UserIDs[1].Origin, gc.DeepEquals, keys[1].UserIDs[1].Origin)
+	c.Assert(dumpKeys[0].SubKeys[0].Origin, gc.DeepEquals, keys[0].SubKeys[0].Origin)
+
+	// Keys which are not read from input have no origins.
+	key := entityKey(c, newTestEntity(c, "Carol"))
+	packets, err := key.Packets()
+	c.Assert(err, gc.IsNil)
+	built, err := (&OpaqueKeyring{Packets: packets}).Parse()
+	c.Assert(err, gc.IsNil)
+	c.Assert(built.Origin, gc.IsNil)
+}
@@ -0,0 +1,128 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrKeyBlockMismatch is the cause of errors for a Key Block subpacket
+// carrying a key other than the one which issued the signature.
+var ErrKeyBlockMismatch = errors.New("key block does not match signature issuer")
+
+// keyBlockData returns the contents of the first Key Block subpacket (type 38)
+// of the signature, if any, without its leading reserved octet.
+func (sig *Signature) keyBlockData() ([]byte, error) {
+	op, err := sig.opaquePacket()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !hasSubpacketAreas(op.Contents) {
+		return nil, nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var data []byte
+	var found bool
+	for _, area := range areas {
+		err := forEachSubpacket(area, func(typ byte, sub []byte) {
+			if typ == 38 && !found {
+				data, found = sub, true
+			}
+		})
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	if len(data) < 1 || data[0] != 0 {
+		return nil, errgo.New("malformed key block subpacket")
+	}
+	return data[1:], nil
+}
+
+// KeyBlock parses the key carried in the signature's Key Block subpacket, by
+// which the issuer may distribute its key along with its signatures. It
+// returns nil if the signature has no such subpacket. An error caused by
+// ErrKeyBlockMismatch is returned if the key is not that of the issuer.
+//
+// Key Block subpackets in the signatures of the key returned are not parsed
+// until requested of them in turn.
+func (sig *Signature) KeyBlock(opts ...ReadOption) (*PrimaryKey, error) {
+	data, err := sig.keyBlockData()
+	if err != nil || data == nil {
+		return nil, errgo.Mask(err)
+	}
+	var key *PrimaryKey
+	for keyRead := range ReadKeys(bytes.NewReader(data), opts...) {
+		switch {
+		case err != nil:
+		case keyRead.Error != nil:
+			err = keyRead.Error
+		case key != nil:
+			err = ErrMultiplePrimaryKeys
+		default:
+			key = keyRead.PrimaryKey
+		}
+	}
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if key == nil {
+		return nil, errgo.Mask(ErrNoPrimaryKey, errgo.Any)
+	}
+	if sig.RIssuerKeyID == "" || !strings.HasPrefix(key.RFingerprint, sig.RIssuerKeyID) {
+		return nil, errgo.WithCausef(nil, ErrKeyBlockMismatch,
+			"key block holds %s, signature issued by %s", key.Fingerprint(), sig.IssuerKeyID())
+	}
+	return key, nil
+}
+
+// KeyBlocks returns the keys carried in Key Block subpackets of all
+// signatures on the key, so that keys distributed only within signatures can
+// be harvested. Key blocks which cannot be read or do not match their
+// signature's issuer are returned among the errors, which list the cause for
+// each offending signature.
+func (pubkey *PrimaryKey) KeyBlocks(opts ...ReadOption) ([]*PrimaryKey, []error) {
+	var keys []*PrimaryKey
+	var errs []error
+	for _, node := range pubkey.contents() {
+		sig, ok := node.(*Signature)
+		if !ok {
+			continue
+		}
+		key, err := sig.KeyBlock(opts...)
+		if err != nil {
+			errs = append(errs, errgo.NoteMask(err, "signature "+sig.UUID, errgo.Any))
+		} else if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, errs
+}
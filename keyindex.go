@@ -0,0 +1,131 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import "strings"
+
+// KeyringIndex indexes a collection of keys by the key IDs and fingerprints
+// of their primary keys and subkeys, so that the issuers of signatures can be
+// found. Several keys may share a key ID, but a fingerprint identifies at most
+// one key.
+//
+// A KeyringIndex may be read concurrently once built, but must not be added to
+// while being read.
+type KeyringIndex struct {
+	keys          map[string]*PrimaryKey
+	byKeyID       map[string][]*PrimaryKey
+	byFingerprint map[string]*PrimaryKey
+}
+
+// NewKeyringIndex returns a new, empty KeyringIndex.
+func NewKeyringIndex() *KeyringIndex {
+	return &KeyringIndex{
+		keys:          make(map[string]*PrimaryKey),
+		byKeyID:       make(map[string][]*PrimaryKey),
+		byFingerprint: make(map[string]*PrimaryKey),
+	}
+}
+
+// IndexKeys indexes all keys received from c. Keys which could not be read are
+// skipped.
+func IndexKeys(c PrimaryKeyChan) *KeyringIndex {
+	idx := NewKeyringIndex()
+	for readKey := range c {
+		if readKey.Error == nil && readKey.PrimaryKey != nil {
+			idx.Add(readKey.PrimaryKey)
+		}
+	}
+	return idx
+}
+
+// Len returns the number of keys in the index.
+func (idx *KeyringIndex) Len() int {
+	return len(idx.keys)
+}
+
+// Add indexes a key, replacing any key already indexed with the same
+// fingerprint.
+func (idx *KeyringIndex) Add(key *PrimaryKey) {
+	if prev, ok := idx.keys[key.RFingerprint]; ok {
+		idx.remove(prev)
+	}
+	idx.keys[key.RFingerprint] = key
+	idx.byKeyID[key.RKeyID] = append(idx.byKeyID[key.RKeyID], key)
+	idx.byFingerprint[key.RFingerprint] = key
+	for _, subkey := range key.SubKeys {
+		if subkey.RKeyID != key.RKeyID {
+			idx.byKeyID[subkey.RKeyID] = append(idx.byKeyID[subkey.RKeyID], key)
+		}
+		if _, ok := idx.byFingerprint[subkey.RFingerprint]; !ok {
+			idx.byFingerprint[subkey.RFingerprint] = key
+		}
+	}
+}
+
+func (idx *KeyringIndex) remove(key *PrimaryKey) {
+	delete(idx.keys, key.RFingerprint)
+	ids := []string{key.RKeyID}
+	fps := []string{key.RFingerprint}
+	for _, subkey := range key.SubKeys {
+		ids = append(ids, subkey.RKeyID)
+		fps = append(fps, subkey.RFingerprint)
+	}
+	for _, id := range ids {
+		var keys []*PrimaryKey
+		for _, k := range idx.byKeyID[id] {
+			if k != key {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			delete(idx.byKeyID, id)
+		} else {
+			idx.byKeyID[id] = keys
+		}
+	}
+	for _, fp := range fps {
+		if idx.byFingerprint[fp] == key {
+			delete(idx.byFingerprint, fp)
+		}
+	}
+}
+
+// Lookup returns the keys having a primary key or subkey with the given
+// hex-encoded 64-bit key ID or fingerprint, which may be prefixed by "0x".
+// Short key IDs, which are trivially forged, are not supported.
+func (idx *KeyringIndex) Lookup(id string) []*PrimaryKey {
+	id = strings.TrimPrefix(strings.ToLower(id), "0x")
+	switch len(id) {
+	case 16:
+		return idx.byKeyID[Reverse(id)]
+	case 32, 40, 64:
+		if key, ok := idx.byFingerprint[Reverse(id)]; ok {
+			return []*PrimaryKey{key}
+		}
+	}
+	return nil
+}
+
+// Issuers returns the keys which may have issued sig: those having a primary
+// key or subkey with its issuer key ID.
+func (idx *KeyringIndex) Issuers(sig *Signature) []*PrimaryKey {
+	if sig.RIssuerKeyID == "" {
+		return nil
+	}
+	return idx.byKeyID[sig.RIssuerKeyID]
+}
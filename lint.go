@@ -0,0 +1,365 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LintCode identifies a kind of lint warning.
+type LintCode string
+
+const (
+	// LintWeakSelfSigHash is given for self-signatures made with a broken
+	// hash algorithm: MD5, SHA-1 or RIPEMD-160.
+	LintWeakSelfSigHash LintCode = "weak-self-sig-hash"
+
+	// LintMissingSelfSig is given for user IDs which have no self-signature.
+	LintMissingSelfSig LintCode = "missing-self-sig"
+
+	// LintHugeUserAttribute is given for user attribute packets larger than
+	// MaxLintUserAttributeLen.
+	LintHugeUserAttribute LintCode = "huge-user-attribute"
+
+	// LintInvalidUTF8 is given for user IDs which are not valid UTF-8 text.
+	LintInvalidUTF8 LintCode = "invalid-utf8"
+
+	// LintHomographEmail is given for user IDs with an email address whose
+	// domain may be mistaken for another, such as one mixing Latin and
+	// Cyrillic letters, so that search results can warn of look-alike
+	// keys.
+	LintHomographEmail LintCode = "homograph-email"
+
+	// LintElGamalSignEncrypt is given for primary keys and sub-keys using
+	// the ElGamal sign and encrypt algorithm (20), whose signatures can be
+	// forged.
+	LintElGamalSignEncrypt LintCode = "elgamal-sign-encrypt"
+
+	// LintWeakDSAKey is given for primary keys and sub-keys using DSA with
+	// a key of 1024 bits or less, which is limited to SHA-1 and is within
+	// reach of well-funded attackers.
+	LintWeakDSAKey LintCode = "weak-dsa-key"
+
+	// LintWeakBackSigHash is given for sub-key binding signatures whose
+	// embedded primary key binding signature, by which a signing sub-key
+	// cross-certifies its primary key, is made with a broken hash
+	// algorithm.
+	LintWeakBackSigHash LintCode = "weak-back-sig-hash"
+
+	// LintSigBeforeKey is given for signatures created before the key, or
+	// the sub-key, which they certify or bind. No genuine signature can be
+	// made before its key exists, so such signatures are forged or
+	// corrupt.
+	LintSigBeforeKey LintCode = "sig-before-key"
+
+	// LintSubKeyBeforePrimary is given for sub-keys created before their
+	// primary key.
+	LintSubKeyBeforePrimary LintCode = "subkey-before-primary"
+)
+
+// MaxLintUserAttributeLen is the user attribute packet length above which a
+// LintHugeUserAttribute warning is given.
+var MaxLintUserAttributeLen = 64 * 1024
+
+// LintWarning describes a questionable feature of a key, which does not
+// prevent it from being read.
+type LintWarning struct {
+	Code LintCode
+
+	// UUID identifies the packet concerned.
+	UUID string
+
+	Message string
+
+	// Algorithm and BitLen are the public key algorithm and size, and Hash
+	// the hash algorithm, of warnings about weak cryptography, so that
+	// they can be tallied without parsing Message. They are zero where
+	// they do not apply.
+	Algorithm int
+	BitLen    int
+	Hash      int
+}
+
+func (w *LintWarning) String() string {
+	return fmt.Sprintf("%s: %s: %s", w.Code, w.UUID, w.Message)
+}
+
+// linters are the checks run by Lint.
+var linters = []func(*PrimaryKey) []*LintWarning{
+	lintWeakSelfSigHash,
+	lintMissingSelfSig,
+	lintHugeUserAttribute,
+	lintInvalidUTF8,
+	lintHomographEmail,
+	lintElGamalSignEncrypt,
+	lintWeakDSAKey,
+	lintWeakBackSigHash,
+	lintCreationTimes,
+}
+
+// Lint runs lightweight checks on a key, without verifying any signatures.
+func Lint(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, linter := range linters {
+		result = append(result, linter(key)...)
+	}
+	return result
+}
+
+// isSelfSig returns whether the signature was issued by the primary key.
+func (pubkey *PrimaryKey) isSelfSig(sig *Signature) bool {
+	return sig.RIssuerKeyID != "" && strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID)
+}
+
+// hashAlgorithm returns the OpenPGP hash algorithm identifier of the
+// signature, read directly from the packet contents.
+func (sig *Signature) hashAlgorithm() (int, bool) {
+	op, err := sig.opaquePacket()
+	if err != nil || len(op.Contents) == 0 {
+		return 0, false
+	}
+	switch op.Contents[0] {
+	case 2, 3:
+		if len(op.Contents) > 16 {
+			return int(op.Contents[16]), true
+		}
+	case 4, 6:
+		if len(op.Contents) > 3 {
+			return int(op.Contents[3]), true
+		}
+	}
+	return 0, false
+}
+
+func lintWeakSelfSigHash(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, node := range key.contents() {
+		sig, ok := node.(*Signature)
+		if !ok || !key.isSelfSig(sig) {
+			continue
+		}
+		hash, ok := sig.hashAlgorithm()
+		if !ok {
+			continue
+		}
+		name, weak := weakHash(hash)
+		if !weak {
+			continue
+		}
+		result = append(result, &LintWarning{
+			Code:    LintWeakSelfSigHash,
+			UUID:    sig.UUID,
+			Message: fmt.Sprintf("self-signature uses %s", name),
+			Hash:    hash,
+		})
+	}
+	return result
+}
+
+// weakHash returns the name of an OpenPGP hash algorithm, if it is broken.
+func weakHash(hash int) (string, bool) {
+	switch hash {
+	case 1:
+		return "MD5", true
+	case 2:
+		return "SHA-1", true
+	case 3:
+		return "RIPEMD-160", true
+	}
+	return "", false
+}
+
+func lintMissingSelfSig(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, uid := range key.UserIDs {
+		var found bool
+		for _, sig := range uid.Signatures {
+			if key.isSelfSig(sig) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, &LintWarning{
+				Code:    LintMissingSelfSig,
+				UUID:    uid.UUID,
+				Message: fmt.Sprintf("user ID %q has no self-signature", uid.Keywords),
+			})
+		}
+	}
+	return result
+}
+
+func lintHugeUserAttribute(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, uat := range key.UserAttributes {
+		if len(uat.Packet.Packet) > MaxLintUserAttributeLen {
+			result = append(result, &LintWarning{
+				Code:    LintHugeUserAttribute,
+				UUID:    uat.UUID,
+				Message: fmt.Sprintf("user attribute is %d bytes", len(uat.Packet.Packet)),
+			})
+		}
+	}
+	return result
+}
+
+func lintInvalidUTF8(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, uid := range key.UserIDs {
+		if uid.InvalidUTF8 {
+			result = append(result, &LintWarning{
+				Code:    LintInvalidUTF8,
+				UUID:    uid.UUID,
+				Message: fmt.Sprintf("user ID %q is not valid UTF-8", uid.Keywords),
+			})
+		}
+	}
+	return result
+}
+
+func lintElGamalSignEncrypt(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	if key.Algorithm == algoElGamalSignEncrypt {
+		result = append(result, &LintWarning{
+			Code:    LintElGamalSignEncrypt,
+			UUID:    key.UUID,
+			Message: "primary key uses ElGamal sign and encrypt",
+		})
+	}
+	for _, subkey := range key.SubKeys {
+		if subkey.Algorithm == algoElGamalSignEncrypt {
+			result = append(result, &LintWarning{
+				Code:    LintElGamalSignEncrypt,
+				UUID:    subkey.UUID,
+				Message: fmt.Sprintf("sub-key %s uses ElGamal sign and encrypt", subkey.KeyID()),
+			})
+		}
+	}
+	return result
+}
+
+func lintWeakDSAKey(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	check := func(pk *PublicKey, what string) {
+		if pk.Algorithm == 17 && pk.BitLen > 0 && pk.BitLen <= 1024 { //packet.PubKeyAlgoDSA
+			result = append(result, &LintWarning{
+				Code:      LintWeakDSAKey,
+				UUID:      pk.UUID,
+				Message:   fmt.Sprintf("%s is %d-bit DSA", what, pk.BitLen),
+				Algorithm: pk.Algorithm,
+				BitLen:    pk.BitLen,
+			})
+		}
+	}
+	check(&key.PublicKey, "primary key")
+	for _, subkey := range key.SubKeys {
+		check(&subkey.PublicKey, "sub-key "+subkey.KeyID())
+	}
+	return result
+}
+
+func lintWeakBackSigHash(key *PrimaryKey) []*LintWarning {
+	var result []*LintWarning
+	for _, subkey := range key.SubKeys {
+		for _, sig := range subkey.Signatures {
+			if sig.SigType != 0x18 || !key.isSelfSig(sig) { //packet.SigTypeSubkeyBinding
+				continue
+			}
+			for _, hash := range sig.embeddedHashAlgorithms() {
+				if name, weak := weakHash(hash); weak {
+					result = append(result, &LintWarning{
+						Code:    LintWeakBackSigHash,
+						UUID:    sig.UUID,
+						Message: fmt.Sprintf("sub-key %s cross-certification uses %s", subkey.KeyID(), name),
+						Hash:    hash,
+					})
+				}
+			}
+		}
+	}
+	return result
+}
+
+// embeddedHashAlgorithms returns the hash algorithms of the V4 signatures
+// embedded in the signature's subpackets.
+func (sig *Signature) embeddedHashAlgorithms() []int {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil
+	}
+	var result []int
+	for _, area := range areas {
+		forEachSubpacket(area, func(typ byte, data []byte) {
+			if typ == 32 && len(data) > 3 && data[0] == 4 { // embedded signature
+				result = append(result, int(data[3]))
+			}
+		})
+	}
+	return result
+}
+
+func lintCreationTimes(key *PrimaryKey) []*LintWarning {
+	if key.Creation.IsZero() {
+		return nil
+	}
+	var result []*LintWarning
+	checkSigs := func(sigs []*Signature, created time.Time, what string) {
+		for _, sig := range sigs {
+			if !sig.Creation.IsZero() && sig.Creation.Before(created) {
+				result = append(result, &LintWarning{
+					Code: LintSigBeforeKey,
+					UUID: sig.UUID,
+					Message: fmt.Sprintf("signature by %s created %s, before %s created %s",
+						sig.IssuerKeyID(), sig.Creation.UTC().Format(time.RFC3339),
+						what, created.UTC().Format(time.RFC3339)),
+				})
+			}
+		}
+	}
+	checkSigs(key.Signatures, key.Creation, "primary key")
+	for _, uid := range key.UserIDs {
+		checkSigs(uid.Signatures, key.Creation, "primary key")
+	}
+	for _, uat := range key.UserAttributes {
+		checkSigs(uat.Signatures, key.Creation, "primary key")
+	}
+	for _, subkey := range key.SubKeys {
+		created, what := subkey.Creation, "sub-key "+subkey.KeyID()
+		if subkey.Creation.Before(key.Creation) {
+			if !subkey.Creation.IsZero() {
+				result = append(result, &LintWarning{
+					Code: LintSubKeyBeforePrimary,
+					UUID: subkey.UUID,
+					Message: fmt.Sprintf("%s created %s, before primary key created %s",
+						what, subkey.Creation.UTC().Format(time.RFC3339),
+						key.Creation.UTC().Format(time.RFC3339)),
+				})
+			}
+			created, what = key.Creation, "primary key"
+		}
+		checkSigs(subkey.Signatures, created, what)
+	}
+	return result
+}
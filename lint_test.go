@@ -0,0 +1,226 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/rand"
+	"encoding/json"
+	"hash"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+	gc "gopkg.in/check.v1"
+
+	"github.com/schmorrison/testing"
+)
+
+type LintSuite struct{}
+
+var _ = gc.Suite(&LintSuite{})
+
+func lintCodes(warnings []*LintWarning) map[LintCode]int {
+	result := make(map[LintCode]int)
+	for _, w := range warnings {
+		result[w.Code]++
+	}
+	return result
+}
+
+func (s *LintSuite) TestMissingSelfSig(c *gc.C) {
+	key := MustInputAscKey("sksdigest.asc")
+	c.Assert(lintCodes(Lint(key))[LintMissingSelfSig], gc.Equals, 0)
+
+	key.UserIDs[0].Signatures = nil
+	warnings := Lint(key)
+	c.Assert(lintCodes(warnings)[LintMissingSelfSig], gc.Equals, 1)
+	for _, w := range warnings {
+		if w.Code == LintMissingSelfSig {
+			c.Assert(w.UUID, gc.Equals, key.UserIDs[0].UUID)
+		}
+	}
+}
+
+func (s *LintSuite) TestHugeUserAttribute(c *gc.C) {
+	key := MustInputAscKey("uat.asc")
+	c.Assert(lintCodes(Lint(key))[LintHugeUserAttribute], gc.Equals, 0)
+
+	defer func(n int) { MaxLintUserAttributeLen = n }(MaxLintUserAttributeLen)
+	MaxLintUserAttributeLen = 16
+	c.Assert(lintCodes(Lint(key))[LintHugeUserAttribute], gc.Equals, 1)
+}
+
+func (s *LintSuite) TestWithLint(c *gc.C) {
+	for keyRead := range MustReadArmorKeys(testing.MustInput("sksdigest.asc"), WithLint()) {
+		c.Assert(keyRead.Error, gc.IsNil)
+		c.Assert(keyRead.Warnings, gc.DeepEquals, Lint(keyRead.PrimaryKey))
+	}
+}
+
+func (s *LintSuite) TestInvalidUTF8(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	c.Assert(key.UserIDs[0].InvalidUTF8, gc.Equals, false)
+	c.Assert(lintCodes(Lint(key))[LintInvalidUTF8], gc.Equals, 0)
+
+	entity := newTestEntity(c, "Al\xffice \xe2\x98")
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	raw := buf.Bytes()
+	keys := ReadKeys(bytes.NewReader(raw)).MustParse()
+	c.Assert(keys[0].UserIDs[0].InvalidUTF8, gc.Equals, true)
+	c.Assert(keys[0].UserIDs[0].Keywords, gc.Equals, "Al?ice ??")
+	c.Assert(lintCodes(Lint(keys[0]))[LintInvalidUTF8], gc.Equals, 1)
+
+	repaired := ReadKeys(bytes.NewReader(raw), ReplaceInvalidUTF8()).MustParse()
+	c.Assert(repaired[0].UserIDs[0].Keywords, gc.Equals, "Al\ufffdice \ufffd\ufffd")
+	c.Assert(repaired[0].MD5, gc.Equals, keys[0].MD5)
+	c.Assert(repaired[0].UserIDs[0].SelfSigs(repaired[0]).Errors, gc.HasLen, 0)
+	_, err := json.Marshal(repaired[0].UserIDs[0].Keywords)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *LintSuite) TestHomographEmail(c *gc.C) {
+	for _, testCase := range []struct {
+		domain, problem string
+	}{
+		{"example.com", ""},
+		{"xn--bcher-kva.example", ""},
+		{"bücher.example", ""},
+		{"日本語.jp", ""},
+		{"xn--80ak6aa92e.com", `label "аррӏе" looks like "apple"`},
+		{"аpple.com", `mixes Cyrillic and Latin scripts in label "аpple"`},
+		{"ｅｘａｍｐｌｅ．com", `maps to ASCII domain "example.com"`},
+		{"xn--a-zlb.example", `mixes Latin and Greek scripts in label "aα"`},
+		{"xn--99999999.com", `has an invalid punycode label "xn--99999999"`},
+	} {
+		c.Assert(homograph(testCase.domain), gc.Equals, testCase.problem, gc.Commentf("%s", testCase.domain))
+	}
+
+	key := entityKey(c, emailEntity(c, "Alice", "alice@xn--80ak6aa92e.com"))
+	warnings := Lint(key)
+	c.Assert(lintCodes(warnings)[LintHomographEmail], gc.Equals, 1)
+	key = entityKey(c, emailEntity(c, "Alice", "alice@example.com"))
+	c.Assert(lintCodes(Lint(key))[LintHomographEmail], gc.Equals, 0)
+}
+
+// elGamalSignEncryptKey returns a serialized public key or sub-key packet
+// using algorithm 20, which golang.org/x/crypto/openpgp cannot parse.
+func elGamalSignEncryptKey(c *gc.C, tag uint8) []byte {
+	contents := []byte{4, 0x5e, 0, 0, 0, 20}
+	for _, mpi := range [][]byte{{0xf7}, {0x05}, {0x3b}} {
+		contents = append(contents, 0, 8)
+		contents = append(contents, mpi...)
+	}
+	var buf bytes.Buffer
+	c.Assert((&packet.OpaquePacket{Tag: tag, Contents: contents}).Serialize(&buf), gc.IsNil)
+	return buf.Bytes()
+}
+
+func (s *LintSuite) TestElGamalSignEncrypt(c *gc.C) {
+	var buf bytes.Buffer
+	buf.Write(elGamalSignEncryptKey(c, 6))
+	c.Assert(packet.NewUserId("Old", "", "").Serialize(&buf), gc.IsNil)
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].Algorithm, gc.Equals, 20)
+	c.Assert(keys[0].Creation.Unix(), gc.Equals, int64(0x5e000000))
+	c.Assert(lintCodes(Lint(keys[0]))[LintElGamalSignEncrypt], gc.Equals, 1)
+
+	entity := newTestEntity(c, "Alice")
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	buf.Write(elGamalSignEncryptKey(c, 14))
+	keys = ReadKeys(&buf).MustParse()
+	c.Assert(keys[0].SubKeys, gc.HasLen, 2)
+	warnings := Lint(keys[0])
+	c.Assert(lintCodes(warnings)[LintElGamalSignEncrypt], gc.Equals, 1)
+	for _, w := range warnings {
+		if w.Code == LintElGamalSignEncrypt {
+			c.Assert(w.UUID, gc.Equals, keys[0].SubKeys[1].UUID)
+		}
+	}
+}
+
+func (s *LintSuite) TestWeakDSAKey(c *gc.C) {
+	var priv dsa.PrivateKey
+	c.Assert(dsa.GenerateParameters(&priv.Parameters, rand.Reader, dsa.L1024N160), gc.IsNil)
+	c.Assert(dsa.GenerateKey(&priv, rand.Reader), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(packet.NewDSAPublicKey(time.Now(), &priv.PublicKey).Serialize(&buf), gc.IsNil)
+	c.Assert(packet.NewUserId("Old", "", "").Serialize(&buf), gc.IsNil)
+	keys := ReadKeys(&buf).MustParse()
+	warnings := Lint(keys[0])
+	c.Assert(lintCodes(warnings)[LintWeakDSAKey], gc.Equals, 1)
+	for _, w := range warnings {
+		if w.Code == LintWeakDSAKey {
+			c.Assert(w.UUID, gc.Equals, keys[0].UUID)
+			c.Assert(w.Algorithm, gc.Equals, 17)
+			c.Assert(w.BitLen, gc.Equals, 1024)
+		}
+	}
+
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	c.Assert(lintCodes(Lint(key))[LintWeakDSAKey], gc.Equals, 0)
+}
+
+func (s *LintSuite) TestWeakBackSigHash(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	key := ReadKeys(bytes.NewReader(buf.Bytes())).MustParse()[0]
+	c.Assert(lintCodes(Lint(key))[LintWeakBackSigHash], gc.Equals, 0)
+
+	// A binding signature embedding a SHA-1 primary key binding signature.
+	backSig := []byte{4, 0x19, 1, 2, 0, 6, 5, 2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 1}
+	buf.Write(rawSig(c, alice, 0x18, time.Now(), func(h hash.Hash) { keyBody(c, h, alice) }, sigSubpacket(32, backSig)))
+	key = ReadKeys(&buf).MustParse()[0]
+	warnings := Lint(key)
+	c.Assert(lintCodes(warnings)[LintWeakBackSigHash], gc.Equals, 1)
+	for _, w := range warnings {
+		if w.Code == LintWeakBackSigHash {
+			c.Assert(w.UUID, gc.Equals, key.SubKeys[0].Signatures[1].UUID)
+			c.Assert(w.Hash, gc.Equals, 2)
+		}
+	}
+}
+
+func (s *LintSuite) TestCreationTimes(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	codes := lintCodes(Lint(key))
+	c.Assert(codes[LintSigBeforeKey], gc.Equals, 0)
+	c.Assert(codes[LintSubKeyBeforePrimary], gc.Equals, 0)
+
+	// A certification dated before the key was created.
+	key.UserIDs[0].Signatures[0].Creation = key.Creation.Add(-time.Hour)
+	c.Assert(lintCodes(Lint(key))[LintSigBeforeKey], gc.Equals, 1)
+
+	key = entityKey(c, newTestEntity(c, "Bob"))
+	key.SubKeys[0].Creation = key.Creation.Add(-time.Hour)
+	key.SubKeys[0].Signatures[0].Creation = key.Creation.Add(-time.Minute)
+	warnings := Lint(key)
+	codes = lintCodes(warnings)
+	c.Assert(codes[LintSubKeyBeforePrimary], gc.Equals, 1)
+	c.Assert(codes[LintSigBeforeKey], gc.Equals, 1)
+	for _, w := range warnings {
+		if w.Code == LintSigBeforeKey {
+			c.Assert(w.UUID, gc.Equals, key.SubKeys[0].Signatures[0].UUID)
+			c.Assert(w.Message, gc.Matches, ".* before primary key created .*")
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"io"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// MergeDumpsResult summarizes the consolidation of several dumps by
+// MergeDumps.
+type MergeDumpsResult struct {
+	// Keys is the number of distinct keys written.
+	Keys int
+
+	// Merged is the number of keys read which were merged into another copy
+	// of the same key.
+	Merged int
+
+	// Errors is the number of keys which could not be read, and were left
+	// out.
+	Errors int
+
+	// Revocations is the number of standalone revocation certificates
+	// applied to the key they revoke. Those which revoke no key read are
+	// written out as they were read, ahead of the keys.
+	Revocations int
+}
+
+// MergeDumps reads the keys in each of the inputs in turn, merges all copies
+// of each key, and writes the merged keys to w as a single dump, in order of
+// fingerprint and without duplicate packets. All keys are held in memory
+// until written, so this is meant for offline consolidation of snapshots from
+// several peers. Standalone revocation certificates are applied to the keys
+// they revoke, wherever they appear in the inputs.
+func MergeDumps(w io.Writer, inputs []io.Reader, opts ...ReadOption) (*MergeDumpsResult, error) {
+	if newReadOptions(opts).partial() {
+		return nil, errgo.New("cannot merge partial keys")
+	}
+	result := &MergeDumpsResult{}
+	keys := make(map[string]*PrimaryKey)
+	var revs []*RevocationCert
+	for _, r := range inputs {
+		var err error
+		// The remaining keys are drained after an error, so that the
+		// reader is not left blocked.
+		for readKey := range ReadKeys(r, opts...) {
+			if err != nil {
+				continue
+			}
+			if readKey.Error != nil {
+				result.Errors++
+				continue
+			}
+			revs = append(revs, readKey.Revocations...)
+			key := readKey.PrimaryKey
+			if key == nil {
+				continue
+			}
+			prev, ok := keys[key.RFingerprint]
+			if !ok {
+				keys[key.RFingerprint] = key
+				continue
+			}
+			err = Merge(prev, key)
+			if err == nil {
+				result.Merged++
+			}
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+
+	byKeyID := make(map[string][]*PrimaryKey)
+	for _, key := range keys {
+		byKeyID[key.RKeyID] = append(byKeyID[key.RKeyID], key)
+	}
+	for _, rc := range revs {
+		applied := false
+		if len(rc.RIssuerKeyID) >= 16 {
+			for _, key := range byKeyID[rc.RIssuerKeyID[:16]] {
+				if ApplyRevocation(key, rc, nil) == nil {
+					applied = true
+				}
+			}
+		}
+		if applied {
+			result.Revocations++
+			continue
+		}
+		op, err := rc.Signature.opaquePacket()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		err = op.Serialize(w)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+
+	fps := make([]string, 0, len(keys))
+	for rfp := range keys {
+		fps = append(fps, Reverse(rfp))
+	}
+	sort.Strings(fps)
+	for _, fp := range fps {
+		key := keys[Reverse(fp)]
+		err := DropDuplicates(key)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		err = WritePackets(w, key)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		result.Keys++
+	}
+	return result, nil
+}
@@ -0,0 +1,217 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// MerkleLeaf is a key in a Merkle tree, identified by its fingerprint and
+// digest.
+type MerkleLeaf struct {
+	Fingerprint string
+	Digest      string
+}
+
+func (l MerkleLeaf) hash() []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write([]byte(l.Fingerprint))
+	h.Write([]byte{0})
+	h.Write([]byte(l.Digest))
+	return h.Sum(nil)
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+type merkleLeafSlice []MerkleLeaf
+
+func (s merkleLeafSlice) Len() int { return len(s) }
+
+func (s merkleLeafSlice) Less(i, j int) bool {
+	if s[i].Fingerprint != s[j].Fingerprint {
+		return s[i].Fingerprint < s[j].Fingerprint
+	}
+	return s[i].Digest < s[j].Digest
+}
+
+func (s merkleLeafSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// MerkleTree is a SHA-256 Merkle tree over a set of keys, sorted by
+// fingerprint, so that a snapshot of a key database can be summarized by its
+// root and the presence of any key in it proven.
+//
+// Leaves are hashed with a zero octet prefix and interior nodes with a one
+// octet prefix, so that neither can be passed off as the other. A node without
+// a sibling is carried up to the next level unchanged.
+type MerkleTree struct {
+	Leaves []MerkleLeaf
+
+	// levels holds the hashes of each level of the tree, from the leaves
+	// up to the root.
+	levels [][][]byte
+}
+
+// NewMerkleTree builds a Merkle tree over the given leaves, which it sorts.
+func NewMerkleTree(leaves []MerkleLeaf) *MerkleTree {
+	sort.Sort(merkleLeafSlice(leaves))
+	t := &MerkleTree{Leaves: leaves}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = leaf.hash()
+	}
+	t.levels = append(t.levels, level)
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+// MerkleTreeFromKeys builds a Merkle tree over the MD5 digests of keys
+// received from c, so that its root is that of MerkleTreeFromDigests over the
+// same keys. It fails on the first key which could not be read.
+func MerkleTreeFromKeys(c PrimaryKeyChan) (*MerkleTree, error) {
+	var leaves []MerkleLeaf
+	var err error
+	for readKey := range c {
+		if err != nil {
+			continue
+		}
+		if readKey.Error != nil {
+			err = readKey.Error
+			continue
+		}
+		if readKey.PrimaryKey == nil {
+			continue
+		}
+		leaves = append(leaves, MerkleLeaf{Fingerprint: readKey.Fingerprint(), Digest: readKey.MD5})
+	}
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return NewMerkleTree(leaves), nil
+}
+
+// MerkleTreeFromDigests builds a Merkle tree over the MD5 digests received
+// from c, such as those of a dump read with DigestKeyrings. It fails on the
+// first keyring which could not be digested.
+func MerkleTreeFromDigests(c DigestResultChan) (*MerkleTree, error) {
+	var leaves []MerkleLeaf
+	var err error
+	for result := range c {
+		if err != nil {
+			continue
+		}
+		if result.Error != nil {
+			err = result.Error
+			continue
+		}
+		leaves = append(leaves, MerkleLeaf{Fingerprint: Reverse(result.RFingerprint), Digest: result.MD5})
+	}
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return NewMerkleTree(leaves), nil
+}
+
+// Root returns the hex-encoded root hash of the tree. The root of an empty
+// tree is the SHA-256 digest of no input.
+func (t *MerkleTree) Root() string {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		d := sha256.Sum256(nil)
+		return hex.EncodeToString(d[:])
+	}
+	return hex.EncodeToString(top[0])
+}
+
+// MerkleStep is a sibling hash on the path from a leaf to the root.
+type MerkleStep struct {
+	Hash string
+
+	// Left indicates that the sibling is on the left.
+	Left bool
+}
+
+// MerkleProof proves the inclusion of a leaf in a Merkle tree.
+type MerkleProof struct {
+	Leaf MerkleLeaf
+	Path []MerkleStep
+}
+
+// Proof returns a proof of inclusion of the key with the given fingerprint,
+// if it is in the tree.
+func (t *MerkleTree) Proof(fingerprint string) (*MerkleProof, bool) {
+	i := sort.Search(len(t.Leaves), func(i int) bool {
+		return t.Leaves[i].Fingerprint >= fingerprint
+	})
+	if i == len(t.Leaves) || t.Leaves[i].Fingerprint != fingerprint {
+		return nil, false
+	}
+	proof := &MerkleProof{Leaf: t.Leaves[i]}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := i ^ 1
+		if sibling < len(level) {
+			proof.Path = append(proof.Path, MerkleStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < i,
+			})
+		}
+		i /= 2
+	}
+	return proof, true
+}
+
+// Verify returns whether the proof shows its leaf to be included in the tree
+// with the given hex-encoded root hash.
+func (p *MerkleProof) Verify(root string) bool {
+	h := p.Leaf.hash()
+	for _, step := range p.Path {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			h = merkleNode(sibling, h)
+		} else {
+			h = merkleNode(h, sibling)
+		}
+	}
+	return hex.EncodeToString(h) == root
+}
@@ -0,0 +1,56 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+// Metrics receives counts of the work done by the package, so that they can
+// be exported to a monitoring system without wrapping every call site.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// KeyParsed is called for each keyring successfully parsed into a key.
+	KeyParsed()
+
+	// ParseFailed is called for each keyring which could not be parsed,
+	// with the cause of the error, as reported by errgo.Cause. The cause is
+	// usually one of the package error values, such as ErrPacketTooLarge.
+	ParseFailed(cause error)
+
+	// KeyMerged is called for each successful Merge.
+	KeyMerged()
+
+	// DuplicatesDropped is called when duplicate packets are removed from a
+	// key, such as by Merge or DropDuplicates, with the number removed.
+	DuplicatesDropped(n int)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) KeyParsed()              {}
+func (noMetrics) ParseFailed(cause error) {}
+func (noMetrics) KeyMerged()              {}
+func (noMetrics) DuplicatesDropped(n int) {}
+
+var metrics Metrics = noMetrics{}
+
+// SetMetrics sets the receiver of the package's metrics, or disables them if
+// m is nil. It should be called during initialization, before keys are read.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noMetrics{}
+	}
+	metrics = m
+}
@@ -0,0 +1,181 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// Mutation is a way of corrupting serialized key material, used to build
+// adversarial inputs for fuzzing the parser.
+type Mutation int
+
+const (
+	// FlipTag changes the tag of a packet.
+	FlipTag Mutation = iota
+
+	// TruncateLength removes octets from the end of a packet body without
+	// adjusting its header, so that the body runs into the packets which
+	// follow it.
+	TruncateLength
+
+	// DuplicatePacket repeats a packet.
+	DuplicatePacket
+
+	// ScrambleSubpackets reorders the subpackets of a signature or user
+	// attribute packet, and corrupts the length of one of them. Other
+	// packets have an octet of their contents corrupted.
+	ScrambleSubpackets
+)
+
+// Mutations lists all the ways in which MutateKeyring can corrupt key
+// material.
+var Mutations = []Mutation{FlipTag, TruncateLength, DuplicatePacket, ScrambleSubpackets}
+
+// MutateKeyring applies a mutation to a randomly chosen packet of the
+// unarmored key material in data, which must be well formed, and returns the
+// result.
+func MutateKeyring(data []byte, m Mutation, rnd *rand.Rand) ([]byte, error) {
+	packets, err := splitPackets(data)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(packets) == 0 {
+		return nil, errgo.New("no packets to mutate")
+	}
+	target := rnd.Intn(len(packets))
+	var buf bytes.Buffer
+	for i, op := range packets {
+		if i != target {
+			err = op.Serialize(&buf)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			continue
+		}
+		switch m {
+		case FlipTag:
+			tag := op.Tag
+			for tag == op.Tag {
+				tag = uint8(1 + rnd.Intn(19))
+			}
+			err = (&packet.OpaquePacket{Tag: tag, Contents: op.Contents}).Serialize(&buf)
+		case TruncateLength:
+			var pbuf bytes.Buffer
+			err = op.Serialize(&pbuf)
+			if err == nil && len(op.Contents) > 0 {
+				pbuf.Truncate(pbuf.Len() - 1 - rnd.Intn(len(op.Contents)))
+			}
+			buf.Write(pbuf.Bytes())
+		case DuplicatePacket:
+			err = op.Serialize(&buf)
+			if err == nil {
+				err = op.Serialize(&buf)
+			}
+		case ScrambleSubpackets:
+			contents := scrambleSubpackets(op, rnd)
+			err = (&packet.OpaquePacket{Tag: op.Tag, Contents: contents}).Serialize(&buf)
+		default:
+			return nil, errgo.Newf("unknown mutation %d", m)
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// MalformedCorpus returns n variants of the unarmored key material in data,
+// each corrupted by a mutation chosen at random. The same seed always gives
+// the same corpus.
+func MalformedCorpus(data []byte, n int, seed int64) ([][]byte, error) {
+	rnd := rand.New(rand.NewSource(seed))
+	var result [][]byte
+	for i := 0; i < n; i++ {
+		m := Mutations[rnd.Intn(len(Mutations))]
+		mutated, err := MutateKeyring(data, m, rnd)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		result = append(result, mutated)
+	}
+	return result, nil
+}
+
+// splitPackets reads all the packets in data.
+func splitPackets(data []byte) ([]*packet.OpaquePacket, error) {
+	var result []*packet.OpaquePacket
+	r := NewOpaqueReader(bytes.NewReader(data))
+	for {
+		op, err := r.Next()
+		if err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		result = append(result, op)
+	}
+}
+
+// scrambleSubpackets returns a copy of the contents of a signature or user
+// attribute packet with its subpackets shuffled and the first length octet of
+// one of them changed. Other packets have a random octet of their contents
+// changed instead.
+func scrambleSubpackets(op *packet.OpaquePacket, rnd *rand.Rand) []byte {
+	contents := append([]byte(nil), op.Contents...)
+	var area []byte
+	switch {
+	case op.Tag == 17: //packet.PacketTypeUserAttribute
+		area = contents
+	case op.Tag == 2 && hasSubpacketAreas(contents): //packet.PacketTypeSignature
+		if areas, err := subpacketAreas(contents); err == nil {
+			area = areas[0]
+		}
+	}
+	if len(area) == 0 {
+		if len(contents) > 0 {
+			contents[rnd.Intn(len(contents))] ^= byte(1 + rnd.Intn(255))
+		}
+		return contents
+	}
+
+	var subpackets [][]byte
+	rest := area
+	for len(rest) > 0 {
+		n, hdr, err := subpacketLen(rest)
+		if err != nil {
+			break
+		}
+		subpackets = append(subpackets, append([]byte(nil), rest[:hdr+n]...))
+		rest = rest[hdr+n:]
+	}
+	if len(subpackets) == 0 {
+		return contents
+	}
+	subpackets[rnd.Intn(len(subpackets))][0] ^= byte(1 + rnd.Intn(255))
+	scrambled := area[:0]
+	for _, i := range rnd.Perm(len(subpackets)) {
+		scrambled = append(scrambled, subpackets[i]...)
+	}
+	return contents
+}
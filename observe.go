@@ -0,0 +1,122 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// MergeObserver is notified of the packets added to a key by MergeObserved and
+// ApplyRevocation, so that notifications can be sent or logs kept without
+// comparing the key before and after.
+type MergeObserver interface {
+	// OnUserIDAdded is called for each user ID added to key.
+	OnUserIDAdded(key *PrimaryKey, uid *UserID)
+
+	// OnSignatureAdded is called for each signature added to key, other
+	// than revocations, with the UUID of the packet signed.
+	OnSignatureAdded(key *PrimaryKey, target string, sig *Signature)
+
+	// OnRevocation is called for each key, sub-key or certification
+	// revocation signature added to key, with the UUID of the packet
+	// revoked.
+	OnRevocation(key *PrimaryKey, target string, sig *Signature)
+}
+
+// nodeKeys returns the keys, as given by nodeKey, of all packets of key.
+func nodeKeys(key *PrimaryKey) map[string]bool {
+	result := make(map[string]bool)
+	for _, node := range key.contents() {
+		result[nodeKey(node)] = true
+	}
+	return result
+}
+
+// notifyAdded notifies obs of the packets of key not in before.
+func notifyAdded(key *PrimaryKey, before map[string]bool, obs MergeObserver) {
+	sigs := func(target string, sigs []*Signature) {
+		for _, sig := range sigs {
+			if before[nodeKey(sig)] {
+				continue
+			}
+			switch sig.SigType {
+			case 0x20, 0x28, 0x30: // packet.SigTypeKeyRevocation, packet.SigTypeSubKeyRevocation, packet.SigTypeCertRevocation
+				obs.OnRevocation(key, target, sig)
+			default:
+				obs.OnSignatureAdded(key, target, sig)
+			}
+		}
+	}
+	sigs(key.UUID, key.Signatures)
+	for _, uid := range key.UserIDs {
+		if !before[nodeKey(uid)] {
+			obs.OnUserIDAdded(key, uid)
+		}
+		sigs(uid.UUID, uid.Signatures)
+	}
+	for _, uat := range key.UserAttributes {
+		sigs(uat.UUID, uat.Signatures)
+	}
+	for _, subkey := range key.SubKeys {
+		sigs(subkey.UUID, subkey.Signatures)
+	}
+}
+
+// ApplyRevocation adds a standalone revocation certificate to the key it
+// revokes, notifying obs if it is new; obs may be nil. The certificate must be
+// a valid key revocation signature by the key itself.
+func ApplyRevocation(key *PrimaryKey, rc *RevocationCert, obs MergeObserver) error {
+	if rc.RIssuerKeyID == "" || !strings.HasPrefix(key.UUID, rc.RIssuerKeyID) {
+		return errgo.Newf("revocation issued by %s, not key %s", rc.IssuerKeyID(), key.KeyID())
+	}
+	op, err := rc.Signature.opaquePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	sig, err := ParseSignature(op, key.UUID, key.UUID)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if sig.SigType != 0x20 { // packet.SigTypeKeyRevocation
+		return errgo.Newf("expected key revocation signature, got type 0x%x", sig.SigType)
+	}
+	err = key.verifyKeyRevocation(sig)
+	if err != nil {
+		return errgo.WithCausef(err, ErrBadSelfSignature, "invalid revocation")
+	}
+
+	var before map[string]bool
+	if obs != nil {
+		before = nodeKeys(key)
+	}
+	key.Signatures = append(key.Signatures, sig)
+	err = dedup(key, nil)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = key.updateMD5()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if obs != nil {
+		notifyAdded(key, before, obs)
+	}
+	return nil
+}
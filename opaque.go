@@ -0,0 +1,121 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// maxPreallocLen is the most that is allocated for a packet body ahead of
+// reading it, so that a bogus length does not cause a huge allocation.
+const maxPreallocLen = 64 * 1024
+
+// OpaqueReader reads a stream of packets without parsing their contents, like
+// packet.OpaqueReader, but with the framing implemented in this package.
+// Unlike packet.OpaqueReader, it fails with io.ErrUnexpectedEOF if the input
+// ends partway through a packet, and memory is allocated for packet bodies
+// only as they are read, not according to the length they claim. All tags
+// which may be framed are accepted, including the private and experimental
+// tags 60 to 63, except for the reserved tag 0.
+type OpaqueReader struct {
+	r byteReader
+
+	// MaxLength, if positive, is the longest packet body read. The body of
+	// a longer packet is skipped over without being held in memory, and Next
+	// returns an error caused by ErrPacketTooLarge, after which reading
+	// may continue with the following packet.
+	MaxLength int64
+}
+
+// NewOpaqueReader returns an OpaqueReader reading packets from r.
+func NewOpaqueReader(r io.Reader) *OpaqueReader {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &OpaqueReader{r: br}
+}
+
+// Next returns the next packet in the stream, or io.EOF at its end.
+func (or *OpaqueReader) Next() (*packet.OpaquePacket, error) {
+	op, _, err := or.next()
+	return op, err
+}
+
+// next is like Next, but also returns the header the packet was read with,
+// even if its body could not be.
+func (or *OpaqueReader) next() (*packet.OpaquePacket, *packetHeader, error) {
+	h, err := readPacketHeader(or.r)
+	if err != nil {
+		return nil, nil, err
+	}
+	contents, err := readPacketBody(or.r, h, or.MaxLength)
+	if err != nil {
+		return nil, h, errgo.Mask(err, errgo.Any)
+	}
+	return &packet.OpaquePacket{Tag: h.tag, Contents: contents}, h, nil
+}
+
+// limitWriter writes up to n octets to w, and discards any beyond, noting
+// that they were written.
+type limitWriter struct {
+	w        io.Writer
+	n        int64
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.exceeded || int64(len(p)) > lw.n {
+		lw.exceeded = true
+		return len(p), nil
+	}
+	lw.n -= int64(len(p))
+	return lw.w.Write(p)
+}
+
+// readPacketBody reads the body of a packet whose header has just been read
+// from r. If max is positive, a body longer than max octets is read to its
+// end but not kept, and an error caused by ErrPacketTooLarge is returned.
+func readPacketBody(r byteReader, h *packetHeader, max int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if n := h.length; n > 0 && (max <= 0 || n <= max) {
+		if n > maxPreallocLen {
+			n = maxPreallocLen
+		}
+		buf.Grow(int(n))
+	}
+	var w io.Writer = &buf
+	var lw *limitWriter
+	if max > 0 {
+		lw = &limitWriter{w: &buf, n: max}
+		w = lw
+	}
+	err := copyPacketBody(w, r, h)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if lw != nil && lw.exceeded {
+		return nil, errgo.WithCausef(nil, ErrPacketTooLarge, "packet with tag %d longer than %d octets", h.tag, max)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,173 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+// ReadOption configures how key material is read from a stream.
+type ReadOption func(*readOptions)
+
+type readOptions struct {
+	indexOnly    bool
+	skipTags     map[uint8]bool
+	maxPacketLen int
+	lint         bool
+	secretKeys   SecretKeyPolicy
+	unknown      UnknownPacketPolicy
+	replaceUTF8  bool
+	observer     PacketObserver
+	progress     func(ReadProgress)
+}
+
+func newReadOptions(opts []ReadOption) *readOptions {
+	ro := &readOptions{}
+	for _, opt := range opts {
+		opt(ro)
+	}
+	return ro
+}
+
+// IndexOnly reads only the primary key, sub-key and user ID packets of each
+// key, skipping signatures, user attributes and unrecognized packets. This is
+// much faster for building search indexes, but keys read this way are not
+// complete: their digests are not calculated and they must not be merged or
+// stored.
+func IndexOnly() ReadOption {
+	return func(ro *readOptions) {
+		ro.indexOnly = true
+	}
+}
+
+// SkipTags discards packets with the given tags as they are read, so that
+// special-purpose scans don't hold on to packets they will ignore. Primary
+// public key packets cannot be skipped. As with IndexOnly, keys read this way
+// are incomplete and are not digested.
+func SkipTags(tags ...uint8) ReadOption {
+	return func(ro *readOptions) {
+		if ro.skipTags == nil {
+			ro.skipTags = make(map[uint8]bool)
+		}
+		for _, tag := range tags {
+			ro.skipTags[tag] = true
+		}
+	}
+}
+
+// MaxPacketLen rejects keys containing a packet with contents longer than n
+// octets, with an error caused by ErrPacketTooLarge. Such contents are skipped
+// over as they are read, and never held in memory.
+func MaxPacketLen(n int) ReadOption {
+	return func(ro *readOptions) {
+		ro.maxPacketLen = n
+	}
+}
+
+// WithLint runs Lint on each key read, attaching any warnings to its
+// ReadKeyResult.
+func WithLint() ReadOption {
+	return func(ro *readOptions) {
+		ro.lint = true
+	}
+}
+
+// SecretKeys sets the policy for secret key and sub-key packets found in the
+// input. By default, keys containing them are rejected.
+func SecretKeys(policy SecretKeyPolicy) ReadOption {
+	return func(ro *readOptions) {
+		ro.secretKeys = policy
+	}
+}
+
+// UnknownPackets sets the policy for packets of an unknown type found in the
+// input. By default, they are discarded.
+func UnknownPackets(policy UnknownPacketPolicy) ReadOption {
+	return func(ro *readOptions) {
+		ro.unknown = policy
+	}
+}
+
+// ReplaceInvalidUTF8 replaces invalid UTF-8 sequences in the Keywords of user
+// IDs with U+FFFD, the Unicode replacement character, rather than '?', so that
+// they can be displayed as such. Keywords are always valid UTF-8, and so can
+// always be encoded as JSON; the user ID packets are read unchanged.
+func ReplaceInvalidUTF8() ReadOption {
+	return func(ro *readOptions) {
+		ro.replaceUTF8 = true
+	}
+}
+
+// PacketObserver is notified of each packet of a keyring as it is parsed,
+// with the packet tag and the length of its contents, so that deployments can
+// record packet size distributions and spot abuse, such as a surge of very
+// large user attribute packets.
+type PacketObserver interface {
+	ObservePacket(tag uint8, length int)
+}
+
+// PacketObserverFunc adapts a function to the PacketObserver interface.
+type PacketObserverFunc func(tag uint8, length int)
+
+// ObservePacket implements PacketObserver.
+func (f PacketObserverFunc) ObservePacket(tag uint8, length int) {
+	f(tag, length)
+}
+
+// ObservePackets notifies obs of every packet parsed, including any which
+// cause the key to be rejected. Packets discarded while reading, such as those
+// skipped with SkipTags, are not parsed and so not observed. The observer may
+// be called from several goroutines at once if keys are read concurrently.
+func ObservePackets(obs PacketObserver) ReadOption {
+	return func(ro *readOptions) {
+		ro.observer = obs
+	}
+}
+
+// ReadProgress reports how far reading a stream of keys has got.
+type ReadProgress struct {
+	// Bytes is the number of octets of input consumed.
+	Bytes int64
+
+	// Keyrings is the number of keyrings read, whether or not they could
+	// be parsed into keys.
+	Keyrings int
+}
+
+// WithProgress calls fn as each keyring is read from the input, so that
+// long-running imports can display their progress and detect stalls. It is
+// called from the goroutine reading the input, which does not proceed until
+// fn returns.
+func WithProgress(fn func(ReadProgress)) ReadOption {
+	return func(ro *readOptions) {
+		ro.progress = fn
+	}
+}
+
+// skip returns whether a packet with the given tag should be discarded.
+func (ro *readOptions) skip(tag uint8) bool {
+	switch {
+	case tag == 6, isSecretKeyTag(tag): //packet.PacketTypePublicKey
+		return false
+	case ro.indexOnly && tag != 13 && tag != 14:
+		return true
+	}
+	return ro.skipTags[tag]
+}
+
+// partial returns whether keys read with these options may be missing
+// packets.
+func (ro *readOptions) partial() bool {
+	return ro.indexOnly || len(ro.skipTags) > 0
+}
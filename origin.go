@@ -0,0 +1,65 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"fmt"
+)
+
+// PacketOrigin records where a packet was read from and how it was framed, so
+// that problems with it may be reported against the original input, and so
+// that indexes of the input may be kept alongside it.
+type PacketOrigin struct {
+	// Offset is the position in octets of the packet header in the input.
+	// Like the Position of the keyring, it is relative to the start of the
+	// file if the input is one, otherwise to the start of the stream.
+	Offset int64
+
+	// Length is the number of octets the packet occupied in the input,
+	// header included.
+	Length int64
+
+	// NewFormat is set if the packet had a new format header.
+	NewFormat bool
+
+	// LengthOctets is the number of octets in which the length of the
+	// packet, or of its first chunk if Partial is set, was encoded. It is 0
+	// for an old format packet of indeterminate length.
+	LengthOctets int
+
+	// Partial is set if the packet body was encoded in chunks with partial
+	// body lengths.
+	Partial bool
+}
+
+// newPacketOrigin returns the origin of a packet read with the header h,
+// starting at offset and ending before end.
+func newPacketOrigin(h *packetHeader, offset, end int64) *PacketOrigin {
+	return &PacketOrigin{
+		Offset:       offset,
+		Length:       end - offset,
+		NewFormat:    h.newFormat,
+		LengthOctets: h.headerLen - 1,
+		Partial:      h.partial,
+	}
+}
+
+// String returns a description of the origin, such as "offset 10234".
+func (o *PacketOrigin) String() string {
+	return fmt.Sprintf("offset %d", o.Offset)
+}
@@ -0,0 +1,281 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// KeyPatch lists the packets to be added to a key, so that updates to a large
+// key can be exchanged without sending the whole key.
+type KeyPatch struct {
+	RFingerprint string
+	Packets      []*PatchPacket
+}
+
+// PatchPacket is a packet to be added to a key.
+type PatchPacket struct {
+	// Parent is the UUID of the packet this one belongs to: the primary
+	// key for user IDs, user attributes, sub-keys and direct signatures,
+	// the packet a signature is on, or that an unrecognized packet
+	// follows. It may be a packet added earlier in the same patch.
+	Parent string
+
+	// Packet is the serialized packet.
+	Packet []byte
+}
+
+// ProducePatch returns the packets in key which are not in base, an earlier
+// version of the same key.
+func ProducePatch(base, key *PrimaryKey) *KeyPatch {
+	have := make(map[string]bool)
+	for _, node := range base.contents() {
+		have[node.uuid()] = true
+	}
+	patch := &KeyPatch{RFingerprint: key.RFingerprint}
+	add := func(parent string, p *Packet) {
+		if !have[p.UUID] {
+			patch.Packets = append(patch.Packets, &PatchPacket{Parent: parent, Packet: p.Packet})
+		}
+	}
+	for _, sig := range key.Signatures {
+		add(key.UUID, &sig.Packet)
+	}
+	for _, uid := range key.UserIDs {
+		add(key.UUID, &uid.Packet)
+		for _, sig := range uid.Signatures {
+			add(uid.UUID, &sig.Packet)
+		}
+		for _, other := range uid.Others {
+			add(uid.UUID, other)
+		}
+	}
+	for _, uat := range key.UserAttributes {
+		add(key.UUID, &uat.Packet)
+		for _, sig := range uat.Signatures {
+			add(uat.UUID, &sig.Packet)
+		}
+		for _, other := range uat.Others {
+			add(uat.UUID, other)
+		}
+	}
+	for _, subkey := range key.SubKeys {
+		add(key.UUID, &subkey.Packet)
+		for _, sig := range subkey.Signatures {
+			add(subkey.UUID, &sig.Packet)
+		}
+		for _, other := range subkey.Others {
+			add(subkey.UUID, other)
+		}
+	}
+	for _, other := range key.Others {
+		add(key.UUID, other)
+	}
+	return patch
+}
+
+// ApplyPatch merges the packets in the patch into key.
+func ApplyPatch(key *PrimaryKey, patch *KeyPatch) error {
+	if patch.RFingerprint != key.RFingerprint {
+		return errgo.Newf("patch for key %s applied to %s", Reverse(patch.RFingerprint), key.Fingerprint())
+	}
+	parents := make(map[string][]byte)
+	for _, uid := range key.UserIDs {
+		parents[uid.UUID] = uid.Packet.Packet
+	}
+	for _, uat := range key.UserAttributes {
+		parents[uat.UUID] = uat.Packet.Packet
+	}
+	for _, subkey := range key.SubKeys {
+		parents[subkey.UUID] = subkey.Packet.Packet
+	}
+
+	// Rebuild a keyring in which each signature, or unrecognized packet,
+	// follows the packet it is on. Those of the primary key must come
+	// first, immediately after it.
+	var ops, direct, rest []*packet.OpaquePacket
+	var restParents []string
+	op, err := newOpaquePacket(key.Packet.Packet)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	ops = append(ops, op)
+	for _, pp := range patch.Packets {
+		op, err := newOpaquePacket(pp.Packet)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if pp.Parent == key.UUID && !isNodeTag(op.Tag) {
+			direct = append(direct, op)
+		} else {
+			rest = append(rest, op)
+			restParents = append(restParents, pp.Parent)
+		}
+	}
+	ops = append(ops, direct...)
+	current := key.UUID
+	for i, op := range rest {
+		switch {
+		case !isNodeTag(op.Tag):
+			if restParents[i] != current {
+				parent, ok := parents[restParents[i]]
+				if !ok {
+					return errgo.Newf("packet %d on unknown packet %q", op.Tag, restParents[i])
+				}
+				parentOp, err := newOpaquePacket(parent)
+				if err != nil {
+					return errgo.Mask(err)
+				}
+				ops = append(ops, parentOp)
+				current = restParents[i]
+			}
+		default:
+			current, err = patchNodeUUID(op, key.UUID)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			buf, err := (*packetArena)(nil).serialize(op)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			parents[current] = buf
+		}
+		ops = append(ops, op)
+	}
+
+	src, err := (&OpaqueKeyring{Packets: ops}).Parse()
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	return errgo.Mask(Merge(key, src))
+}
+
+// isNodeTag returns whether tag is that of a user ID, sub-key or user
+// attribute packet, which a patch adds to the primary key.
+func isNodeTag(tag uint8) bool {
+	return tag == 13 || tag == 14 || tag == 17
+}
+
+// patchNodeUUID returns the UUID of a user ID, user attribute or sub-key packet
+// belonging to the primary key with the given UUID.
+func patchNodeUUID(op *packet.OpaquePacket, pubkeyUUID string) (string, error) {
+	switch op.Tag {
+	case 13: //packet.PacketTypeUserId
+		uid, err := ParseUserID(op, pubkeyUUID)
+		if err != nil {
+			return "", errgo.Mask(err, errgo.Any)
+		}
+		return uid.UUID, nil
+	case 17: //packet.PacketTypeUserAttribute
+		uat, err := ParseUserAttribute(op, pubkeyUUID)
+		if err != nil {
+			return "", errgo.Mask(err, errgo.Any)
+		}
+		return uat.UUID, nil
+	}
+	subkey, err := ParseSubKey(op)
+	if err != nil {
+		return "", errgo.Mask(err, errgo.Any)
+	}
+	return subkey.UUID, nil
+}
+
+// keyPatchVersion is the version of the KeyPatch encoding.
+const keyPatchVersion = 1
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding is a version
+// octet, the fingerprint as a length octet followed by its binary value, and
+// the number of packets as a big-endian 32-bit integer. Each packet follows
+// as its parent UUID preceded by a length octet, and the serialized packet
+// preceded by its length as a big-endian 32-bit integer.
+func (p *KeyPatch) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(keyPatchVersion)
+	err := writeHexField(&buf, p.RFingerprint)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(len(p.Packets)))
+	for _, pp := range p.Packets {
+		if len(pp.Parent) > 255 {
+			return nil, errgo.Newf("parent UUID too long: %d octets", len(pp.Parent))
+		}
+		buf.WriteByte(byte(len(pp.Parent)))
+		buf.WriteString(pp.Parent)
+		binary.Write(&buf, binary.BigEndian, uint32(len(pp.Packet)))
+		buf.Write(pp.Packet)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (p *KeyPatch) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if version != keyPatchVersion {
+		return errgo.Newf("unsupported key patch version %d", version)
+	}
+	fp, err := readHexField(r)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	var n uint32
+	err = binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return errgo.Mask(noEOF(err), errgo.Any)
+	}
+	if int64(n) > int64(r.Len()) {
+		return errgo.Newf("invalid number of packets %d", n)
+	}
+	var packets []*PatchPacket
+	for i := uint32(0); i < n; i++ {
+		plen, err := r.ReadByte()
+		if err != nil {
+			return errgo.Mask(noEOF(err), errgo.Any)
+		}
+		parent := make([]byte, plen)
+		_, err = io.ReadFull(r, parent)
+		if err != nil {
+			return errgo.Mask(noEOF(err), errgo.Any)
+		}
+		var length uint32
+		err = binary.Read(r, binary.BigEndian, &length)
+		if err != nil {
+			return errgo.Mask(noEOF(err), errgo.Any)
+		}
+		if int64(length) > int64(r.Len()) {
+			return errgo.Mask(io.ErrUnexpectedEOF, errgo.Any)
+		}
+		buf := make([]byte, length)
+		io.ReadFull(r, buf)
+		packets = append(packets, &PatchPacket{Parent: string(parent), Packet: buf})
+	}
+	if r.Len() > 0 {
+		return errgo.Newf("%d trailing octets in key patch", r.Len())
+	}
+	*p = KeyPatch{RFingerprint: fp, Packets: packets}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Proof is an identity proof claimed by the owner of a key, in the manner of
+// Keyoxide: a notation on a self-signature naming a URI, such as an account
+// or DNS record, which in turn links back to the key. The proof is only
+// claimed; it is up to a verification service to check the URI.
+type Proof struct {
+	// UserID is the user ID whose self-signature carries the proof, or the
+	// empty string if it is carried by a direct-key signature.
+	UserID string
+
+	// Notation is the name of the notation, such as "proof@ariadne.id".
+	Notation string
+
+	// URI is the value of the notation, and Scheme its scheme, such as
+	// "https" or "dns", if it parses as a URI.
+	URI    string
+	Scheme string
+}
+
+// isProofNotation returns whether a notation name is that of an identity
+// proof: "proof@ariadne.id", the older "proof@metacode.biz", and others of
+// their form.
+func isProofNotation(name string) bool {
+	return strings.HasPrefix(name, "proof@")
+}
+
+func appendProofs(proofs []*Proof, uid string, sig *Signature) []*Proof {
+	for _, notation := range sig.Notations() {
+		if !notation.HumanReadable || !isProofNotation(notation.Name) {
+			continue
+		}
+		proof := &Proof{UserID: uid, Notation: notation.Name, URI: string(notation.Value)}
+		if u, err := url.Parse(proof.URI); err == nil {
+			proof.Scheme = strings.ToLower(u.Scheme)
+		}
+		proofs = append(proofs, proof)
+	}
+	return proofs
+}
+
+// Proofs returns the identity proofs claimed by the most recent valid
+// direct-key self-signature of the key, followed by those of the most recent
+// valid self-signature of each user ID which has not been revoked.
+func (pubkey *PrimaryKey) Proofs() []*Proof {
+	var proofs []*Proof
+	if ss := pubkey.SelfSigs(); len(ss.DirectKeys) > 0 {
+		proofs = appendProofs(proofs, "", ss.DirectKeys[0].Signature)
+	}
+	for _, uid := range pubkey.UserIDs {
+		if ss := uid.SelfSigs(pubkey); len(ss.Certifications) > 0 {
+			proofs = appendProofs(proofs, uid.Keywords, ss.Certifications[0].Signature)
+		}
+	}
+	return proofs
+}
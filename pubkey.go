@@ -21,9 +21,10 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"strings"
 	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
@@ -53,6 +54,10 @@ type PublicKey struct {
 	Others     []*Packet
 }
 
+// algoElGamalSignEncrypt is the deprecated ElGamal sign and encrypt public key
+// algorithm, which is insecure when used for signing.
+const algoElGamalSignEncrypt = 20
+
 func AlgorithmName(code int) string {
 	switch code {
 	case 1, 2, 3:
@@ -78,14 +83,18 @@ func (pk *PublicKey) QualifiedFingerprint() string {
 	return fmt.Sprintf("%s%d/%s", AlgorithmName(pk.Algorithm), pk.BitLen, Reverse(pk.RFingerprint))
 }
 
+// ShortID returns the low 32 bits of the key ID in hex. Short key IDs are
+// trivially forged, and should only be displayed, never used to look up keys.
 func (pk *PublicKey) ShortID() string {
 	return Reverse(pk.RShortID)
 }
 
+// KeyID returns the 64-bit, or long, key ID in hex.
 func (pk *PublicKey) KeyID() string {
 	return Reverse(pk.RKeyID)
 }
 
+// Fingerprint returns the fingerprint of the key in hex.
 func (pk *PublicKey) Fingerprint() string {
 	return Reverse(pk.RFingerprint)
 }
@@ -130,7 +139,7 @@ func (pkp *PublicKey) publicKeyV3Packet() (*packet.PublicKeyV3, error) {
 func (pkp *PublicKey) parse(op *packet.OpaquePacket, subkey bool) error {
 	p, err := op.Parse()
 	if err != nil {
-		return errgo.Mask(err)
+		return errgo.Mask(classifyError(err), errgo.Any)
 	}
 
 	switch pk := p.(type) {
@@ -158,9 +167,28 @@ func (pkp *PublicKey) setUnsupported(op *packet.OpaquePacket) error {
 	fpr := hex.EncodeToString(h.Sum(nil))
 	pkp.RFingerprint = Reverse(fpr)
 	pkp.UUID = pkp.RFingerprint
+	pkp.setRawHeader(op.Contents)
 	return pkp.setV4IDs(pkp.UUID)
 }
 
+// setRawHeader sets the creation time and algorithm of a public key packet
+// which could not be parsed, such as one using an unsupported algorithm, from
+// the fixed fields which start its contents.
+func (pkp *PublicKey) setRawHeader(contents []byte) {
+	if len(contents) < 6 {
+		return
+	}
+	algoOffset := 5
+	if contents[0] == 2 || contents[0] == 3 {
+		algoOffset = 7
+	}
+	if len(contents) <= algoOffset {
+		return
+	}
+	pkp.Creation = time.Unix(int64(binary.BigEndian.Uint32(contents[1:5])), 0)
+	pkp.Algorithm = int(contents[algoOffset])
+}
+
 func (pkp *PublicKey) setPublicKey(pk *packet.PublicKey) error {
 	buf := bytes.NewBuffer(nil)
 	err := pk.Serialize(buf)
@@ -197,6 +225,35 @@ func (pkp *PublicKey) setV4IDs(rfp string) error {
 	return nil
 }
 
+// checkFingerprint recomputes the fingerprint and key IDs of the public key
+// from its packet, as when it was parsed, and compares them with those the key
+// carries.
+func (pkp *PublicKey) checkFingerprint(subkey bool) error {
+	op, err := pkp.opaquePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var derived PublicKey
+	if derived.parse(op, subkey) != nil {
+		err = derived.setUnsupported(op)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	switch {
+	case pkp.RFingerprint != derived.RFingerprint:
+		return errgo.WithCausef(nil, ErrFingerprintMismatch,
+			"key material has fingerprint %s, not %s", Reverse(derived.RFingerprint), Reverse(pkp.RFingerprint))
+	case pkp.UUID != derived.UUID:
+		return errgo.WithCausef(nil, ErrFingerprintMismatch,
+			"key %s has UUID %q, not %q", Reverse(derived.RFingerprint), pkp.UUID, derived.UUID)
+	case pkp.RKeyID != derived.RKeyID || pkp.RShortID != derived.RShortID:
+		return errgo.WithCausef(nil, ErrFingerprintMismatch,
+			"key %s has key ID %s, not %s", Reverse(derived.RFingerprint), Reverse(pkp.RKeyID), Reverse(derived.RKeyID))
+	}
+	return nil
+}
+
 func (pkp *PublicKey) setPublicKeyV3(pk *packet.PublicKeyV3) error {
 	var buf bytes.Buffer
 	err := pk.Serialize(&buf)
@@ -210,8 +267,8 @@ func (pkp *PublicKey) setPublicKeyV3(pk *packet.PublicKeyV3) error {
 	}
 	pkp.RFingerprint = Reverse(fingerprint)
 	pkp.UUID = pkp.RFingerprint
-	pkp.RShortID = Reverse(fmt.Sprintf("%08x", uint32(pk.KeyId)))
-	pkp.RKeyID = Reverse(fmt.Sprintf("%016x", pk.KeyId))
+	pkp.RShortID = ReverseShortID(pk.KeyId)
+	pkp.RKeyID = ReverseKeyID(pk.KeyId)
 	pkp.Creation = pk.CreationTime
 	if pk.DaysToExpire > 0 {
 		pkp.Expiration = pkp.Creation.Add(time.Duration(pk.DaysToExpire) * time.Hour * 24)
@@ -228,6 +285,13 @@ type PrimaryKey struct {
 	MD5    string
 	SHA256 string
 
+	// Revision is incremented whenever an operation such as Merge or
+	// DropDuplicates changes the packets of the key. It starts from zero
+	// when a key is read; storage layers may set it from their own record,
+	// and compare it before writing back, for optimistic concurrency
+	// control.
+	Revision uint64
+
 	SubKeys        []*SubKey
 	UserIDs        []*UserID
 	UserAttributes []*UserAttribute
@@ -259,17 +323,20 @@ func (*PrimaryKey) removeDuplicate(parent packetNode, dup packetNode) error {
 }
 
 func ParsePrimaryKey(op *packet.OpaquePacket) (*PrimaryKey, error) {
-	var buf bytes.Buffer
-	var err error
+	return parsePrimaryKey(op, nil)
+}
 
-	if err = op.Serialize(&buf); err != nil {
+func parsePrimaryKey(op *packet.OpaquePacket, arena *packetArena) (*PrimaryKey, error) {
+	buf, err := arena.serialize(op)
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	pubkey := &PrimaryKey{
 		PublicKey: PublicKey{
 			Packet: Packet{
 				Tag:    op.Tag,
-				Packet: buf.Bytes(),
+				Packet: buf,
+				shared: arena != nil,
 			},
 		},
 	}
@@ -288,6 +355,25 @@ func ParsePrimaryKey(op *packet.OpaquePacket) (*PrimaryKey, error) {
 	return pubkey, nil
 }
 
+// CheckFingerprints recomputes the fingerprints of the primary key and its
+// subkeys from their key material, returning an error with the cause
+// ErrFingerprintMismatch if any differs from the fingerprint, UUID or key ID
+// the key carries. Keys loaded from storage, where these are held apart from
+// the packets, should be checked before they are indexed.
+func (pubkey *PrimaryKey) CheckFingerprints() error {
+	err := pubkey.checkFingerprint(false)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrFingerprintMismatch))
+	}
+	for _, subkey := range pubkey.SubKeys {
+		err = subkey.checkFingerprint(true)
+		if err != nil {
+			return errgo.NoteMask(err, "sub-key "+subkey.KeyID(), errgo.Is(ErrFingerprintMismatch))
+		}
+	}
+	return nil
+}
+
 func (pubkey *PrimaryKey) setPublicKey(pk *packet.PublicKey) error {
 	if pk.IsSubkey {
 		return errgo.NoteMask(ErrInvalidPacketType, "expected primary public key packet, got sub-key")
@@ -302,18 +388,47 @@ func (pubkey *PrimaryKey) setPublicKeyV3(pk *packet.PublicKeyV3) error {
 	return pubkey.PublicKey.setPublicKeyV3(pk)
 }
 
-func (pubkey *PrimaryKey) SelfSigs() *SelfSigs {
+// Timestamps returns the timestamp signatures attached to the primary key.
+func (pubkey *PrimaryKey) Timestamps() []*Signature {
+	var result []*Signature
+	for _, sig := range pubkey.Signatures {
+		if sig.IsTimestamp() {
+			result = append(result, sig)
+		}
+	}
+	return result
+}
+
+func (pubkey *PrimaryKey) SelfSigs(opts ...SelfSigOption) *SelfSigs {
+	so := newSelfSigOptions(opts)
 	result := &SelfSigs{target: pubkey}
 	for _, sig := range pubkey.Signatures {
 		// Skip non-self-certifications.
-		if !strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID) {
+		candidate, trial := pubkey.selfSigCandidate(sig, so)
+		if !candidate {
 			continue
 		}
-		checkSig := &CheckSig{
-			PrimaryKey: pubkey,
-			Signature:  sig,
-			Error:      pubkey.verifyPublicKeySelfSig(&pubkey.PublicKey, sig),
+		if err := sig.Expand(); err != nil {
+			if !trial {
+				result.Errors = append(result.Errors, newCheckSig(pubkey, sig, err))
+			}
+			continue
+		}
+		err := pubkey.verifyCached(sig, pubkey, func() error {
+			switch sig.SigType {
+			case 0x20, 0x1f: // packet.SigTypeKeyRevocation, direct-key
+				// Both are made over the primary key alone; see
+				// verifyKeyRevocation.
+				return pubkey.verifyKeyRevocation(sig)
+			case 0x02: // standalone
+				return pubkey.verifyStandaloneSig(sig)
+			}
+			return pubkey.verifyPublicKeySelfSig(&pubkey.PublicKey, sig)
+		})
+		if err != nil && trial {
+			continue
 		}
+		checkSig := newCheckSig(pubkey, sig, err)
 		if checkSig.Error != nil {
 			result.Errors = append(result.Errors, checkSig)
 			continue
@@ -321,17 +436,37 @@ func (pubkey *PrimaryKey) SelfSigs() *SelfSigs {
 		switch sig.SigType {
 		case 0x20: // packet.SigTypeKeyRevocation
 			result.Revocations = append(result.Revocations, checkSig)
+		case 0x1f: // direct-key
+			result.DirectKeys = append(result.DirectKeys, checkSig)
+		case 0x02: // standalone
+			result.Standalones = append(result.Standalones, checkSig)
 		}
 	}
 	result.resolve()
+	// The most recent direct-key signature sets the key's expiration,
+	// superseding any earlier one.
+	if len(result.Revocations) == 0 && len(result.DirectKeys) > 0 && !result.DirectKeys[0].Signature.Expiration.IsZero() {
+		result.Expirations = result.DirectKeys[:1]
+	}
 	return result
 }
 
+// updateMD5 recalculates the digest of the key after its packets have
+// changed, and its SHA256 digest too if it has one.
 func (pubkey *PrimaryKey) updateMD5() error {
 	digest, err := SksDigest(pubkey, md5.New())
 	if err != nil {
 		return err
 	}
+	if digest != pubkey.MD5 {
+		pubkey.Revision++
+	}
 	pubkey.MD5 = digest
+	if pubkey.SHA256 != "" {
+		pubkey.SHA256, err = SksDigest(pubkey, sha256.New())
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
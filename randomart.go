@@ -0,0 +1,178 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	randomartWidth  = 17
+	randomartHeight = 9
+
+	// randomartSymbols are drawn for squares visited increasingly often,
+	// with the last two marking the start and end of the walk.
+	randomartSymbols = " .o+=*BOX@%&#/^SE"
+)
+
+// Randomart renders the fingerprint of the key as the "drunken bishop"
+// randomart drawn by OpenSSH, framed by the algorithm and size of the key and
+// the fingerprint digest, so that fingerprints may be compared at a glance.
+func (pk *PublicKey) Randomart() string {
+	fp, _ := hex.DecodeString(pk.Fingerprint())
+	title := fmt.Sprintf("[%s %d]", strings.ToUpper(AlgorithmName(pk.Algorithm)), pk.BitLen)
+	return Randomart(fp, title, "["+fingerprintDigestName(fp)+"]")
+}
+
+// fingerprintDigestName returns the name of the digest which gives
+// fingerprints of the length of fp.
+func fingerprintDigestName(fp []byte) string {
+	switch len(fp) {
+	case 16:
+		return "MD5"
+	case 20:
+		return "SHA1"
+	case 32:
+		return "SHA256"
+	}
+	return ""
+}
+
+// Randomart renders data, such as a fingerprint, as drunken bishop randomart
+// with the given title and footer centred in its top and bottom borders.
+func Randomart(data []byte, title, footer string) string {
+	var field [randomartWidth][randomartHeight]int
+	last := len(randomartSymbols) - 1
+	x, y := randomartWidth/2, randomartHeight/2
+	for _, b := range data {
+		// Each pair of bits, least significant first, moves the bishop
+		// one square diagonally.
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = clampInt(x, 0, randomartWidth-1)
+			y = clampInt(y, 0, randomartHeight-1)
+			if field[x][y] < last-2 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[randomartWidth/2][randomartHeight/2] = last - 1
+	field[x][y] = last
+
+	var buf bytes.Buffer
+	randomartBorder(&buf, title)
+	for j := 0; j < randomartHeight; j++ {
+		buf.WriteByte('|')
+		for i := 0; i < randomartWidth; i++ {
+			buf.WriteByte(randomartSymbols[field[i][j]])
+		}
+		buf.WriteString("|\n")
+	}
+	randomartBorder(&buf, footer)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func randomartBorder(buf *bytes.Buffer, label string) {
+	if len(label) > randomartWidth {
+		label = label[:randomartWidth]
+	}
+	pad := (randomartWidth - len(label)) / 2
+	buf.WriteByte('+')
+	buf.WriteString(strings.Repeat("-", pad))
+	buf.WriteString(label)
+	buf.WriteString(strings.Repeat("-", randomartWidth-pad-len(label)))
+	buf.WriteString("+\n")
+}
+
+func clampInt(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// FingerprintSymbol is one of the 64 emoji, each with an English name, in
+// which a fingerprint may be read.
+type FingerprintSymbol struct {
+	Emoji string
+	Name  string
+}
+
+// fingerprintSymbols is the table of emoji used for short authentication
+// strings in Matrix, chosen to be easily told apart.
+var fingerprintSymbols = [64]FingerprintSymbol{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// FingerprintSymbols returns the fingerprint of the key as a sequence of
+// emoji and their names, one for every six bits: a visual and spoken
+// alternative to comparing hex digits.
+func (pk *PublicKey) FingerprintSymbols() []FingerprintSymbol {
+	fp, _ := hex.DecodeString(pk.Fingerprint())
+	return FingerprintSymbolsOf(fp)
+}
+
+// FingerprintSymbolsOf returns data as a sequence of fingerprint symbols, one
+// for every six bits, most significant first. Trailing bits which do not fill
+// a symbol are ignored.
+func FingerprintSymbolsOf(data []byte) []FingerprintSymbol {
+	var result []FingerprintSymbol
+	var acc uint
+	var bits uint
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 6 {
+			bits -= 6
+			result = append(result, fingerprintSymbols[(acc>>bits)&0x3f])
+		}
+		acc &= 1<<bits - 1
+	}
+	return result
+}
@@ -0,0 +1,220 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// KeyRecord is a key as stored in a key-value database, such as LevelDB or
+// RocksDB: its binary packets, with a header giving its fingerprint, digest
+// and storage timestamps, which can be read without parsing the packets.
+type KeyRecord struct {
+	// Fingerprint is the hex-encoded fingerprint of the key, and MD5 its
+	// hex-encoded digest.
+	Fingerprint string
+	MD5         string
+
+	// CTime and MTime are the times at which the key was first stored and
+	// last modified. They are maintained by the store, not read from the
+	// key.
+	CTime time.Time
+	MTime time.Time
+
+	// Packets are the packets of the key, as written by WritePackets.
+	Packets []byte
+}
+
+// keyRecordVersion is the version of the KeyRecord encoding.
+const keyRecordVersion = 1
+
+// NewKeyRecord returns a record of a key, which must have been digested, with
+// the given storage timestamps.
+func NewKeyRecord(key *PrimaryKey, ctime, mtime time.Time) (*KeyRecord, error) {
+	if key.MD5 == "" {
+		return nil, errgo.New("key has not been digested")
+	}
+	var buf bytes.Buffer
+	err := WritePackets(&buf, key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &KeyRecord{
+		Fingerprint: key.Fingerprint(),
+		MD5:         key.MD5,
+		CTime:       ctime,
+		MTime:       mtime,
+		Packets:     buf.Bytes(),
+	}, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding is a version
+// octet and the length of the header as a big-endian 16-bit integer, then the
+// header: the fingerprint and digest each as a length octet followed by their
+// binary value, and the timestamps in seconds as big-endian 64-bit integers.
+// The header is followed by the length of the packets as a big-endian 32-bit
+// integer, and the packets.
+func (r *KeyRecord) MarshalBinary() ([]byte, error) {
+	var hdr bytes.Buffer
+	for _, s := range []string{r.Fingerprint, r.MD5} {
+		err := writeHexField(&hdr, s)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	binary.Write(&hdr, binary.BigEndian, r.CTime.Unix())
+	binary.Write(&hdr, binary.BigEndian, r.MTime.Unix())
+
+	buf := bytes.NewBuffer(make([]byte, 0, 7+hdr.Len()+len(r.Packets)))
+	buf.WriteByte(keyRecordVersion)
+	binary.Write(buf, binary.BigEndian, uint16(hdr.Len()))
+	buf.Write(hdr.Bytes())
+	binary.Write(buf, binary.BigEndian, uint32(len(r.Packets)))
+	buf.Write(r.Packets)
+	return buf.Bytes(), nil
+}
+
+// splitKeyRecord splits an encoded record into its header and packets.
+func splitKeyRecord(data []byte) (hdr, packets []byte, err error) {
+	if len(data) < 3 {
+		return nil, nil, errgo.Mask(io.ErrUnexpectedEOF, errgo.Any)
+	}
+	if data[0] != keyRecordVersion {
+		return nil, nil, errgo.Newf("unsupported key record version %d", data[0])
+	}
+	n := int(binary.BigEndian.Uint16(data[1:3]))
+	data = data[3:]
+	if len(data) < n+4 {
+		return nil, nil, errgo.Mask(io.ErrUnexpectedEOF, errgo.Any)
+	}
+	hdr, data = data[:n], data[n:]
+	m := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint64(len(data)) < uint64(m) {
+		return nil, nil, errgo.Mask(io.ErrUnexpectedEOF, errgo.Any)
+	}
+	if uint64(len(data)) > uint64(m) {
+		return nil, nil, errgo.Newf("%d trailing octets in key record", uint64(len(data))-uint64(m))
+	}
+	return hdr, data, nil
+}
+
+// readHeader reads the fields of a record header into r.
+func (r *KeyRecord) readHeader(hdr []byte) error {
+	br := bytes.NewReader(hdr)
+	fp, err := readHexField(br)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	digest, err := readHexField(br)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	var ctime, mtime int64
+	err = binary.Read(br, binary.BigEndian, &ctime)
+	if err == nil {
+		err = binary.Read(br, binary.BigEndian, &mtime)
+	}
+	if err != nil {
+		return errgo.Mask(noEOF(err), errgo.Any)
+	}
+	// Later versions may extend the header.
+	r.Fingerprint, r.MD5 = fp, digest
+	r.CTime, r.MTime = time.Unix(ctime, 0), time.Unix(mtime, 0)
+	return nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The record's packets
+// refer to data, rather than being copied.
+func (r *KeyRecord) UnmarshalBinary(data []byte) error {
+	hdr, packets, err := splitKeyRecord(data)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	var result KeyRecord
+	err = result.readHeader(hdr)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	result.Packets = packets
+	*r = result
+	return nil
+}
+
+// ReadKeyRecordHeader returns the fingerprint, digest and timestamps of an
+// encoded record, without reading its packets.
+func ReadKeyRecordHeader(data []byte) (*KeyRecord, error) {
+	hdr, _, err := splitKeyRecord(data)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	r := &KeyRecord{}
+	err = r.readHeader(hdr)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	return r, nil
+}
+
+// Key parses the packets of the record into a key. It fails if they are not
+// those of the key and digest given in the header.
+func (r *KeyRecord) Key() (*PrimaryKey, error) {
+	key, err := readStoredKey(bytes.NewReader(r.Packets))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if key.Fingerprint() != r.Fingerprint {
+		return nil, errgo.Newf("record for %q holds key %q", r.Fingerprint, key.Fingerprint())
+	}
+	if key.MD5 != r.MD5 {
+		return nil, errgo.Newf("record for %q has digest %q, but its packets %q", r.Fingerprint, r.MD5, key.MD5)
+	}
+	return key, nil
+}
+
+// readStoredKey reads the packets of a single key, such as one previously
+// stored, retaining any of unknown types so that nothing stored is lost.
+func readStoredKey(r io.Reader) (*PrimaryKey, error) {
+	var key *PrimaryKey
+	var err error
+	for readKey := range ReadKeys(r, UnknownPackets(RetainUnknownPackets)) {
+		switch {
+		case err != nil:
+		case key != nil:
+			err = errgo.New("more than one key found")
+		case errgo.Cause(readKey.Error) == io.EOF:
+			// Empty input.
+		case readKey.Error != nil:
+			err = errgo.Mask(readKey.Error, errgo.Any)
+		case readKey.PrimaryKey != nil:
+			key = readKey.PrimaryKey
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrNoPrimaryKey
+	}
+	return key, nil
+}
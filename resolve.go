@@ -43,6 +43,19 @@ func CollectDuplicates(key *PrimaryKey) error {
 }
 
 func Merge(dst, src *PrimaryKey) error {
+	return MergeObserved(dst, src, nil)
+}
+
+// MergeObserved merges src into dst, as Merge, notifying obs of the user IDs
+// and signatures added to dst. obs may be nil.
+func MergeObserved(dst, src *PrimaryKey, obs MergeObserver) error {
+	var before map[string]bool
+	if obs != nil {
+		before = nodeKeys(dst)
+	}
+	// Packets taken from src outlive it, so they must not pin its buffer.
+	src.Detach()
+	dst.Signatures = append(dst.Signatures, src.Signatures...)
 	dst.UserIDs = append(dst.UserIDs, src.UserIDs...)
 	dst.UserAttributes = append(dst.UserAttributes, src.UserAttributes...)
 	dst.SubKeys = append(dst.SubKeys, src.SubKeys...)
@@ -57,7 +70,15 @@ func Merge(dst, src *PrimaryKey) error {
 	if err != nil {
 		return err
 	}
-	return dst.updateMD5()
+	err = dst.updateMD5()
+	if err != nil {
+		return err
+	}
+	metrics.KeyMerged()
+	if obs != nil {
+		notifyAdded(dst, before, obs)
+	}
+	return nil
 }
 
 func hexmd5(b []byte) string {
@@ -66,6 +87,15 @@ func hexmd5(b []byte) string {
 }
 
 func dedup(root packetNode, handleDuplicate func(primary, duplicate packetNode)) error {
+	before := len(root.contents())
+	err := dedupNodes(root, handleDuplicate)
+	if n := before - len(root.contents()); n > 0 {
+		metrics.DuplicatesDropped(n)
+	}
+	return err
+}
+
+func dedupNodes(root packetNode, handleDuplicate func(primary, duplicate packetNode)) error {
 	nodes := map[string]packetNode{}
 
 	for _, node := range root.contents() {
@@ -77,7 +107,7 @@ func dedup(root packetNode, handleDuplicate func(primary, duplicate packetNode))
 				return errgo.Mask(err)
 			}
 
-			err = dedup(primary, nil)
+			err = dedupNodes(primary, nil)
 			if err != nil {
 				return errgo.Mask(err)
 			}
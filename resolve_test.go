@@ -18,15 +18,31 @@
 package openpgp
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/big"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 
 	"github.com/schmorrison/testing"
 )
@@ -91,11 +107,11 @@ func (s *ResolveSuite) TestRoundTripSksDigest(c *gc.C) {
 }
 
 func patchNow(t time.Time) func() {
-	now = func() time.Time {
+	SetClock(func() time.Time {
 		return t
-	}
+	})
 	return func() {
-		now = time.Now
+		SetClock(nil)
 	}
 }
 
@@ -122,6 +138,29 @@ func (s *ResolveSuite) TestUserIDSelfSigs(c *gc.C) {
 	c.Assert(key.UserIDs[0].Keywords, gc.Equals, "Phil Pennock <phil.pennock@globnix.org>")
 }
 
+func (s *ResolveSuite) TestValidAt(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	lifetime := uint32(3600)
+	for _, ident := range alice.Identities {
+		ident.SelfSignature.SigLifetimeSecs = &lifetime
+		c.Assert(ident.SelfSignature.SignUserId(ident.UserId.Id, alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+	}
+	key := entityKey(c, alice)
+	c.Assert(key.UserIDs, gc.HasLen, 1)
+	ss := key.UserIDs[0].SelfSigs(key)
+	created := ss.Certifications[0].Signature.Creation
+
+	c.Assert(ss.ValidAt(created.Add(time.Minute)), gc.Equals, true)
+	c.Assert(ss.ValidAt(created.Add(2*time.Hour)), gc.Equals, false)
+	_, ok := ss.ValidSinceAt(created.Add(2 * time.Hour))
+	c.Assert(ok, gc.Equals, false)
+
+	defer patchNow(created.Add(2 * time.Hour))()
+	c.Assert(ss.Valid(), gc.Equals, false)
+	SetClock(nil)
+	c.Assert(ss.Valid(), gc.Equals, time.Now().Before(created.Add(time.Hour)))
+}
+
 func (s *ResolveSuite) TestSortUserIDs(c *gc.C) {
 	defer patchNow(time.Date(2014, time.January, 1, 0, 0, 0, 0, time.UTC))()
 
@@ -238,3 +277,1494 @@ func (s *ResolveSuite) TestMergeAddSig(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 	c.Assert(hasExpectedSig(unsignedKeys[0]), gc.Equals, true)
 }
+
+func (s *ResolveSuite) TestSkippedTrustPackets(c *gc.C) {
+	f := testing.MustInput("snowcrash.gpg")
+	defer f.Close()
+	var keyRead *ReadKeyResult
+	for kr := range ReadKeys(f) {
+		if keyRead == nil {
+			keyRead = kr
+		}
+	}
+	c.Assert(keyRead.Error, gc.IsNil)
+	c.Assert(keyRead.Skipped, gc.Not(gc.HasLen), 0)
+	for _, skipped := range keyRead.Skipped {
+		c.Assert(skipped.Tag, gc.Equals, uint8(12))
+		c.Assert(skipped.Reason, gc.Equals, SkipNotKeyMaterial)
+		c.Assert(skipped.Retained, gc.Equals, false)
+		c.Assert(skipped.Digest, gc.HasLen, 64)
+	}
+}
+
+func (s *ResolveSuite) TestUnknownPackets(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	keyLen := int64(buf.Len())
+	c.Assert((&packet.OpaquePacket{Tag: 40, Contents: []byte("future")}).Serialize(&buf), gc.IsNil)
+	c.Assert((&packet.OpaquePacket{Tag: 61, Contents: bytes.Repeat([]byte("x"), 300)}).Serialize(&buf), gc.IsNil)
+	input := buf.Bytes()
+
+	read := func(opts ...ReadOption) *ReadKeyResult {
+		var results []*ReadKeyResult
+		for kr := range ReadKeys(bytes.NewReader(input), opts...) {
+			results = append(results, kr)
+		}
+		c.Assert(results, gc.HasLen, 1)
+		return results[0]
+	}
+
+	dropped := read()
+	c.Assert(dropped.Error, gc.IsNil)
+	c.Assert(dropped.Others, gc.HasLen, 0)
+	c.Assert(dropped.Skipped, gc.HasLen, 2)
+	for _, skipped := range dropped.Skipped {
+		c.Assert(skipped.Reason, gc.Equals, SkipUnknownTag)
+		c.Assert(skipped.Retained, gc.Equals, false)
+	}
+
+	retained := read(UnknownPackets(RetainUnknownPackets))
+	c.Assert(retained.Error, gc.IsNil)
+	c.Assert(retained.SubKeys[0].Others, gc.HasLen, 2)
+	c.Assert(retained.Skipped, gc.HasLen, 2)
+	c.Assert(retained.Skipped[0].Retained, gc.Equals, true)
+	c.Assert(retained.MD5, gc.Not(gc.Equals), dropped.MD5)
+
+	// Retained packets are written back out unchanged.
+	var out bytes.Buffer
+	c.Assert(WritePackets(&out, retained.PrimaryKey), gc.IsNil)
+	c.Assert(out.Bytes(), gc.DeepEquals, input)
+	again := ReadKeys(&out, UnknownPackets(RetainUnknownPackets)).MustParse()
+	c.Assert(again, gc.HasLen, 1)
+	c.Assert(again[0].MD5, gc.Equals, retained.MD5)
+
+	rejected := read(UnknownPackets(RejectUnknownPackets))
+	c.Assert(errgo.Cause(rejected.Error), gc.Equals, ErrUnknownPacket)
+	pe, ok := rejected.Error.(*PacketError)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(pe.Tag, gc.Equals, uint8(40))
+
+	// The offset of the packet is in the input, rather than its keyring.
+	var prefixed bytes.Buffer
+	c.Assert(newTestEntity(c, "Bob").Serialize(&prefixed), gc.IsNil)
+	prefixLen := int64(prefixed.Len())
+	prefixed.Write(input)
+	var errs []error
+	for kr := range ReadKeys(&prefixed, UnknownPackets(RejectUnknownPackets)) {
+		if kr.Error != nil {
+			errs = append(errs, kr.Error)
+		}
+	}
+	c.Assert(errs, gc.HasLen, 1)
+	pe, ok = errs[0].(*PacketError)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(pe.Offset, gc.Equals, prefixLen+keyLen)
+
+	// A packet between a user ID and a sub-key is kept with the user ID,
+	// and written back out in place.
+	buf.Reset()
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	ident := alice.Identities["Alice"]
+	c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+	c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	c.Assert((&packet.OpaquePacket{Tag: 40, Contents: []byte("future")}).Serialize(&buf), gc.IsNil)
+	c.Assert(alice.Subkeys[0].PublicKey.Serialize(&buf), gc.IsNil)
+	c.Assert(alice.Subkeys[0].Sig.Serialize(&buf), gc.IsNil)
+	input = buf.Bytes()
+	retained = read(UnknownPackets(RetainUnknownPackets))
+	c.Assert(retained.Error, gc.IsNil)
+	c.Assert(retained.UserIDs[0].Others, gc.HasLen, 1)
+	c.Assert(retained.Others, gc.HasLen, 0)
+	out.Reset()
+	c.Assert(WritePackets(&out, retained.PrimaryKey), gc.IsNil)
+	c.Assert(out.Bytes(), gc.DeepEquals, input)
+}
+
+func (s *ResolveSuite) TestSkippedPadding(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var plain bytes.Buffer
+	c.Assert(alice.Serialize(&plain), gc.IsNil)
+	expect := ReadKeys(bytes.NewReader(plain.Bytes())).MustParse()[0]
+
+	var buf bytes.Buffer
+	c.Assert((&packet.OpaquePacket{Tag: 10, Contents: []byte("PGP")}).Serialize(&buf), gc.IsNil)
+	buf.Write(plain.Bytes())
+	c.Assert((&packet.OpaquePacket{Tag: 21, Contents: make([]byte, 32)}).Serialize(&buf), gc.IsNil)
+	var results []*ReadKeyResult
+	for kr := range ReadKeys(&buf) {
+		results = append(results, kr)
+	}
+	c.Assert(results, gc.HasLen, 1)
+	c.Assert(results[0].Error, gc.IsNil)
+	c.Assert(results[0].Others, gc.HasLen, 0)
+	c.Assert(results[0].MD5, gc.Equals, expect.MD5)
+	c.Assert(results[0].Skipped, gc.HasLen, 1)
+	c.Assert(results[0].Skipped[0].Tag, gc.Equals, uint8(21))
+	c.Assert(results[0].Skipped[0].Reason, gc.Equals, SkipPadding)
+	c.Assert(results[0].Skipped[0].Retained, gc.Equals, false)
+
+	// Padding given to OpaqueKeyring.Parse directly is skipped too.
+	var okr OpaqueKeyring
+	r := packet.NewOpaqueReader(bytes.NewReader(plain.Bytes()))
+	for op, err := r.Next(); err == nil; op, err = r.Next() {
+		okr.Packets = append(okr.Packets, op, &packet.OpaquePacket{Tag: 21, Contents: []byte{0}})
+	}
+	key, err := okr.Parse()
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.Others, gc.HasLen, 0)
+	c.Assert(key.MD5, gc.Equals, expect.MD5)
+}
+
+func (s *ResolveSuite) TestMergeChange(c *gc.C) {
+	t := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	defer patchNow(t)()
+
+	alice := newTestEntity(c, "Alice")
+	dst := entityKey(c, alice)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	src := entityKey(c, alice)
+
+	oldDigest := dst.MD5
+	change, err := MergeChange(dst, src)
+	c.Assert(err, gc.IsNil)
+	c.Assert(change.Fingerprint, gc.Equals, dst.Fingerprint())
+	c.Assert(change.OldDigest, gc.Equals, oldDigest)
+	c.Assert(change.NewDigest, gc.Equals, dst.MD5)
+	c.Assert(change.NewDigest, gc.Not(gc.Equals), oldDigest)
+	c.Assert(change.Added, gc.HasLen, 1)
+	c.Assert(change.Timestamp, gc.Equals, t)
+
+	var buf bytes.Buffer
+	c.Assert(WriteKeyChange(&buf, change), gc.IsNil)
+	c.Assert(WriteKeyChange(&buf, &KeyChange{Fingerprint: change.Fingerprint, NewDigest: oldDigest, Timestamp: t}), gc.IsNil)
+	read, err := ReadKeyChange(&buf, 1024)
+	c.Assert(err, gc.IsNil)
+	c.Assert(read.Timestamp.Equal(t), gc.Equals, true)
+	read.Timestamp = t
+	c.Assert(read, gc.DeepEquals, change)
+	read, err = ReadKeyChange(&buf, 1024)
+	c.Assert(err, gc.IsNil)
+	c.Assert(read.OldDigest, gc.Equals, "")
+	c.Assert(read.Added, gc.HasLen, 0)
+	_, err = ReadKeyChange(&buf, 1024)
+	c.Assert(err, gc.Equals, io.EOF)
+
+	// Changes longer than the limit are refused before they are read, and
+	// a log cut short claiming a huge change ends without reading it.
+	c.Assert(WriteKeyChange(&buf, change), gc.IsNil)
+	_, err = ReadKeyChange(&buf, 16)
+	c.Assert(err, gc.ErrorMatches, "key change of [0-9]+ octets exceeds limit of 16")
+	_, err = ReadKeyChange(bytes.NewReader([]byte{0x7f, 0xff, 0xff, 0xff, 1}), math.MaxInt32)
+	c.Assert(errgo.Cause(err), gc.Equals, io.ErrUnexpectedEOF)
+}
+
+func (s *ResolveSuite) TestDiff(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	a := entityKey(c, alice)
+	c.Assert(Diff(a, a).Empty(), gc.Equals, true)
+
+	bob := newTestEntity(c, "Bob")
+	c.Assert(alice.SignIdentity("Alice", bob, nil), gc.IsNil)
+	b := entityKey(c, alice)
+	b.SubKeys = nil
+
+	d := Diff(a, b)
+	c.Assert(d.Empty(), gc.Equals, false)
+	c.Assert(d.AddedSignatures, gc.HasLen, 1)
+	c.Assert(d.AddedSignatures[0].Signature.IssuerKeyID(), gc.Equals, strings.ToLower(bob.PrimaryKey.KeyIdString()))
+	c.Assert(d.RemovedSubKeys, gc.DeepEquals, a.SubKeys)
+	c.Assert(d.String(), gc.Equals, fmt.Sprintf("-sub %s\n+sig 0x10 by %s on uid %q\n",
+		a.SubKeys[0].KeyID(), strings.ToLower(bob.PrimaryKey.KeyIdString()), "Alice"))
+
+	// Signature types are written as two hex digits.
+	d = &KeyDiff{RemovedSignatures: []*SignatureDiff{{Target: "key", Signature: &Signature{SigType: 0x02, RIssuerKeyID: "0807060504030201"}}}}
+	c.Assert(d.String(), gc.Equals, "-sig 0x02 by 1020304050607080 on key\n")
+}
+
+func (s *ResolveSuite) TestKeyPatch(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	base := entityKey(c, alice)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	updated := entityKey(c, alice)
+
+	patch := ProducePatch(base, updated)
+	c.Assert(patch.Packets, gc.HasLen, 1)
+	c.Assert(patch.Packets[0].Parent, gc.Equals, base.UserIDs[0].UUID)
+	c.Assert(ProducePatch(updated, updated).Packets, gc.HasLen, 0)
+
+	data, err := patch.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	var read KeyPatch
+	c.Assert(read.UnmarshalBinary(data), gc.IsNil)
+	c.Assert(&read, gc.DeepEquals, patch)
+
+	c.Assert(ApplyPatch(base, &read), gc.IsNil)
+	c.Assert(base.MD5, gc.Equals, updated.MD5)
+
+	// A patch from a key with only its primary key packet adds everything
+	// else, including packets whose parents are in the patch.
+	bare := entityKey(c, alice)
+	bare.Signatures, bare.UserIDs, bare.SubKeys = nil, nil, nil
+	c.Assert(bare.updateMD5(), gc.IsNil)
+	patch = ProducePatch(bare, updated)
+	c.Assert(ApplyPatch(bare, patch), gc.IsNil)
+	c.Assert(bare.MD5, gc.Equals, updated.MD5)
+}
+
+func (s *ResolveSuite) TestKeyDelta(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	base := entityKey(c, alice)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	updated := entityKey(c, alice)
+
+	delta, err := KeyDelta(base, updated)
+	c.Assert(err, gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(WritePackets(&buf, updated), gc.IsNil)
+	c.Assert(len(delta) < buf.Len()/2, gc.Equals, true, gc.Commentf("delta %d octets, key %d", len(delta), buf.Len()))
+
+	key, err := ApplyKeyDelta(base, delta, buf.Len())
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.MD5, gc.Equals, updated.MD5)
+
+	_, err = ApplyKeyDelta(base, delta, buf.Len()-1)
+	c.Assert(err, gc.ErrorMatches, "delta output of [0-9]+ octets exceeds limit of [0-9]+")
+	_, err = ApplyKeyDelta(updated, delta, buf.Len())
+	c.Assert(err, gc.ErrorMatches, "delta does not apply to this data")
+
+	for _, data := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("abcdefgh"), 100)} {
+		out, err := DecodeDelta(buf.Bytes(), EncodeDelta(buf.Bytes(), data), len(data))
+		c.Assert(err, gc.IsNil)
+		c.Assert(bytes.Equal(out, data), gc.Equals, true)
+	}
+
+	// Copies cannot take the output past the length given in the delta.
+	forged := bytes.NewBuffer(EncodeDelta(buf.Bytes(), nil))
+	for i := 0; i < 100; i++ {
+		forged.Write([]byte{deltaCopy, 0})
+		writeUvarint(forged, uint64(buf.Len()))
+	}
+	_, err = DecodeDelta(buf.Bytes(), forged.Bytes(), 1<<30)
+	c.Assert(err, gc.ErrorMatches, "delta output too long")
+
+	// Unknown packets in the key survive the delta.
+	c.Assert((&packet.OpaquePacket{Tag: 40, Contents: []byte("future")}).Serialize(&buf), gc.IsNil)
+	updated = ReadKeys(&buf, UnknownPackets(RetainUnknownPackets)).MustParse()[0]
+	c.Assert(updated.SubKeys[0].Others, gc.HasLen, 1)
+	delta, err = KeyDelta(base, updated)
+	c.Assert(err, gc.IsNil)
+	key, err = ApplyKeyDelta(base, delta, 1<<20)
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.MD5, gc.Equals, updated.MD5)
+}
+
+func (s *ResolveSuite) TestSharedKey(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	shared, err := NewSharedKey(entityKey(c, alice))
+	c.Assert(err, gc.IsNil)
+	var updates []*PrimaryKey
+	for _, name := range []string{"Bob", "Carol", "Dave"} {
+		c.Assert(alice.SignIdentity("Alice", newTestEntity(c, name), nil), gc.IsNil)
+		updates = append(updates, entityKey(c, alice))
+	}
+	expect := updates[len(updates)-1].MD5
+
+	var wg sync.WaitGroup
+	for _, update := range updates {
+		wg.Add(2)
+		go func(update *PrimaryKey) {
+			defer wg.Done()
+			c.Check(shared.Merge(update), gc.IsNil)
+		}(update)
+		go func() {
+			defer wg.Done()
+			c.Check(shared.Read(func(key *PrimaryKey) error {
+				key.UserIDs[0].SelfSigs(key)
+				return nil
+			}), gc.IsNil)
+			var buf bytes.Buffer
+			c.Check(shared.WritePackets(&buf), gc.IsNil)
+		}()
+	}
+	wg.Wait()
+	md5, _ := shared.Digests()
+	c.Assert(md5, gc.Equals, expect)
+	c.Assert(shared.RFingerprint(), gc.Equals, updates[0].RFingerprint)
+}
+
+func (s *ResolveSuite) TestKeySnapshot(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	old, err := NewKeySnapshot(entityKey(c, alice))
+	c.Assert(err, gc.IsNil)
+	oldDigest := old.Key().MD5
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+
+	expect := entityKey(c, alice)
+	merged := entityKey(c, alice)
+	c.Assert(Merge(merged, entityKey(c, alice)), gc.IsNil)
+
+	snap, err := old.Merge(entityKey(c, alice))
+	c.Assert(err, gc.IsNil)
+	c.Assert(snap.Key().MD5, gc.Equals, merged.MD5)
+	c.Assert(snap.Key().MD5, gc.Equals, expect.MD5)
+	c.Assert(snap.Key().UserIDs[0].Signatures, gc.HasLen, 2)
+	c.Assert(snap.Key().SubKeys[0], gc.Equals, old.Key().SubKeys[0])
+	c.Assert(snap.Key().UserIDs[0].Signatures[0], gc.Equals, old.Key().UserIDs[0].Signatures[0])
+
+	c.Assert(old.Key().MD5, gc.Equals, oldDigest)
+	c.Assert(old.Key().UserIDs[0].Signatures, gc.HasLen, 1)
+
+	_, err = old.Merge(entityKey(c, newTestEntity(c, "Bob")))
+	c.Assert(err, gc.ErrorMatches, "cannot merge key .*")
+
+	// A key revocation survives the merge, as with Merge.
+	rev := keyRevocation(c, alice)
+	revoked := entityKey(c, alice)
+	c.Assert(ApplyRevocation(revoked, rev, nil), gc.IsNil)
+	merged = entityKey(c, alice)
+	c.Assert(Merge(merged, revoked), gc.IsNil)
+	c.Assert(merged.SelfSigs().Revocations, gc.HasLen, 1)
+	revoked = entityKey(c, alice)
+	c.Assert(ApplyRevocation(revoked, rev, nil), gc.IsNil)
+	snap, err = old.Merge(revoked)
+	c.Assert(err, gc.IsNil)
+	c.Assert(snap.Key().SelfSigs().Revocations, gc.HasLen, 1)
+	c.Assert(snap.Key().MD5, gc.Equals, merged.MD5)
+	c.Assert(old.Key().Signatures, gc.HasLen, 0)
+}
+
+func (s *ResolveSuite) TestRevision(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	c.Assert(key.Revision, gc.Equals, uint64(0))
+	c.Assert(DropDuplicates(key), gc.IsNil)
+	c.Assert(key.Revision, gc.Equals, uint64(0))
+
+	c.Assert(Merge(key, entityKey(c, alice)), gc.IsNil)
+	c.Assert(key.Revision, gc.Equals, uint64(0))
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	c.Assert(Merge(key, entityKey(c, alice)), gc.IsNil)
+	c.Assert(key.Revision, gc.Equals, uint64(1))
+
+	snap, err := NewKeySnapshot(key)
+	c.Assert(err, gc.IsNil)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Carol"), nil), gc.IsNil)
+	snap, err = snap.Merge(entityKey(c, alice))
+	c.Assert(err, gc.IsNil)
+	c.Assert(snap.Key().Revision, gc.Equals, uint64(2))
+	c.Assert(key.Revision, gc.Equals, uint64(1))
+}
+
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) OnUserIDAdded(key *PrimaryKey, uid *UserID) {
+	o.events = append(o.events, "uid "+uid.Keywords)
+}
+
+func (o *recordingObserver) OnSignatureAdded(key *PrimaryKey, target string, sig *Signature) {
+	o.events = append(o.events, fmt.Sprintf("sig 0x%x on %s", sig.SigType, target))
+}
+
+func (o *recordingObserver) OnRevocation(key *PrimaryKey, target string, sig *Signature) {
+	o.events = append(o.events, fmt.Sprintf("rev 0x%x on %s", sig.SigType, target))
+}
+
+// keyRevocation returns a key revocation certificate for the entity.
+func keyRevocation(c *gc.C, entity *openpgp.Entity) *RevocationCert {
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   entity.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &entity.PrimaryKey.KeyId,
+	}
+	var buf bytes.Buffer
+	c.Assert(entity.PrimaryKey.Serialize(&buf), gc.IsNil)
+	op, err := packet.NewOpaqueReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	h := sig.Hash.New()
+	entity.PrimaryKey.SerializeSignaturePrefix(h)
+	h.Write(op.Contents)
+	c.Assert(sig.Sign(h, entity.PrivateKey, nil), gc.IsNil)
+	c.Assert(sig.Serialize(&buf), gc.IsNil)
+	var revs []*RevocationCert
+	for readKey := range ReadKeys(&buf) {
+		c.Assert(readKey.Error, gc.IsNil)
+		revs = append(revs, readKey.Revocations...)
+	}
+	c.Assert(revs, gc.HasLen, 1)
+	return revs[0]
+}
+
+func (s *ResolveSuite) TestKeyRevocationSelfSig(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	c.Assert(ApplyRevocation(key, keyRevocation(c, alice), nil), gc.IsNil)
+	ss := key.SelfSigs()
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Revocations, gc.HasLen, 1)
+
+	// A revocation computed over the key twice, as a binding signature is,
+	// does not verify.
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   alice.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &alice.PrimaryKey.KeyId,
+	}
+	c.Assert(sig.SignKey(alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(sig.Serialize(&buf), gc.IsNil)
+	op, err := packet.NewOpaqueReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	key = entityKey(c, alice)
+	bad, err := ParseSignature(op, key.UUID, key.UUID)
+	c.Assert(err, gc.IsNil)
+	key.Signatures = append(key.Signatures, bad)
+	ss = key.SelfSigs()
+	c.Assert(ss.Errors, gc.HasLen, 1)
+	c.Assert(ss.Revocations, gc.HasLen, 0)
+}
+
+func (s *ResolveSuite) TestMergeObserved(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	alice.Identities["Carol"] = newTestEntity(c, "Carol").Identities["Carol"]
+	update := entityKey(c, alice)
+
+	obs := &recordingObserver{}
+	c.Assert(MergeObserved(key, update, obs), gc.IsNil)
+	var carol *UserID
+	for _, uid := range key.UserIDs {
+		if uid.Keywords == "Carol" {
+			carol = uid
+		}
+	}
+	c.Assert(carol, gc.NotNil)
+	sort.Strings(obs.events)
+	c.Assert(obs.events, gc.DeepEquals, []string{
+		"sig 0x10 on " + key.UserIDs[0].UUID,
+		"sig 0x13 on " + carol.UUID,
+		"uid Carol",
+	})
+
+	obs.events = nil
+	rev := keyRevocation(c, alice)
+	c.Assert(ApplyRevocation(key, rev, obs), gc.IsNil)
+	c.Assert(obs.events, gc.DeepEquals, []string{"rev 0x20 on " + key.UUID})
+	_, revoked := key.SelfSigs().RevokedSince()
+	c.Assert(revoked, gc.Equals, true)
+
+	obs.events = nil
+	c.Assert(ApplyRevocation(key, rev, obs), gc.IsNil)
+	c.Assert(obs.events, gc.HasLen, 0)
+	c.Assert(key.Signatures, gc.HasLen, 1)
+
+	err := ApplyRevocation(entityKey(c, newTestEntity(c, "Dave")), rev, nil)
+	c.Assert(err, gc.ErrorMatches, "revocation issued by .*")
+}
+
+func (s *ResolveSuite) TestMigrateUUIDs(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	input := buf.Bytes()
+
+	key := ReadKeys(bytes.NewReader(input)).MustParse()[0]
+	other, err := ParseOther(&packet.OpaquePacket{Tag: 60, Contents: []byte("other")}, key.UserIDs[0].UUID)
+	c.Assert(err, gc.IsNil)
+	key.Others = append(key.Others, other)
+	oldUUIDs := make(map[string]bool)
+	for _, node := range key.contents() {
+		oldUUIDs[node.uuid()] = true
+	}
+
+	sha512Scheme := HashUUIDScheme(sha512.New)
+	changed, err := MigrateUUIDs(key, DefaultUUIDScheme, sha512Scheme)
+	c.Assert(err, gc.IsNil)
+	// All but the primary key and subkey.
+	c.Assert(changed, gc.HasLen, len(oldUUIDs)-2)
+	c.Assert(other.UUID, gc.Equals, sha512Scheme.UUID([]string{key.UserIDs[0].UUID}, packetTag, other.Packet))
+
+	SetUUIDScheme(sha512Scheme)
+	defer SetUUIDScheme(nil)
+	expect := ReadKeys(bytes.NewReader(input)).MustParse()[0]
+	key.Others = nil
+	c.Assert(len(key.contents()), gc.Equals, len(expect.contents()))
+	for i, node := range key.contents() {
+		c.Assert(node.uuid(), gc.Equals, expect.contents()[i].uuid())
+		if old, ok := changed[node.uuid()]; ok {
+			c.Assert(oldUUIDs[old], gc.Equals, false)
+		}
+	}
+
+	_, err = MigrateUUIDs(key, DefaultUUIDScheme, sha512Scheme)
+	c.Assert(err, gc.ErrorMatches, "UUID .* not derived by scheme")
+	reverted, err := MigrateUUIDs(key, sha512Scheme, DefaultUUIDScheme)
+	c.Assert(err, gc.IsNil)
+	for old, migrated := range changed {
+		if migrated != other.UUID {
+			c.Assert(reverted[migrated], gc.Equals, old)
+		}
+	}
+}
+
+func (s *ResolveSuite) TestCompareNodes(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	uid, subkey := key.UserIDs[0], key.SubKeys[0]
+	c.Assert(CompareNodes(key, uid, uid), gc.Equals, 0)
+	c.Assert(CompareNodes(key, uid, subkey), gc.Equals, 0)
+	c.Assert(CompareNodes(key, key, key), gc.Equals, 0)
+
+	older := &Signature{Creation: time.Unix(1000, 0)}
+	newer := &Signature{Creation: time.Unix(2000, 0)}
+	c.Assert(CompareNodes(key, older, newer), gc.Equals, -1)
+	c.Assert(CompareNodes(key, newer, older), gc.Equals, 1)
+
+	// Subkeys without a valid binding signature sort after valid ones.
+	unbound := *subkey
+	unbound.Signatures = nil
+	c.Assert(CompareNodes(key, subkey, &unbound), gc.Equals, -1)
+	c.Assert(CompareNodes(key, &unbound, subkey), gc.Equals, 1)
+
+	a := &packet.OpaquePacket{Tag: 2, Contents: []byte{1, 2}}
+	b := &packet.OpaquePacket{Tag: 2, Contents: []byte{1, 3}}
+	d := &packet.OpaquePacket{Tag: 13, Contents: []byte{0}}
+	c.Assert(ComparePackets(a, b), gc.Equals, -1)
+	c.Assert(ComparePackets(b, a), gc.Equals, 1)
+	c.Assert(ComparePackets(b, d), gc.Equals, -1)
+	c.Assert(ComparePackets(a, &packet.OpaquePacket{Tag: 2, Contents: []byte{1, 2}}), gc.Equals, 0)
+}
+
+func (s *ResolveSuite) TestControllingSignature(c *gc.C) {
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	c.Assert(alice.SignIdentity("Alice", bob, nil), gc.IsNil)
+	ident := alice.Identities["Alice"]
+	cert := ident.Signatures[0]
+	bobID := fmt.Sprintf("%016x", bob.PrimaryKey.KeyId)
+
+	key := entityKey(c, alice)
+	uid := key.UserIDs[0]
+	sig := uid.ControllingSignature(bobID)
+	c.Assert(sig, gc.NotNil)
+	c.Assert(sig.SigType, gc.Equals, 0x10)
+	c.Assert(uid.CertifiedBy(bobID), gc.Equals, true)
+	c.Assert(uid.CertifiedBy(fmt.Sprintf("%x", bob.PrimaryKey.Fingerprint)), gc.Equals, true)
+	c.Assert(uid.ControllingSignature("0123456789abcdef"), gc.IsNil)
+
+	// A revocation supersedes the certification.
+	revocation := &packet.Signature{
+		SigType:      packet.SignatureType(0x30),
+		PubKeyAlgo:   bob.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: cert.CreationTime.Add(time.Hour),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(revocation.SignUserId("Alice", alice.PrimaryKey, bob.PrivateKey, nil), gc.IsNil)
+	ident.Signatures = append(ident.Signatures, revocation)
+	key = entityKey(c, alice)
+	uid = key.UserIDs[0]
+	c.Assert(uid.ControllingSignature(bobID).SigType, gc.Equals, 0x30)
+	c.Assert(uid.CertifiedBy(bobID), gc.Equals, false)
+
+	// And is superseded in turn by a later certification.
+	recert := &packet.Signature{
+		SigType:      packet.SignatureType(0x12),
+		PubKeyAlgo:   bob.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: cert.CreationTime.Add(2 * time.Hour),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(recert.SignUserId("Alice", alice.PrimaryKey, bob.PrivateKey, nil), gc.IsNil)
+	ident.Signatures = append(ident.Signatures, recert)
+	key = entityKey(c, alice)
+	uid = key.UserIDs[0]
+	c.Assert(uid.ControllingSignature(bobID).SigType, gc.Equals, 0x12)
+	c.Assert(uid.CertifiedBy(bobID), gc.Equals, true)
+}
+
+func (s *ResolveSuite) TestVerifiedControllingSignature(c *gc.C) {
+	alice, bob, mallory := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Mallory")
+	c.Assert(alice.SignIdentity("Alice", bob, nil), gc.IsNil)
+	ident := alice.Identities["Alice"]
+	bobID := fmt.Sprintf("%016x", bob.PrimaryKey.KeyId)
+
+	// Mallory revokes the certification in Bob's name.
+	forged := &packet.Signature{
+		SigType:      packet.SignatureType(0x30),
+		PubKeyAlgo:   mallory.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: ident.Signatures[0].CreationTime.Add(time.Hour),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(forged.SignUserId("Alice", alice.PrimaryKey, mallory.PrivateKey, nil), gc.IsNil)
+	ident.Signatures = append(ident.Signatures, forged)
+
+	key, bobKey := entityKey(c, alice), entityKey(c, bob)
+	uid := key.UserIDs[0]
+	c.Assert(uid.ControllingSignature(bobID).SigType, gc.Equals, 0x30)
+	c.Assert(uid.CertifiedBy(bobID), gc.Equals, false)
+	sig := uid.VerifiedControllingSignature(key, bobKey)
+	c.Assert(sig, gc.NotNil)
+	c.Assert(sig.SigType, gc.Equals, 0x10)
+	c.Assert(uid.CertifiedByKey(key, bobKey), gc.Equals, true)
+	c.Assert(uid.CertifiedByKey(key, entityKey(c, mallory)), gc.Equals, false)
+}
+
+// sigSubpacket returns a serialized signature subpacket.
+func sigSubpacket(typ byte, data []byte) []byte {
+	var buf bytes.Buffer
+	if n := len(data) + 1; n < 192 {
+		buf.WriteByte(byte(n))
+	} else {
+		n -= 192
+		buf.Write([]byte{byte(n>>8) + 192, byte(n)})
+	}
+	buf.WriteByte(typ)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// directKeySig returns a serialized direct-key signature by entity, with the
+// given hashed subpackets besides its creation time. The packet library
+// cannot write subpackets such as revocation keys, so the signature is built
+// by hand.
+func directKeySig(c *gc.C, entity *openpgp.Entity, created time.Time, subpackets ...[]byte) []byte {
+	return rawSig(c, entity, 0x1f, created, func(h hash.Hash) {
+		keyBody(c, h, entity)
+	}, subpackets...)
+}
+
+// certificationSig returns a serialized generic certification by signer of
+// the user ID of target, built by hand as for directKeySig.
+func certificationSig(c *gc.C, signer, target *openpgp.Entity, uid string, created time.Time, subpackets ...[]byte) []byte {
+	return rawSig(c, signer, 0x10, created, func(h hash.Hash) {
+		keyBody(c, h, target)
+		var u32 [4]byte
+		binary.BigEndian.PutUint32(u32[:], uint32(len(uid)))
+		h.Write(append([]byte{0xb4}, u32[:]...))
+		h.Write([]byte(uid))
+	}, subpackets...)
+}
+
+// keyBody writes the primary key of entity to h as signatures hash it.
+func keyBody(c *gc.C, h hash.Hash, entity *openpgp.Entity) {
+	var buf bytes.Buffer
+	c.Assert(entity.PrimaryKey.Serialize(&buf), gc.IsNil)
+	op, err := packet.NewOpaqueReader(&buf).Next()
+	c.Assert(err, gc.IsNil)
+	entity.PrimaryKey.SerializeSignaturePrefix(h)
+	h.Write(op.Contents)
+}
+
+// rawSig returns a serialized v4 RSA signature by entity, over whatever
+// target writes to the hash.
+func rawSig(c *gc.C, entity *openpgp.Entity, sigType byte, created time.Time, target func(hash.Hash), subpackets ...[]byte) []byte {
+	var u32 [4]byte
+	binary.BigEndian.PutUint32(u32[:], uint32(created.Unix()))
+	hashed := sigSubpacket(2, u32[:])
+	for _, sp := range subpackets {
+		hashed = append(hashed, sp...)
+	}
+	var keyID [8]byte
+	binary.BigEndian.PutUint64(keyID[:], entity.PrimaryKey.KeyId)
+	unhashed := sigSubpacket(16, keyID[:])
+
+	var contents bytes.Buffer
+	contents.Write([]byte{4, sigType, byte(entity.PrimaryKey.PubKeyAlgo), 8}) // SHA256
+	binary.Write(&contents, binary.BigEndian, uint16(len(hashed)))
+	contents.Write(hashed)
+	trailerLen := contents.Len()
+
+	h := crypto.SHA256.New()
+	target(h)
+	h.Write(contents.Bytes())
+	binary.BigEndian.PutUint32(u32[:], uint32(trailerLen))
+	h.Write(append([]byte{4, 0xff}, u32[:]...))
+	digest := h.Sum(nil)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, entity.PrivateKey.PrivateKey.(*rsa.PrivateKey), crypto.SHA256, digest)
+	c.Assert(err, gc.IsNil)
+
+	binary.Write(&contents, binary.BigEndian, uint16(len(unhashed)))
+	contents.Write(unhashed)
+	contents.Write(digest[:2])
+	// MPIs carry no leading zero octets.
+	mpi := new(big.Int).SetBytes(rsaSig)
+	binary.Write(&contents, binary.BigEndian, uint16(mpi.BitLen()))
+	contents.Write(mpi.Bytes())
+	var out bytes.Buffer
+	c.Assert((&packet.OpaquePacket{Tag: 2, Contents: contents.Bytes()}).Serialize(&out), gc.IsNil)
+	return out.Bytes()
+}
+
+// keyLifetime, trustSignature and revocationKey return signature subpackets.
+func keyLifetime(secs uint32) []byte {
+	var u32 [4]byte
+	binary.BigEndian.PutUint32(u32[:], secs)
+	return sigSubpacket(9, u32[:])
+}
+
+func trustSignature(level, amount byte) []byte {
+	return sigSubpacket(5, []byte{level, amount})
+}
+
+func revocationKey(revoker *openpgp.Entity) []byte {
+	return sigSubpacket(12, append([]byte{0x80, byte(revoker.PrimaryKey.PubKeyAlgo)}, revoker.PrimaryKey.Fingerprint[:]...))
+}
+
+func (s *ResolveSuite) TestDirectKeySigs(c *gc.C) {
+	alice, bob, carol := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Carol")
+	created := alice.PrimaryKey.CreationTime
+	var buf bytes.Buffer
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, alice, created.Add(time.Hour), keyLifetime(86400), revocationKey(bob)))
+	buf.Write(directKeySig(c, alice, created.Add(2*time.Hour), keyLifetime(3600), revocationKey(carol)))
+	standalone := &packet.Signature{
+		SigType:      packet.SignatureType(0x02),
+		PubKeyAlgo:   alice.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: created.Add(time.Hour),
+		IssuerKeyId:  &alice.PrimaryKey.KeyId,
+	}
+	c.Assert(standalone.Sign(crypto.SHA256.New(), alice.PrivateKey, nil), gc.IsNil)
+	c.Assert(standalone.Serialize(&buf), gc.IsNil)
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	key := keys[0]
+
+	ss := key.SelfSigs()
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.DirectKeys, gc.HasLen, 2)
+	c.Assert(ss.Standalones, gc.HasLen, 1)
+	// The most recent direct-key signature controls expiration.
+	expires, ok := ss.ExpiresAt()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expires.Unix(), gc.Equals, created.Add(3*time.Hour).Unix())
+	// Designated revokers accumulate.
+	rks := ss.RevocationKeys()
+	c.Assert(rks, gc.HasLen, 2)
+	c.Assert(rks[0].RFingerprint, gc.Equals, Reverse(fmt.Sprintf("%x", carol.PrimaryKey.Fingerprint)))
+	c.Assert(rks[0].Class, gc.Equals, byte(0x80))
+	c.Assert(rks[1].RFingerprint, gc.Equals, Reverse(fmt.Sprintf("%x", bob.PrimaryKey.Fingerprint)))
+}
+
+func (s *ResolveSuite) TestKeyBlock(c *gc.C) {
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	var block bytes.Buffer
+	c.Assert(alice.Serialize(&block), gc.IsNil)
+	keyBlock := sigSubpacket(38, append([]byte{0}, block.Bytes()...))
+
+	var buf bytes.Buffer
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, alice, time.Now(), keyBlock))
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	harvested, errs := keys[0].KeyBlocks()
+	c.Assert(errs, gc.HasLen, 0)
+	c.Assert(harvested, gc.HasLen, 1)
+	c.Assert(harvested[0].RFingerprint, gc.Equals, keys[0].RFingerprint)
+	c.Assert(harvested[0].UserIDs, gc.HasLen, 1)
+
+	// Bob's key carried in a signature by Alice.
+	block.Reset()
+	c.Assert(bob.Serialize(&block), gc.IsNil)
+	buf.Reset()
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, alice, time.Now(), sigSubpacket(38, append([]byte{0}, block.Bytes()...))))
+	keys = ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	_, err := keys[0].Signatures[0].KeyBlock()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrKeyBlockMismatch)
+	harvested, errs = keys[0].KeyBlocks()
+	c.Assert(harvested, gc.HasLen, 0)
+	c.Assert(errs, gc.HasLen, 1)
+
+	key, err := entityKey(c, alice).UserIDs[0].Signatures[0].KeyBlock()
+	c.Assert(err, gc.IsNil)
+	c.Assert(key, gc.IsNil)
+}
+
+func (s *ResolveSuite) TestWildcardIssuer(c *gc.C) {
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	ident := alice.Identities["Alice"]
+	var zero uint64
+	for i, signer := range []*openpgp.Entity{alice, alice, bob} {
+		sig := &packet.Signature{
+			SigType:      packet.SigTypePositiveCert,
+			PubKeyAlgo:   signer.PrimaryKey.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: ident.SelfSignature.CreationTime.Add(time.Duration(i+1) * time.Second),
+		}
+		if i == 0 {
+			// Wildcard issuer.
+			sig.IssuerKeyId = &zero
+		}
+		c.Assert(sig.SignUserId("Alice", alice.PrimaryKey, signer.PrivateKey, nil), gc.IsNil)
+		ident.Signatures = append(ident.Signatures, sig)
+	}
+	key := entityKey(c, alice)
+	uid := key.UserIDs[0]
+	c.Assert(uid.Signatures, gc.HasLen, 4)
+	var wildcards int
+	for _, sig := range uid.Signatures {
+		if sig.IsWildcardIssuer() {
+			wildcards++
+		}
+	}
+	c.Assert(wildcards, gc.Equals, 3)
+
+	ss := uid.SelfSigs(key)
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Certifications, gc.HasLen, 1)
+	c.Assert(ss.Valid(), gc.Equals, true)
+
+	ss = uid.SelfSigs(key, TrialVerifyWildcards())
+	// Bob's signature fails trial verification, and is ignored.
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Certifications, gc.HasLen, 3)
+	c.Assert(ss.Valid(), gc.Equals, true)
+
+	// The option applies only to the call it is given to.
+	c.Assert(uid.SelfSigs(key).Certifications, gc.HasLen, 1)
+}
+
+func (s *ResolveSuite) TestGraph(c *gc.C) {
+	alice, bob, carol := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Carol")
+	c.Assert(alice.SignIdentity("Alice", bob, nil), gc.IsNil)
+	c.Assert(bob.SignIdentity("Bob", alice, nil), gc.IsNil)
+	c.Assert(carol.SignIdentity("Carol", alice, nil), gc.IsNil)
+
+	// Bob's certification of Carol is revoked.
+	c.Assert(carol.SignIdentity("Carol", bob, nil), gc.IsNil)
+	revocation := &packet.Signature{
+		SigType:      packet.SignatureType(0x30),
+		PubKeyAlgo:   bob.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now().Add(time.Hour),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(revocation.SignUserId("Carol", carol.PrimaryKey, bob.PrivateKey, nil), gc.IsNil)
+	ident := carol.Identities["Carol"]
+	ident.Signatures = append(ident.Signatures, revocation)
+
+	var buf bytes.Buffer
+	for _, entity := range []*openpgp.Entity{alice, bob, carol} {
+		c.Assert(entity.Serialize(&buf), gc.IsNil)
+	}
+	g := BuildGraph(ReadKeys(&buf))
+	c.Assert(g.Len(), gc.Equals, 3)
+	c.Assert(g.Edges(), gc.Equals, 3)
+
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+	certs := g.Certifiers(rfp(alice))
+	c.Assert(certs, gc.HasLen, 1)
+	c.Assert(certs[0].Issuer, gc.Equals, rfp(bob))
+	c.Assert(certs[0].Target, gc.Equals, rfp(alice))
+	c.Assert(certs[0].UserID, gc.Equals, "Alice")
+	c.Assert(certs[0].Class, gc.Equals, CertGeneric)
+
+	certs = g.Certifications(rfp(alice))
+	c.Assert(certs, gc.HasLen, 2)
+	targets := []string{certs[0].Target, certs[1].Target}
+	sort.Strings(targets)
+	expect := []string{rfp(bob), rfp(carol)}
+	sort.Strings(expect)
+	c.Assert(targets, gc.DeepEquals, expect)
+
+	c.Assert(g.Certifiers(rfp(carol)), gc.HasLen, 1)
+	c.Assert(g.Certifications(rfp(carol)), gc.HasLen, 0)
+	c.Assert(g.Certifiers("0123"), gc.HasLen, 0)
+}
+
+// trustKey returns the serialized primary key and user ID of entity, with its
+// self-signature and the given certifications.
+func trustKey(c *gc.C, entity *openpgp.Entity, certs ...[]byte) []byte {
+	var buf bytes.Buffer
+	c.Assert(entity.PrimaryKey.Serialize(&buf), gc.IsNil)
+	for _, ident := range entity.Identities {
+		c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	}
+	for _, cert := range certs {
+		buf.Write(cert)
+	}
+	return buf.Bytes()
+}
+
+func (s *ResolveSuite) TestPath(c *gc.C) {
+	root, alice, bob, carol, dave := newTestEntity(c, "Root"), newTestEntity(c, "Alice"),
+		newTestEntity(c, "Bob"), newTestEntity(c, "Carol"), newTestEntity(c, "Dave")
+	t := time.Now().Add(-time.Hour)
+	var buf bytes.Buffer
+	buf.Write(trustKey(c, root))
+	// Root trusts Alice as an introducer of depth 2, who trusts Bob as an
+	// introducer of depth 1; Bob certifies Carol, and Carol, Dave.
+	buf.Write(trustKey(c, alice, certificationSig(c, root, alice, "Alice", t, trustSignature(2, 120))))
+	buf.Write(trustKey(c, bob, certificationSig(c, alice, bob, "Bob", t, trustSignature(1, 120))))
+	buf.Write(trustKey(c, carol, certificationSig(c, bob, carol, "Carol", t)))
+	buf.Write(trustKey(c, dave, certificationSig(c, carol, dave, "Dave", t, trustSignature(1, 60))))
+	g := BuildGraph(ReadKeys(&buf))
+	c.Assert(g.Len(), gc.Equals, 5)
+
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+	path := g.Path(rfp(root), rfp(carol), 0)
+	c.Assert(path, gc.HasLen, 3)
+	c.Assert(path[0].Issuer, gc.Equals, rfp(root))
+	c.Assert(path[0].TrustLevel, gc.Equals, 2)
+	c.Assert(path[0].TrustAmount, gc.Equals, 120)
+	c.Assert(path[1].Issuer, gc.Equals, rfp(alice))
+	c.Assert(path[2].Issuer, gc.Equals, rfp(bob))
+	c.Assert(path[2].Target, gc.Equals, rfp(carol))
+
+	// Carol was not made an introducer.
+	c.Assert(g.Path(rfp(root), rfp(dave), 0), gc.IsNil)
+	c.Assert(g.Path(rfp(root), rfp(carol), 2), gc.IsNil)
+	c.Assert(g.Path(rfp(bob), rfp(carol), 1), gc.HasLen, 1)
+	c.Assert(g.Path(rfp(carol), rfp(root), 0), gc.IsNil)
+	c.Assert(g.Path(rfp(root), rfp(root), 0), gc.HasLen, 0)
+
+	// Depth is limited by that of the introducer: trusted to depth 1, Alice
+	// may introduce Bob, but not whoever Bob introduces in turn, however
+	// deeply she trusts him.
+	buf.Reset()
+	buf.Write(trustKey(c, root))
+	buf.Write(trustKey(c, alice, certificationSig(c, root, alice, "Alice", t, trustSignature(1, 120))))
+	buf.Write(trustKey(c, bob, certificationSig(c, alice, bob, "Bob", t, trustSignature(5, 120))))
+	buf.Write(trustKey(c, carol, certificationSig(c, bob, carol, "Carol", t)))
+	g = BuildGraph(ReadKeys(&buf))
+	c.Assert(g.Path(rfp(root), rfp(bob), 0), gc.HasLen, 2)
+	c.Assert(g.Path(rfp(root), rfp(carol), 0), gc.IsNil)
+
+	// Mallory cannot make herself an introducer by claiming Root's key ID,
+	// whichever key is read first.
+	mallory := newTestEntity(c, "Mallory")
+	forged := forgeIssuer(certificationSig(c, mallory, mallory, "Mallory", t, trustSignature(2, 120)), mallory, root)
+	for _, order := range [][]*openpgp.Entity{{root, mallory}, {mallory, root}} {
+		buf.Reset()
+		for _, entity := range order {
+			switch entity {
+			case root:
+				buf.Write(trustKey(c, root))
+			case mallory:
+				buf.Write(trustKey(c, mallory, forged))
+			}
+		}
+		buf.Write(trustKey(c, dave, certificationSig(c, mallory, dave, "Dave", t)))
+		g = BuildGraph(ReadKeys(&buf))
+		c.Assert(g.Len(), gc.Equals, 3)
+		c.Assert(g.Edges(), gc.Equals, 1)
+		c.Assert(g.Certifiers(rfp(mallory)), gc.HasLen, 0)
+		c.Assert(g.Path(rfp(root), rfp(mallory), 0), gc.IsNil)
+		c.Assert(g.Path(rfp(root), rfp(dave), 0), gc.IsNil)
+	}
+}
+
+// forgeIssuer returns the signature sig by signer, altered to claim to be
+// issued by issuer.
+func forgeIssuer(sig []byte, signer, issuer *openpgp.Entity) []byte {
+	var from, to [8]byte
+	binary.BigEndian.PutUint64(from[:], signer.PrimaryKey.KeyId)
+	binary.BigEndian.PutUint64(to[:], issuer.PrimaryKey.KeyId)
+	return bytes.Replace(sig, from[:], to[:], 1)
+}
+
+func (s *ResolveSuite) TestGraphStats(c *gc.C) {
+	alice, bob, carol := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Carol")
+	t := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var buf bytes.Buffer
+	buf.Write(trustKey(c, alice,
+		certificationSig(c, bob, alice, "Alice", t),
+		certificationSig(c, carol, alice, "Alice", t.Add(time.Minute))))
+	buf.Write(trustKey(c, bob, certificationSig(c, alice, bob, "Bob", t.Add(-time.Minute))))
+	// Carol's key is certified by Dave, who is not in the graph, and in
+	// Bob's name by Carol herself.
+	dave := newTestEntity(c, "Dave")
+	buf.Write(trustKey(c, carol,
+		certificationSig(c, dave, carol, "Carol", t),
+		forgeIssuer(certificationSig(c, carol, carol, "Carol", t), carol, bob)))
+	g := BuildGraph(ReadKeys(&buf))
+
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+	stats := g.Stats(rfp(alice))
+	c.Assert(stats.Certifiers, gc.Equals, 2)
+	c.Assert(stats.Received, gc.Equals, 2)
+	c.Assert(stats.Issued, gc.Equals, 1)
+	c.Assert(stats.Unverified, gc.Equals, 0)
+	c.Assert(stats.Oldest.Equal(t), gc.Equals, true)
+	c.Assert(stats.Newest.Equal(t.Add(time.Minute)), gc.Equals, true)
+
+	stats = g.Stats(rfp(carol))
+	c.Assert(stats.Received, gc.Equals, 0)
+	c.Assert(stats.Issued, gc.Equals, 1)
+	c.Assert(stats.Unverified, gc.Equals, 2)
+	c.Assert(stats.Oldest.IsZero(), gc.Equals, true)
+	c.Assert(g.Stats("0123"), gc.IsNil)
+}
+
+// agedEntity returns a new entity with a primary key created at t, and no
+// subkeys.
+func agedEntity(c *gc.C, name string, t time.Time) *openpgp.Entity {
+	entity := newTestEntity(c, name)
+	priv := entity.PrivateKey.PrivateKey.(*rsa.PrivateKey)
+	entity.PrimaryKey = packet.NewRSAPublicKey(t, &priv.PublicKey)
+	entity.PrivateKey = packet.NewRSAPrivateKey(t, priv)
+	entity.Subkeys = nil
+	for uid, ident := range entity.Identities {
+		ident.SelfSignature.CreationTime = t
+		ident.SelfSignature.IssuerKeyId = &entity.PrimaryKey.KeyId
+		c.Assert(ident.SelfSignature.SignUserId(uid, entity.PrimaryKey, entity.PrivateKey, nil), gc.IsNil)
+	}
+	return entity
+}
+
+func notation(name, value string) []byte {
+	data := make([]byte, 8, 8+len(name)+len(value))
+	data[0] = 0x80
+	binary.BigEndian.PutUint16(data[4:6], uint16(len(name)))
+	binary.BigEndian.PutUint16(data[6:8], uint16(len(value)))
+	return sigSubpacket(20, append(append(data, name...), value...))
+}
+
+func (s *ResolveSuite) TestTransitions(c *gc.C) {
+	t := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	old, cur := agedEntity(c, "Alice", t), agedEntity(c, "Alice", t.Add(time.Hour))
+	bob := agedEntity(c, "Bob", t.Add(2*time.Hour))
+	newest := agedEntity(c, "Alice", t.Add(3*time.Hour))
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+
+	var buf bytes.Buffer
+	// The old key and the current certify each other, and Bob, with another
+	// user ID, certifies both.
+	buf.Write(trustKey(c, old,
+		certificationSig(c, cur, old, "Alice", t.Add(time.Hour)),
+		certificationSig(c, bob, old, "Alice", t.Add(2*time.Hour))))
+	buf.Write(trustKey(c, cur,
+		certificationSig(c, old, cur, "Alice", t.Add(time.Hour)),
+		certificationSig(c, bob, cur, "Alice", t.Add(2*time.Hour))))
+	buf.Write(trustKey(c, bob, certificationSig(c, old, bob, "Bob", t.Add(2*time.Hour))))
+	// The newest key claims to replace the current one, which does not
+	// confirm it: the certification the newest key bears in its name is
+	// forged.
+	c.Assert(newest.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, newest, t.Add(3*time.Hour), notation(NotationPredecessor, fmt.Sprintf("0x%X", cur.PrimaryKey.Fingerprint))))
+	for _, ident := range newest.Identities {
+		c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	}
+	buf.Write(forgeIssuer(certificationSig(c, newest, newest, "Alice", t.Add(3*time.Hour)), newest, cur))
+	g := BuildGraph(ReadKeys(&buf))
+	c.Assert(g.Len(), gc.Equals, 4)
+
+	succs := g.Successors(rfp(old))
+	c.Assert(succs, gc.HasLen, 1)
+	c.Assert(succs[0], gc.DeepEquals, &Transition{
+		Predecessor: rfp(old), Successor: rfp(cur), Certified: true, CounterCertified: true,
+	})
+	preds := g.Predecessors(rfp(cur))
+	c.Assert(preds, gc.DeepEquals, succs)
+
+	succs = g.Successors(rfp(cur))
+	c.Assert(succs, gc.HasLen, 1)
+	c.Assert(succs[0], gc.DeepEquals, &Transition{Predecessor: rfp(cur), Successor: rfp(newest), Notation: true})
+	c.Assert(succs[0].Confirmed(), gc.Equals, false)
+	c.Assert(g.Predecessors(rfp(newest)), gc.DeepEquals, succs)
+
+	c.Assert(g.Successors(rfp(bob)), gc.HasLen, 0)
+	c.Assert(g.Predecessors(rfp(bob)), gc.HasLen, 0)
+	c.Assert(g.Current(rfp(old)), gc.Equals, rfp(cur))
+	c.Assert(g.Current(rfp(cur)), gc.Equals, rfp(cur))
+	c.Assert(g.Current("0123"), gc.Equals, "")
+}
+
+func (s *ResolveSuite) TestSupersededBy(c *gc.C) {
+	t := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	old, cur := agedEntity(c, "Alice", t), agedEntity(c, "Alice Smith", t.Add(time.Hour))
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+	supersededBy := notation(NotationSupersededBy, fmt.Sprintf("%x", cur.PrimaryKey.Fingerprint))
+
+	var buf bytes.Buffer
+	c.Assert(old.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, old, t.Add(2*time.Hour), supersededBy))
+	oldKey := buf.String()
+	buf.Write(trustKey(c, cur))
+	keys := ReadKeys(bytes.NewBufferString(oldKey)).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	succ, ok := keys[0].SelfSigs().SupersededBy()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(succ, gc.Equals, rfp(cur))
+
+	g := BuildGraph(ReadKeys(&buf))
+	c.Assert(g.Successors(rfp(old)), gc.DeepEquals, []*Transition{{
+		Predecessor: rfp(old), Successor: rfp(cur), Superseded: true,
+	}})
+	c.Assert(g.Predecessors(rfp(cur)), gc.HasLen, 1)
+	c.Assert(g.Current(rfp(old)), gc.Equals, rfp(cur))
+
+	// A later direct-key signature withdraws the notation.
+	buf.Reset()
+	buf.WriteString(oldKey)
+	buf.Write(directKeySig(c, old, t.Add(3*time.Hour)))
+	keys = ReadKeys(&buf).MustParse()
+	_, ok = keys[0].SelfSigs().SupersededBy()
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *ResolveSuite) TestGraphExport(c *gc.C) {
+	t := time.Now().Add(-time.Hour)
+	alice, bob, carol := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Carol")
+	var buf bytes.Buffer
+	buf.Write(trustKey(c, alice, certificationSig(c, bob, alice, "Alice", t)))
+	buf.Write(trustKey(c, bob))
+	buf.Write(trustKey(c, carol, certificationSig(c, alice, carol, "Carol", t)))
+	g := BuildGraph(ReadKeys(&buf))
+	fp := func(entity *openpgp.Entity) string {
+		return fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint)
+	}
+
+	c.Assert(g.Neighborhood(Reverse(fp(bob)), 1), gc.DeepEquals, []string{Reverse(fp(bob)), Reverse(fp(alice))})
+	c.Assert(g.Neighborhood(Reverse(fp(bob)), 2), gc.HasLen, 3)
+	c.Assert(g.Neighborhood("0123", 1), gc.IsNil)
+
+	var out bytes.Buffer
+	c.Assert(g.WriteDOT(&out, g.Neighborhood(Reverse(fp(bob)), 1)), gc.IsNil)
+	dot := out.String()
+	c.Assert(strings.HasPrefix(dot, "digraph wot {\n"), gc.Equals, true)
+	c.Assert(strings.Contains(dot, fmt.Sprintf("\t%q -> %q [label=\"Alice\"];\n", fp(bob), fp(alice))), gc.Equals, true)
+	c.Assert(strings.Contains(dot, `\nBob"];`), gc.Equals, true)
+	c.Assert(dotQuote("Bob \"B\" \\\n"), gc.Equals, `"Bob \"B\" \\\n"`)
+	c.Assert(strings.Contains(dot, fp(carol)), gc.Equals, false)
+
+	out.Reset()
+	c.Assert(g.WriteGraphML(&out, nil), gc.IsNil)
+	var doc struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+			Target string `xml:"target,attr"`
+			Data   []struct {
+				Key   string `xml:"key,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"data"`
+		} `xml:"graph>edge"`
+	}
+	c.Assert(xml.Unmarshal(out.Bytes(), &doc), gc.IsNil)
+	c.Assert(doc.Nodes, gc.HasLen, 3)
+	c.Assert(doc.Edges, gc.HasLen, 2)
+	c.Assert(doc.Edges[0].Source, gc.Equals, fp(bob))
+	c.Assert(doc.Edges[0].Target, gc.Equals, fp(alice))
+	c.Assert(doc.Edges[0].Data[0].Value, gc.Equals, "Alice")
+	c.Assert(doc.Edges[0].Data[2].Value, gc.Equals, "generic")
+}
+
+func trustRegexp(re string) []byte {
+	return sigSubpacket(6, append([]byte(re), 0))
+}
+
+func emailEntity(c *gc.C, name, email string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", email, &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	return entity
+}
+
+func (s *ResolveSuite) TestDelegation(c *gc.C) {
+	t := time.Now().Add(-time.Hour)
+	ca, dept := newTestEntity(c, "Example CA"), newTestEntity(c, "Example Sales CA")
+	alice, bob := emailEntity(c, "Alice", "alice@example.com"), emailEntity(c, "Bob", "bob@sales.example.com")
+	mallory := emailEntity(c, "Mallory", "mallory@example.org")
+	rfp := func(entity *openpgp.Entity) string {
+		return Reverse(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	}
+	var buf bytes.Buffer
+	buf.Write(trustKey(c, ca))
+	// The CA delegates to the sales CA, for example.com addresses.
+	buf.Write(trustKey(c, dept, certificationSig(c, ca, dept, "Example Sales CA", t,
+		trustSignature(1, 60), trustRegexp(`<[^>]+[@.]example\.com>$`))))
+	buf.Write(trustKey(c, alice, certificationSig(c, ca, alice, "Alice <alice@example.com>", t)))
+	buf.Write(trustKey(c, bob, certificationSig(c, dept, bob, "Bob <bob@sales.example.com>", t)))
+	// Mallory forges the CA's delegation to herself, to extend it to Eve.
+	eve := emailEntity(c, "Eve", "eve@example.com")
+	forged := forgeIssuer(certificationSig(c, mallory, mallory, "Mallory <mallory@example.org>", t, trustSignature(1, 120)), mallory, ca)
+	buf.Write(trustKey(c, mallory, certificationSig(c, dept, mallory, "Mallory <mallory@example.org>", t), forged))
+	buf.Write(trustKey(c, eve, certificationSig(c, mallory, eve, "Eve <eve@example.com>", t)))
+	g := BuildGraph(ReadKeys(&buf))
+
+	expect := []*Coverage{
+		{Target: rfp(dept), UserID: "Example Sales CA", Amount: 120},
+		{Target: rfp(alice), UserID: "Alice <alice@example.com>", Amount: 120},
+		{Target: rfp(bob), UserID: "Bob <bob@sales.example.com>", Depth: 1, Amount: 60},
+	}
+	sort.Sort(coverageByKey(expect))
+	c.Assert(g.Delegation(rfp(ca)), gc.DeepEquals, expect)
+
+	certs := g.Certifiers(rfp(dept))
+	c.Assert(certs, gc.HasLen, 1)
+	c.Assert(certs[0].TrustScope, gc.Equals, `<[^>]+[@.]example\.com>$`)
+
+	// The sales CA's own delegation is unscoped.
+	c.Assert(g.Delegation(rfp(dept)), gc.HasLen, 2)
+	c.Assert(g.Delegation("0123"), gc.IsNil)
+}
+
+func (s *ResolveSuite) TestProofs(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	t := time.Now().Add(time.Minute)
+	var buf bytes.Buffer
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, alice, t, notation("proof@ariadne.id", "dns:example.org?type=TXT")))
+	ident := alice.Identities["Alice"]
+	c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+	c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	buf.Write(certificationSig(c, alice, alice, "Alice", t,
+		notation("proof@metacode.biz", "https://fosstodon.org/@alice"),
+		notation("salt@notations.openpgpjs.org", "x")))
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].Proofs(), gc.DeepEquals, []*Proof{
+		{Notation: "proof@ariadne.id", URI: "dns:example.org?type=TXT", Scheme: "dns"},
+		{UserID: "Alice", Notation: "proof@metacode.biz", URI: "https://fosstodon.org/@alice", Scheme: "https"},
+	})
+}
+
+func (s *ResolveSuite) TestFreshness(c *gc.C) {
+	alice, bob, dave := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Dave")
+	t := alice.PrimaryKey.CreationTime
+	// Bob certifies Alice, and forges a certification in Dave's name.
+	forged := forgeIssuer(certificationSig(c, bob, alice, "Alice", t), bob, dave)
+	key := ReadKeys(bytes.NewReader(trustKey(c, alice, certificationSig(c, bob, alice, "Alice", t), forged))).MustParse()[0]
+	created := key.Creation
+	idx := NewKeyringIndex()
+	idx.Add(entityKey(c, bob))
+	idx.Add(entityKey(c, dave))
+	policy := *DefaultFreshnessPolicy
+	policy.Issuers = idx.Issuers
+
+	defer patchNow(created)()
+	score := Freshness(key, &policy)
+	c.Assert(score.SelfSigs, gc.Equals, 1.0)
+	c.Assert(score.Certifications, gc.Equals, 0.2)
+	c.Assert(math.Abs(score.Score-0.84) < 1e-9, gc.Equals, true)
+
+	// Without issuers to verify them, certifications do not count.
+	score = Freshness(key, nil)
+	c.Assert(score.Certifications, gc.Equals, 0.0)
+	c.Assert(math.Abs(score.Score-0.8) < 1e-9, gc.Equals, true)
+
+	// Signatures lose half their worth over each half-life.
+	patchNow(created.Add(DefaultFreshnessPolicy.HalfLife))
+	score = Freshness(key, &FreshnessPolicy{HalfLife: DefaultFreshnessPolicy.HalfLife, SelfSigWeight: 1})
+	c.Assert(math.Abs(score.Score-0.5) < 1e-9, gc.Equals, true)
+
+	patchNow(created)
+	c.Assert(ApplyRevocation(key, keyRevocation(c, alice), nil), gc.IsNil)
+	c.Assert(Freshness(key, nil), gc.DeepEquals, &FreshnessScore{})
+}
+
+// recordingBackend rejects every signature, recording which methods were
+// called.
+type recordingBackend struct {
+	calls []string
+}
+
+var errBackendRejected = errgo.New("rejected by backend")
+
+func (b *recordingBackend) VerifyKeySignature(pk, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "key")
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyBindingSignature(pk, signed, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "binding")
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyUserIDSignature(pk, uid, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "uid "+string(uid.Contents))
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyUserAttributeSignature(pk, uat, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "uat")
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyStandaloneSignature(pk, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "standalone")
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyUserIDCertification(issuer, pk, uid, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "uid certification "+string(uid.Contents))
+	return errBackendRejected
+}
+
+func (b *recordingBackend) VerifyUserAttributeCertification(issuer, pk, uat, sig *packet.OpaquePacket) error {
+	b.calls = append(b.calls, "uat certification")
+	return errBackendRejected
+}
+
+func (s *ResolveSuite) TestBackend(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	digest := key.MD5
+	c.Assert(key.UserIDs[0].SelfSigs(key).Certifications, gc.HasLen, 1)
+
+	b := &recordingBackend{}
+	SetBackend(b)
+	defer SetBackend(nil)
+	ss := key.UserIDs[0].SelfSigs(key)
+	c.Assert(ss.Certifications, gc.HasLen, 0)
+	c.Assert(ss.Errors, gc.HasLen, 1)
+	c.Assert(errgo.Cause(ss.Errors[0].Error), gc.Equals, ErrBadSelfSignature)
+	c.Assert(key.SubKeys[0].SelfSigs(key).Errors, gc.HasLen, 1)
+	c.Assert(b.calls, gc.DeepEquals, []string{"uid Alice", "binding"})
+
+	// Digests do not depend on the backend.
+	c.Assert(DropDuplicates(key), gc.IsNil)
+	c.Assert(key.MD5, gc.Equals, digest)
+
+	// Nor do certifications by other keys escape it.
+	alice, bob := newTestEntity(c, "Alice"), newTestEntity(c, "Bob")
+	var buf bytes.Buffer
+	buf.Write(trustKey(c, bob))
+	buf.Write(trustKey(c, alice, certificationSig(c, bob, alice, "Alice", time.Now().Add(-time.Hour))))
+	keys := ReadKeys(bytes.NewReader(buf.Bytes())).MustParse()
+	b.calls = nil
+	c.Assert(keys[1].UserIDs[0].CertifiedByKey(keys[1], keys[0]), gc.Equals, false)
+	c.Assert(BuildGraph(ReadKeys(bytes.NewReader(buf.Bytes()))).Edges(), gc.Equals, 0)
+	c.Assert(b.calls, gc.DeepEquals, []string{"uid certification Alice", "uid certification Alice"})
+
+	SetBackend(nil)
+	c.Assert(keys[1].UserIDs[0].CertifiedByKey(keys[1], keys[0]), gc.Equals, true)
+	c.Assert(BuildGraph(ReadKeys(bytes.NewReader(buf.Bytes()))).Edges(), gc.Equals, 1)
+}
+
+func (s *ResolveSuite) TestStorageDocument(c *gc.C) {
+	alice := emailEntity(c, "Alice", "Alice@Example.com")
+	key := entityKey(c, alice)
+	doc, err := NewStorageDocument(key, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(doc.RFingerprint, gc.Equals, key.RFingerprint)
+	c.Assert(doc.RKeyID, gc.Equals, key.RKeyID)
+	c.Assert(doc.RSubFingerprints, gc.DeepEquals, []string{key.SubKeys[0].RFingerprint})
+	c.Assert(doc.RSubKeyIDs, gc.DeepEquals, []string{key.SubKeys[0].RKeyID})
+	c.Assert(doc.Keywords, gc.DeepEquals, []string{"alice <alice@example.com>", "alice@example.com"})
+	c.Assert(doc.CTime, gc.Equals, key.Creation.Unix())
+	c.Assert(doc.MTime >= doc.CTime, gc.Equals, true)
+	c.Assert(doc.MD5, gc.Equals, key.MD5)
+	c.Assert(doc.Packets, gc.HasLen, len(key.contents()))
+	c.Assert(doc.Packets[0].Tag, gc.Equals, uint8(6))
+
+	buf, err := MarshalStorageDocument(key, nil)
+	c.Assert(err, gc.IsNil)
+	again, err := MarshalStorageDocument(key, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(again, gc.DeepEquals, buf)
+
+	var decoded StorageDocument
+	c.Assert(json.Unmarshal(buf, &decoded), gc.IsNil)
+	c.Assert(&decoded, gc.DeepEquals, doc)
+	readKey, err := decoded.Key()
+	c.Assert(err, gc.IsNil)
+	c.Assert(readKey.RFingerprint, gc.Equals, key.RFingerprint)
+	c.Assert(readKey.MD5, gc.Equals, key.MD5)
+
+	decoded.MD5 = "00"
+	_, err = decoded.Key()
+	c.Assert(err, gc.ErrorMatches, ".*has digest.*")
+
+	_, err = NewStorageDocument(&PrimaryKey{}, nil)
+	c.Assert(err, gc.ErrorMatches, "key has not been digested")
+}
+
+func (s *ResolveSuite) TestKeyRecord(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	ctime := time.Unix(1500000000, 0)
+	mtime := time.Unix(1600000000, 0)
+	rec, err := NewKeyRecord(key, ctime, mtime)
+	c.Assert(err, gc.IsNil)
+	data, err := rec.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+
+	hdr, err := ReadKeyRecordHeader(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(hdr.Fingerprint, gc.Equals, key.Fingerprint())
+	c.Assert(hdr.MD5, gc.Equals, key.MD5)
+	c.Assert(hdr.CTime.Equal(ctime), gc.Equals, true)
+	c.Assert(hdr.MTime.Equal(mtime), gc.Equals, true)
+	c.Assert(hdr.Packets, gc.IsNil)
+
+	var decoded KeyRecord
+	c.Assert(decoded.UnmarshalBinary(data), gc.IsNil)
+	c.Assert(decoded.Packets, gc.DeepEquals, rec.Packets)
+	readKey, err := decoded.Key()
+	c.Assert(err, gc.IsNil)
+	c.Assert(readKey.RFingerprint, gc.Equals, key.RFingerprint)
+	c.Assert(readKey.MD5, gc.Equals, key.MD5)
+
+	// The packets are not parsed to read the header, so only the key
+	// detects a mismatched digest.
+	rec.MD5 = strings.Repeat("0", 32)
+	data, err = rec.MarshalBinary()
+	c.Assert(err, gc.IsNil)
+	_, err = ReadKeyRecordHeader(data)
+	c.Assert(err, gc.IsNil)
+	c.Assert(decoded.UnmarshalBinary(data), gc.IsNil)
+	_, err = decoded.Key()
+	c.Assert(err, gc.ErrorMatches, ".*has digest.*")
+
+	c.Assert(decoded.UnmarshalBinary(data[:len(data)-1]), gc.NotNil)
+	c.Assert(decoded.UnmarshalBinary(append(data, 0)), gc.ErrorMatches, "1 trailing octets in key record")
+	c.Assert(decoded.UnmarshalBinary([]byte{2, 0, 0}), gc.ErrorMatches, "unsupported key record version 2")
+}
+
+func (s *ResolveSuite) TestEqual(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert(newTestEntity(c, "Alice").Serialize(&buf), gc.IsNil)
+	c.Assert(packet.NewUserId("Other", "", "").Serialize(&buf), gc.IsNil)
+	var ops []*packet.OpaquePacket
+	r := packet.NewOpaqueReader(&buf)
+	for op, err := r.Next(); err == nil; op, err = r.Next() {
+		ops = append(ops, op)
+	}
+	c.Assert(ops, gc.HasLen, 6)
+	read := func(order ...int) *PrimaryKey {
+		var buf bytes.Buffer
+		for _, i := range order {
+			c.Assert(ops[i].Serialize(&buf), gc.IsNil)
+		}
+		return ReadKeys(&buf).MustParse()[0]
+	}
+
+	// Public key, user ID and self-signature, sub-key and binding
+	// signature, then the unsigned user ID.
+	a := read(0, 1, 2, 3, 4, 5)
+	c.Assert(Equal(a, read(0, 1, 2, 3, 4, 5)), gc.Equals, true)
+	c.Assert(SemanticEqual(a, read(0, 1, 2, 3, 4, 5)), gc.Equals, true)
+
+	// Reordered user IDs, and a repeated self-signature.
+	b := read(0, 5, 1, 2, 2, 3, 4)
+	c.Assert(Equal(a, b), gc.Equals, false)
+	c.Assert(SemanticEqual(a, b), gc.Equals, true)
+
+	// A missing packet, or one under a different parent, differs.
+	c.Assert(SemanticEqual(a, read(0, 1, 2, 3, 4)), gc.Equals, false)
+	c.Assert(SemanticEqual(a, read(0, 1, 5, 2, 3, 4)), gc.Equals, false)
+	c.Assert(Equal(a, nil), gc.Equals, false)
+}
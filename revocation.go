@@ -0,0 +1,71 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// RevocationCert is a standalone key revocation certificate: a key
+// revocation signature, distributed without the key it revokes.
+type RevocationCert struct {
+	// Signature is the key revocation signature.
+	Signature *Signature
+
+	// RIssuerKeyID is the reversed key ID of the issuer of the signature,
+	// which is also the key being revoked.
+	RIssuerKeyID string
+}
+
+// IssuerKeyID returns the key ID of the revoked key.
+func (rc *RevocationCert) IssuerKeyID() string {
+	return Reverse(rc.RIssuerKeyID)
+}
+
+// standalone returns whether the keyring holds signatures read before any
+// key, rather than a key.
+func (ok *OpaqueKeyring) standalone() bool {
+	return len(ok.Packets) > 0 && ok.Packets[0].Tag == 2 //packet.PacketTypeSignature
+}
+
+// isKeyRevocation returns whether the signature packet is a key revocation.
+func isKeyRevocation(op *packet.OpaquePacket) bool {
+	var sig Signature
+	return sig.scan(op.Contents) == nil && sig.SigType == 0x20 // packet.SigTypeKeyRevocation
+}
+
+// revocationCerts returns the keyring's packets as revocation certificates, if
+// they are all key revocation signatures.
+func (ok *OpaqueKeyring) revocationCerts() []*RevocationCert {
+	var result []*RevocationCert
+	for _, opkt := range ok.Packets {
+		if opkt.Tag != 2 { //packet.PacketTypeSignature
+			return nil
+		}
+		sig, err := ParseSignature(opkt, "", "")
+		if err != nil || sig.SigType != 0x20 { // packet.SigTypeKeyRevocation
+			return nil
+		}
+		sig.RIssuerKeyID = ok.strings.intern(sig.RIssuerKeyID)
+		result = append(result, &RevocationCert{
+			Signature:    sig,
+			RIssuerKeyID: sig.RIssuerKeyID,
+		})
+	}
+	return result
+}
@@ -0,0 +1,288 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// packetHeader describes the framing of an OpenPGP packet, RFC 4880 section
+// 4.2.
+type packetHeader struct {
+	tag       uint8
+	newFormat bool
+
+	// length is the length of the packet body, or of its first chunk if
+	// partial is set. It is -1 for old format packets of indeterminate length.
+	length  int64
+	partial bool
+
+	// headerLen is the number of octets in the packet header.
+	headerLen int
+}
+
+func readPacketHeader(r io.ByteReader) (*packetHeader, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if b&0x80 == 0 {
+		return nil, errgo.Newf("invalid packet tag octet %#x", b)
+	}
+	h := &packetHeader{headerLen: 1}
+	if b&0x40 != 0 {
+		h.newFormat = true
+		h.tag = b & 0x3f
+		if h.tag == 0 {
+			return nil, errgo.New("reserved packet tag 0")
+		}
+		var n int
+		h.length, h.partial, n, err = readNewLength(r)
+		h.headerLen += n
+		return h, errgo.Mask(noEOF(err), errgo.Any)
+	}
+
+	h.tag = (b & 0x3f) >> 2
+	if h.tag == 0 {
+		return nil, errgo.New("reserved packet tag 0")
+	}
+	var n int
+	switch b & 3 {
+	case 0:
+		n = 1
+	case 1:
+		n = 2
+	case 2:
+		n = 4
+	default:
+		h.length = -1
+		return h, nil
+	}
+	for i := 0; i < n; i++ {
+		b, err = r.ReadByte()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		h.length = h.length<<8 | int64(b)
+	}
+	h.headerLen += n
+	return h, nil
+}
+
+// readNewLength reads a new format packet length, returning the length, whether
+// it is a partial body length and the number of octets read.
+func readNewLength(r io.ByteReader) (int64, bool, int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, false, 0, err
+	}
+	switch {
+	case b < 192:
+		return int64(b), false, 1, nil
+	case b < 224:
+		b2, err := r.ReadByte()
+		if err != nil {
+			return 0, false, 1, noEOF(err)
+		}
+		return (int64(b)-192)<<8 + int64(b2) + 192, false, 2, nil
+	case b < 255:
+		return 1 << (b & 0x1f), true, 1, nil
+	}
+	var length int64
+	for i := 0; i < 4; i++ {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, false, 1 + i, noEOF(err)
+		}
+		length = length<<8 | int64(b)
+	}
+	return length, false, 5, nil
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// copyPacketBody copies the body of a packet whose header has just been read
+// from r to w, following any partial body chunks.
+func copyPacketBody(w io.Writer, r byteReader, h *packetHeader) error {
+	length, partial := h.length, h.partial
+	for {
+		if length < 0 {
+			_, err := io.Copy(w, r)
+			return errgo.Mask(err)
+		}
+		if _, err := io.CopyN(w, r, length); err != nil {
+			return noEOF(err)
+		}
+		if !partial {
+			return nil
+		}
+		var err error
+		length, partial, _, err = readNewLength(r)
+		if err != nil {
+			return noEOF(err)
+		}
+	}
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF, for use where the stream
+// ended in the middle of a packet.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countingReader keeps track of the number of bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func newCountingReader(r io.Reader) *countingReader {
+	return &countingReader{r: bufio.NewReader(r)}
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// offsetReader keeps track of the number of bytes read through it, like
+// countingReader, but without buffering, so that the position of an
+// underlying file remains that of the last packet read.
+type offsetReader struct {
+	r io.Reader
+	n int64
+	b [1]byte
+}
+
+func (or *offsetReader) Read(p []byte) (int, error) {
+	n, err := or.r.Read(p)
+	or.n += int64(n)
+	return n, err
+}
+
+func (or *offsetReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(or.r, or.b[:])
+	if err != nil {
+		return 0, err
+	}
+	or.n++
+	return or.b[0], nil
+}
+
+// KeyHeader summarizes a key found in a stream of key material, without the
+// contents of any of the packets following its primary public key packet.
+type KeyHeader struct {
+	// PrimaryKey is parsed from the primary public key packet alone. It has
+	// no signatures, user IDs or sub-keys, and no digest.
+	PrimaryKey *PrimaryKey
+
+	// Offset is the position of the primary public key packet in the stream.
+	Offset int64
+
+	// Length is the total length in octets of the key's packets.
+	Length int64
+
+	// Counts holds the number of packets of each tag following the primary
+	// public key packet.
+	Counts map[uint8]int
+
+	Error error
+}
+
+type KeyHeaderChan chan *KeyHeader
+
+// ScanKeyHeaders reads a stream of key material, such as a keyserver dump,
+// and sends a KeyHeader for each key found on a channel. Packet bodies other
+// than primary public keys are skipped over rather than read into memory.
+// Caller must receive all headers until the channel is closed.
+func ScanKeyHeaders(r io.Reader) KeyHeaderChan {
+	c := make(KeyHeaderChan)
+	go func() {
+		defer close(c)
+		cr := newCountingReader(r)
+		var current *KeyHeader
+		for {
+			offset := cr.n
+			h, err := readPacketHeader(cr)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				if current == nil {
+					current = &KeyHeader{Offset: offset}
+				}
+				current.Error = errgo.Mask(err, errgo.Any)
+				c <- current
+				return
+			}
+
+			if h.tag == 6 { //packet.PacketTypePublicKey
+				if current != nil {
+					c <- current
+				}
+				current = &KeyHeader{Offset: offset, Counts: make(map[uint8]int)}
+				var buf bytes.Buffer
+				err = copyPacketBody(&buf, cr, h)
+				if err == nil {
+					current.PrimaryKey, err = ParsePrimaryKey(&packet.OpaquePacket{
+						Tag: h.tag, Contents: buf.Bytes()})
+				}
+			} else {
+				if current != nil {
+					current.Counts[h.tag]++
+				}
+				err = copyPacketBody(ioutil.Discard, cr, h)
+			}
+			if current != nil {
+				current.Length = cr.n - current.Offset
+			}
+			if err != nil {
+				if current == nil {
+					current = &KeyHeader{Offset: offset}
+				}
+				current.Error = errgo.Mask(err, errgo.Any)
+				c <- current
+				return
+			}
+		}
+		if current != nil {
+			c <- current
+		}
+	}()
+	return c
+}
@@ -0,0 +1,271 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+)
+
+// SecretKeyPolicy determines what is done with secret key packets found among
+// the public key material being read.
+type SecretKeyPolicy int
+
+const (
+	// RejectSecretKeys fails any key containing a secret key or sub-key
+	// packet with an error caused by ErrSecretKeyMaterial.
+	RejectSecretKeys SecretKeyPolicy = iota
+
+	// ExtractPublicKeys replaces secret key and sub-key packets with their
+	// public counterparts, discarding the secret material.
+	ExtractPublicKeys
+
+	// AbortOnSecretKeys stops reading at the first secret key or sub-key
+	// packet, without parsing it, discards the keyring it belongs to, and
+	// sends a final result with an error caused by ErrSecretKeyMaterial.
+	// The abort applies only from the offending keyring onward: keyrings
+	// completed before it have already been sent. Callers which must reject
+	// the whole of such a submission should receive all results, and check
+	// the last, before processing any of them.
+	AbortOnSecretKeys
+)
+
+// isSecretKeyTag returns whether tag is that of a secret key or secret sub-key
+// packet.
+func isSecretKeyTag(tag uint8) bool {
+	return tag == 5 || tag == 7 //packet.PacketTypePrivateKey, packet.PacketTypePrivateSubkey
+}
+
+// SecretKeyProtection describes how the secret material of a secret key
+// packet is protected, as given by its string-to-key (S2K) usage and
+// specifier.
+type SecretKeyProtection string
+
+const (
+	// ProtectionNone is given for unencrypted secret material.
+	ProtectionNone SecretKeyProtection = "none"
+
+	// ProtectionGNUDummy is given for GnuPG stubs which carry no secret
+	// material, as exported with --export-secret-subkeys.
+	ProtectionGNUDummy SecretKeyProtection = "gnu-dummy"
+
+	// ProtectionGNUDivertToCard is given for GnuPG stubs whose secret
+	// material is held on a smartcard.
+	ProtectionGNUDivertToCard SecretKeyProtection = "gnu-divert-to-card"
+
+	// ProtectionSimple, ProtectionSalted, ProtectionIteratedSalted and
+	// ProtectionArgon2 are given for secret material encrypted with a key
+	// derived from a passphrase by the respective S2K function.
+	ProtectionSimple         SecretKeyProtection = "simple"
+	ProtectionSalted         SecretKeyProtection = "salted"
+	ProtectionIteratedSalted SecretKeyProtection = "iterated-salted"
+	ProtectionArgon2         SecretKeyProtection = "argon2"
+
+	// ProtectionLegacy is given for secret material encrypted with the
+	// MD5 digest of the passphrase, by a cipher named in place of the S2K
+	// usage.
+	ProtectionLegacy SecretKeyProtection = "legacy"
+
+	// ProtectionUnknown is given for S2K specifiers not recognized.
+	ProtectionUnknown SecretKeyProtection = "unknown"
+)
+
+// SecretKeyInfo describes the protection of a secret key packet.
+type SecretKeyInfo struct {
+	// Usage is the S2K usage octet: 0 for unencrypted material, 253 for
+	// AEAD, 254 for CFB with a SHA-1 check, 255 for CFB with a checksum, or
+	// otherwise the cipher of the legacy scheme.
+	Usage byte
+
+	// AEAD indicates that the secret material is AEAD-protected.
+	AEAD bool
+
+	Protection SecretKeyProtection
+}
+
+// publicKeyLen returns the length of the public key fields at the start of
+// public or secret key packet contents.
+func publicKeyLen(contents []byte) (int, error) {
+	if len(contents) == 0 {
+		return 0, errgo.New("empty key packet")
+	}
+	var n int
+	switch contents[0] {
+	case 2, 3:
+		// RFC 4880, section 5.5.2: time, validity days and algorithm.
+		n = 8
+	case 4:
+		n = 6
+	case 5, 6:
+		// RFC 9580, section 5.5.2: V6 keys give the length of their
+		// key material after the algorithm.
+		if len(contents) < 10 {
+			return 0, errgo.New("truncated key packet")
+		}
+		n = 10 + int(binary.BigEndian.Uint32(contents[6:10]))
+		if n > len(contents) {
+			return 0, errgo.New("truncated key packet")
+		}
+		return n, nil
+	default:
+		return 0, errgo.Mask(ErrInvalidPacketType, errgo.Any)
+	}
+	if len(contents) < n {
+		return 0, errgo.New("truncated key packet")
+	}
+	mpis := func(count int) error {
+		for i := 0; i < count; i++ {
+			if len(contents) < n+2 {
+				return errgo.New("truncated key packet")
+			}
+			n += 2 + (int(binary.BigEndian.Uint16(contents[n:]))+7)/8
+		}
+		return nil
+	}
+	field := func() error {
+		if len(contents) < n+1 {
+			return errgo.New("truncated key packet")
+		}
+		n += 1 + int(contents[n])
+		return nil
+	}
+	var err error
+	switch contents[n-1] {
+	case 1, 2, 3: // RSA
+		err = mpis(2)
+	case 16, 20: // ElGamal
+		err = mpis(3)
+	case 17: // DSA
+		err = mpis(4)
+	case 19, 22: // ECDSA, EdDSA: curve OID and point
+		if err = field(); err == nil {
+			err = mpis(1)
+		}
+	case 18: // ECDH: curve OID, point and KDF parameters
+		if err = field(); err == nil {
+			if err = mpis(1); err == nil {
+				err = field()
+			}
+		}
+	case 25, 27: // X25519, Ed25519
+		n += 32
+	case 26: // X448
+		n += 56
+	case 28: // Ed448
+		n += 57
+	default:
+		return 0, errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "public key algorithm %d", contents[n-1])
+	}
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	if n > len(contents) {
+		return 0, errgo.New("truncated key packet")
+	}
+	return n, nil
+}
+
+// InspectSecretKey describes the protection of the secret material in a
+// secret key or sub-key packet, without decrypting or otherwise parsing it.
+func InspectSecretKey(op *packet.OpaquePacket) (*SecretKeyInfo, error) {
+	if !isSecretKeyTag(op.Tag) {
+		return nil, errgo.Mask(ErrInvalidPacketType, errgo.Any)
+	}
+	n, err := publicKeyLen(op.Contents)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	v6 := op.Contents[0] >= 5
+	rest := op.Contents[n:]
+	if len(rest) == 0 {
+		return nil, errgo.New("missing S2K usage")
+	}
+	info := &SecretKeyInfo{Usage: rest[0], AEAD: rest[0] == 253}
+	rest = rest[1:]
+	switch info.Usage {
+	case 0:
+		info.Protection = ProtectionNone
+		return info, nil
+	case 253, 254, 255:
+	default:
+		info.Protection = ProtectionLegacy
+		return info, nil
+	}
+	skip := 1 // cipher
+	if v6 {
+		skip++ // count of the following fields
+	}
+	if info.AEAD {
+		skip++ // AEAD algorithm
+	}
+	if v6 {
+		skip++ // length of the S2K specifier
+	}
+	if len(rest) < skip+1 {
+		return nil, errgo.New("truncated S2K specifier")
+	}
+	spec := rest[skip:]
+	switch spec[0] {
+	case 0:
+		info.Protection = ProtectionSimple
+	case 1:
+		info.Protection = ProtectionSalted
+	case 3:
+		info.Protection = ProtectionIteratedSalted
+	case 4:
+		info.Protection = ProtectionArgon2
+	case 101:
+		// GnuPG extension: hash algorithm, "GNU" and the mode.
+		info.Protection = ProtectionUnknown
+		if len(spec) >= 6 && string(spec[2:5]) == "GNU" {
+			switch spec[5] {
+			case 1:
+				info.Protection = ProtectionGNUDummy
+			case 2:
+				info.Protection = ProtectionGNUDivertToCard
+			}
+		}
+	default:
+		info.Protection = ProtectionUnknown
+	}
+	return info, nil
+}
+
+// publicKeyPacket returns the public key or sub-key packet corresponding to a
+// secret key or sub-key packet. The public key fields are taken as they are,
+// so that stubs and secret material protected by any S2K function are
+// handled alike, and the secret material is never parsed.
+func publicKeyPacket(op *packet.OpaquePacket) (*packet.OpaquePacket, error) {
+	if !isSecretKeyTag(op.Tag) {
+		return nil, errgo.Mask(ErrInvalidPacketType, errgo.Any)
+	}
+	n, err := publicKeyLen(op.Contents)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	tag := uint8(6)  //packet.PacketTypePublicKey
+	if op.Tag == 7 { //packet.PacketTypePrivateSubkey
+		tag = 14 //packet.PacketTypePublicSubKey
+	}
+	return &packet.OpaquePacket{
+		Tag:      tag,
+		Contents: append([]byte(nil), op.Contents[:n]...),
+	}, nil
+}
@@ -19,11 +19,66 @@ package openpgp
 
 import (
 	"sort"
+	"strings"
 	"time"
+
+	"gopkg.in/errgo.v1"
 )
 
 var now = time.Now
 
+// SetClock sets the source of the current time, against which the validity
+// and expiration of keys and signatures are evaluated, or restores time.Now
+// if fn is nil. Like SetMetrics, it should be called during initialization or
+// in tests, before keys are checked. To evaluate a single key at a given
+// time, use the *At methods of SelfSigs instead.
+func SetClock(fn func() time.Time) {
+	if fn == nil {
+		fn = time.Now
+	}
+	now = fn
+}
+
+// SelfSigOption configures how self-signatures are found and checked by the
+// SelfSigs methods of keys, user IDs and user attributes.
+type SelfSigOption func(*selfSigOptions)
+
+type selfSigOptions struct {
+	trialVerifyWildcards bool
+}
+
+func newSelfSigOptions(opts []SelfSigOption) *selfSigOptions {
+	so := &selfSigOptions{}
+	for _, opt := range opts {
+		opt(so)
+	}
+	return so
+}
+
+// TrialVerifyWildcards verifies signatures which do not identify their issuer
+// on trial as self-signatures by the key they are found in. Those which
+// verify are then treated as self-signatures; those which do not are ignored,
+// rather than reported among the errors, as they may well have been made by
+// another key. Without this option, such signatures are not self-signatures.
+func TrialVerifyWildcards() SelfSigOption {
+	return func(so *selfSigOptions) {
+		so.trialVerifyWildcards = true
+	}
+}
+
+// selfSigCandidate returns whether sig may be a self-signature by pubkey, and
+// whether it is a wildcard signature, to be verified on trial.
+func (pubkey *PrimaryKey) selfSigCandidate(sig *Signature, so *selfSigOptions) (candidate, trial bool) {
+	if sig.IsTimestamp() {
+		// Timestamps are not made over the target.
+		return false, false
+	}
+	if sig.IsWildcardIssuer() {
+		return so.trialVerifyWildcards, true
+	}
+	return strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID), false
+}
+
 // CheckSig represents the result of checking a self-signature.
 type CheckSig struct {
 	PrimaryKey *PrimaryKey
@@ -31,6 +86,23 @@ type CheckSig struct {
 	Error      error
 }
 
+// newCheckSig returns the result of checking a self-signature, given the
+// verification error. Failures have ErrBadSelfSignature as their cause.
+func newCheckSig(pubkey *PrimaryKey, sig *Signature, err error) *CheckSig {
+	if err != nil {
+		err = errgo.WithCausef(err, ErrBadSelfSignature, "")
+		eventLogger.LogEvent(&Event{
+			Kind:         EventBadSelfSignature,
+			RFingerprint: pubkey.RFingerprint,
+			Tag:          sig.Tag,
+			Digest:       packetDigest(sig.Packet.Packet),
+			Message:      "self-signature verification failed",
+			Err:          err,
+		})
+	}
+	return &CheckSig{PrimaryKey: pubkey, Signature: sig, Error: err}
+}
+
 // SelfSigs holds self-signatures on OpenPGP targets, which may be keys, user
 // IDs, or user attributes.
 type SelfSigs struct {
@@ -40,6 +112,15 @@ type SelfSigs struct {
 	Primaries      []*CheckSig
 	Errors         []*CheckSig
 
+	// DirectKeys and Standalones hold the direct-key (0x1F) and standalone
+	// (0x02) self-signatures on a primary key, most recent first.
+	DirectKeys  []*CheckSig
+	Standalones []*CheckSig
+
+	// Attestations holds the attestation key signatures (0x16) on a user ID
+	// or user attribute, most recent first.
+	Attestations []*CheckSig
+
 	target packetNode
 }
 
@@ -92,6 +173,9 @@ func (s *SelfSigs) resolve() {
 	sort.Sort(checkSigCreationDesc(s.Certifications))
 	sort.Sort(checkSigExpirationDesc(s.Expirations))
 	sort.Sort(checkSigCreationDesc(s.Primaries))
+	sort.Sort(checkSigCreationDesc(s.DirectKeys))
+	sort.Sort(checkSigCreationDesc(s.Standalones))
+	sort.Sort(checkSigCreationDesc(s.Attestations))
 }
 
 var zeroTime time.Time
@@ -103,6 +187,35 @@ func (s *SelfSigs) RevokedSince() (time.Time, bool) {
 	return zeroTime, false
 }
 
+// CertificationClass returns the type of the most recent valid
+// self-certification of a user ID or user attribute, or false if there is
+// none.
+func (s *SelfSigs) CertificationClass() (CertificationClass, bool) {
+	if len(s.Certifications) == 0 {
+		return 0, false
+	}
+	return s.Certifications[0].Signature.CertificationClass()
+}
+
+// RevocationKeys returns the keys designated to revoke a primary key by its
+// direct-key self-signatures. Designations accumulate: a later direct-key
+// signature without a designation does not withdraw an earlier one, as a
+// designated revoker is meant to remain able to act even if the key holder
+// has lost control of the key.
+func (s *SelfSigs) RevocationKeys() []*RevocationKey {
+	var result []*RevocationKey
+	seen := make(map[string]bool)
+	for _, checkSig := range s.DirectKeys {
+		for _, rk := range checkSig.Signature.RevocationKeys() {
+			if !seen[rk.RFingerprint] {
+				seen[rk.RFingerprint] = true
+				result = append(result, rk)
+			}
+		}
+	}
+	return result
+}
+
 func (s *SelfSigs) ExpiresAt() (time.Time, bool) {
 	if len(s.Expirations) > 0 {
 		return s.Expirations[0].Signature.Expiration, true
@@ -111,17 +224,29 @@ func (s *SelfSigs) ExpiresAt() (time.Time, bool) {
 }
 
 func (s *SelfSigs) Valid() bool {
+	return s.ValidAt(now())
+}
+
+// ValidAt returns whether the target was valid at time t: it has no
+// revocations, had not expired, and had a non-expired self-signature.
+func (s *SelfSigs) ValidAt(t time.Time) bool {
 	revoked := len(s.Revocations) > 0
 	expiration, okExpiration := s.ExpiresAt()
-	_, okValid := s.ValidSince()
+	_, okValid := s.ValidSinceAt(t)
 	return (!revoked && // target has no revocations
 		// target does not expire or hasn't expired yet
-		(!okExpiration || expiration.Unix() > now().Unix()) &&
+		(!okExpiration || expiration.Unix() > t.Unix()) &&
 		// target has non-expired self-signatures
 		okValid)
 }
 
 func (s *SelfSigs) ValidSince() (time.Time, bool) {
+	return s.ValidSinceAt(now())
+}
+
+// ValidSinceAt is like ValidSince, but considers self-signatures which had not
+// expired at time t.
+func (s *SelfSigs) ValidSinceAt(t time.Time) (time.Time, bool) {
 	if len(s.Revocations) > 0 {
 		return zeroTime, false
 	}
@@ -131,7 +256,7 @@ func (s *SelfSigs) ValidSince() (time.Time, bool) {
 	for _, checkSig := range s.Certifications {
 		// Return the first non-expired self-signature creation time.
 		expiresAt := checkSig.Signature.Expiration
-		if expiresAt.IsZero() || expiresAt.Unix() > now().Unix() {
+		if expiresAt.IsZero() || expiresAt.Unix() > t.Unix() {
 			return checkSig.Signature.Creation, true
 		}
 	}
@@ -139,12 +264,18 @@ func (s *SelfSigs) ValidSince() (time.Time, bool) {
 }
 
 func (s *SelfSigs) PrimarySince() (time.Time, bool) {
+	return s.PrimarySinceAt(now())
+}
+
+// PrimarySinceAt is like PrimarySince, but considers primary user ID
+// self-signatures which had not expired at time t.
+func (s *SelfSigs) PrimarySinceAt(t time.Time) (time.Time, bool) {
 	if len(s.Revocations) > 0 {
 		return zeroTime, false
 	}
 	for _, checkSig := range s.Primaries {
 		expiresAt := checkSig.Signature.Expiration
-		if expiresAt.IsZero() || expiresAt.Unix() > now().Unix() {
+		if expiresAt.IsZero() || expiresAt.Unix() > t.Unix() {
 			return checkSig.Signature.Creation, true
 		}
 	}
@@ -0,0 +1,157 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"io"
+)
+
+// ServePolicy sets which user IDs and user attributes of a key are served. A
+// nil policy serves all of them.
+type ServePolicy struct {
+	// RequireValidSelfSigs serves user IDs and user attributes only if they
+	// have at least one valid self-certification which has not expired, or
+	// a valid revocation. Those which do not are still retained in the key,
+	// and so count towards its digest for reconciliation with other
+	// servers, but are omitted from the responses written by
+	// WriteGetResponse and WriteServedPackets and from the keywords of
+	// storage documents.
+	RequireValidSelfSigs bool
+}
+
+func (p *ServePolicy) requireValidSelfSigs() bool {
+	return p != nil && p.RequireValidSelfSigs
+}
+
+// hasValidSelfCert returns whether the self-signatures include a
+// certification which has not expired, or a revocation. Revoked user IDs are
+// served, so that their revocations propagate.
+func hasValidSelfCert(ss *SelfSigs) bool {
+	if len(ss.Revocations) > 0 {
+		return true
+	}
+	t := now()
+	for _, checkSig := range ss.Certifications {
+		expiresAt := checkSig.Signature.Expiration
+		if expiresAt.IsZero() || expiresAt.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServedUserIDs returns the user IDs of the key which are served by the
+// policy.
+func (pubkey *PrimaryKey) ServedUserIDs(policy *ServePolicy) []*UserID {
+	if !policy.requireValidSelfSigs() {
+		return pubkey.UserIDs
+	}
+	var result []*UserID
+	for _, uid := range pubkey.UserIDs {
+		if hasValidSelfCert(uid.SelfSigs(pubkey)) {
+			result = append(result, uid)
+		}
+	}
+	return result
+}
+
+// ServedUserAttributes returns the user attributes of the key which are
+// served, as ServedUserIDs.
+func (pubkey *PrimaryKey) ServedUserAttributes(policy *ServePolicy) []*UserAttribute {
+	if !policy.requireValidSelfSigs() {
+		return pubkey.UserAttributes
+	}
+	var result []*UserAttribute
+	for _, uat := range pubkey.UserAttributes {
+		if hasValidSelfCert(uat.SelfSigs(pubkey)) {
+			result = append(result, uat)
+		}
+	}
+	return result
+}
+
+// servedContents is like contents, but includes only the served user IDs and
+// user attributes.
+func (pubkey *PrimaryKey) servedContents(policy *ServePolicy) []packetNode {
+	if !policy.requireValidSelfSigs() {
+		return pubkey.contents()
+	}
+	result := []packetNode{pubkey}
+	for _, sig := range pubkey.Signatures {
+		result = append(result, sig.contents()...)
+	}
+	for _, uid := range pubkey.ServedUserIDs(policy) {
+		result = append(result, uid.contents()...)
+	}
+	for _, uat := range pubkey.ServedUserAttributes(policy) {
+		result = append(result, uat.contents()...)
+	}
+	for _, subkey := range pubkey.SubKeys {
+		result = append(result, subkey.contents()...)
+	}
+	for _, other := range pubkey.Others {
+		result = append(result, other.contents()...)
+	}
+	return result
+}
+
+// WriteServedPackets is like WritePackets, but writes only the user IDs and
+// user attributes of the key served by the policy, for export.
+func WriteServedPackets(w io.Writer, key *PrimaryKey, policy *ServePolicy) error {
+	return writeNodes(w, key.servedContents(policy))
+}
+
+// minimalContents is like servedContents, but includes only self-signatures,
+// and omits revoked sub-keys and packets which are not key material.
+func (pubkey *PrimaryKey) minimalContents(policy *ServePolicy) []packetNode {
+	result := []packetNode{pubkey}
+	selfSigs := func(sigs []*Signature) {
+		for _, sig := range sigs {
+			if pubkey.isSelfSig(sig) {
+				result = append(result, sig)
+			}
+		}
+	}
+	selfSigs(pubkey.Signatures)
+	for _, uid := range pubkey.ServedUserIDs(policy) {
+		result = append(result, uid)
+		selfSigs(uid.Signatures)
+	}
+	for _, uat := range pubkey.ServedUserAttributes(policy) {
+		result = append(result, uat)
+		selfSigs(uat.Signatures)
+	}
+	for _, subkey := range pubkey.SubKeys {
+		if subkey.Status(pubkey) == SubKeyRevoked {
+			continue
+		}
+		result = append(result, subkey)
+		selfSigs(subkey.Signatures)
+	}
+	return result
+}
+
+// WriteMinimalPackets writes a minimal export of the key, for clients which
+// only need to encrypt to or verify it: its served user IDs and user
+// attributes with their self-signatures alone, and its sub-keys which have
+// not been revoked. The key itself retains its revoked sub-keys and their
+// revocations, which WritePackets and WriteServedPackets write, so that the
+// revocations propagate.
+func WriteMinimalPackets(w io.Writer, key *PrimaryKey, policy *ServePolicy) error {
+	return writeNodes(w, key.minimalContents(policy))
+}
@@ -0,0 +1,108 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"io"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SharedKey is a handle on a key shared among goroutines. Keys are modified
+// in place by Merge, Sort and DropDuplicates, and their signatures are
+// expanded lazily, so a *PrimaryKey must not be used concurrently without
+// synchronization; SharedKey serializes modifications and allows concurrent
+// reads between them.
+type SharedKey struct {
+	mu  sync.RWMutex
+	key *PrimaryKey
+}
+
+// NewSharedKey returns a handle on key, which must not be used other than
+// through the handle afterwards. All of its signatures are expanded, so that
+// reading them does not modify the key.
+func NewSharedKey(key *PrimaryKey) (*SharedKey, error) {
+	err := expandSignatures(key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &SharedKey{key: key}, nil
+}
+
+func expandSignatures(key *PrimaryKey) error {
+	for _, node := range key.contents() {
+		if sig, ok := node.(*Signature); ok {
+			err := sig.Expand()
+			if err != nil {
+				return errgo.Notef(err, "signature %s", sig.UUID)
+			}
+		}
+	}
+	return nil
+}
+
+// RFingerprint returns the reversed fingerprint of the key, which does not
+// change.
+func (k *SharedKey) RFingerprint() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key.RFingerprint
+}
+
+// Digests returns the MD5 and SHA256 digests of the key.
+func (k *SharedKey) Digests() (string, string) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.key.MD5, k.key.SHA256
+}
+
+// Read calls fn with the key, which fn must not modify or retain. Other
+// readers may run concurrently, but not writers.
+func (k *SharedKey) Read(fn func(*PrimaryKey) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return fn(k.key)
+}
+
+// Update calls fn with the key, which fn may modify but not retain. No other
+// readers or writers run concurrently.
+func (k *SharedKey) Update(fn func(*PrimaryKey) error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	err := fn(k.key)
+	if err != nil {
+		return err
+	}
+	return expandSignatures(k.key)
+}
+
+// Merge merges src into the key, as the package-level Merge. src must not be
+// used afterwards.
+func (k *SharedKey) Merge(src *PrimaryKey) error {
+	return k.Update(func(key *PrimaryKey) error {
+		return errgo.Mask(Merge(key, src))
+	})
+}
+
+// WritePackets writes the packets of the key to w.
+func (k *SharedKey) WritePackets(w io.Writer) error {
+	return k.Read(func(key *PrimaryKey) error {
+		return errgo.Mask(WritePackets(w, key))
+	})
+}
@@ -18,23 +18,42 @@
 package openpgp
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
 	"gopkg.in/errgo.v1"
 )
 
 type Signature struct {
 	Packet
 
+	// ParentUUID is the UUID of the packet the signature belongs to: the
+	// primary key, a user ID, user attribute or subkey.
+	ParentUUID string
+
 	SigType      int
 	RIssuerKeyID string
 	Creation     time.Time
-	Expiration   time.Time
-	Primary      bool
+
+	// Expiration and Primary are not populated until Expand is called on
+	// signatures read with ReadKeys or OpaqueKeyring.Parse.
+	Expiration time.Time
+	Primary    bool
+
+	// lazy is set when only SigType, RIssuerKeyID and Creation have been
+	// extracted from the packet, and guards the completion of the parse.
+	lazy *lazySignature
+}
+
+// lazySignature records the expansion of a partially parsed signature.
+type lazySignature struct {
+	once sync.Once
+	err  error
 }
 
 const sigTag = "{sig}"
@@ -75,22 +94,36 @@ func (ss sigSlice) without(target *Signature) []*Signature {
 }
 
 func ParseSignature(op *packet.OpaquePacket, pubkeyUUID, scopedUUID string) (*Signature, error) {
-	var buf bytes.Buffer
-	var err error
+	return parseSignature(op, pubkeyUUID, scopedUUID, nil, false)
+}
 
-	if err = op.Serialize(&buf); err != nil {
+// parseSignature parses a signature packet. If lazy is set, only the fields
+// needed for deduplication and sorting are extracted, and the remainder is
+// deferred until the signature is expanded.
+func parseSignature(op *packet.OpaquePacket, pubkeyUUID, scopedUUID string, arena *packetArena, lazy bool) (*Signature, error) {
+	buf, err := arena.serialize(op)
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	sig := &Signature{
 		Packet: Packet{
-			UUID:   scopedDigest([]string{pubkeyUUID, scopedUUID}, sigTag, buf.Bytes()),
+			UUID:   scopedDigest([]string{pubkeyUUID, scopedUUID}, sigTag, buf),
 			Tag:    op.Tag,
-			Packet: buf.Bytes(),
+			Packet: buf,
+			shared: arena != nil,
 		},
+		ParentUUID: scopedUUID,
 	}
 
-	// Attempt to parse the opaque packet into a public key type.
-	err = sig.parse(op)
+	if lazy {
+		err = sig.scan(op.Contents)
+		if err == nil && hasSubpacketAreas(op.Contents) {
+			sig.lazy = &lazySignature{}
+		}
+	} else {
+		// Attempt to parse the opaque packet into a signature type.
+		err = sig.parse(op)
+	}
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -98,15 +131,685 @@ func ParseSignature(op *packet.OpaquePacket, pubkeyUUID, scopedUUID string) (*Si
 	return sig, nil
 }
 
-func (sig *Signature) parse(op *packet.OpaquePacket) error {
-	p, err := op.Parse()
+// CertificationClass is the type of a user ID or user attribute
+// certification, which states how carefully the issuer checked that the
+// identity belongs to the key holder. Its values are the signature types of
+// the certifications.
+type CertificationClass int
+
+const (
+	// CertGeneric makes no particular assertion about the identity.
+	CertGeneric CertificationClass = 0x10
+	// CertPersona asserts that no verification of the identity was done.
+	CertPersona CertificationClass = 0x11
+	// CertCasual asserts that some casual verification was done.
+	CertCasual CertificationClass = 0x12
+	// CertPositive asserts that substantial verification was done.
+	CertPositive CertificationClass = 0x13
+)
+
+var certificationClassStrings = []string{
+	"generic",
+	"persona",
+	"casual",
+	"positive",
+}
+
+func (c CertificationClass) String() string {
+	if c >= CertGeneric && c <= CertPositive {
+		return certificationClassStrings[c-CertGeneric]
+	}
+	return "unknown"
+}
+
+// CertificationClass returns the type of certification made by the signature,
+// or false if it is not a certification.
+func (sig *Signature) CertificationClass() (CertificationClass, bool) {
+	class := CertificationClass(sig.SigType)
+	if class < CertGeneric || class > CertPositive {
+		return 0, false
+	}
+	return class, true
+}
+
+// IsWildcardIssuer returns whether the signature does not identify its
+// issuer, either naming no issuer at all or the wildcard key ID of all zeros.
+// Such signatures are not considered self-signatures unless they are verified
+// on trial, with the TrialVerifyWildcards option.
+func (sig *Signature) IsWildcardIssuer() bool {
+	return strings.Trim(sig.RIssuerKeyID, "0") == ""
+}
+
+// IsTimestamp returns whether the signature is a timestamp signature (type
+// 0x40), such as those attached to keys by notarization services. Timestamp
+// signatures attest only to the time of signing, and are neither
+// certifications nor self-signatures.
+func (sig *Signature) IsTimestamp() bool {
+	return sig.SigType == 0x40 // packet.SigTypeTimestamp
+}
+
+// RevocationKey is a key designated, by a revocation key subpacket, as
+// authorized to revoke the key which made the signature.
+type RevocationKey struct {
+	// Class is the class octet of the designation, in which 0x40 marks it as
+	// sensitive.
+	Class        byte
+	Algorithm    int
+	RFingerprint string
+}
+
+// RevocationKeys returns the revocation key designations in the hashed
+// subpackets of the signature.
+func (sig *Signature) RevocationKeys() []*RevocationKey {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil
+	}
+	var result []*RevocationKey
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ == 12 && len(data) == 22 { // revocation key
+			result = append(result, &RevocationKey{
+				Class:        data[0],
+				Algorithm:    int(data[1]),
+				RFingerprint: ReverseHex(data[2:]),
+			})
+		}
+	})
+	return result
+}
+
+// TrustSignature returns the level and amount of the trust signature
+// subpacket in the hashed area of the signature, if there is one. A
+// certification of level n > 0 designates the key certified as a trusted
+// introducer, whose own certifications of level below n are trusted in turn.
+func (sig *Signature) TrustSignature() (level, amount int, ok bool) {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return 0, 0, false
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return 0, 0, false
+	}
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ == 5 && len(data) == 2 { // trust signature
+			level, amount, ok = int(data[0]), int(data[1]), true
+		}
+	})
+	return level, amount, ok
+}
+
+// KeyLifetime returns the key expiration time subpacket in the hashed area of
+// the signature, if there is one: the validity period of the key the
+// signature binds, from the creation of the key. A lifetime of zero means
+// the key does not expire.
+func (sig *Signature) KeyLifetime() (time.Duration, bool) {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return 0, false
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return 0, false
+	}
+	var lifetime time.Duration
+	var ok bool
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ == 9 && len(data) == 4 { // key expiration time
+			lifetime, ok = time.Duration(binary.BigEndian.Uint32(data))*time.Second, true
+		}
+	})
+	return lifetime, ok
+}
+
+// TrustRegexps returns the regular expressions of the hashed subpackets of the
+// signature, which limit a trust signature to the user IDs they match.
+func (sig *Signature) TrustRegexps() []string {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil
+	}
+	var result []string
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ == 6 { // regular expression
+			result = append(result, strings.TrimRight(string(data), "\x00"))
+		}
+	})
+	return result
+}
+
+// Notation is a notation data subpacket of a signature: a name, such as
+// "name@example.com", and a value, which is text if HumanReadable is set.
+type Notation struct {
+	Name          string
+	Value         []byte
+	HumanReadable bool
+}
+
+// Notations returns the notations in the hashed subpackets of the signature.
+// Notations in the unhashed area are not covered by the signature, and are
+// ignored.
+func (sig *Signature) Notations() []*Notation {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return nil
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return nil
+	}
+	var result []*Notation
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		if typ != 20 || len(data) < 8 { // notation data
+			return
+		}
+		nameLen := int(binary.BigEndian.Uint16(data[4:6]))
+		valueLen := int(binary.BigEndian.Uint16(data[6:8]))
+		if len(data) != 8+nameLen+valueLen {
+			return
+		}
+		result = append(result, &Notation{
+			Name:          string(data[8 : 8+nameLen]),
+			Value:         append([]byte(nil), data[8+nameLen:]...),
+			HumanReadable: data[0]&0x80 != 0,
+		})
+	})
+	return result
+}
+
+// ScopedID returns an identifier for the signature composed of the UUID of the
+// packet it belongs to and the hex-encoded SHA-256 digest of the signature
+// packet. The same certification appearing under two user IDs has a distinct
+// ScopedID under each. The UUIDs of user IDs and user attributes depend on the
+// UUID scheme, so the ScopedID of a signature on one changes when the key's
+// UUIDs are migrated to another scheme.
+func (sig *Signature) ScopedID() string {
+	return sig.ParentUUID + "/" + packetDigest(sig.Packet.Packet)
+}
+
+// Expand completes the parse of a signature which was only partially read
+// along with its keyring. It is a no-op on fully parsed signatures.
+//
+// Accessors which read Expiration or Primary, such as SelfSigs, call Expand,
+// and so modify the signatures of a key as it is read. Expand is safe to call
+// concurrently: the parse is completed once, and the fields already extracted
+// during the partial read are left as they are.
+func (sig *Signature) Expand() error {
+	l := sig.lazy
+	if l == nil {
+		return nil
+	}
+	l.once.Do(func() {
+		l.err = sig.expand()
+	})
+	return l.err
+}
+
+func (sig *Signature) expand() error {
+	op, err := sig.opaquePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	full := &Signature{Packet: sig.Packet}
+	err = full.parse(op)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	sig.Expiration = full.Expiration
+	sig.Primary = full.Primary
+	return nil
+}
+
+// scan extracts the signature type, issuer key ID and creation time directly
+// from the signature packet contents, without a full parse. It rejects just
+// the signatures that parse rejects, so that a malformed signature is set
+// aside however it is read.
+func (sig *Signature) scan(contents []byte) error {
+	if len(contents) == 0 {
+		return errgo.New("empty signature packet")
+	}
+	err := checkNesting(contents, 0)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	switch contents[0] {
+	case 2, 3:
+		// RFC 4880, section 5.2.2: V3 signatures have a fixed layout and
+		// carry nothing more that we extract.
+		err := checkSignatureV3(contents)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		sig.SigType = int(contents[2])
+		sig.Creation = time.Unix(int64(binary.BigEndian.Uint32(contents[3:7])), 0)
+		sig.RIssuerKeyID = ReverseHex(contents[7:15])
+		return nil
+	case 4:
+		err := checkSignatureV4(contents, true)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	case 6:
+		// RFC 9580, section 5.2.3: V6 signatures are salted. The packet
+		// library cannot parse them, so parse scans them too.
+		if _, err := v6Salt(contents); err != nil {
+			return errgo.Mask(err)
+		}
+	default:
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "signature packet version %d", contents[0])
+	}
+
+	// RFC 4880, section 5.2.3; RFC 9580, section 5.2.3
+	areas, err := subpacketAreas(contents)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	sig.SigType = int(contents[1])
+
+	var haveCreation bool
+	var fpIssuer string
+	for i, area := range areas {
+		err := forEachSubpacket(area, func(typ byte, data []byte) {
+			switch typ {
+			case 2: // creation time, which must be hashed
+				if i == 0 && len(data) == 4 {
+					sig.Creation = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+					haveCreation = true
+				}
+			case 16: // issuer
+				if len(data) == 8 {
+					sig.RIssuerKeyID = ReverseHex(data)
+				}
+			case 33: // issuer fingerprint
+				if rkeyid := fingerprintIssuer(data); rkeyid != "" && fpIssuer == "" {
+					fpIssuer = rkeyid
+				}
+			}
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if !haveCreation {
+		return errgo.New("missing signature creation time")
+	}
+	if sig.RIssuerKeyID == "" {
+		// Signatures may identify their issuer only by fingerprint, or
+		// not at all; see IsWildcardIssuer.
+		sig.RIssuerKeyID = fpIssuer
+	}
+	return nil
+}
+
+// scanV6 completes the scan of a V6 signature with the expiration and primary
+// user ID indicator given by its hashed subpackets, as setSignature does for
+// the V4 signatures parsed by the packet library.
+func (sig *Signature) scanV6(contents []byte) {
+	areas, err := subpacketAreas(contents)
+	if err != nil {
+		return
+	}
+	var sigLifetime, keyLifetime []byte
+	forEachSubpacket(areas[0], func(typ byte, data []byte) {
+		switch {
+		case typ == 3 && len(data) == 4: // signature expiration time
+			sigLifetime = data
+		case typ == 9 && len(data) == 4: // key expiration time
+			keyLifetime = data
+		case typ == 25 && len(data) == 1: // primary user ID
+			sig.Primary = data[0] > 0
+		}
+	})
+	if sigLifetime == nil {
+		sigLifetime = keyLifetime
+	}
+	if sigLifetime != nil {
+		sig.Expiration = sig.Creation.Add(time.Duration(binary.BigEndian.Uint32(sigLifetime)) * time.Second)
+	}
+}
+
+// checkSignatureV3 returns an error if the packet library would not parse V3
+// signature packet contents.
+func checkSignatureV3(contents []byte) error {
+	// RFC 4880, section 5.2.2: the version, the length of the hashed
+	// material, which is always 5, the signature type and creation time,
+	// the issuer key ID, the algorithms and the hash prefix.
+	if len(contents) < 19 {
+		return errgo.New("malformed V3 signature packet")
+	}
+	if contents[1] != 5 {
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "invalid hashed material length %d", contents[1])
+	}
+	switch contents[15] {
+	case 1, 3, 17: // RSA, RSA sign-only, DSA
+	default:
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "public key algorithm %d", contents[15])
+	}
+	if _, ok := s2k.HashIdToHash(contents[16]); !ok {
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "hash function %d", contents[16])
+	}
+	return checkSignatureMPIs(contents[19:], contents[15])
+}
+
+// checkSignatureV4 returns an error if the packet library would not parse V4
+// signature packet contents, or if lenient is set, parseSignaturePacket
+// would not: critical subpackets of the types in lenientCritical are then
+// accepted in the hashed area.
+func checkSignatureV4(contents []byte, lenient bool) error {
+	if len(contents) < 6 || contents[0] != 4 {
+		return errgo.New("malformed signature packet")
+	}
+	switch contents[2] {
+	case 1, 3, 17, 19: // RSA, RSA sign-only, DSA, ECDSA
+	default:
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "public key algorithm %d", contents[2])
+	}
+	if _, ok := s2k.HashIdToHash(contents[3]); !ok {
+		return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "hash function %d", contents[3])
+	}
+	areas, rest, err := splitSignature(contents)
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	var haveCreation, haveEmbedded bool
+	for i, area := range areas {
+		hashed := i == 0
+		for len(area) > 0 {
+			n, hdr, err := subpacketLen(area)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			typ, data := area[hdr]&0x7f, area[hdr+1:hdr+n]
+			critical := area[hdr]&0x80 != 0 && !(lenient && hashed && lenientCritical[typ])
+			area = area[hdr+n:]
+
+			// Only the subpackets the packet library parses are
+			// checked, and those in the unhashed area are mostly
+			// ignored.
+			var malformed bool
+			switch typ {
+			case 2: // creation time
+				malformed = !hashed || len(data) != 4
+				haveCreation = true
+			case 3, 9: // signature and key expiration times
+				malformed = hashed && len(data) != 4
+			case 16: // issuer
+				malformed = len(data) != 8
+			case 25: // primary user ID
+				malformed = hashed && len(data) != 1
+			case 27, 29: // key flags, reason for revocation
+				malformed = hashed && len(data) == 0
+			case 11, 21, 22, 30: // preferences and features
+			case 32: // embedded signature
+				if haveEmbedded {
+					return errgo.New("multiple embedded signatures")
+				}
+				haveEmbedded = true
+				err := checkSignatureV4(data, false)
+				if err != nil {
+					return errgo.Mask(err, errgo.Any)
+				}
+				if data[1] != 0x19 { // packet.SigTypePrimaryKeyBinding
+					return errgo.Newf("embedded signature has unexpected type 0x%02x", data[1])
+				}
+			default:
+				if critical {
+					return errgo.WithCausef(nil, ErrUnsupportedAlgorithm, "unknown critical signature subpacket type %d", typ)
+				}
+			}
+			if malformed {
+				return errgo.Newf("malformed signature subpacket of type %d", typ)
+			}
+		}
+		if !haveCreation {
+			return errgo.New("missing signature creation time")
+		}
+	}
+	if len(rest) < 2 {
+		return errgo.New("malformed signature packet")
+	}
+	return checkSignatureMPIs(rest[2:], contents[2])
+}
+
+// checkSignatureMPIs returns an error unless data starts with the MPIs of a
+// signature made with the given public key algorithm.
+func checkSignatureMPIs(data []byte, algo byte) error {
+	n := 2
+	if algo == 1 || algo == 3 { // RSA
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		if len(data) < 2 {
+			return errgo.New("truncated signature MPI")
+		}
+		l := 2 + (int(binary.BigEndian.Uint16(data))+7)/8
+		if len(data) < l {
+			return errgo.New("truncated signature MPI")
+		}
+		data = data[l:]
+	}
+	return nil
+}
+
+// fingerprintIssuer returns the reversed key ID of the issuer named in the
+// data of an issuer fingerprint subpacket, or "" if it cannot be determined.
+func fingerprintIssuer(data []byte) string {
+	switch {
+	case len(data) == 21 && data[0] == 4:
+		// V4 key IDs are the low 64 bits of the fingerprint.
+		return ReverseHex(data[13:])
+	case len(data) == 33 && (data[0] == 5 || data[0] == 6):
+		// V5 and V6 key IDs are the high 64 bits.
+		return ReverseHex(data[1:9])
+	}
+	return ""
+}
+
+// subpacketFingerprintIssuer returns the reversed key ID of the issuer named by
+// the first issuer fingerprint subpacket of signature packet contents, if
+// any.
+func subpacketFingerprintIssuer(contents []byte) string {
+	areas, err := subpacketAreas(contents)
+	if err != nil {
+		return ""
+	}
+	var result string
+	for _, area := range areas {
+		forEachSubpacket(area, func(typ byte, data []byte) {
+			if typ == 33 && result == "" {
+				result = fingerprintIssuer(data)
+			}
+		})
+	}
+	return result
+}
+
+// hasSubpacketAreas returns whether signature packet contents are of a
+// version carrying subpacket areas: V4, or V6.
+func hasSubpacketAreas(contents []byte) bool {
+	return len(contents) > 0 && (contents[0] == 4 || contents[0] == 6)
+}
+
+// subpacketAreas returns the hashed and unhashed subpacket areas of V4 or V6
+// signature packet contents.
+func subpacketAreas(contents []byte) ([2][]byte, error) {
+	areas, _, err := splitSignature(contents)
+	return areas, err
+}
+
+// splitSignature returns the hashed and unhashed subpacket areas of V4 or V6
+// signature packet contents, and the remainder which follows them. V6
+// signatures have four-octet area lengths rather than two.
+func splitSignature(contents []byte) ([2][]byte, []byte, error) {
+	var areas [2][]byte
+	lenSize := 2
+	if len(contents) > 0 && contents[0] == 6 {
+		lenSize = 4
+	}
+	if len(contents) < 4+2*lenSize {
+		return areas, nil, errgo.New("malformed signature packet")
+	}
+	rest := contents[4:]
+	for i := range areas {
+		if len(rest) < lenSize {
+			return areas, nil, errgo.New("malformed signature packet")
+		}
+		var n uint32
+		if lenSize == 4 {
+			n = binary.BigEndian.Uint32(rest)
+		} else {
+			n = uint32(binary.BigEndian.Uint16(rest))
+		}
+		rest = rest[lenSize:]
+		if uint32(len(rest)) < n {
+			return areas, nil, errgo.New("malformed signature subpacket area")
+		}
+		areas[i], rest = rest[:n], rest[n:]
+	}
+	return areas, rest, nil
+}
+
+// v6Salt returns the salt of V6 signature packet contents, found after the
+// subpacket areas and the two-octet hash prefix.
+func v6Salt(contents []byte) ([]byte, error) {
+	_, rest, err := splitSignature(contents)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(rest) < 3 || len(rest) < 3+int(rest[2]) {
+		return nil, errgo.New("malformed V6 signature salt")
+	}
+	return rest[3 : 3+int(rest[2])], nil
+}
+
+// Salt returns the random salt of a V6 signature, which is hashed ahead of the
+// signed data so that no two V6 signatures are alike, or nil for signatures of
+// other versions. The salt is part of the packet, and so of its UUID and of
+// key digests: two V6 signatures which differ only in their salts are
+// distinct, and are not duplicates of one another.
+func (sig *Signature) Salt() []byte {
+	op, err := sig.opaquePacket()
+	if err != nil || len(op.Contents) == 0 || op.Contents[0] != 6 {
+		return nil
+	}
+	salt, err := v6Salt(op.Contents)
+	if err != nil {
+		return nil
+	}
+	return salt
+}
+
+// MaxNestingDepth is the deepest that signatures may be embedded within one
+// another. The packet library parses embedded signatures recursively, so
+// signatures nested more deeply are rejected, with an error caused by
+// ErrNestingTooDeep, before they are parsed.
+//
+// Embedded signatures are the only nested structure parsed from keyrings:
+// user attribute subpackets do not nest, and compressed packets are not key
+// material and are never decompressed.
+var MaxNestingDepth = 4
+
+// checkNesting checks that the signature packet contents, found at the given
+// depth of embedding, embed no signatures deeper than MaxNestingDepth.
+// Malformed contents are left for the packet library to report.
+func checkNesting(contents []byte, depth int) error {
+	if depth > MaxNestingDepth {
+		return errgo.WithCausef(nil, ErrNestingTooDeep,
+			"signatures embedded more than %d deep", MaxNestingDepth)
+	}
+	if !hasSubpacketAreas(contents) {
+		return nil
+	}
+	areas, err := subpacketAreas(contents)
+	if err != nil {
+		return nil
+	}
+	var nestErr error
+	for _, area := range areas {
+		forEachSubpacket(area, func(typ byte, data []byte) {
+			if typ == 32 && nestErr == nil {
+				nestErr = checkNesting(data, depth+1)
+			}
+		})
+	}
+	return nestErr
+}
+
+// forEachSubpacket calls f with the type and data of each signature subpacket
+// in area. The critical bit is masked off the type.
+func forEachSubpacket(area []byte, f func(typ byte, data []byte)) error {
+	for len(area) > 0 {
+		n, hdr, err := subpacketLen(area)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		area = area[hdr:]
+		f(area[0]&0x7f, area[1:n])
+		area = area[n:]
+	}
+	return nil
+}
+
+// subpacketLen decodes the length of the subpacket at the start of area,
+// returning the length of the subpacket following its header and the length
+// of the header itself.
+func subpacketLen(area []byte) (int, int, error) {
+	var n, hdr int
+	switch {
+	case area[0] < 192:
+		n, hdr = int(area[0]), 1
+	case area[0] < 255:
+		if len(area) < 2 {
+			return 0, 0, errgo.New("truncated subpacket length")
+		}
+		n, hdr = (int(area[0])-192)<<8+int(area[1])+192, 2
+	default:
+		if len(area) < 5 {
+			return 0, 0, errgo.New("truncated subpacket length")
+		}
+		n, hdr = int(binary.BigEndian.Uint32(area[1:5])), 5
+	}
+	if n == 0 || n > len(area)-hdr {
+		return 0, 0, errgo.New("invalid subpacket length")
+	}
+	return n, hdr, nil
+}
+
+func (sig *Signature) parse(op *packet.OpaquePacket) error {
+	err := checkNesting(op.Contents, 0)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if len(op.Contents) > 0 && op.Contents[0] == 6 {
+		err := sig.scan(op.Contents)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		sig.scanV6(op.Contents)
+		return nil
+	}
+	p, err := parseSignaturePacket(op)
+	if err != nil {
+		return errgo.Mask(classifyError(err), errgo.Any)
+	}
 
 	switch s := p.(type) {
 	case *packet.Signature:
-		return sig.setSignature(s)
+		if err := sig.setSignature(s); err != nil {
+			return err
+		}
+		if sig.RIssuerKeyID == "" {
+			sig.RIssuerKeyID = subpacketFingerprintIssuer(op.Contents)
+		}
+		return nil
 	case *packet.SignatureV3:
 		return sig.setSignatureV3(s)
 	}
@@ -114,9 +817,6 @@ func (sig *Signature) parse(op *packet.OpaquePacket) error {
 }
 
 func (sig *Signature) setSignature(s *packet.Signature) error {
-	if s.IssuerKeyId == nil {
-		return errgo.New("missing issuer key ID")
-	}
 	sig.Creation = s.CreationTime
 	sig.SigType = int(s.SigType)
 
@@ -160,7 +860,7 @@ func (sig *Signature) signaturePacket() (*packet.Signature, error) {
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
-	p, err := op.Parse()
+	p, err := parseSignaturePacket(op)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
@@ -187,6 +887,49 @@ func (sig *Signature) signatureV3Packet() (*packet.SignatureV3, error) {
 	return s, nil
 }
 
+// IssuerKeyID returns the 64-bit key ID of the issuer of the signature in hex,
+// or "" if the signature does not identify its issuer.
 func (sig *Signature) IssuerKeyID() string {
 	return Reverse(sig.RIssuerKeyID)
 }
+
+// IssuerShortID returns the low 32 bits of IssuerKeyID in hex, or "" if the
+// signature does not identify its issuer.
+func (sig *Signature) IssuerShortID() string {
+	if len(sig.RIssuerKeyID) < 8 {
+		return ""
+	}
+	return Reverse(sig.RIssuerKeyID[:8])
+}
+
+// IssuerLongKeyID returns IssuerKeyID in upper case, prefixed by "0x", as
+// PublicKey.LongKeyID, or "" if the signature does not identify its issuer.
+func (sig *Signature) IssuerLongKeyID() string {
+	if sig.RIssuerKeyID == "" {
+		return ""
+	}
+	return "0x" + strings.ToUpper(sig.IssuerKeyID())
+}
+
+// IssuerFingerprint returns the fingerprint of the issuer in hex, as given by
+// an issuer fingerprint subpacket in either subpacket area, or "" if the
+// signature has none.
+func (sig *Signature) IssuerFingerprint() string {
+	op, err := sig.opaquePacket()
+	if err != nil || !hasSubpacketAreas(op.Contents) {
+		return ""
+	}
+	areas, err := subpacketAreas(op.Contents)
+	if err != nil {
+		return ""
+	}
+	var result string
+	for _, area := range areas {
+		forEachSubpacket(area, func(typ byte, data []byte) {
+			if typ == 33 && result == "" && fingerprintIssuer(data) != "" { // issuer fingerprint
+				result = hex.EncodeToString(data[1:])
+			}
+		})
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+)
+
+// SerializedSize returns the number of octets the packet occupies when
+// written by WritePackets. It reads only the packet header, unless the packet
+// was framed differently, such as with partial lengths.
+func (p *Packet) SerializedSize() int64 {
+	h, err := readPacketHeader(bytes.NewReader(p.Packet))
+	if err == nil && !h.partial && h.length >= 0 && int64(h.headerLen)+h.length == int64(len(p.Packet)) {
+		return framedLen(h.length)
+	}
+	op, err := p.opaquePacket()
+	if err != nil {
+		return int64(len(p.Packet))
+	}
+	return serializedLen(op)
+}
+
+// nodesSize returns the total serialized size of the packets.
+func nodesSize(nodes []packetNode) int64 {
+	var n int64
+	for _, node := range nodes {
+		n += node.packet().SerializedSize()
+	}
+	return n
+}
+
+// SerializedSize returns the number of octets the user ID and its signatures
+// occupy when written by WritePackets.
+func (uid *UserID) SerializedSize() int64 {
+	return nodesSize(uid.contents())
+}
+
+// SerializedSize returns the number of octets the user attribute and its
+// signatures occupy when written by WritePackets.
+func (uat *UserAttribute) SerializedSize() int64 {
+	return nodesSize(uat.contents())
+}
+
+// SerializedSize returns the number of octets the sub-key and its signatures
+// occupy when written by WritePackets.
+func (subkey *SubKey) SerializedSize() int64 {
+	return nodesSize(subkey.contents())
+}
+
+// SerializedSize returns the number of octets written by WritePackets for the
+// key, without serializing it, so that quotas may be enforced and response
+// lengths known cheaply.
+func (pubkey *PrimaryKey) SerializedSize() int64 {
+	return nodesSize(pubkey.contents())
+}
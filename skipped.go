@@ -0,0 +1,133 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// SkipReason describes why a packet was not accepted as key material.
+type SkipReason int
+
+const (
+	// SkipNotKeyMaterial is given for packets which do not belong in a
+	// public keyring, such as trust packets. These are discarded.
+	SkipNotKeyMaterial SkipReason = iota
+
+	// SkipUnparseable is given for packets which could not be parsed.
+	SkipUnparseable
+
+	// SkipOutOfContext is given for signatures which do not follow a
+	// packet they could apply to.
+	SkipOutOfContext
+
+	// SkipUnknownTag is given for packets of an unrecognized type.
+	SkipUnknownTag
+
+	// SkipPadding is given for padding packets and marker packets, which
+	// carry no information. These are discarded, and do not affect digests.
+	SkipPadding
+)
+
+var skipReasonStrings = []string{
+	"not key material",
+	"unparseable",
+	"out of context",
+	"unknown tag",
+	"padding",
+}
+
+// isPaddingTag returns whether packets with the given tag are padding, to be
+// discarded wherever they appear: the crypto-refresh padding packet (tag 21),
+// and the marker packet (tag 10), which implementations must ignore.
+func isPaddingTag(tag uint8) bool {
+	return tag == 21 || tag == 10
+}
+
+// isUnknownTag returns whether tag is not assigned to any packet type, such as
+// a reserved or experimental tag, or one assigned by a future standard.
+func isUnknownTag(tag uint8) bool {
+	return tag == 15 || tag == 16 || tag >= 22
+}
+
+// UnknownPacketPolicy determines what is done with packets of an unknown type
+// found among the key material being read.
+type UnknownPacketPolicy int
+
+const (
+	// DropUnknownPackets discards unknown packets as they are read, as is
+	// done for other packets which are not key material.
+	DropUnknownPackets UnknownPacketPolicy = iota
+
+	// RetainUnknownPackets keeps unknown packets in the Others of the user
+	// ID, user attribute or sub-key they follow, or of the key if they
+	// follow none. They are included in the key's digests, and are written
+	// back out unchanged with the rest of the key, after the signatures of
+	// the packet they follow.
+	RetainUnknownPackets
+
+	// RejectUnknownPackets fails any key containing an unknown packet with
+	// an error caused by ErrUnknownPacket.
+	RejectUnknownPackets
+)
+
+func (r SkipReason) String() string {
+	if int(r) < len(skipReasonStrings) {
+		return skipReasonStrings[r]
+	}
+	return "unknown"
+}
+
+// SkippedPacket describes a packet in a keyring which was not accepted as key
+// material.
+type SkippedPacket struct {
+	Tag uint8
+
+	// Offset is the position in octets of the packet from the start of its
+	// keyring, as serialized, or -1 for packets discarded before parsing.
+	Offset int64
+
+	Reason SkipReason
+
+	// Digest is the hex-encoded SHA-256 digest of the serialized packet.
+	Digest string
+
+	// Err is the error encountered parsing the packet, if any.
+	Err error
+
+	// Retained indicates that the packet was kept in the Others of the key,
+	// or of the packet it followed, rather than discarded.
+	Retained bool
+}
+
+// packetDigest returns the hex-encoded SHA-256 digest of a serialized packet.
+func packetDigest(buf []byte) string {
+	d := sha256.Sum256(buf)
+	return hex.EncodeToString(d[:])
+}
+
+func opaqueDigest(op *packet.OpaquePacket) string {
+	buf, err := (*packetArena)(nil).serialize(op)
+	if err != nil {
+		return ""
+	}
+	return packetDigest(buf)
+}
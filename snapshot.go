@@ -0,0 +1,222 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"gopkg.in/errgo.v1"
+)
+
+// KeySnapshot is an immutable version of a key. Merging into a snapshot
+// produces a new snapshot, which shares with the old one all the nodes which
+// the merge did not change, so readers may go on using the old snapshot
+// without locking while the new one is made.
+type KeySnapshot struct {
+	key *PrimaryKey
+}
+
+// NewKeySnapshot returns a snapshot of key, which must not be modified
+// afterwards. All of its signatures are expanded, so that reading them does
+// not modify the key.
+func NewKeySnapshot(key *PrimaryKey) (*KeySnapshot, error) {
+	err := expandSignatures(key)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &KeySnapshot{key: key}, nil
+}
+
+// Key returns the key, which must not be modified.
+func (s *KeySnapshot) Key() *PrimaryKey {
+	return s.key
+}
+
+// Merge returns a new snapshot of the key with src merged into it, with the
+// same contents as the package-level Merge would produce. The snapshot is
+// not changed. src must not be used afterwards.
+func (s *KeySnapshot) Merge(src *PrimaryKey) (*KeySnapshot, error) {
+	if src.RFingerprint != s.key.RFingerprint {
+		return nil, errgo.Newf("cannot merge key %s into %s", src.Fingerprint(), s.key.Fingerprint())
+	}
+	src.Detach()
+	err := expandSignatures(src)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	key := *s.key
+	key.Signatures, _ = mergeSignatures(key.Signatures, src.Signatures)
+	key.UserIDs = mergeUserIDs(key.UserIDs, src.UserIDs)
+	key.UserAttributes = mergeUserAttributes(key.UserAttributes, src.UserAttributes)
+	key.SubKeys = mergeSubKeys(key.SubKeys, src.SubKeys)
+	key.Others, _ = mergeOthers(key.Others, src.Others)
+	err = key.updateMD5()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	metrics.KeyMerged()
+	return &KeySnapshot{key: &key}, nil
+}
+
+// nodeKey identifies a node by its UUID and packet contents, as dedup does.
+func nodeKey(node packetNode) string {
+	return node.uuid() + "_" + hexmd5(node.packet().Packet)
+}
+
+// mergeSignatures returns dst with the signatures of src not already in it
+// appended, and whether it differs from dst. dst is not modified; signatures
+// which gain a higher Count are replaced by copies.
+func mergeSignatures(dst, src []*Signature) ([]*Signature, bool) {
+	index := make(map[string]int)
+	for i, sig := range dst {
+		index[nodeKey(sig)] = i
+	}
+	result := dst
+	changed := false
+	for _, sig := range src {
+		i, ok := index[nodeKey(sig)]
+		if ok && sig.Count <= result[i].Count {
+			continue
+		}
+		if !changed {
+			result = append([]*Signature(nil), dst...)
+			changed = true
+		}
+		if ok {
+			update := *result[i]
+			update.Count = sig.Count
+			result[i] = &update
+		} else {
+			index[nodeKey(sig)] = len(result)
+			result = append(result, sig)
+		}
+	}
+	return result, changed
+}
+
+// mergeOthers is as mergeSignatures, for unrecognized packets.
+func mergeOthers(dst, src []*Packet) ([]*Packet, bool) {
+	index := make(map[string]int)
+	for i, p := range dst {
+		index[nodeKey(p)] = i
+	}
+	result := dst
+	changed := false
+	for _, p := range src {
+		i, ok := index[nodeKey(p)]
+		if ok && p.Count <= result[i].Count {
+			continue
+		}
+		if !changed {
+			result = append([]*Packet(nil), dst...)
+			changed = true
+		}
+		if ok {
+			update := *result[i]
+			update.Count = p.Count
+			result[i] = &update
+		} else {
+			index[nodeKey(p)] = len(result)
+			result = append(result, p)
+		}
+	}
+	return result, changed
+}
+
+// mergeUserIDs returns a new slice of the user IDs of dst and src. Those of
+// dst which gain packets from src are replaced by copies.
+func mergeUserIDs(dst, src []*UserID) []*UserID {
+	index := make(map[string]int)
+	for i, uid := range dst {
+		index[nodeKey(uid)] = i
+	}
+	result := append([]*UserID(nil), dst...)
+	for _, uid := range src {
+		i, ok := index[nodeKey(uid)]
+		if !ok {
+			index[nodeKey(uid)] = len(result)
+			result = append(result, uid)
+			continue
+		}
+		sigs, sigsChanged := mergeSignatures(result[i].Signatures, uid.Signatures)
+		others, othersChanged := mergeOthers(result[i].Others, uid.Others)
+		if sigsChanged || othersChanged || uid.Count > result[i].Count {
+			update := *result[i]
+			update.Signatures, update.Others = sigs, others
+			if uid.Count > update.Count {
+				update.Count = uid.Count
+			}
+			result[i] = &update
+		}
+	}
+	return result
+}
+
+// mergeUserAttributes is as mergeUserIDs, for user attributes.
+func mergeUserAttributes(dst, src []*UserAttribute) []*UserAttribute {
+	index := make(map[string]int)
+	for i, uat := range dst {
+		index[nodeKey(uat)] = i
+	}
+	result := append([]*UserAttribute(nil), dst...)
+	for _, uat := range src {
+		i, ok := index[nodeKey(uat)]
+		if !ok {
+			index[nodeKey(uat)] = len(result)
+			result = append(result, uat)
+			continue
+		}
+		sigs, sigsChanged := mergeSignatures(result[i].Signatures, uat.Signatures)
+		others, othersChanged := mergeOthers(result[i].Others, uat.Others)
+		if sigsChanged || othersChanged || uat.Count > result[i].Count {
+			update := *result[i]
+			update.Signatures, update.Others = sigs, others
+			if uat.Count > update.Count {
+				update.Count = uat.Count
+			}
+			result[i] = &update
+		}
+	}
+	return result
+}
+
+// mergeSubKeys is as mergeUserIDs, for sub-keys.
+func mergeSubKeys(dst, src []*SubKey) []*SubKey {
+	index := make(map[string]int)
+	for i, subkey := range dst {
+		index[nodeKey(subkey)] = i
+	}
+	result := append([]*SubKey(nil), dst...)
+	for _, subkey := range src {
+		i, ok := index[nodeKey(subkey)]
+		if !ok {
+			index[nodeKey(subkey)] = len(result)
+			result = append(result, subkey)
+			continue
+		}
+		sigs, sigsChanged := mergeSignatures(result[i].Signatures, subkey.Signatures)
+		others, othersChanged := mergeOthers(result[i].Others, subkey.Others)
+		if sigsChanged || othersChanged || subkey.Count > result[i].Count {
+			update := *result[i]
+			update.Signatures, update.Others = sigs, others
+			if subkey.Count > update.Count {
+				update.Count = subkey.Count
+			}
+			result[i] = &update
+		}
+	}
+	return result
+}
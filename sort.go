@@ -60,6 +60,80 @@ func lessSelfSigs(i, j *SelfSigs) (bool, bool) {
 	return false, false
 }
 
+func lessUserIDs(key *PrimaryKey, a, b *UserID) bool {
+	less, ok := lessSelfSigs(a.SelfSigs(key), b.SelfSigs(key))
+	if ok {
+		return less
+	}
+	return a.Keywords < b.Keywords
+}
+
+func lessUserAttributes(key *PrimaryKey, a, b *UserAttribute) bool {
+	less, _ := lessSelfSigs(a.SelfSigs(key), b.SelfSigs(key))
+	return less
+}
+
+func lessSubKeys(key *PrimaryKey, a, b *SubKey) bool {
+	less, ok := lessSelfSigs(a.SelfSigs(key), b.SelfSigs(key))
+	if ok {
+		return less
+	}
+	return a.Creation.Unix() < b.Creation.Unix()
+}
+
+func lessSignatures(a, b *Signature) bool {
+	return a.Creation.Unix() < b.Creation.Unix()
+}
+
+// CompareNodes compares two packets of the same kind belonging to key, as Sort
+// orders them. It returns -1 if a precedes b, 1 if b precedes a, and 0 if
+// neither takes precedence, in which case Sort keeps them in their existing
+// order.
+//
+// The packets must both be user IDs, user attributes, subkeys or signatures,
+// given as *UserID, *UserAttribute, *SubKey or *Signature. Other packets are
+// not reordered by Sort, and always compare equal.
+//
+// User IDs, user attributes and subkeys are ordered by their self-signatures:
+// valid before invalid, unrevoked before revoked, primary before
+// non-primary, and then most recently self-certified first. Remaining ties
+// between user IDs are broken by their text, and between subkeys by their
+// creation time. Signatures are ordered by creation time. These rules depend
+// on the current time, as set by SetClock, and will not otherwise change
+// within a major version of this package.
+func CompareNodes(key *PrimaryKey, a, b interface{}) int {
+	var less func(a, b interface{}) bool
+	switch a.(type) {
+	case *UserID:
+		if _, ok := b.(*UserID); ok {
+			less = func(a, b interface{}) bool { return lessUserIDs(key, a.(*UserID), b.(*UserID)) }
+		}
+	case *UserAttribute:
+		if _, ok := b.(*UserAttribute); ok {
+			less = func(a, b interface{}) bool {
+				return lessUserAttributes(key, a.(*UserAttribute), b.(*UserAttribute))
+			}
+		}
+	case *SubKey:
+		if _, ok := b.(*SubKey); ok {
+			less = func(a, b interface{}) bool { return lessSubKeys(key, a.(*SubKey), b.(*SubKey)) }
+		}
+	case *Signature:
+		if _, ok := b.(*Signature); ok {
+			less = func(a, b interface{}) bool { return lessSignatures(a.(*Signature), b.(*Signature)) }
+		}
+	}
+	switch {
+	case less == nil:
+		return 0
+	case less(a, b):
+		return -1
+	case less(b, a):
+		return 1
+	}
+	return 0
+}
+
 type uidSorter struct {
 	*PrimaryKey
 }
@@ -67,13 +141,7 @@ type uidSorter struct {
 func (s *uidSorter) Len() int { return len(s.UserIDs) }
 
 func (s *uidSorter) Less(i, j int) bool {
-	iss := s.UserIDs[i].SelfSigs(s.PrimaryKey)
-	jss := s.UserIDs[j].SelfSigs(s.PrimaryKey)
-	less, ok := lessSelfSigs(iss, jss)
-	if ok {
-		return less
-	}
-	return s.UserIDs[i].Keywords < s.UserIDs[j].Keywords
+	return lessUserIDs(s.PrimaryKey, s.UserIDs[i], s.UserIDs[j])
 }
 
 func (s *uidSorter) Swap(i, j int) {
@@ -87,10 +155,7 @@ type uatSorter struct {
 func (s *uatSorter) Len() int { return len(s.UserAttributes) }
 
 func (s *uatSorter) Less(i, j int) bool {
-	iss := s.UserAttributes[i].SelfSigs(s.PrimaryKey)
-	jss := s.UserAttributes[j].SelfSigs(s.PrimaryKey)
-	less, _ := lessSelfSigs(iss, jss)
-	return less
+	return lessUserAttributes(s.PrimaryKey, s.UserAttributes[i], s.UserAttributes[j])
 }
 
 func (s *uatSorter) Swap(i, j int) {
@@ -104,13 +169,7 @@ type subkeySorter struct {
 func (s *subkeySorter) Len() int { return len(s.SubKeys) }
 
 func (s *subkeySorter) Less(i, j int) bool {
-	iss := s.SubKeys[i].SelfSigs(s.PrimaryKey)
-	jss := s.SubKeys[j].SelfSigs(s.PrimaryKey)
-	less, ok := lessSelfSigs(iss, jss)
-	if ok {
-		return less
-	}
-	return s.SubKeys[i].Creation.Unix() < s.SubKeys[j].Creation.Unix()
+	return lessSubKeys(s.PrimaryKey, s.SubKeys[i], s.SubKeys[j])
 }
 
 func (s *subkeySorter) Swap(i, j int) {
@@ -124,28 +183,30 @@ type sigSorter struct {
 func (s *sigSorter) Len() int { return len(s.sigs) }
 
 func (s *sigSorter) Less(i, j int) bool {
-	return s.sigs[i].Creation.Unix() < s.sigs[j].Creation.Unix()
+	return lessSignatures(s.sigs[i], s.sigs[j])
 }
 
 func (s *sigSorter) Swap(i, j int) {
 	s.sigs[i], s.sigs[j] = s.sigs[j], s.sigs[i]
 }
 
-// Sort reorders the key material based on precedence rules.
+// Sort reorders the key material based on precedence rules, as given by
+// CompareNodes. The sort is stable, so that packets which compare equal keep
+// their existing order.
 func Sort(pubkey *PrimaryKey) {
 	for _, node := range pubkey.contents() {
 		switch p := node.(type) {
 		case *PrimaryKey:
-			sort.Sort(&sigSorter{p.Signatures})
-			sort.Sort(&uidSorter{p})
-			sort.Sort(&uatSorter{p})
-			sort.Sort(&subkeySorter{p})
+			sort.Stable(&sigSorter{p.Signatures})
+			sort.Stable(&uidSorter{p})
+			sort.Stable(&uatSorter{p})
+			sort.Stable(&subkeySorter{p})
 		case *SubKey:
-			sort.Sort(&sigSorter{p.Signatures})
+			sort.Stable(&sigSorter{p.Signatures})
 		case *UserID:
-			sort.Sort(&sigSorter{p.Signatures})
+			sort.Stable(&sigSorter{p.Signatures})
 		case *UserAttribute:
-			sort.Sort(&sigSorter{p.Signatures})
+			sort.Stable(&sigSorter{p.Signatures})
 		}
 	}
 }
@@ -0,0 +1,106 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"encoding/hex"
+	"io"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SplitDump writes the keyrings received from c to outputs, in contiguous runs
+// of about the same number of octets, so that the outputs taken in order hold
+// the keyrings in their original order. size is the total size of the input,
+// such as the Len of a DumpFile; if it is inaccurate the outputs are less
+// evenly balanced.
+func SplitDump(c OpaqueKeyringChan, size int64, outputs []io.Writer) error {
+	if len(outputs) == 0 {
+		return errgo.New("no outputs")
+	}
+	if size < 1 {
+		size = 1
+	}
+	n := int64(len(outputs))
+	var written int64
+	return writeKeyrings(c, func(okr *OpaqueKeyring) io.Writer {
+		i := written * n / size
+		if i >= n {
+			i = n - 1
+		}
+		for _, op := range okr.Packets {
+			written += serializedLen(op)
+		}
+		return outputs[i]
+	})
+}
+
+// ShardDump writes each keyring received from c to one of outputs, chosen by
+// the leading octets of its fingerprint, so that each output holds a
+// contiguous range of fingerprints. As fingerprints are evenly distributed,
+// so are keys among the outputs. Keyrings keep their original order within
+// each output. Keyrings without a primary key, such as standalone revocation
+// certificates, are written to the first output.
+func ShardDump(c OpaqueKeyringChan, outputs []io.Writer) error {
+	if len(outputs) == 0 {
+		return errgo.New("no outputs")
+	}
+	return writeKeyrings(c, func(okr *OpaqueKeyring) io.Writer {
+		return outputs[fingerprintShard(okr, len(outputs))]
+	})
+}
+
+// fingerprintShard returns which of n shards a keyring belongs in.
+func fingerprintShard(okr *OpaqueKeyring, n int) int {
+	if len(okr.Packets) == 0 || okr.Packets[0].Tag != 6 { //packet.PacketTypePublicKey
+		return 0
+	}
+	pubkey, err := ParsePrimaryKey(okr.Packets[0])
+	if err != nil {
+		return 0
+	}
+	prefix, err := hex.DecodeString(pubkey.Fingerprint()[:4])
+	if err != nil {
+		return 0
+	}
+	return (int(prefix[0])<<8 | int(prefix[1])) * n >> 16
+}
+
+// writeKeyrings writes each keyring received from c to the output chosen for
+// it, stopping at the first error. The remaining keyrings are drained from c.
+func writeKeyrings(c OpaqueKeyringChan, output func(*OpaqueKeyring) io.Writer) error {
+	var err error
+	for okr := range c {
+		if err != nil {
+			continue
+		}
+		if okr.Error != nil {
+			err = errgo.Mask(okr.Error, errgo.Any)
+			continue
+		}
+		w := output(okr)
+		for _, op := range okr.Packets {
+			err = op.Serialize(w)
+			if err != nil {
+				err = errgo.Mask(err)
+				break
+			}
+		}
+	}
+	return err
+}
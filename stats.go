@@ -0,0 +1,179 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// KeyStats accumulates statistics over a collection of keys, such as a full
+// dump, for capacity planning and reporting on the keys in use. Statistics
+// are collected from the packets alone; signatures are not verified.
+type KeyStats struct {
+	// Keys is the number of keys counted, and Errors the number of keys
+	// which could not be read.
+	Keys   int
+	Errors int
+
+	// Versions counts primary keys by packet version.
+	Versions map[int]int
+
+	// Algorithms counts primary keys by algorithm name, as given by
+	// AlgorithmName, and KeySizes by algorithm name and bit length, such as
+	// "rsa4096".
+	Algorithms map[string]int
+	KeySizes   map[string]int
+
+	// CreationYears counts primary keys by the year, in UTC, they were
+	// created.
+	CreationYears map[int]int
+
+	// Revoked is the number of keys bearing a key revocation signature
+	// issued by the key itself.
+	Revoked int
+
+	// Packets counts the packets of all keys by tag.
+	Packets map[uint8]int
+}
+
+// NewKeyStats returns an empty KeyStats.
+func NewKeyStats() *KeyStats {
+	return &KeyStats{
+		Versions:      make(map[int]int),
+		Algorithms:    make(map[string]int),
+		KeySizes:      make(map[string]int),
+		CreationYears: make(map[int]int),
+		Packets:       make(map[uint8]int),
+	}
+}
+
+// CollectKeyStats counts all keys received from c.
+func CollectKeyStats(c PrimaryKeyChan) *KeyStats {
+	s := NewKeyStats()
+	for readKey := range c {
+		if readKey.Error != nil {
+			s.Errors++
+			continue
+		}
+		if readKey.PrimaryKey != nil {
+			s.Add(readKey.PrimaryKey)
+		}
+	}
+	return s
+}
+
+// Add counts a key.
+func (s *KeyStats) Add(key *PrimaryKey) {
+	s.Keys++
+	if op, err := key.opaquePacket(); err == nil && len(op.Contents) > 0 {
+		s.Versions[int(op.Contents[0])]++
+	}
+	algo := AlgorithmName(key.Algorithm)
+	s.Algorithms[algo]++
+	s.KeySizes[fmt.Sprintf("%s%d", algo, key.BitLen)]++
+	s.CreationYears[key.Creation.UTC().Year()]++
+	for _, sig := range key.Signatures {
+		if sig.SigType == 0x20 && key.isSelfSig(sig) { // packet.SigTypeKeyRevocation
+			s.Revoked++
+			break
+		}
+	}
+	for _, node := range key.contents() {
+		s.Packets[node.packet().Tag]++
+	}
+}
+
+// HistogramPeriod is the width of the buckets of a CreationHistogram.
+type HistogramPeriod int
+
+const (
+	// ByYear buckets by calendar year, labelled as "2006".
+	ByYear HistogramPeriod = iota
+
+	// ByMonth buckets by calendar month, labelled as "2006-01".
+	ByMonth
+)
+
+// CreationHistogram counts keys and signatures by the period in which they
+// were created, in UTC.
+type CreationHistogram struct {
+	Period HistogramPeriod
+
+	// Keys counts primary keys, and Signatures all signatures on them, by
+	// period label.
+	Keys       map[string]int
+	Signatures map[string]int
+}
+
+// NewCreationHistogram returns an empty histogram with buckets of the given
+// period.
+func NewCreationHistogram(period HistogramPeriod) *CreationHistogram {
+	return &CreationHistogram{
+		Period:     period,
+		Keys:       make(map[string]int),
+		Signatures: make(map[string]int),
+	}
+}
+
+// CollectCreationHistogram counts all keys received from c. Keys which could
+// not be read are ignored.
+func CollectCreationHistogram(c PrimaryKeyChan, period HistogramPeriod) *CreationHistogram {
+	h := NewCreationHistogram(period)
+	for readKey := range c {
+		if readKey.Error == nil && readKey.PrimaryKey != nil {
+			h.Add(readKey.PrimaryKey)
+		}
+	}
+	return h
+}
+
+func (h *CreationHistogram) label(t time.Time) string {
+	if h.Period == ByMonth {
+		return t.UTC().Format("2006-01")
+	}
+	return t.UTC().Format("2006")
+}
+
+// Add counts a key and its signatures.
+func (h *CreationHistogram) Add(key *PrimaryKey) {
+	h.Keys[h.label(key.Creation)]++
+	for _, node := range key.contents() {
+		if sig, ok := node.(*Signature); ok {
+			h.Signatures[h.label(sig.Creation)]++
+		}
+	}
+}
+
+// Labels returns the labels of all periods in which keys or signatures were
+// created, in chronological order.
+func (h *CreationHistogram) Labels() []string {
+	seen := make(map[string]bool)
+	var labels []string
+	for _, m := range []map[string]int{h.Keys, h.Signatures} {
+		for label := range m {
+			if !seen[label] {
+				seen[label] = true
+				labels = append(labels, label)
+			}
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
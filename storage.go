@@ -0,0 +1,154 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// StorageDocument is a flattened form in which a key may be stored and
+// indexed by a database: the identifiers and keywords it is looked up by, its
+// timestamps and digests, and the packets from which the key itself is read
+// back. Encoding the same key always yields the same document. Its schema is
+// this package's own, and is not that of the documents stored by any
+// Hockeypuck storage backend.
+type StorageDocument struct {
+	RFingerprint string `json:"rfingerprint"`
+	RKeyID       string `json:"rkeyid"`
+	RShortID     string `json:"rshortid"`
+
+	// RSubFingerprints and RSubKeyIDs are the reversed fingerprints and key
+	// IDs of the key's subkeys, in key order.
+	RSubFingerprints []string `json:"rsubfps,omitempty"`
+	RSubKeyIDs       []string `json:"rsubkeyids,omitempty"`
+
+	// Keywords are the distinct user IDs of the key served by the policy it
+	// was encoded with, and the email addresses they contain, folded to
+	// lower case and sorted.
+	Keywords []string `json:"keywords,omitempty"`
+
+	// CTime is the creation time of the primary key, and MTime that of the
+	// most recent signature on the key, both in seconds since the Unix
+	// epoch. MTime is never earlier than CTime.
+	CTime int64 `json:"ctime"`
+	MTime int64 `json:"mtime"`
+
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	Packets []*StoragePacket `json:"packets"`
+}
+
+// StoragePacket is a packet of a StorageDocument.
+type StoragePacket struct {
+	Tag uint8 `json:"tag"`
+
+	// Data is the serialized packet, including its header, which is
+	// encoded in JSON as standard base64.
+	Data []byte `json:"data"`
+}
+
+// NewStorageDocument returns the storage document for a key, which must have
+// been digested, with the keywords of the user IDs served by policy.
+func NewStorageDocument(key *PrimaryKey, policy *ServePolicy) (*StorageDocument, error) {
+	if key.MD5 == "" {
+		return nil, errgo.New("key has not been digested")
+	}
+	doc := &StorageDocument{
+		RFingerprint: key.RFingerprint,
+		RKeyID:       key.RKeyID,
+		RShortID:     key.RShortID,
+		CTime:        key.Creation.Unix(),
+		MD5:          key.MD5,
+		SHA256:       key.SHA256,
+	}
+	for _, subkey := range key.SubKeys {
+		doc.RSubFingerprints = append(doc.RSubFingerprints, subkey.RFingerprint)
+		doc.RSubKeyIDs = append(doc.RSubKeyIDs, subkey.RKeyID)
+	}
+	doc.Keywords = storageKeywords(key, policy)
+
+	mtime := key.Creation
+	for _, node := range key.contents() {
+		if sig, ok := node.(*Signature); ok && sig.Creation.After(mtime) {
+			mtime = sig.Creation
+		}
+		p := node.packet()
+		doc.Packets = append(doc.Packets, &StoragePacket{Tag: p.Tag, Data: p.Packet})
+	}
+	doc.MTime = mtime.Unix()
+	return doc, nil
+}
+
+// storageKeywords returns the sorted, distinct keywords of a key's served
+// user IDs.
+func storageKeywords(key *PrimaryKey, policy *ServePolicy) []string {
+	seen := make(map[string]bool)
+	var keywords []string
+	add := func(s string) {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" && !seen[s] {
+			seen[s] = true
+			keywords = append(keywords, s)
+		}
+	}
+	for _, uid := range key.ServedUserIDs(policy) {
+		add(uid.Keywords)
+		add(userIDEmail(uid.Keywords))
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// MarshalStorageDocument returns the JSON encoding of the storage document
+// for a key, as given by NewStorageDocument.
+func MarshalStorageDocument(key *PrimaryKey, policy *ServePolicy) ([]byte, error) {
+	doc, err := NewStorageDocument(key, policy)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return buf, nil
+}
+
+// Key reads the key back from the packets of the document.
+func (doc *StorageDocument) Key() (*PrimaryKey, error) {
+	var buf bytes.Buffer
+	for _, p := range doc.Packets {
+		buf.Write(p.Data)
+	}
+	key, err := readStoredKey(&buf)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if key.RFingerprint != doc.RFingerprint {
+		return nil, errgo.Newf("document for %q holds key %q", doc.RFingerprint, key.RFingerprint)
+	}
+	if key.MD5 != doc.MD5 {
+		return nil, errgo.Newf("document for %q has digest %q, but its packets %q", doc.RFingerprint, doc.MD5, key.MD5)
+	}
+	return key, nil
+}
@@ -17,6 +17,62 @@
 
 package openpgp
 
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// maxInternedStrings bounds the size of a stringTable. When it is reached the
+// table starts over, which only costs some sharing.
+const maxInternedStrings = 1 << 16
+
+// stringTable interns strings which recur across many packets in a stream of
+// keys, such as the issuer key IDs of signatures made by a few prolific
+// certifiers, so that parsed keys share a single copy of each. Only strings
+// of low cardinality are worth interning: user ID keywords are nearly all
+// distinct, and would only churn the table.
+type stringTable struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{m: make(map[string]string)}
+}
+
+// intern returns the canonical copy of s. A nil table returns s unchanged.
+func (t *stringTable) intern(s string) string {
+	if t == nil {
+		return s
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v, ok := t.m[s]; ok {
+		return v
+	}
+	if len(t.m) >= maxInternedStrings {
+		t.m = make(map[string]string)
+	}
+	t.m[s] = s
+	return s
+}
+
+// Keys and signatures are identified throughout by reversed hex strings: the
+// lower case hex encoding of a fingerprint or key ID, with its characters in
+// reverse order. RFingerprint is the reversed fingerprint of a key, and as a
+// v4 key ID is the low 64 bits of its fingerprint, RKeyID and RShortID are
+// the first 16 and 8 characters of RFingerprint. (The key IDs of v3 keys are
+// taken from their RSA modulus instead, and so are not prefixes of their
+// reversed fingerprints.) Reversal places the most varying digits first, so
+// that prefix searches on an index of reversed identifiers find keys by
+// their short or long key IDs.
+
+// Reverse returns s with its characters in reverse order. Applied to a
+// reversed hex identifier, it returns the identifier in its usual order.
 func Reverse(s string) string {
 	runes := []rune(s)
 	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
@@ -24,3 +80,56 @@ func Reverse(s string) string {
 	}
 	return string(runes)
 }
+
+// ReverseHex returns the reversed hex encoding of b, such as a fingerprint.
+func ReverseHex(b []byte) string {
+	return Reverse(hex.EncodeToString(b))
+}
+
+// ReverseKeyID returns the reversed hex encoding of a 64-bit key ID.
+func ReverseKeyID(id uint64) string {
+	return Reverse(fmt.Sprintf("%016x", id))
+}
+
+// ReverseShortID returns the reversed hex encoding of the low 32 bits of a
+// key ID.
+func ReverseShortID(id uint64) string {
+	return Reverse(fmt.Sprintf("%08x", uint32(id)))
+}
+
+// normalizeHex returns a hex identifier as entered by a user, which may be in
+// upper case, prefixed by "0x" and grouped by spaces, in lower case without
+// prefix or spaces.
+func normalizeHex(s string) string {
+	s = strings.ToLower(strings.Replace(strings.TrimSpace(s), " ", "", -1))
+	return strings.TrimPrefix(s, "0x")
+}
+
+// ParseRFingerprint returns the reversed fingerprint of a v3, v4 or v5/v6
+// fingerprint in hex, which may be in upper case, prefixed by "0x" and
+// grouped by spaces.
+func ParseRFingerprint(s string) (string, error) {
+	fp := normalizeHex(s)
+	switch len(fp) {
+	case 32, 40, 64:
+	default:
+		return "", errgo.Newf("invalid fingerprint %q", s)
+	}
+	if _, err := hex.DecodeString(fp); err != nil {
+		return "", errgo.Newf("invalid fingerprint %q", s)
+	}
+	return Reverse(fp), nil
+}
+
+// ParseRKeyID returns the reversed key ID of a 64-bit key ID in hex, which
+// may be given as ParseRFingerprint allows.
+func ParseRKeyID(s string) (string, error) {
+	id := normalizeHex(s)
+	if len(id) != 16 {
+		return "", errgo.Newf("invalid key ID %q", s)
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", errgo.Newf("invalid key ID %q", s)
+	}
+	return Reverse(id), nil
+}
@@ -18,8 +18,7 @@
 package openpgp
 
 import (
-	"bytes"
-	"strings"
+	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
 	"gopkg.in/errgo.v1"
@@ -42,18 +41,20 @@ func (subkey *SubKey) contents() []packetNode {
 }
 
 func ParseSubKey(op *packet.OpaquePacket) (*SubKey, error) {
-	var buf bytes.Buffer
-	var err error
+	return parseSubKey(op, nil)
+}
 
-	if err = op.Serialize(&buf); err != nil {
+func parseSubKey(op *packet.OpaquePacket, arena *packetArena) (*SubKey, error) {
+	buf, err := arena.serialize(op)
+	if err != nil {
 		return nil, errgo.Mask(err)
-		panic("unable to write internal buffer")
 	}
 	subkey := &SubKey{
 		PublicKey: PublicKey{
 			Packet: Packet{
 				Tag:    op.Tag,
-				Packet: buf.Bytes(),
+				Packet: buf,
+				shared: arena != nil,
 			},
 		},
 	}
@@ -97,18 +98,28 @@ func (ss subkeySlice) without(target *SubKey) []*SubKey {
 	return result
 }
 
-func (subkey *SubKey) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
+func (subkey *SubKey) SelfSigs(pubkey *PrimaryKey, opts ...SelfSigOption) *SelfSigs {
+	so := newSelfSigOptions(opts)
 	result := &SelfSigs{target: subkey}
 	for _, sig := range subkey.Signatures {
 		// Skip non-self-certifications.
-		if !strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID) {
+		candidate, trial := pubkey.selfSigCandidate(sig, so)
+		if !candidate {
 			continue
 		}
-		checkSig := &CheckSig{
-			PrimaryKey: pubkey,
-			Signature:  sig,
-			Error:      pubkey.verifyPublicKeySelfSig(&subkey.PublicKey, sig),
+		if err := sig.Expand(); err != nil {
+			if !trial {
+				result.Errors = append(result.Errors, newCheckSig(pubkey, sig, err))
+			}
+			continue
+		}
+		err := pubkey.verifyCached(sig, subkey, func() error {
+			return pubkey.verifyPublicKeySelfSig(&subkey.PublicKey, sig)
+		})
+		if err != nil && trial {
+			continue
 		}
+		checkSig := newCheckSig(pubkey, sig, err)
 		if checkSig.Error != nil {
 			result.Errors = append(result.Errors, checkSig)
 			continue
@@ -126,3 +137,95 @@ func (subkey *SubKey) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
 	result.resolve()
 	return result
 }
+
+// SubKeyStatus is the state of a sub-key at a given time.
+type SubKeyStatus int
+
+const (
+	// SubKeyValid means the sub-key is bound to the primary key by a valid
+	// binding signature, and has neither expired nor been revoked.
+	SubKeyValid SubKeyStatus = iota
+
+	// SubKeyUnbound means the sub-key has no valid binding signature, and
+	// so is not part of the key at all.
+	SubKeyUnbound
+
+	// SubKeyExpired means the sub-key has expired, as given by its most
+	// recent valid binding signature.
+	SubKeyExpired
+
+	// SubKeyRevoked means the sub-key has a valid revocation.
+	SubKeyRevoked
+)
+
+var subKeyStatusNames = []string{"valid", "unbound", "expired", "revoked"}
+
+// String returns the name of the status.
+func (s SubKeyStatus) String() string {
+	if s >= 0 && int(s) < len(subKeyStatusNames) {
+		return subKeyStatusNames[s]
+	}
+	return "unknown"
+}
+
+// EffectiveExpiration returns the time at which the sub-key expires: its
+// creation time plus the key lifetime given by its most recent valid binding
+// signature. It returns false if the sub-key does not expire, or has no
+// valid binding signature.
+func (subkey *SubKey) EffectiveExpiration(pubkey *PrimaryKey) (time.Time, bool) {
+	return subkey.effectiveExpiration(subkey.SelfSigs(pubkey))
+}
+
+func (subkey *SubKey) effectiveExpiration(ss *SelfSigs) (time.Time, bool) {
+	if len(ss.Certifications) == 0 {
+		return zeroTime, false
+	}
+	lifetime, ok := ss.Certifications[0].Signature.KeyLifetime()
+	if !ok || lifetime == 0 {
+		return zeroTime, false
+	}
+	return subkey.Creation.Add(lifetime), true
+}
+
+// Status returns the state of the sub-key at the current time.
+func (subkey *SubKey) Status(pubkey *PrimaryKey) SubKeyStatus {
+	return subkey.StatusAt(pubkey, now())
+}
+
+// StatusAt returns the state of the sub-key at time t. A revocation takes
+// effect whenever it was made, as a key is typically revoked once it is
+// suspected of compromise, and is not undone by a later binding signature.
+func (subkey *SubKey) StatusAt(pubkey *PrimaryKey, t time.Time) SubKeyStatus {
+	return subkey.statusAt(subkey.SelfSigs(pubkey), t)
+}
+
+func (subkey *SubKey) statusAt(ss *SelfSigs, t time.Time) SubKeyStatus {
+	if len(ss.Revocations) > 0 {
+		return SubKeyRevoked
+	}
+	if len(ss.Certifications) == 0 {
+		return SubKeyUnbound
+	}
+	if expiration, ok := subkey.effectiveExpiration(ss); ok && !expiration.After(t) {
+		return SubKeyExpired
+	}
+	return SubKeyValid
+}
+
+// ValidSubKeys returns the sub-keys of the key which are valid at the current
+// time, skipping those which are revoked, expired or unbound, for selecting a
+// sub-key with which to encrypt or verify.
+func (pubkey *PrimaryKey) ValidSubKeys() []*SubKey {
+	return pubkey.ValidSubKeysAt(now())
+}
+
+// ValidSubKeysAt returns the sub-keys of the key which were valid at time t.
+func (pubkey *PrimaryKey) ValidSubKeysAt(t time.Time) []*SubKey {
+	var result []*SubKey
+	for _, subkey := range pubkey.SubKeys {
+		if subkey.StatusAt(pubkey, t) == SubKeyValid {
+			result = append(result, subkey)
+		}
+	}
+	return result
+}
@@ -0,0 +1,318 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package testkeys
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"time"
+
+	xopenpgp "golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/schmorrison/openpgp.v1"
+)
+
+// sigTypeCertificationRevocation is the type of user ID revocations, for which
+// golang.org/x/crypto/openpgp/packet has no constant.
+const sigTypeCertificationRevocation = 0x30
+
+// Builder generates RSA keys of a given structure, with real signatures, so
+// that tests of edge cases need not depend on fixtures having just the right
+// packets.
+//
+// The user IDs of a built key are "User 1 <user1@example.com>" and so on, the
+// first being primary. Every user ID and subkey is self-signed, and revoked,
+// expired or duplicated as specified.
+type Builder struct {
+	// UserIDs and SubKeys are the numbers of user IDs and encryption
+	// subkeys.
+	UserIDs int
+	SubKeys int
+
+	// Bits is the RSA modulus length of each key, 1024 if zero.
+	Bits int
+
+	// Created is the creation time of the keys and of all signatures, the
+	// current time if zero.
+	Created time.Time
+
+	// Lifetime, if non-zero, is the validity period of the primary key
+	// from its creation, as given in its self-signatures. A key with a
+	// short lifetime created in the past is expired.
+	Lifetime time.Duration
+
+	// Revoked adds a revocation of the primary key.
+	Revoked bool
+
+	// RevokedUserIDs and RevokedSubKeys list the zero-based indexes of the
+	// user IDs and subkeys to revoke.
+	RevokedUserIDs []int
+	RevokedSubKeys []int
+
+	// ExpiredSubKeys lists the zero-based indexes of the subkeys to bind
+	// with a lifetime of one second.
+	ExpiredSubKeys []int
+
+	// RecertifiedSubKeys lists the zero-based indexes of the subkeys to
+	// revoke one second after their creation, and then bind again one
+	// second after that. The subkeys remain revoked.
+	RecertifiedSubKeys []int
+
+	// Duplicates writes every user ID and subkey, with its signatures,
+	// twice, as in keys which were mismerged.
+	Duplicates bool
+}
+
+// Build generates the key, returning its binary packets, and the entity
+// holding its private keys, with which tests can make further signatures.
+func (b *Builder) Build() ([]byte, *xopenpgp.Entity, error) {
+	created := b.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	created = created.Truncate(time.Second)
+	priv, err := b.generate(created)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	entity := &xopenpgp.Entity{
+		PrimaryKey: &priv.PublicKey,
+		PrivateKey: priv,
+		Identities: make(map[string]*xopenpgp.Identity),
+	}
+	newSig := func(sigType packet.SignatureType) *packet.Signature {
+		return &packet.Signature{
+			SigType:      sigType,
+			PubKeyAlgo:   priv.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: created,
+			IssuerKeyId:  &priv.KeyId,
+		}
+	}
+
+	var buf bytes.Buffer
+	err = priv.PublicKey.Serialize(&buf)
+	if err != nil {
+		return nil, nil, errgo.Mask(err)
+	}
+	if b.Revoked {
+		sig := newSig(packet.SigTypeKeyRevocation)
+		h := sig.Hash.New()
+		err = keySignatureHash(h, &priv.PublicKey)
+		if err == nil {
+			err = sig.Sign(h, priv, nil)
+		}
+		if err == nil {
+			err = sig.Serialize(&buf)
+		}
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+	}
+
+	for i := 0; i < b.UserIDs; i++ {
+		uid := packet.NewUserId(fmt.Sprintf("User %d", i+1), "", fmt.Sprintf("user%d@example.com", i+1))
+		sig := newSig(packet.SigTypePositiveCert)
+		primary := i == 0
+		sig.IsPrimaryId = &primary
+		sig.FlagsValid, sig.FlagSign, sig.FlagCertify = true, true, true
+		if b.Lifetime > 0 {
+			secs := uint32(b.Lifetime / time.Second)
+			sig.KeyLifetimeSecs = &secs
+		}
+		err = sig.SignUserId(uid.Id, &priv.PublicKey, priv, nil)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		sigs := []*packet.Signature{sig}
+		if contains(b.RevokedUserIDs, i) {
+			rev := newSig(sigTypeCertificationRevocation)
+			err = rev.SignUserId(uid.Id, &priv.PublicKey, priv, nil)
+			if err != nil {
+				return nil, nil, errgo.Mask(err)
+			}
+			sigs = append(sigs, rev)
+		}
+		entity.Identities[uid.Id] = &xopenpgp.Identity{
+			Name:          uid.Id,
+			UserId:        uid,
+			SelfSignature: sig,
+			Signatures:    sigs[1:],
+		}
+		err = writePackets(&buf, b.Duplicates, append([]packetSerializer{uid}, signatureSerializers(sigs)...))
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+	}
+
+	for i := 0; i < b.SubKeys; i++ {
+		subPriv, err := b.generate(created)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		subPriv.PublicKey.IsSubkey = true
+		sig := newSig(packet.SigTypeSubkeyBinding)
+		sig.FlagsValid, sig.FlagEncryptStorage, sig.FlagEncryptCommunications = true, true, true
+		if contains(b.ExpiredSubKeys, i) {
+			secs := uint32(1)
+			sig.KeyLifetimeSecs = &secs
+		}
+		err = sig.SignKey(&subPriv.PublicKey, priv, nil)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		sigs := []*packet.Signature{sig}
+		if contains(b.RevokedSubKeys, i) {
+			rev := newSig(packet.SigTypeSubkeyRevocation)
+			err = rev.SignKey(&subPriv.PublicKey, priv, nil)
+			if err != nil {
+				return nil, nil, errgo.Mask(err)
+			}
+			sigs = append(sigs, rev)
+		}
+		if contains(b.RecertifiedSubKeys, i) {
+			rev := newSig(packet.SigTypeSubkeyRevocation)
+			rev.CreationTime = created.Add(time.Second)
+			rebind := newSig(packet.SigTypeSubkeyBinding)
+			rebind.CreationTime = created.Add(2 * time.Second)
+			rebind.FlagsValid, rebind.FlagEncryptStorage, rebind.FlagEncryptCommunications = true, true, true
+			for _, sig := range []*packet.Signature{rev, rebind} {
+				err = sig.SignKey(&subPriv.PublicKey, priv, nil)
+				if err != nil {
+					return nil, nil, errgo.Mask(err)
+				}
+			}
+			sigs = append(sigs, rev, rebind)
+		}
+		entity.Subkeys = append(entity.Subkeys, xopenpgp.Subkey{
+			PublicKey:  &subPriv.PublicKey,
+			PrivateKey: subPriv,
+			Sig:        sig,
+		})
+		err = writePackets(&buf, b.Duplicates, append([]packetSerializer{&subPriv.PublicKey}, signatureSerializers(sigs)...))
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+	}
+	return buf.Bytes(), entity, nil
+}
+
+// Key builds the key and reads it, as openpgp.ReadKeys.
+func (b *Builder) Key() (*openpgp.PrimaryKey, error) {
+	data, _, err := b.Build()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var key *openpgp.PrimaryKey
+	for readKey := range openpgp.ReadKeys(bytes.NewReader(data)) {
+		if readKey.Error != nil && err == nil {
+			err = errgo.Mask(readKey.Error, errgo.Any)
+		} else if readKey.PrimaryKey != nil && key == nil {
+			key = readKey.PrimaryKey
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, openpgp.ErrNoPrimaryKey
+	}
+	return key, nil
+}
+
+// MustKey is as Key, but panics if the key cannot be built.
+func (b *Builder) MustKey() *openpgp.PrimaryKey {
+	key, err := b.Key()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func (b *Builder) generate(created time.Time) (*packet.PrivateKey, error) {
+	bits := b.Bits
+	if bits == 0 {
+		bits = 1024
+	}
+	k, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return packet.NewRSAPrivateKey(created, k), nil
+}
+
+// keySignatureHash writes the primary key to h, as hashed for a key
+// revocation.
+func keySignatureHash(h io.Writer, pk *packet.PublicKey) error {
+	var buf bytes.Buffer
+	err := pk.Serialize(&buf)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	op, err := packet.NewOpaqueReader(&buf).Next()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	pk.SerializeSignaturePrefix(h)
+	_, err = h.Write(op.Contents)
+	return errgo.Mask(err)
+}
+
+type packetSerializer interface {
+	Serialize(w io.Writer) error
+}
+
+func signatureSerializers(sigs []*packet.Signature) []packetSerializer {
+	result := make([]packetSerializer, len(sigs))
+	for i, sig := range sigs {
+		result[i] = sig
+	}
+	return result
+}
+
+// writePackets writes the packets, twice over if dup is set.
+func writePackets(w io.Writer, dup bool, packets []packetSerializer) error {
+	n := 1
+	if dup {
+		n = 2
+	}
+	for ; n > 0; n-- {
+		for _, p := range packets {
+			err := p.Serialize(w)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	}
+	return nil
+}
+
+func contains(indexes []int, i int) bool {
+	for _, j := range indexes {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package testkeys
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/schmorrison/openpgp.v1"
+)
+
+// CheckRoundTrip reads the keys in binary input, read with the given options,
+// and checks that each is stable when written out and read back: that its
+// digest is that of its packets, and that writing and reading it again yields
+// the same packets and digest, both as read and once duplicate packets have
+// been dropped. It returns an error describing the first discrepancy found.
+// Options with which keys are not digested, such as IndexOnly, must not be
+// given.
+//
+// Changes to packet handling which alter the digests of existing keys, such as
+// the historical mishandling of duplicate signatures, fail this check.
+func CheckRoundTrip(data []byte, opts ...openpgp.ReadOption) error {
+	var keys []*openpgp.PrimaryKey
+	var err error
+	for readKey := range openpgp.ReadKeys(bytes.NewReader(data), opts...) {
+		if readKey.Error != nil && err == nil {
+			err = errgo.Notef(readKey.Error, "cannot read key")
+		} else if readKey.PrimaryKey != nil {
+			keys = append(keys, readKey.PrimaryKey)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errgo.New("no keys found")
+	}
+	for _, key := range keys {
+		err := checkKeyRoundTrip(key, opts)
+		if err != nil {
+			return errgo.Notef(err, "key %s", key.Fingerprint())
+		}
+	}
+	return nil
+}
+
+// CheckArmoredRoundTrip is as CheckRoundTrip, for armored input.
+func CheckArmoredRoundTrip(armored []byte, opts ...openpgp.ReadOption) error {
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil {
+		return errgo.Notef(err, "cannot decode armor")
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return errgo.Notef(err, "cannot decode armor")
+	}
+	return CheckRoundTrip(data, opts...)
+}
+
+// AssertRoundTrip fails the test if CheckArmoredRoundTrip fails for the sample
+// with the given name.
+func AssertRoundTrip(t testing.TB, name string, opts ...openpgp.ReadOption) {
+	t.Helper()
+	f := MustInput(name)
+	defer f.Close()
+	armored, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	err = CheckArmoredRoundTrip(armored, opts...)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func checkKeyRoundTrip(key *openpgp.PrimaryKey, opts []openpgp.ReadOption) error {
+	digest, err := openpgp.SksDigest(key, md5.New())
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if digest != key.MD5 {
+		return errgo.Newf("digest %s as read, but %s of its packets", key.MD5, digest)
+	}
+	written, again, err := rewrite(key, opts)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if again.MD5 != key.MD5 {
+		return errgo.Newf("digest %s as read, but %s when read back", key.MD5, again.MD5)
+	}
+
+	err = openpgp.DropDuplicates(again)
+	if err != nil {
+		return errgo.Notef(err, "cannot drop duplicates")
+	}
+	_, deduped, err := rewrite(again, opts)
+	if err != nil {
+		return errgo.Notef(err, "without duplicates")
+	}
+	if deduped.MD5 != again.MD5 {
+		return errgo.Newf("digest %s without duplicates, but %s when read back", again.MD5, deduped.MD5)
+	}
+	if again.MD5 == key.MD5 {
+		var buf bytes.Buffer
+		err = openpgp.WritePackets(&buf, again)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if !bytes.Equal(buf.Bytes(), written) {
+			return errgo.New("packets changed by dropping duplicates, but digest did not")
+		}
+	}
+	return nil
+}
+
+// rewrite writes the key and reads it back, checking that doing so again
+// writes the same packets. It returns the packets written and the key read.
+func rewrite(key *openpgp.PrimaryKey, opts []openpgp.ReadOption) ([]byte, *openpgp.PrimaryKey, error) {
+	var buf bytes.Buffer
+	err := openpgp.WritePackets(&buf, key)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot write key")
+	}
+	written := buf.Bytes()
+	var result []*openpgp.PrimaryKey
+	for readKey := range openpgp.ReadKeys(bytes.NewReader(written), opts...) {
+		if readKey.Error != nil && err == nil {
+			err = errgo.Notef(readKey.Error, "cannot read key back")
+		} else if readKey.PrimaryKey != nil {
+			result = append(result, readKey.PrimaryKey)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(result) != 1 {
+		return nil, nil, errgo.Newf("%d keys read back", len(result))
+	}
+	again := result[0]
+	if again.RFingerprint != key.RFingerprint {
+		return nil, nil, errgo.Newf("key %s read back", again.Fingerprint())
+	}
+	var rewritten bytes.Buffer
+	err = openpgp.WritePackets(&rewritten, again)
+	if err != nil {
+		return nil, nil, errgo.Notef(err, "cannot write key read back")
+	}
+	if !bytes.Equal(rewritten.Bytes(), written) {
+		return nil, nil, errgo.New("packets changed when read back")
+	}
+	return written, again, nil
+}
@@ -0,0 +1,89 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package testkeys provides sample keys, and helpers for reading them, for
+// use in the tests of packages which handle keys read with package openpgp,
+// such as storage backends and HKP handlers.
+package testkeys
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+
+	"gopkg.in/schmorrison/openpgp.v1"
+)
+
+// The sample keys are:
+//
+//	alice.asc          Alice <alice@example.com>, with an encryption subkey.
+//	bob_certified.asc  Bob <bob@example.com>, whose user ID is certified by Alice.
+//	keyring.asc        Alice's and Bob's keys, in one armored block.
+//
+//go:embed testdata/*.asc
+var fixtures embed.FS
+
+// Names returns the names of the sample keys, sorted.
+func Names() []string {
+	matches, err := fs.Glob(fixtures, "testdata/*")
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	for _, match := range matches {
+		names = append(names, path.Base(match))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MustInput opens the sample with the given name. It panics if there is no
+// such sample.
+func MustInput(name string) io.ReadCloser {
+	f, err := fixtures.Open(path.Join("testdata", name))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// MustReadArmorKeys reads keys from armored input, as openpgp.ReadArmorKeys.
+// It panics if the input is not armored.
+func MustReadArmorKeys(r io.Reader, opts ...openpgp.ReadOption) openpgp.PrimaryKeyChan {
+	return openpgp.MustReadArmorKeys(r, opts...)
+}
+
+// MustInputAscKeys returns the keys of the armored sample with the given name.
+// It panics if they cannot be read.
+func MustInputAscKeys(name string) []*openpgp.PrimaryKey {
+	f := MustInput(name)
+	defer f.Close()
+	return MustReadArmorKeys(f).MustParse()
+}
+
+// MustInputAscKey returns the key of the armored sample with the given name,
+// which must hold exactly one key.
+func MustInputAscKey(name string) *openpgp.PrimaryKey {
+	keys := MustInputAscKeys(name)
+	if len(keys) != 1 {
+		panic(fmt.Errorf("expected one key, got %d", len(keys)))
+	}
+	return keys[0]
+}
@@ -0,0 +1,144 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package testkeys
+
+import (
+	"bytes"
+	"io/ioutil"
+	stdtesting "testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/schmorrison/openpgp.v1"
+)
+
+func Test(t *stdtesting.T) { gc.TestingT(t) }
+
+type TestKeysSuite struct{}
+
+var _ = gc.Suite(&TestKeysSuite{})
+
+func (s *TestKeysSuite) TestSamples(c *gc.C) {
+	c.Assert(Names(), gc.DeepEquals, []string{"alice.asc", "bob_certified.asc", "keyring.asc"})
+	for _, name := range Names() {
+		keys := MustInputAscKeys(name)
+		c.Assert(keys, gc.Not(gc.HasLen), 0)
+		for _, key := range keys {
+			c.Assert(key.MD5, gc.Not(gc.Equals), "")
+			c.Assert(key.SubKeys, gc.HasLen, 1)
+		}
+	}
+
+	alice := MustInputAscKey("alice.asc")
+	c.Assert(alice.UserIDs[0].Keywords, gc.Equals, "Alice <alice@example.com>")
+	bob := MustInputAscKey("bob_certified.asc")
+	c.Assert(bob.UserIDs[0].Signatures, gc.HasLen, 2)
+	c.Assert(bob.UserIDs[0].Signatures[1].RIssuerKeyID, gc.Equals, alice.RKeyID)
+	c.Assert(MustInputAscKeys("keyring.asc"), gc.HasLen, 2)
+
+	c.Assert(func() { MustInputAscKey("keyring.asc") }, gc.PanicMatches, "expected one key, got 2")
+	c.Assert(func() { MustInput("missing.asc") }, gc.PanicMatches, ".*file does not exist")
+}
+
+func (s *TestKeysSuite) TestBuilder(c *gc.C) {
+	created := time.Now().Add(-time.Hour)
+	b := &Builder{
+		UserIDs:        2,
+		SubKeys:        2,
+		Created:        created,
+		Lifetime:       time.Minute,
+		RevokedUserIDs: []int{1},
+		RevokedSubKeys: []int{0},
+		ExpiredSubKeys: []int{1},
+	}
+	key := b.MustKey()
+	c.Assert(key.Creation.Unix(), gc.Equals, created.Unix())
+	c.Assert(key.UserIDs, gc.HasLen, 2)
+	c.Assert(key.UserIDs[0].Keywords, gc.Equals, "User 1 <user1@example.com>")
+	c.Assert(key.SubKeys, gc.HasLen, 2)
+
+	ss := key.UserIDs[0].SelfSigs(key)
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Primaries, gc.HasLen, 1)
+	expires, ok := ss.ExpiresAt()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expires.Unix(), gc.Equals, created.Add(time.Minute).Unix())
+	c.Assert(ss.Valid(), gc.Equals, false)
+	c.Assert(key.UserIDs[1].SelfSigs(key).Revocations, gc.HasLen, 1)
+
+	ss = key.SubKeys[0].SelfSigs(key)
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Revocations, gc.HasLen, 1)
+	ss = key.SubKeys[1].SelfSigs(key)
+	c.Assert(ss.Revocations, gc.HasLen, 0)
+	expires, ok = ss.ExpiresAt()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expires.Unix(), gc.Equals, created.Add(time.Second).Unix())
+	c.Assert(key.SelfSigs().Revocations, gc.HasLen, 0)
+
+	key = (&Builder{SubKeys: 2, RecertifiedSubKeys: []int{1}}).MustKey()
+	c.Assert(key.SubKeys, gc.HasLen, 2)
+	ss = key.SubKeys[1].SelfSigs(key)
+	c.Assert(ss.Revocations, gc.HasLen, 1)
+	c.Assert(key.SubKeys[1].Signatures, gc.HasLen, 3)
+	c.Assert(key.SubKeys[1].Status(key), gc.Equals, openpgp.SubKeyRevoked)
+	valid := key.ValidSubKeys()
+	c.Assert(valid, gc.HasLen, 1)
+	c.Assert(valid[0].UUID, gc.Equals, key.SubKeys[0].UUID)
+
+	key = (&Builder{Revoked: true}).MustKey()
+	c.Assert(key.UserIDs, gc.HasLen, 0)
+	c.Assert(key.SelfSigs().Revocations, gc.HasLen, 1)
+
+	data, entity, err := (&Builder{UserIDs: 1, SubKeys: 1, Duplicates: true}).Build()
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.PrivateKey, gc.NotNil)
+	c.Assert(entity.Subkeys, gc.HasLen, 1)
+	keys := openpgp.ReadKeys(bytes.NewReader(data)).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	c.Assert(keys[0].UserIDs, gc.HasLen, 2)
+	c.Assert(keys[0].SubKeys, gc.HasLen, 2)
+	c.Assert(openpgp.DropDuplicates(keys[0]), gc.IsNil)
+	c.Assert(keys[0].UserIDs, gc.HasLen, 1)
+	c.Assert(keys[0].SubKeys, gc.HasLen, 1)
+	c.Assert(keys[0].UserIDs[0].Signatures, gc.HasLen, 1)
+}
+
+func (s *TestKeysSuite) TestCheckRoundTrip(c *gc.C) {
+	for _, name := range Names() {
+		f := MustInput(name)
+		armored, err := ioutil.ReadAll(f)
+		f.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(CheckArmoredRoundTrip(armored), gc.IsNil, gc.Commentf("%s", name))
+	}
+	data, _, err := (&Builder{UserIDs: 2, SubKeys: 1, RevokedUserIDs: []int{0}, Duplicates: true}).Build()
+	c.Assert(err, gc.IsNil)
+	c.Assert(CheckRoundTrip(data), gc.IsNil)
+
+	c.Assert(CheckRoundTrip(nil), gc.ErrorMatches, "cannot read key: EOF")
+	c.Assert(CheckRoundTrip(data, openpgp.IndexOnly()), gc.ErrorMatches, "key [0-9a-f]+: digest  as read, but [0-9a-f]+ of its packets")
+	c.Assert(CheckArmoredRoundTrip(data), gc.ErrorMatches, "cannot decode armor: .*")
+}
+
+func TestAssertRoundTrip(t *stdtesting.T) {
+	for _, name := range Names() {
+		AssertRoundTrip(t, name)
+	}
+}
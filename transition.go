@@ -0,0 +1,259 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"sort"
+)
+
+// NotationPredecessor is the name of a notation on a direct-key
+// self-signature, whose value is the hex-encoded fingerprint of a key the
+// signing key replaces.
+const NotationPredecessor = "predecessor@hockeypuck.io"
+
+// NotationSupersededBy is the name of a notation on a direct-key
+// self-signature, whose value is the hex-encoded fingerprint of the key by
+// which the owner has replaced the signing key.
+const NotationSupersededBy = "superseded-by@hockeypuck.io"
+
+// parseFingerprintNotation returns the reversed fingerprint given by the value
+// of a notation, which may be prefixed with "0x" and grouped by spaces.
+func parseFingerprintNotation(value []byte) (string, bool) {
+	rfp, err := ParseRFingerprint(string(value))
+	if err != nil || len(rfp) == 32 {
+		return "", false
+	}
+	return rfp, true
+}
+
+// fingerprintNotations returns the reversed fingerprints given by the notations
+// of the given name on the most recent valid direct-key self-signature, which
+// supersedes any made before it.
+func (s *SelfSigs) fingerprintNotations(name string) []string {
+	if len(s.DirectKeys) == 0 {
+		return nil
+	}
+	var result []string
+	for _, notation := range s.DirectKeys[0].Signature.Notations() {
+		if notation.Name != name {
+			continue
+		}
+		if rfp, ok := parseFingerprintNotation(notation.Value); ok {
+			result = append(result, rfp)
+		}
+	}
+	return result
+}
+
+// predecessors returns the reversed fingerprints of the keys named as
+// predecessors of a key.
+func (s *SelfSigs) predecessors() []string {
+	return s.fingerprintNotations(NotationPredecessor)
+}
+
+// SupersededBy returns the reversed fingerprint of the key named by a
+// NotationSupersededBy notation on the most recent direct-key self-signature,
+// if any, as the replacement of the key.
+func (s *SelfSigs) SupersededBy() (string, bool) {
+	rfps := s.fingerprintNotations(NotationSupersededBy)
+	if len(rfps) == 0 {
+		return "", false
+	}
+	return rfps[0], true
+}
+
+// Transition is a statement that one key has been replaced by another, newer
+// key of the same owner.
+type Transition struct {
+	// Predecessor and Successor are the reversed fingerprints of the old key
+	// and the new.
+	Predecessor string
+	Successor   string
+
+	// Certified is set if the predecessor certifies a user ID of the
+	// successor which it shares, and CounterCertified if the successor
+	// certifies a shared user ID of the predecessor. Only certifications
+	// verified by the certifying key count, as for the edges of a Graph.
+	// Keys of others with other user IDs are not thereby transitions.
+	Certified        bool
+	CounterCertified bool
+
+	// Notation is set if the successor names the predecessor by a
+	// NotationPredecessor notation, and Superseded if the predecessor names
+	// the successor by a NotationSupersededBy notation.
+	Notation   bool
+	Superseded bool
+}
+
+// Confirmed returns whether the transition is vouched for by the predecessor,
+// by a certification verified by its key or by its own notation, rather than
+// claimed by the successor alone, which anyone can do.
+func (t *Transition) Confirmed() bool {
+	return t.Certified || t.Superseded
+}
+
+type transitionsByKey []*Transition
+
+func (s transitionsByKey) Len() int { return len(s) }
+
+func (s transitionsByKey) Less(i, j int) bool {
+	if s[i].Successor != s[j].Successor {
+		return s[i].Successor < s[j].Successor
+	}
+	return s[i].Predecessor < s[j].Predecessor
+}
+
+func (s transitionsByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (n *graphNode) hasUserID(uid int32) bool {
+	for _, id := range n.uids {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// transitions returns the transitions involving the key at node: those from
+// it to newer keys if forward is set, or from older keys to it if not.
+func (g *Graph) transitions(node int32, forward bool) []*Transition {
+	links := make(map[int32]*Transition)
+	link := func(other int32) *Transition {
+		old, cur := node, other
+		if !forward {
+			old, cur = other, node
+		}
+		if g.nodes[cur].created <= g.nodes[old].created {
+			return nil
+		}
+		t, ok := links[other]
+		if !ok {
+			t = &Transition{Predecessor: g.nodes[old].rfp, Successor: g.nodes[cur].rfp}
+			links[other] = t
+		}
+		return t
+	}
+
+	// Certifications issued by the key, of user IDs it shares.
+	for _, ei := range g.nodes[node].out {
+		e := g.edges[ei]
+		if !g.nodes[node].hasUserID(e.uid) {
+			continue
+		}
+		if t := link(e.target); t != nil {
+			t.Certified = t.Certified || forward
+			t.CounterCertified = t.CounterCertified || !forward
+		}
+	}
+	// Certifications of the key by those sharing the user ID.
+	for _, ei := range g.nodes[node].in {
+		e := g.edges[ei]
+		other := e.from
+		if other == node || !g.nodes[other].hasUserID(e.uid) {
+			continue
+		}
+		if t := link(other); t != nil {
+			t.Certified = t.Certified || !forward
+			t.CounterCertified = t.CounterCertified || forward
+		}
+	}
+
+	// Notations by the newer key naming the older, and the older naming the
+	// newer.
+	if forward {
+		for _, other := range g.claims[g.nodes[node].rfp] {
+			if t := link(other); t != nil {
+				t.Notation = true
+			}
+		}
+		if other, ok := g.byFP[g.nodes[node].supersededBy]; ok {
+			if t := link(other); t != nil {
+				t.Superseded = true
+			}
+		}
+	} else {
+		for _, rfp := range g.nodes[node].predecessors {
+			if other, ok := g.byFP[rfp]; ok {
+				if t := link(other); t != nil {
+					t.Notation = true
+				}
+			}
+		}
+		for _, other := range g.supersessions[g.nodes[node].rfp] {
+			if t := link(other); t != nil {
+				t.Superseded = true
+			}
+		}
+	}
+
+	var result []*Transition
+	for _, t := range links {
+		result = append(result, t)
+	}
+	sort.Sort(transitionsByKey(result))
+	return result
+}
+
+// Successors returns the transitions from the key with the given reversed
+// fingerprint to newer keys in the graph.
+func (g *Graph) Successors(rfp string) []*Transition {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	return g.transitions(node, true)
+}
+
+// Predecessors returns the transitions to the key with the given reversed
+// fingerprint from older keys in the graph.
+func (g *Graph) Predecessors(rfp string) []*Transition {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return nil
+	}
+	return g.transitions(node, false)
+}
+
+// Current returns the reversed fingerprint of the key which has replaced the
+// key with the given reversed fingerprint, following confirmed transitions to
+// successors which have not been revoked, the most recently created first.
+// The key itself is returned if it has not been replaced, and the empty string
+// if it is not in the graph.
+func (g *Graph) Current(rfp string) string {
+	node, ok := g.byFP[rfp]
+	if !ok {
+		return ""
+	}
+	// Successors are newer, so the search cannot cycle.
+	for {
+		next := int32(-1)
+		for _, t := range g.transitions(node, true) {
+			succ := g.byFP[t.Successor]
+			if !t.Confirmed() || g.nodes[succ].revoked {
+				continue
+			}
+			if next < 0 || g.nodes[succ].created > g.nodes[next].created {
+				next = succ
+			}
+		}
+		if next < 0 {
+			return g.nodes[node].rfp
+		}
+		node = next
+	}
+}
@@ -0,0 +1,174 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// trustScope returns a single regular expression matching the user IDs
+// matched by any of those of a trust signature, or the empty string if there
+// are none.
+func trustScope(regexps []string) string {
+	switch len(regexps) {
+	case 0:
+		return ""
+	case 1:
+		return regexps[0]
+	}
+	alts := make([]string, len(regexps))
+	for i, re := range regexps {
+		alts[i] = "(?:" + re + ")"
+	}
+	return strings.Join(alts, "|")
+}
+
+// Coverage is a user ID covered by the delegation of a trust root.
+type Coverage struct {
+	// Target is the reversed fingerprint of the key bearing the user ID.
+	Target string
+	UserID string
+
+	// Depth is the number of introducers between the root and the
+	// certification of the user ID, zero if the root certifies it directly.
+	Depth int
+
+	// Amount is the least trust amount of the trust signatures designating
+	// those introducers, or 120, complete trust, if there are none.
+	Amount int
+}
+
+type coverageByKey []*Coverage
+
+func (s coverageByKey) Len() int { return len(s) }
+
+func (s coverageByKey) Less(i, j int) bool {
+	if s[i].Target != s[j].Target {
+		return s[i].Target < s[j].Target
+	}
+	return s[i].UserID < s[j].UserID
+}
+
+func (s coverageByKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+type delegationStep struct {
+	node   int32
+	depth  int
+	hops   int
+	amount int
+
+	// scopes are the interned regular expressions, plus one, which limit
+	// the trust signatures leading to the introducer.
+	scopes []int32
+}
+
+// delegationKey identifies an introducer as reached under a set of scopes.
+func delegationKey(step delegationStep) string {
+	return fmt.Sprint(step.node, step.scopes)
+}
+
+// Delegation returns the user IDs covered by the delegation of the trust root
+// with reversed fingerprint root, such as the key of an organizational
+// certification authority: those it certifies itself, and those certified by
+// the introducers it designates by trust signatures, to the depth they allow
+// and within the user IDs their regular expressions match. Each user ID is
+// reported once, as reached by the fewest introducers, ordered by key and
+// user ID. Revoked keys and expired certifications are not followed, and
+// only certifications verified by their issuers' keys are in the graph, so
+// that a delegation cannot be forged in the root's name.
+//
+// Regular expressions are evaluated with the regexp package, whose syntax
+// extends that required of trust signatures. One which fails to compile
+// matches nothing.
+func (g *Graph) Delegation(root string) []*Coverage {
+	src, ok := g.byFP[root]
+	if !ok || g.nodes[src].revoked {
+		return nil
+	}
+	t := uint32(now().Unix())
+	compiled := make(map[int32]*regexp.Regexp)
+	matches := func(scopes []int32, uid int32) bool {
+		for _, scope := range scopes {
+			re, ok := compiled[scope]
+			if !ok {
+				re, _ = regexp.Compile(g.strings[scope-1])
+				compiled[scope] = re
+			}
+			if re == nil || !re.MatchString(g.strings[uid]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	type covered struct{ node, uid int32 }
+	seen := make(map[covered]bool)
+	var result []*Coverage
+	// visited holds the greatest depth with which each introducer has been
+	// reached under a given set of scopes.
+	visited := make(map[string]int)
+	queue := []delegationStep{{node: src, depth: 256, amount: 120}}
+	for i := 0; i < len(queue); i++ {
+		cur := queue[i]
+		for _, ei := range g.nodes[cur.node].out {
+			e := g.edges[ei]
+			if e.target == src || g.nodes[e.target].revoked || (e.expires != 0 && e.expires <= t) {
+				continue
+			}
+			if !matches(cur.scopes, e.uid) {
+				continue
+			}
+			if k := (covered{e.target, e.uid}); !seen[k] {
+				seen[k] = true
+				result = append(result, &Coverage{
+					Target: g.nodes[e.target].rfp,
+					UserID: g.strings[e.uid],
+					Depth:  cur.hops,
+					Amount: cur.amount,
+				})
+			}
+
+			depth := cur.depth - 1
+			if int(e.level) < depth {
+				depth = int(e.level)
+			}
+			if depth < 1 {
+				continue
+			}
+			next := delegationStep{node: e.target, depth: depth, hops: cur.hops + 1, amount: cur.amount}
+			if int(e.amount) < next.amount {
+				next.amount = int(e.amount)
+			}
+			next.scopes = cur.scopes
+			if e.scope != 0 {
+				next.scopes = append(append([]int32(nil), cur.scopes...), e.scope)
+			}
+			key := delegationKey(next)
+			if prev, ok := visited[key]; ok && prev >= depth {
+				continue
+			}
+			visited[key] = depth
+			queue = append(queue, next)
+		}
+	}
+	sort.Sort(coverageByKey(result))
+	return result
+}
@@ -0,0 +1,100 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+// Path returns a shortest certification path, of at most maxLen
+// certifications, from the key with reversed fingerprint from to the key with
+// reversed fingerprint to, or nil if there is none. A maxLen of zero or less
+// places no bound on the length of the path. A key is trivially reached from
+// itself by an empty path.
+//
+// The key from is taken as the root of trust. Every other key along the path
+// must have been designated a trusted introducer by the certification
+// reaching it: a trust signature of level n permits n further certifications
+// to follow it, limited in turn by the depth remaining to its issuer. Keys
+// which have been revoked, and certifications which have expired, are not
+// followed, and certifications revoked by their issuers are not in the graph.
+func (g *Graph) Path(from, to string, maxLen int) []*Certification {
+	src, ok := g.byFP[from]
+	if !ok || g.nodes[src].revoked {
+		return nil
+	}
+	dst, ok := g.byFP[to]
+	if !ok || g.nodes[dst].revoked {
+		return nil
+	}
+	if src == dst {
+		return []*Certification{}
+	}
+	if maxLen <= 0 || maxLen > len(g.nodes) {
+		maxLen = len(g.nodes)
+	}
+	t := uint32(now().Unix())
+
+	// steps is the breadth-first search queue, which records how each key
+	// was reached; depths holds the greatest introducer depth with which each
+	// key has been reached so far. A key is searched again if it is reached
+	// by a longer path with a greater depth remaining.
+	steps := []pathStep{{node: src, depth: maxLen, edge: -1, prev: -1}}
+	depths := map[int32]int{src: maxLen}
+	for i := 0; i < len(steps); i++ {
+		cur := steps[i]
+		for _, ei := range g.nodes[cur.node].out {
+			e := g.edges[ei]
+			if g.nodes[e.target].revoked || (e.expires != 0 && e.expires <= t) {
+				continue
+			}
+			if e.target == dst {
+				return g.path(steps, i, ei)
+			}
+			depth := cur.depth - 1
+			if int(e.level) < depth {
+				depth = int(e.level)
+			}
+			if depth < 1 {
+				continue
+			}
+			if prev, ok := depths[e.target]; ok && prev >= depth {
+				continue
+			}
+			depths[e.target] = depth
+			steps = append(steps, pathStep{node: e.target, depth: depth, edge: ei, prev: i})
+		}
+	}
+	return nil
+}
+
+type pathStep struct {
+	node  int32
+	depth int
+	edge  int32
+	prev  int
+}
+
+// path returns the certifications along the search steps leading to step i,
+// followed by the final edge.
+func (g *Graph) path(steps []pathStep, i int, last int32) []*Certification {
+	result := []*Certification{g.certification(last)}
+	for ; steps[i].edge >= 0; i = steps[i].prev {
+		result = append(result, g.certification(steps[i].edge))
+	}
+	for l, r := 0, len(result)-1; l < r; l, r = l+1, r-1 {
+		result[l], result[r] = result[r], result[l]
+	}
+	return result
+}
@@ -26,11 +26,9 @@ package openpgp
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"errors"
 
 	"golang.org/x/crypto/openpgp/packet"
-	"gopkg.in/basen.v1"
 	"gopkg.in/errgo.v1"
 )
 
@@ -57,22 +55,34 @@ type Packet struct {
 
 	// Packet contains the raw packet bytes.
 	Packet []byte
+
+	// Origin records where the packet was read from, and how it was
+	// framed there, if it was parsed from input. It is nil otherwise.
+	Origin *PacketOrigin
+
+	// shared indicates that Packet is a slice of a buffer shared with the
+	// other packets of the key it was parsed from.
+	shared bool
 }
 
 const packetTag = "{other}"
 
 func ParseOther(op *packet.OpaquePacket, parentID string) (*Packet, error) {
-	var buf bytes.Buffer
-	err := op.Serialize(&buf)
+	return parseOther(op, parentID, nil)
+}
+
+func parseOther(op *packet.OpaquePacket, parentID string, arena *packetArena) (*Packet, error) {
+	buf, err := arena.serialize(op)
 	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 
 	return &Packet{
-		UUID:   scopedDigest([]string{parentID}, packetTag, buf.Bytes()),
+		UUID:   scopedDigest([]string{parentID}, packetTag, buf),
 		Tag:    op.Tag,
-		Packet: buf.Bytes(),
+		Packet: buf,
 		Parsed: false,
+		shared: arena != nil,
 	}, nil
 }
 
@@ -139,9 +149,28 @@ func (ps packetSlice) without(target *Packet) []*Packet {
 	return result
 }
 
+// newOpaquePacket returns the first packet framed in buf, whose contents
+// refer to buf rather than a copy.
 func newOpaquePacket(buf []byte) (*packet.OpaquePacket, error) {
-	r := packet.NewOpaqueReader(bytes.NewBuffer(buf))
-	return r.Next()
+	return readOpaquePacketAt(buf, bytes.NewReader(buf))
+}
+
+// serializedLen returns the length of an opaque packet as written by its
+// Serialize method, with a new format header.
+func serializedLen(op *packet.OpaquePacket) int64 {
+	return framedLen(int64(len(op.Contents)))
+}
+
+// framedLen returns the length of a packet with n octets of contents, with a
+// new format header.
+func framedLen(n int64) int64 {
+	switch {
+	case n < 192:
+		return n + 2
+	case n < 8384:
+		return n + 3
+	}
+	return n + 6
 }
 
 type opaquePacketSlice []*packet.OpaquePacket
@@ -155,21 +184,24 @@ func (ps opaquePacketSlice) Swap(i, j int) {
 }
 
 func (ps opaquePacketSlice) Less(i, j int) bool {
-	cmp := int32(ps[i].Tag) - int32(ps[j].Tag)
-	if cmp < 0 {
-		return true
-	} else if cmp > 0 {
-		return false
+	return ComparePackets(ps[i], ps[j]) < 0
+}
+
+// ComparePackets compares two packets in the canonical order in which they are
+// digested by SksDigest: by tag, and then by contents, compared octet by
+// octet. It returns -1 if a precedes b, 1 if b precedes a, and 0 if the
+// packets are identical. This order is fixed by compatibility with SKS, and
+// will not change.
+func ComparePackets(a, b *packet.OpaquePacket) int {
+	switch {
+	case a.Tag < b.Tag:
+		return -1
+	case a.Tag > b.Tag:
+		return 1
 	}
-	return bytes.Compare(ps[i].Contents, ps[j].Contents) < 0
+	return bytes.Compare(a.Contents, b.Contents)
 }
 
 func scopedDigest(parents []string, tag string, packet []byte) string {
-	h := sha256.New()
-	for i := range parents {
-		h.Write([]byte(parents[i]))
-		h.Write([]byte(tag))
-	}
-	h.Write(packet)
-	return basen.Base58.EncodeToString(h.Sum(nil))
+	return uuidScheme.UUID(parents, tag, packet)
 }
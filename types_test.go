@@ -18,7 +18,20 @@
 package openpgp
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
 )
 
 type TypesSuite struct{}
@@ -77,3 +90,526 @@ func (s *TypesSuite) TestIterOpaque(c *gc.C) {
 	c.Assert(1, gc.Equals, len(key.SubKeys[0].Signatures))
 	c.Assert(4, gc.Equals, len(hits))
 }
+
+func (s *TypesSuite) TestPacketArena(c *gc.C) {
+	ops := []*packet.OpaquePacket{
+		{Tag: 13, Contents: []byte("alice")},
+		{Tag: 13, Contents: []byte("bob")},
+	}
+	arena := newPacketArena(ops)
+	var bufs [][]byte
+	for _, op := range ops {
+		buf, err := arena.serialize(op)
+		c.Assert(err, gc.IsNil)
+		c.Assert(cap(buf), gc.Equals, len(buf))
+		bufs = append(bufs, buf)
+	}
+	// Appending to one slice must not clobber its neighbour.
+	_ = append(bufs[0], 0xff)
+	op, err := newOpaquePacket(bufs[1])
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(op.Contents), gc.Equals, "bob")
+
+	p := &Packet{Packet: bufs[0], shared: true}
+	p.Detach()
+	c.Assert(p.shared, gc.Equals, false)
+	c.Assert(p.Packet, gc.DeepEquals, bufs[0])
+	c.Assert(&p.Packet[0] != &bufs[0][0], gc.Equals, true)
+}
+
+// nestedSignature returns V4 signature packet contents with signatures
+// embedded depth levels deep.
+func nestedSignature(depth int) []byte {
+	// version, type, public key and hash algorithms, and empty subpacket
+	// areas.
+	sig := []byte{4, 0x19, 1, 8, 0, 0, 0, 0}
+	for i := 0; i < depth; i++ {
+		sub := append([]byte{byte(len(sig) + 1), 32}, sig...)
+		sig = append([]byte{4, 0x18, 1, 8, 0, byte(len(sub))}, sub...)
+		sig = append(sig, 0, 0)
+	}
+	return sig
+}
+
+func (s *TypesSuite) TestNestingDepth(c *gc.C) {
+	c.Assert(checkNesting(nestedSignature(MaxNestingDepth), 0), gc.IsNil)
+	err := checkNesting(nestedSignature(MaxNestingDepth+1), 0)
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNestingTooDeep)
+
+	sig := &Signature{}
+	err = sig.parse(&packet.OpaquePacket{Tag: 2, Contents: nestedSignature(MaxNestingDepth + 1)})
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNestingTooDeep)
+	err = sig.scan(nestedSignature(MaxNestingDepth + 1))
+	c.Assert(errgo.Cause(err), gc.Equals, ErrNestingTooDeep)
+}
+
+// scannedSignature returns V4 signature packet contents with the given hashed
+// and unhashed subpacket areas and an RSA signature MPI.
+func scannedSignature(hashed, unhashed []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{4, 0x10, 1, 8})
+	binary.Write(&buf, binary.BigEndian, uint16(len(hashed)))
+	buf.Write(hashed)
+	binary.Write(&buf, binary.BigEndian, uint16(len(unhashed)))
+	buf.Write(unhashed)
+	buf.Write([]byte{0xab, 0xcd, 0, 9, 1, 0x23})
+	return buf.Bytes()
+}
+
+func (s *TypesSuite) TestScanAsParse(c *gc.C) {
+	creation := sigSubpacket(2, []byte{0x5f, 0, 0, 0})
+	issuer := sigSubpacket(16, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	valid := scannedSignature(creation, issuer)
+	backSig := []byte{4, 0x19, 1, 8, 0, 6, 5, 2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 1}
+	critical := sigSubpacket(0x80|100, nil)
+	attested := sigSubpacket(0x80|37, make([]byte, 32))
+	for i, test := range []struct {
+		contents []byte
+		ok       bool
+	}{
+		{valid, true},
+		{scannedSignature(append(creation, attested...), issuer), true},
+		{scannedSignature(append(creation, sigSubpacket(32, backSig)...), issuer), true},
+		// An issuer of seven octets.
+		{scannedSignature(creation, sigSubpacket(16, []byte{1, 2, 3, 4, 5, 6, 7})), false},
+		// An unknown critical subpacket, in either area.
+		{scannedSignature(append(creation, critical...), issuer), false},
+		{scannedSignature(creation, append(issuer, critical...)), false},
+		// Attested certifications are only understood when hashed.
+		{scannedSignature(creation, append(issuer, attested...)), false},
+		// A creation time only in the unhashed area.
+		{scannedSignature(nil, append(issuer, creation...)), false},
+		// Malformed embedded signatures.
+		{scannedSignature(append(creation, sigSubpacket(32, backSig[:len(backSig)-1])...), issuer), false},
+		{scannedSignature(append(creation, sigSubpacket(32, append([]byte{4, 0x18}, backSig[2:]...))...), issuer), false},
+		{scannedSignature(append(creation, append(sigSubpacket(32, backSig), sigSubpacket(32, backSig)...)...), issuer), false},
+		// An unknown hash function and public key algorithm.
+		{append([]byte{4, 0x10, 1, 4}, valid[4:]...), false},
+		{append([]byte{4, 0x10, 22, 8}, valid[4:]...), false},
+		// A truncated MPI.
+		{valid[:len(valid)-1], false},
+		// Unknown versions.
+		{append([]byte{5}, valid[1:]...), false},
+	} {
+		c.Logf("test %d", i)
+		op := &packet.OpaquePacket{Tag: 2, Contents: test.contents}
+		_, parseErr := ParseSignature(op, "", "")
+		_, scanErr := parseSignature(op, "", "", nil, true)
+		c.Check(parseErr == nil, gc.Equals, test.ok, gc.Commentf("%v", parseErr))
+		c.Check(scanErr == nil, gc.Equals, test.ok, gc.Commentf("%v", scanErr))
+	}
+
+	// However the contents are mangled, the scan and the parse agree.
+	contents := scannedSignature(append(creation, sigSubpacket(32, backSig)...), issuer)
+	for i := range contents {
+		for _, b := range []byte{0, 1, 0x7f, 0xff, contents[i] ^ 0x80, contents[i] + 1} {
+			mangled := append([]byte(nil), contents...)
+			mangled[i] = b
+			op := &packet.OpaquePacket{Tag: 2, Contents: mangled}
+			_, parseErr := ParseSignature(op, "", "")
+			_, scanErr := parseSignature(op, "", "", nil, true)
+			c.Assert(scanErr == nil, gc.Equals, parseErr == nil,
+				gc.Commentf("octet %d set to %d: parse %v, scan %v", i, b, parseErr, scanErr))
+		}
+	}
+
+	// A malformed signature read with a key is set aside, just as
+	// ParseSignature rejects it.
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	c.Assert(packet.NewUserId("Alice", "", "").Serialize(&buf), gc.IsNil)
+	malformed := &packet.OpaquePacket{Tag: 2, Contents: scannedSignature(creation, sigSubpacket(16, []byte{1, 2, 3, 4, 5, 6, 7}))}
+	c.Assert(malformed.Serialize(&buf), gc.IsNil)
+	key := ReadKeys(&buf).MustParse()[0]
+	c.Assert(key.UserIDs, gc.HasLen, 1)
+	c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 0)
+	c.Assert(key.UserIDs[0].Others, gc.HasLen, 1)
+}
+
+func (s *TypesSuite) TestMerkleTree(c *gc.C) {
+	for n := 0; n < 9; n++ {
+		var leaves []MerkleLeaf
+		for i := n - 1; i >= 0; i-- {
+			leaves = append(leaves, MerkleLeaf{
+				Fingerprint: fmt.Sprintf("%040x", i),
+				Digest:      fmt.Sprintf("%032x", i*i),
+			})
+		}
+		t := NewMerkleTree(leaves)
+		root := t.Root()
+		for i := 0; i < n; i++ {
+			proof, ok := t.Proof(fmt.Sprintf("%040x", i))
+			c.Assert(ok, gc.Equals, true)
+			c.Assert(proof.Verify(root), gc.Equals, true)
+			proof.Leaf.Digest = "tampered"
+			c.Assert(proof.Verify(root), gc.Equals, false)
+		}
+		_, ok := t.Proof("missing")
+		c.Assert(ok, gc.Equals, false)
+	}
+
+	// Trees built from keys and from digests agree, even where a key has a
+	// SHA256 digest.
+	var buf bytes.Buffer
+	var keys []*PrimaryKey
+	for _, name := range []string{"Alice", "Bob", "Carol"} {
+		key := entityKey(c, newTestEntity(c, name))
+		c.Assert(WritePackets(&buf, key), gc.IsNil)
+		keys = append(keys, key)
+	}
+	c.Assert(ValidateSubmission(keys[0], &SubmissionPolicy{}).Decision, gc.Equals, SubmissionAccept)
+	c.Assert(keys[0].SHA256, gc.Not(gc.Equals), "")
+	kc := make(PrimaryKeyChan, len(keys))
+	for _, key := range keys {
+		kc <- &ReadKeyResult{PrimaryKey: key}
+	}
+	close(kc)
+	fromKeys, err := MerkleTreeFromKeys(kc)
+	c.Assert(err, gc.IsNil)
+	fromDigests, err := MerkleTreeFromDigests(DigestKeyrings(ReadOpaqueKeyrings(&buf), 1))
+	c.Assert(err, gc.IsNil)
+	c.Assert(fromKeys.Root(), gc.Equals, fromDigests.Root())
+}
+
+func (s *TypesSuite) TestScopedID(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	uid := key.UserIDs[0]
+	sig := uid.Signatures[0]
+	c.Assert(sig.ParentUUID, gc.Equals, uid.UUID)
+	c.Assert(key.SubKeys[0].Signatures[0].ParentUUID, gc.Equals, key.SubKeys[0].UUID)
+
+	// The same certification under another user ID.
+	other, err := ParseUserID(&packet.OpaquePacket{Tag: 13, Contents: []byte("Mallory")}, key.UUID)
+	c.Assert(err, gc.IsNil)
+	op, err := sig.opaquePacket()
+	c.Assert(err, gc.IsNil)
+	copied, err := ParseSignature(op, key.UUID, other.UUID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(copied.ScopedID(), gc.Not(gc.Equals), sig.ScopedID())
+	c.Assert(copied.ScopedID(), gc.Equals, other.UUID+"/"+packetDigest(sig.Packet.Packet))
+
+	scoped := sig.ScopedID()
+	_, err = MigrateUUIDs(key, DefaultUUIDScheme, HashUUIDScheme(sha512.New))
+	c.Assert(err, gc.IsNil)
+	c.Assert(sig.ParentUUID, gc.Equals, uid.UUID)
+	c.Assert(sig.ScopedID(), gc.Not(gc.Equals), scoped)
+}
+
+func (s *TypesSuite) TestCertificationClass(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	key := entityKey(c, alice)
+	uid := key.UserIDs[0]
+	c.Assert(uid.Signatures, gc.HasLen, 2)
+	classes := make(map[CertificationClass]bool)
+	for _, sig := range uid.Signatures {
+		class, ok := sig.CertificationClass()
+		c.Assert(ok, gc.Equals, true)
+		classes[class] = true
+	}
+	c.Assert(classes, gc.DeepEquals, map[CertificationClass]bool{CertGeneric: true, CertPositive: true})
+
+	class, ok := uid.SelfSigs(key).CertificationClass()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(class, gc.Equals, CertPositive)
+	c.Assert(class.String(), gc.Equals, "positive")
+
+	_, ok = key.SubKeys[0].Signatures[0].CertificationClass()
+	c.Assert(ok, gc.Equals, false)
+	_, ok = key.SubKeys[0].SelfSigs(key).CertificationClass()
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(CertificationClass(0x18).String(), gc.Equals, "unknown")
+}
+
+func (s *TypesSuite) TestTimestamps(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	timestamp := func() *packet.Signature {
+		sig := &packet.Signature{
+			SigType:      packet.SignatureType(0x40),
+			PubKeyAlgo:   alice.PrimaryKey.PubKeyAlgo,
+			Hash:         crypto.SHA256,
+			CreationTime: time.Now(),
+			IssuerKeyId:  &alice.PrimaryKey.KeyId,
+		}
+		c.Assert(sig.Sign(crypto.SHA256.New(), alice.PrivateKey, nil), gc.IsNil)
+		return sig
+	}
+
+	var buf bytes.Buffer
+	c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+	c.Assert(timestamp().Serialize(&buf), gc.IsNil)
+	for _, ident := range alice.Identities {
+		c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+		c.Assert(timestamp().Serialize(&buf), gc.IsNil)
+	}
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	key := keys[0]
+
+	c.Assert(key.Signatures, gc.HasLen, 1)
+	c.Assert(key.Timestamps(), gc.DeepEquals, key.Signatures)
+	c.Assert(key.SelfSigs().Errors, gc.HasLen, 0)
+	uid := key.UserIDs[0]
+	c.Assert(uid.Signatures, gc.HasLen, 2)
+	ss := uid.SelfSigs(key)
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Certifications, gc.HasLen, 1)
+	c.Assert(ss.Certifications[0].Signature.IsTimestamp(), gc.Equals, false)
+}
+
+// v6Signature returns synthetic V6 signature packet contents, with the given
+// creation time and salt, issued by the V6 key with fingerprint fp.
+func v6Signature(created time.Time, fp, salt []byte) []byte {
+	var hashed bytes.Buffer
+	var u32 [4]byte
+	binary.BigEndian.PutUint32(u32[:], uint32(created.Unix()))
+	hashed.Write([]byte{5, 2})
+	hashed.Write(u32[:])
+	hashed.Write([]byte{byte(len(fp) + 2), 33, 6})
+	hashed.Write(fp)
+
+	var contents bytes.Buffer
+	contents.Write([]byte{6, 0x13, 27, 10}) // Ed25519, SHA512
+	binary.BigEndian.PutUint32(u32[:], uint32(hashed.Len()))
+	contents.Write(u32[:])
+	contents.Write(hashed.Bytes())
+	contents.Write([]byte{0, 0, 0, 0}) // no unhashed subpackets
+	contents.Write([]byte{0xab, 0xcd})
+	contents.WriteByte(byte(len(salt)))
+	contents.Write(salt)
+	contents.Write(bytes.Repeat([]byte{0x5a}, 64))
+	return contents.Bytes()
+}
+
+func (s *TypesSuite) TestV6SignatureSalt(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	created := alice.PrimaryKey.CreationTime
+	fp := bytes.Repeat([]byte{0x11}, 32)
+	fp[0] = 0xfe
+	salt := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+	sigs := [][]byte{
+		v6Signature(created.Add(2*time.Second), fp, salt(1)),
+		v6Signature(created.Add(time.Second), fp, salt(2)),
+		// Differs from the first only in its salt.
+		v6Signature(created.Add(2*time.Second), fp, salt(3)),
+	}
+	ident := alice.Identities["Alice"]
+	read := func(sigs ...[]byte) *PrimaryKey {
+		var buf bytes.Buffer
+		c.Assert(alice.PrimaryKey.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+		for _, contents := range sigs {
+			c.Assert((&packet.OpaquePacket{Tag: 2, Contents: contents}).Serialize(&buf), gc.IsNil)
+		}
+		keys := ReadKeys(&buf).MustParse()
+		c.Assert(keys, gc.HasLen, 1)
+		return keys[0]
+	}
+
+	key := read(sigs...)
+	uid := key.UserIDs[0]
+	c.Assert(key.Others, gc.HasLen, 0)
+	c.Assert(uid.Signatures, gc.HasLen, 4)
+	// Merging in an exact copy of the first signature drops it as a
+	// duplicate, but keeps the one differing only in its salt.
+	c.Assert(Merge(key, read(sigs[0])), gc.IsNil)
+	c.Assert(uid.Signatures, gc.HasLen, 4)
+	var v6 []*Signature
+	for _, sig := range uid.Signatures {
+		if sig.Salt() != nil {
+			v6 = append(v6, sig)
+		}
+	}
+	c.Assert(v6, gc.HasLen, 3)
+	for _, sig := range v6 {
+		c.Assert(sig.IssuerKeyID(), gc.Equals, "fe11111111111111")
+	}
+
+	// Canonical ordering by creation time, then by packet for digests.
+	Sort(key)
+	c.Assert(uid.Signatures[1].Salt(), gc.DeepEquals, salt(2))
+	c.Assert(uid.SelfSigs(key).Valid(), gc.Equals, true)
+
+	// Digests depend on the salt, and not on the order of submission.
+	digest := key.MD5
+	c.Assert(read(sigs[2], sigs[1], sigs[0]).MD5, gc.Equals, digest)
+	c.Assert(read(sigs[0], sigs[1]).MD5, gc.Not(gc.Equals), digest)
+
+	// A V6 signature truncated within its salt is not accepted.
+	truncated := sigs[0][:len(sigs[0])-64-16]
+	key = read(truncated)
+	c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 1)
+	c.Assert(key.UserIDs[0].Others, gc.HasLen, 1)
+}
+
+func (s *TypesSuite) TestDisplaySafe(c *gc.C) {
+	for _, testCase := range []struct {
+		keywords, display string
+	}{
+		{"Alice <alice@example.com>", "Alice &lt;alice@example.com&gt;"},
+		{`<script>alert("x&y")</script>`, "&lt;script&gt;alert(&#34;x&amp;y&#34;)&lt;/script&gt;"},
+		{"O'Brien", "O&#39;Brien"},
+		{"bob@\u202emoc.live", "bob@moc.live"},
+		{"zero\u200bwidth\ufeff", "zerowidth"},
+		{"\u0645\u06cc\u200c\u062e\u0648\u0627\u0645", "\u0645\u06cc\u200c\u062e\u0648\u0627\u0645"},
+	} {
+		uid := &UserID{Keywords: testCase.keywords}
+		c.Assert(uid.DisplaySafe(), gc.Equals, testCase.display)
+	}
+}
+
+func (s *TypesSuite) TestDescribe(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	c.Assert(packet.NewUserId("Mallory", "", "").Serialize(&buf), gc.IsNil)
+	key := ReadKeys(&buf).MustParse()[0]
+
+	created := key.Creation.UTC().Format("2006-01-02")
+	lines := strings.Split(strings.TrimSuffix(Describe(key), "\n"), "\n")
+	c.Assert(lines, gc.DeepEquals, []string{
+		fmt.Sprintf("pub  rsa1024/%s created %s", key.KeyID(), created),
+		"     " + key.Fingerprint(),
+		`uid  "Alice"`,
+		`uid  "Mallory" [unverified]`,
+		fmt.Sprintf("sub  rsa1024/%s created %s", key.SubKeys[0].KeyID(), created),
+	})
+}
+
+func (s *TypesSuite) TestRandomart(c *gc.C) {
+	// As drawn by ssh-keygen -lv -E md5.
+	fp, err := hex.DecodeString("2d1f65087e6bc34efb2436273b493c66")
+	c.Assert(err, gc.IsNil)
+	c.Assert(Randomart(fp, "[ED25519 256]", "[MD5]"), gc.Equals, strings.Join([]string{
+		"+--[ED25519 256]--+",
+		"|        .        |",
+		"|       . . .     |",
+		"|        . o o    |",
+		"|         + +     |",
+		"|        S.O      |",
+		"|         *E+     |",
+		"|         +Ooo    |",
+		"|         .oO     |",
+		"|          ...    |",
+		"+------[MD5]------+",
+	}, "\n"))
+
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	art := strings.Split(key.Randomart(), "\n")
+	c.Assert(art, gc.HasLen, 11)
+	c.Assert(art[0], gc.Equals, "+---[RSA 1024]----+")
+	c.Assert(art[10], gc.Equals, "+-----[SHA1]------+")
+
+	symbols := FingerprintSymbolsOf([]byte{0x00, 0x10, 0x83, 0xff})
+	c.Assert(symbols, gc.HasLen, 5)
+	c.Assert(symbols[0].Name, gc.Equals, "Dog")
+	c.Assert(symbols[1].Name, gc.Equals, "Cat")
+	c.Assert(symbols[2].Name, gc.Equals, "Lion")
+	c.Assert(symbols[3].Emoji, gc.Equals, "🐎")
+	c.Assert(symbols[4].Name, gc.Equals, "Pin")
+	c.Assert(key.FingerprintSymbols(), gc.HasLen, 26)
+}
+
+func (s *TypesSuite) TestFingerprintFormats(c *gc.C) {
+	c.Assert(FormatFingerprint("0123456789abcdef0123456789abcdef01234567"), gc.Equals,
+		"0123 4567 89AB CDEF 0123  4567 89AB CDEF 0123 4567")
+	c.Assert(FormatFingerprint("0123456789abcdef0123"), gc.Equals, "0123 4567 89AB CDEF 0123")
+
+	// The Web Key Directory hash of "Joe.Doe".
+	h := sha1.Sum([]byte("joe.doe"))
+	c.Assert(ZBase32(h[:]), gc.Equals, "iy9q119eutrkn8s1mk4r39qejnbu3n5q")
+	c.Assert(ZBase32([]byte{0xff}), gc.Equals, "9h")
+
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	fp := strings.ToUpper(key.Fingerprint())
+	c.Assert(key.FormattedFingerprint(), gc.HasLen, 50)
+	c.Assert(strings.Replace(key.FormattedFingerprint(), " ", "", -1), gc.Equals, fp)
+	c.Assert(key.LongKeyID(), gc.Equals, "0x"+fp[24:])
+	c.Assert(key.FingerprintURI(), gc.Equals, "openpgp4fpr:"+fp)
+	c.Assert(key.ZBase32Fingerprint(), gc.HasLen, 32)
+}
+
+func (s *TypesSuite) TestReversedIDs(c *gc.C) {
+	c.Assert(Reverse(""), gc.Equals, "")
+	c.Assert(Reverse("a"), gc.Equals, "a")
+	c.Assert(Reverse("0123abcd"), gc.Equals, "dcba3210")
+	c.Assert(Reverse(Reverse("0123456789abcdef")), gc.Equals, "0123456789abcdef")
+	c.Assert(ReverseHex([]byte{0x01, 0x23, 0xab}), gc.Equals, "ba3210")
+	c.Assert(ReverseKeyID(0x0123456789abcdef), gc.Equals, "fedcba9876543210")
+	c.Assert(ReverseKeyID(0xff), gc.Equals, "ff00000000000000")
+	c.Assert(ReverseShortID(0x0123456789abcdef), gc.Equals, "fedcba98")
+
+	for _, input := range []string{
+		"0123456789ABCDEF0123456789ABCDEF01234567",
+		"0x0123456789abcdef0123456789abcdef01234567",
+		"0123 4567 89AB CDEF 0123  4567 89AB CDEF 0123 4567",
+		" 0X0123456789abcdef0123456789abcdef01234567 ",
+	} {
+		rfp, err := ParseRFingerprint(input)
+		c.Assert(err, gc.IsNil, gc.Commentf("%q", input))
+		c.Assert(rfp, gc.Equals, "76543210fedcba9876543210fedcba9876543210")
+	}
+	for _, input := range []string{"", "0123", "0123456789abcdef0123456789abcdef0123456g", "0x0x0123456789abcdef"} {
+		_, err := ParseRFingerprint(input)
+		c.Assert(err, gc.ErrorMatches, "invalid fingerprint .*", gc.Commentf("%q", input))
+	}
+	rfp, err := ParseRFingerprint("0123456789abcdef0123456789abcdef")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rfp, gc.HasLen, 32)
+
+	rkeyid, err := ParseRKeyID("0x89ABCDEF01234567")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rkeyid, gc.Equals, "76543210fedcba98")
+	_, err = ParseRKeyID("89abcdef")
+	c.Assert(err, gc.ErrorMatches, `invalid key ID "89abcdef"`)
+
+	// The identifiers of a parsed v4 key follow the scheme.
+	entity := newTestEntity(c, "Alice")
+	key := entityKey(c, entity)
+	rfp, err = ParseRFingerprint(fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint))
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.RFingerprint, gc.Equals, rfp)
+	c.Assert(key.RFingerprint, gc.Equals, ReverseHex(entity.PrimaryKey.Fingerprint[:]))
+	c.Assert(key.RKeyID, gc.Equals, ReverseKeyID(entity.PrimaryKey.KeyId))
+	c.Assert(key.RKeyID, gc.Equals, key.RFingerprint[:16])
+	c.Assert(key.RShortID, gc.Equals, ReverseShortID(entity.PrimaryKey.KeyId))
+	c.Assert(key.RShortID, gc.Equals, key.RFingerprint[:8])
+	c.Assert(key.KeyID(), gc.Equals, fmt.Sprintf("%016x", entity.PrimaryKey.KeyId))
+}
+
+func (s *TypesSuite) TestIDAccessors(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	key := entityKey(c, alice)
+	subkey := key.SubKeys[0]
+	fp := fmt.Sprintf("%x", alice.Subkeys[0].PublicKey.Fingerprint)
+	c.Assert(subkey.Fingerprint(), gc.Equals, fp)
+	c.Assert(subkey.KeyID(), gc.Equals, fp[24:])
+	c.Assert(subkey.ShortID(), gc.Equals, fp[32:])
+	c.Assert(subkey.LongKeyID(), gc.Equals, "0x"+strings.ToUpper(fp[24:]))
+
+	sig := subkey.Signatures[0]
+	c.Assert(sig.IssuerKeyID(), gc.Equals, key.KeyID())
+	c.Assert(sig.IssuerShortID(), gc.Equals, key.ShortID())
+	c.Assert(sig.IssuerLongKeyID(), gc.Equals, key.LongKeyID())
+	c.Assert(sig.IssuerFingerprint(), gc.Equals, "")
+
+	// An issuer fingerprint subpacket names the issuer in full. The
+	// signature follows the sub-key binding, and so belongs to the sub-key.
+	issuerFP := append([]byte{4}, alice.PrimaryKey.Fingerprint[:]...)
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	buf.Write(rawSig(c, alice, 0x1f, time.Now(), func(h hash.Hash) { keyBody(c, h, alice) },
+		sigSubpacket(33, issuerFP)))
+	key = ReadKeys(&buf).MustParse()[0]
+	sigs := key.SubKeys[0].Signatures
+	c.Assert(sigs, gc.HasLen, 2)
+	c.Assert(sigs[1].IssuerFingerprint(), gc.Equals, key.Fingerprint())
+
+	var none Signature
+	c.Assert(none.IssuerShortID(), gc.Equals, "")
+	c.Assert(none.IssuerLongKeyID(), gc.Equals, "")
+}
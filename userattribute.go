@@ -18,9 +18,6 @@
 package openpgp
 
 import (
-	"bytes"
-	"strings"
-
 	"golang.org/x/crypto/openpgp/packet"
 	"gopkg.in/errgo.v1"
 )
@@ -82,15 +79,20 @@ func (us uatSlice) without(target *UserAttribute) []*UserAttribute {
 }
 
 func ParseUserAttribute(op *packet.OpaquePacket, parentID string) (*UserAttribute, error) {
-	var buf bytes.Buffer
-	if err := op.Serialize(&buf); err != nil {
+	return parseUserAttribute(op, parentID, nil)
+}
+
+func parseUserAttribute(op *packet.OpaquePacket, parentID string, arena *packetArena) (*UserAttribute, error) {
+	buf, err := arena.serialize(op)
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	uat := &UserAttribute{
 		Packet: Packet{
-			UUID:   scopedDigest([]string{parentID}, uatTag, buf.Bytes()),
+			UUID:   scopedDigest([]string{parentID}, uatTag, buf),
 			Tag:    op.Tag,
-			Packet: buf.Bytes(),
+			Packet: buf,
+			shared: arena != nil,
 		},
 	}
 
@@ -120,18 +122,28 @@ func (uat *UserAttribute) userAttributePacket() (*packet.UserAttribute, error) {
 	return u, nil
 }
 
-func (uat *UserAttribute) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
+func (uat *UserAttribute) SelfSigs(pubkey *PrimaryKey, opts ...SelfSigOption) *SelfSigs {
+	so := newSelfSigOptions(opts)
 	result := &SelfSigs{target: uat}
 	for _, sig := range uat.Signatures {
 		// Skip non-self-certifications.
-		if !strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID) {
+		candidate, trial := pubkey.selfSigCandidate(sig, so)
+		if !candidate {
 			continue
 		}
-		checkSig := &CheckSig{
-			PrimaryKey: pubkey,
-			Signature:  sig,
-			Error:      pubkey.verifyUserAttrSelfSig(uat, sig),
+		if err := sig.Expand(); err != nil {
+			if !trial {
+				result.Errors = append(result.Errors, newCheckSig(pubkey, sig, err))
+			}
+			continue
+		}
+		err := pubkey.verifyCached(sig, uat, func() error {
+			return pubkey.verifyUserAttrSelfSig(uat, sig)
+		})
+		if err != nil && trial {
+			continue
 		}
+		checkSig := newCheckSig(pubkey, sig, err)
 		if checkSig.Error != nil {
 			result.Errors = append(result.Errors, checkSig)
 			continue
@@ -139,6 +151,8 @@ func (uat *UserAttribute) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
 		switch sig.SigType {
 		case 0x30: // packet.SigTypeCertRevocation
 			result.Revocations = append(result.Revocations, checkSig)
+		case 0x16: // attestation key signature
+			result.Attestations = append(result.Attestations, checkSig)
 		case 0x10, 0x11, 0x12, 0x13:
 			result.Certifications = append(result.Certifications, checkSig)
 			if !sig.Expiration.IsZero() {
@@ -18,8 +18,9 @@
 package openpgp
 
 import (
-	"bytes"
+	"html"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"golang.org/x/crypto/openpgp/packet"
@@ -31,6 +32,11 @@ type UserID struct {
 
 	Keywords string
 
+	// InvalidUTF8 indicates that the user ID is not valid UTF-8 text. Its
+	// invalid sequences are replaced in Keywords, but the packet is kept
+	// as it is, so that its signatures and the key's digest are unaffected.
+	InvalidUTF8 bool
+
 	Signatures []*Signature
 	Others     []*Packet
 }
@@ -83,15 +89,20 @@ func (us uidSlice) without(target *UserID) []*UserID {
 }
 
 func ParseUserID(op *packet.OpaquePacket, parentID string) (*UserID, error) {
-	var buf bytes.Buffer
-	if err := op.Serialize(&buf); err != nil {
+	return parseUserID(op, parentID, nil)
+}
+
+func parseUserID(op *packet.OpaquePacket, parentID string, arena *packetArena) (*UserID, error) {
+	buf, err := arena.serialize(op)
+	if err != nil {
 		return nil, errgo.Mask(err)
 	}
 	uid := &UserID{
 		Packet: Packet{
-			UUID:   scopedDigest([]string{parentID}, uidTag, buf.Bytes()),
+			UUID:   scopedDigest([]string{parentID}, uidTag, buf),
 			Tag:    op.Tag,
-			Packet: buf.Bytes(),
+			Packet: buf,
+			shared: arena != nil,
 		},
 	}
 
@@ -129,15 +140,33 @@ func (uid *UserID) userIDPacket() (*packet.UserId, error) {
 }
 
 func (uid *UserID) setUserID(u *packet.UserId) error {
-	uid.Keywords = cleanUtf8(u.Id)
+	uid.Keywords = cleanUtf8(u.Id, '?')
+	uid.InvalidUTF8 = !utf8.ValidString(u.Id)
 	return nil
 }
 
-func cleanUtf8(s string) string {
+// repairKeywords sets the Keywords of a user ID which is not valid UTF-8 text
+// with its invalid sequences replaced by U+FFFD, the Unicode replacement
+// character, rather than '?'.
+func (uid *UserID) repairKeywords() error {
+	if !uid.InvalidUTF8 {
+		return nil
+	}
+	u, err := uid.userIDPacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	uid.Keywords = cleanUtf8(u.Id, utf8.RuneError)
+	return nil
+}
+
+// cleanUtf8 returns s without control characters, and with invalid UTF-8
+// sequences replaced.
+func cleanUtf8(s string, replacement rune) string {
 	var runes []rune
 	for _, r := range s {
 		if r == utf8.RuneError {
-			r = '?'
+			r = replacement
 		}
 		if r < 0x20 || r == 0x7f {
 			continue
@@ -147,18 +176,53 @@ func cleanUtf8(s string) string {
 	return string(runes)
 }
 
-func (uid *UserID) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
+// DisplaySafe returns the user ID's Keywords escaped for inclusion in HTML,
+// either as text or as a quoted attribute value. User IDs are chosen by
+// whoever uploads a key, so they must never be rendered unescaped. Invisible
+// formatting characters, which can reorder or hide the displayed text, such
+// as to make "moc.live" appear as "evil.com", are removed; the zero-width
+// joiner and non-joiner, which some scripts need, are kept.
+func (uid *UserID) DisplaySafe() string {
+	s := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) && r != '\u200c' && r != '\u200d' {
+			return -1
+		}
+		return r
+	}, uid.Keywords)
+	return html.EscapeString(s)
+}
+
+// length returns the length of the user ID, in octets, as given in its packet.
+func (uid *UserID) length() (int, error) {
+	op, err := uid.opaquePacket()
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return len(op.Contents), nil
+}
+
+func (uid *UserID) SelfSigs(pubkey *PrimaryKey, opts ...SelfSigOption) *SelfSigs {
+	so := newSelfSigOptions(opts)
 	result := &SelfSigs{target: uid}
 	for _, sig := range uid.Signatures {
 		// Skip non-self-certifications.
-		if !strings.HasPrefix(pubkey.UUID, sig.RIssuerKeyID) {
+		candidate, trial := pubkey.selfSigCandidate(sig, so)
+		if !candidate {
 			continue
 		}
-		checkSig := &CheckSig{
-			PrimaryKey: pubkey,
-			Signature:  sig,
-			Error:      pubkey.verifyUserIDSelfSig(uid, sig),
+		if err := sig.Expand(); err != nil {
+			if !trial {
+				result.Errors = append(result.Errors, newCheckSig(pubkey, sig, err))
+			}
+			continue
+		}
+		err := pubkey.verifyCached(sig, uid, func() error {
+			return pubkey.verifyUserIDSelfSig(uid, sig)
+		})
+		if err != nil && trial {
+			continue
 		}
+		checkSig := newCheckSig(pubkey, sig, err)
 		if checkSig.Error != nil {
 			result.Errors = append(result.Errors, checkSig)
 			continue
@@ -166,6 +230,8 @@ func (uid *UserID) SelfSigs(pubkey *PrimaryKey) *SelfSigs {
 		switch sig.SigType {
 		case 0x30: // packet.SigTypeCertRevocation
 			result.Revocations = append(result.Revocations, checkSig)
+		case 0x16: // attestation key signature
+			result.Attestations = append(result.Attestations, checkSig)
 		case 0x10, 0x11, 0x12, 0x13:
 			result.Certifications = append(result.Certifications, checkSig)
 			if !sig.Expiration.IsZero() {
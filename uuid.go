@@ -0,0 +1,187 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"hash"
+
+	"gopkg.in/basen.v1"
+	"gopkg.in/errgo.v1"
+)
+
+// UUIDScheme derives the UUIDs of user IDs, user attributes, signatures and
+// other packets, from the UUIDs of the packets they belong to, a tag naming
+// the kind of packet, and the serialized packet. Primary keys and subkeys are
+// identified by their reversed fingerprints regardless of scheme.
+type UUIDScheme interface {
+	UUID(parents []string, tag string, packet []byte) string
+}
+
+// UUIDSchemeFunc adapts a function to the UUIDScheme interface.
+type UUIDSchemeFunc func(parents []string, tag string, packet []byte) string
+
+// UUID implements UUIDScheme.
+func (f UUIDSchemeFunc) UUID(parents []string, tag string, packet []byte) string {
+	return f(parents, tag, packet)
+}
+
+// HashUUIDScheme returns a UUIDScheme which Base58-encodes the digest, by a
+// hash of the given kind, of each parent UUID followed by the tag, and then
+// the packet.
+func HashUUIDScheme(newHash func() hash.Hash) UUIDScheme {
+	return UUIDSchemeFunc(func(parents []string, tag string, packet []byte) string {
+		h := newHash()
+		for i := range parents {
+			h.Write([]byte(parents[i]))
+			h.Write([]byte(tag))
+		}
+		h.Write(packet)
+		return basen.Base58.EncodeToString(h.Sum(nil))
+	})
+}
+
+// DefaultUUIDScheme is the scheme with which packet UUIDs are derived unless
+// another is set, using SHA-256.
+var DefaultUUIDScheme = HashUUIDScheme(sha256.New)
+
+var uuidScheme = DefaultUUIDScheme
+
+// SetUUIDScheme sets the scheme with which the UUIDs of parsed packets are
+// derived, or restores DefaultUUIDScheme if s is nil. It should be called
+// during initialization, before keys are read; keys already stored may be
+// brought up to date with MigrateUUIDs.
+func SetUUIDScheme(s UUIDScheme) {
+	if s == nil {
+		s = DefaultUUIDScheme
+	}
+	uuidScheme = s
+}
+
+// MigrateUUIDs recomputes the UUIDs of the packets of key, which were derived
+// with scheme from, using scheme to instead. It returns a map from the former
+// UUID of each packet which changed to its new UUID, so that references held
+// elsewhere, such as in a database, can be updated one key at a time.
+//
+// The key is left unchanged if any of its UUIDs were not derived with scheme
+// from, as its packets' parents could not be established.
+func MigrateUUIDs(key *PrimaryKey, from, to UUIDScheme) (map[string]string, error) {
+	type update struct {
+		p    *Packet
+		uuid string
+	}
+	var updates []update
+	type sigParent struct {
+		sig  *Signature
+		uuid string
+	}
+	var sigParents []sigParent
+	// parents maps the former UUIDs of packets which may be signed to their
+	// new UUIDs.
+	parents := map[string]string{key.UUID: key.UUID}
+	migrate := func(p *Packet, tag string, oldParents []string) error {
+		if from.UUID(oldParents, tag, p.Packet) != p.UUID {
+			return errgo.Newf("UUID %q of packet in key %q not derived by scheme", p.UUID, key.Fingerprint())
+		}
+		newParents := make([]string, len(oldParents))
+		for i := range oldParents {
+			newParents[i] = parents[oldParents[i]]
+		}
+		updates = append(updates, update{p: p, uuid: to.UUID(newParents, tag, p.Packet)})
+		return nil
+	}
+	sigs := func(parent string, sigs []*Signature) error {
+		for _, sig := range sigs {
+			if err := migrate(&sig.Packet, sigTag, []string{key.UUID, parent}); err != nil {
+				return err
+			}
+			sigParents = append(sigParents, sigParent{sig: sig, uuid: parents[parent]})
+		}
+		return nil
+	}
+
+	if err := sigs(key.UUID, key.Signatures); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for _, uid := range key.UserIDs {
+		if err := migrate(&uid.Packet, uidTag, []string{key.UUID}); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		parents[uid.UUID] = updates[len(updates)-1].uuid
+		if err := sigs(uid.UUID, uid.Signatures); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	for _, uat := range key.UserAttributes {
+		if err := migrate(&uat.Packet, uatTag, []string{key.UUID}); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		parents[uat.UUID] = updates[len(updates)-1].uuid
+		if err := sigs(uat.UUID, uat.Signatures); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	for _, subkey := range key.SubKeys {
+		parents[subkey.UUID] = subkey.UUID
+		if err := sigs(subkey.UUID, subkey.Signatures); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	// Other packets belong to whichever packet preceded them when the key
+	// was read, which is only recorded in their UUIDs.
+	others := func(others []*Packet) error {
+	next:
+		for _, other := range others {
+			for parent := range parents {
+				if from.UUID([]string{parent}, packetTag, other.Packet) == other.UUID {
+					migrate(other, packetTag, []string{parent})
+					continue next
+				}
+			}
+			return errgo.Newf("UUID %q of packet in key %q not derived by scheme", other.UUID, key.Fingerprint())
+		}
+		return nil
+	}
+	otherLists := [][]*Packet{key.Others}
+	for _, uid := range key.UserIDs {
+		otherLists = append(otherLists, uid.Others)
+	}
+	for _, uat := range key.UserAttributes {
+		otherLists = append(otherLists, uat.Others)
+	}
+	for _, subkey := range key.SubKeys {
+		otherLists = append(otherLists, subkey.Others)
+	}
+	for _, list := range otherLists {
+		if err := others(list); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+
+	changed := make(map[string]string)
+	for _, u := range updates {
+		if u.p.UUID != u.uuid {
+			changed[u.p.UUID] = u.uuid
+			u.p.UUID = u.uuid
+		}
+	}
+	for _, sp := range sigParents {
+		sp.sig.ParentUUID = sp.uuid
+	}
+	return changed, nil
+}
@@ -0,0 +1,379 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SubmissionPolicy sets the limits applied to keys submitted to a keyserver by
+// ValidateSubmission. Zero values impose no limit.
+type SubmissionPolicy struct {
+	// MaxLength is the largest total size of the key's packets, in octets.
+	MaxLength int
+
+	// MaxPackets is the largest number of packets a key may have.
+	MaxPackets int
+
+	// MaxUserIDs and MaxSubKeys limit the number of user IDs and sub-keys.
+	MaxUserIDs int
+	MaxSubKeys int
+
+	// MaxCertifications limits the number of third-party signatures kept on
+	// the primary key and on each user ID, user attribute and sub-key, so
+	// that a key cannot be flooded with certifications. The most recent are
+	// kept.
+	MaxCertifications int
+
+	// MaxUserIDLength limits the length of each user ID, in octets. Longer
+	// user IDs are handled as given by LongUserIDs.
+	MaxUserIDLength int
+	LongUserIDs     LongUserIDPolicy
+
+	// MinRSABits and MaxRSABits bound the modulus size of RSA keys. Keys
+	// whose primary key is out of bounds are rejected, and sub-keys out of
+	// bounds are removed. The maximum is checked before any signature is
+	// verified, protecting verification from keys so large that each
+	// signature takes seconds to check.
+	MinRSABits int
+	MaxRSABits int
+
+	// RejectElGamalSignEncrypt rejects keys whose primary key uses the
+	// broken ElGamal sign and encrypt algorithm (20), and removes sub-keys
+	// which use it.
+	RejectElGamalSignEncrypt bool
+
+	// RequireUserID rejects keys left without any user ID bearing a valid
+	// self-signature.
+	RequireUserID bool
+
+	// AttestedCertificationsOnly removes the third-party certifications of
+	// user IDs and user attributes which the key owner has not attested by
+	// an attestation key signature, so that only those the owner approves
+	// are distributed with the key.
+	AttestedCertificationsOnly bool
+}
+
+// LongUserIDPolicy determines what is done with user IDs longer than a
+// submission policy's MaxUserIDLength.
+type LongUserIDPolicy int
+
+const (
+	// DropLongUserIDs removes long user IDs from the key, with their
+	// signatures, so that neither they nor their packets are stored or
+	// served. A user ID cannot be shortened without invalidating its
+	// self-signatures.
+	DropLongUserIDs LongUserIDPolicy = iota
+
+	// RejectLongUserIDs rejects keys having a long user ID, which may be
+	// used to smuggle arbitrary data onto a keyserver.
+	RejectLongUserIDs
+)
+
+// SubmissionDecision is the outcome of validating a key submission.
+type SubmissionDecision int
+
+const (
+	// SubmissionAccept means the key may be stored as submitted.
+	SubmissionAccept SubmissionDecision = iota
+
+	// SubmissionClean means packets were removed from the key, which may be
+	// stored in its cleaned form.
+	SubmissionClean
+
+	// SubmissionReject means the key must not be stored.
+	SubmissionReject
+)
+
+var submissionDecisionStrings = []string{
+	"accept",
+	"clean",
+	"reject",
+}
+
+func (d SubmissionDecision) String() string {
+	if int(d) < len(submissionDecisionStrings) {
+		return submissionDecisionStrings[d]
+	}
+	return "unknown"
+}
+
+// SubmissionResult describes the decision made on a key submission.
+type SubmissionResult struct {
+	Decision SubmissionDecision
+
+	// Reasons explain why the key was cleaned or rejected.
+	Reasons []string
+
+	// Removed lists the UUIDs of packets removed from a cleaned key, not
+	// including duplicates.
+	Removed []string
+
+	// Key is the validated key, with its digests calculated. It is nil if
+	// the key was rejected.
+	Key *PrimaryKey
+}
+
+func (r *SubmissionResult) clean(uuid string, format string, args ...interface{}) {
+	r.Decision = SubmissionClean
+	r.Removed = append(r.Removed, uuid)
+	r.Reasons = append(r.Reasons, fmt.Sprintf(format, args...))
+}
+
+func (r *SubmissionResult) reject(format string, args ...interface{}) *SubmissionResult {
+	r.Decision = SubmissionReject
+	r.Reasons = append(r.Reasons, fmt.Sprintf(format, args...))
+	r.Key = nil
+	return r
+}
+
+// ValidateSubmission checks a key submitted to a keyserver against the
+// policy. User IDs, user attributes and sub-keys without a valid
+// self-signature or revocation are removed, as are duplicate packets and
+// certifications in excess of the policy's limit; the key is modified in
+// place. The MD5 and SHA256 digests of the resulting key are calculated.
+func ValidateSubmission(key *PrimaryKey, policy *SubmissionPolicy) *SubmissionResult {
+	result := &SubmissionResult{Key: key}
+	if key == nil {
+		return result.reject("no primary key")
+	}
+	if key.RFingerprint == "" {
+		return result.reject("primary key has no fingerprint")
+	}
+
+	if err := policy.checkLimits(key); err != nil {
+		return result.reject("%v", err)
+	}
+
+	if policy.MaxUserIDLength > 0 {
+		var uids []*UserID
+		for _, uid := range key.UserIDs {
+			n, err := uid.length()
+			if err != nil {
+				return result.reject("%v", err)
+			}
+			if n <= policy.MaxUserIDLength {
+				uids = append(uids, uid)
+				continue
+			}
+			if policy.LongUserIDs == RejectLongUserIDs {
+				return result.reject("user ID is %d octets, more than %d", n, policy.MaxUserIDLength)
+			}
+			result.clean(uid.UUID, "user ID is %d octets, more than %d", n, policy.MaxUserIDLength)
+		}
+		key.UserIDs = uids
+	}
+
+	if err := policy.checkRSABits(&key.PublicKey); err != nil {
+		return result.reject("primary key %v", err)
+	}
+	var boundedSubKeys []*SubKey
+	for _, subkey := range key.SubKeys {
+		if err := policy.checkRSABits(&subkey.PublicKey); err != nil {
+			result.clean(subkey.UUID, "sub-key %s %v", subkey.KeyID(), err)
+		} else {
+			boundedSubKeys = append(boundedSubKeys, subkey)
+		}
+	}
+	key.SubKeys = boundedSubKeys
+
+	if policy.RejectElGamalSignEncrypt {
+		if key.Algorithm == algoElGamalSignEncrypt {
+			return result.reject("primary key uses ElGamal sign and encrypt")
+		}
+		var subkeys []*SubKey
+		for _, subkey := range key.SubKeys {
+			if subkey.Algorithm == algoElGamalSignEncrypt {
+				result.clean(subkey.UUID, "sub-key %s uses ElGamal sign and encrypt", subkey.KeyID())
+			} else {
+				subkeys = append(subkeys, subkey)
+			}
+		}
+		key.SubKeys = subkeys
+	}
+
+	var uids []*UserID
+	for _, uid := range key.UserIDs {
+		if selfSigned(uid.SelfSigs(key)) {
+			uids = append(uids, uid)
+		} else {
+			result.clean(uid.UUID, "user ID %q has no valid self-signature", uid.Keywords)
+		}
+	}
+	key.UserIDs = uids
+	var uats []*UserAttribute
+	for _, uat := range key.UserAttributes {
+		if selfSigned(uat.SelfSigs(key)) {
+			uats = append(uats, uat)
+		} else {
+			result.clean(uat.UUID, "user attribute has no valid self-signature")
+		}
+	}
+	key.UserAttributes = uats
+	var subkeys []*SubKey
+	for _, subkey := range key.SubKeys {
+		if selfSigned(subkey.SelfSigs(key)) {
+			subkeys = append(subkeys, subkey)
+		} else {
+			result.clean(subkey.UUID, "sub-key %s has no valid self-signature", subkey.KeyID())
+		}
+	}
+	key.SubKeys = subkeys
+	if policy.RequireUserID && len(key.UserIDs) == 0 {
+		return result.reject("key has no valid user IDs")
+	}
+
+	if policy.AttestedCertificationsOnly {
+		for _, uid := range key.UserIDs {
+			uid.Signatures = attestedCertifications(key, uid.Signatures, uid.SelfSigs(key), result)
+		}
+		for _, uat := range key.UserAttributes {
+			uat.Signatures = attestedCertifications(key, uat.Signatures, uat.SelfSigs(key), result)
+		}
+	}
+
+	if policy.MaxCertifications > 0 {
+		key.Signatures = limitCertifications(key, key.Signatures, policy.MaxCertifications, result)
+		for _, uid := range key.UserIDs {
+			uid.Signatures = limitCertifications(key, uid.Signatures, policy.MaxCertifications, result)
+		}
+		for _, uat := range key.UserAttributes {
+			uat.Signatures = limitCertifications(key, uat.Signatures, policy.MaxCertifications, result)
+		}
+		for _, subkey := range key.SubKeys {
+			subkey.Signatures = limitCertifications(key, subkey.Signatures, policy.MaxCertifications, result)
+		}
+	}
+
+	npackets := len(key.contents())
+	err := DropDuplicates(key)
+	if err != nil {
+		return result.reject("%v", err)
+	}
+	if n := len(key.contents()); n < npackets {
+		result.Decision = SubmissionClean
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d duplicate packets", npackets-n))
+	}
+	key.SHA256, err = SksDigest(key, sha256.New())
+	if err != nil {
+		return result.reject("%v", err)
+	}
+	return result
+}
+
+// checkLimits returns an error if the key exceeds any of the policy's size
+// limits.
+func (policy *SubmissionPolicy) checkLimits(key *PrimaryKey) error {
+	nodes := key.contents()
+	var length int
+	for _, node := range nodes {
+		length += len(node.packet().Packet)
+	}
+	switch {
+	case policy.MaxLength > 0 && length > policy.MaxLength:
+		return errgo.Newf("key is %d octets, more than %d", length, policy.MaxLength)
+	case policy.MaxPackets > 0 && len(nodes) > policy.MaxPackets:
+		return errgo.Newf("key has %d packets, more than %d", len(nodes), policy.MaxPackets)
+	case policy.MaxUserIDs > 0 && len(key.UserIDs) > policy.MaxUserIDs:
+		return errgo.Newf("key has %d user IDs, more than %d", len(key.UserIDs), policy.MaxUserIDs)
+	case policy.MaxSubKeys > 0 && len(key.SubKeys) > policy.MaxSubKeys:
+		return errgo.Newf("key has %d sub-keys, more than %d", len(key.SubKeys), policy.MaxSubKeys)
+	}
+	return nil
+}
+
+// checkRSABits returns an error if pk is an RSA key with a modulus outside the
+// policy's bounds.
+func (policy *SubmissionPolicy) checkRSABits(pk *PublicKey) error {
+	switch pk.Algorithm {
+	case 1, 2, 3: //packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly
+	default:
+		return nil
+	}
+	switch {
+	case policy.MinRSABits > 0 && pk.BitLen < policy.MinRSABits:
+		return errgo.Newf("is %d-bit RSA, less than %d", pk.BitLen, policy.MinRSABits)
+	case policy.MaxRSABits > 0 && pk.BitLen > policy.MaxRSABits:
+		return errgo.Newf("is %d-bit RSA, more than %d", pk.BitLen, policy.MaxRSABits)
+	}
+	return nil
+}
+
+// selfSigned returns whether a packet has a valid self-signature or
+// revocation.
+func selfSigned(ss *SelfSigs) bool {
+	return len(ss.Certifications) > 0 || len(ss.Revocations) > 0
+}
+
+// limitCertifications returns sigs without all but the max most recent
+// third-party signatures, recording those removed in result.
+func limitCertifications(key *PrimaryKey, sigs []*Signature, max int, result *SubmissionResult) []*Signature {
+	var certs []*Signature
+	for _, sig := range sigs {
+		if !key.isSelfSig(sig) {
+			certs = append(certs, sig)
+		}
+	}
+	if len(certs) <= max {
+		return sigs
+	}
+	sort.Stable(sigCreationDesc(certs))
+	drop := make(map[*Signature]bool)
+	for _, sig := range certs[max:] {
+		drop[sig] = true
+		result.clean(sig.UUID, "certification by %s exceeds limit of %d", sig.IssuerKeyID(), max)
+	}
+	var kept []*Signature
+	for _, sig := range sigs {
+		if !drop[sig] {
+			kept = append(kept, sig)
+		}
+	}
+	return kept
+}
+
+// attestedCertifications returns sigs without the third-party signatures
+// which are not attested by the self-signatures ss, recording those removed in
+// result.
+func attestedCertifications(key *PrimaryKey, sigs []*Signature, ss *SelfSigs, result *SubmissionResult) []*Signature {
+	var kept []*Signature
+	for _, sig := range sigs {
+		if key.isSelfSig(sig) || ss.Attests(sig) {
+			kept = append(kept, sig)
+		} else {
+			result.clean(sig.UUID, "certification by %s is not attested", sig.IssuerKeyID())
+		}
+	}
+	return kept
+}
+
+type sigCreationDesc []*Signature
+
+func (s sigCreationDesc) Len() int { return len(s) }
+
+func (s sigCreationDesc) Less(i, j int) bool {
+	return s[i].Creation.Unix() > s[j].Creation.Unix()
+}
+
+func (s sigCreationDesc) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
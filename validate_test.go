@@ -0,0 +1,453 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+)
+
+type ValidateSuite struct{}
+
+var _ = gc.Suite(&ValidateSuite{})
+
+func newTestEntity(c *gc.C, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", "", &packet.Config{RSABits: 1024})
+	c.Assert(err, gc.IsNil)
+	return entity
+}
+
+func entityKey(c *gc.C, entity *openpgp.Entity) *PrimaryKey {
+	var buf bytes.Buffer
+	c.Assert(entity.Serialize(&buf), gc.IsNil)
+	keys := ReadKeys(&buf).MustParse()
+	c.Assert(keys, gc.HasLen, 1)
+	return keys[0]
+}
+
+func (s *ValidateSuite) TestAccept(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	result := ValidateSubmission(key, &SubmissionPolicy{RequireUserID: true})
+	c.Assert(result.Decision, gc.Equals, SubmissionAccept)
+	c.Assert(result.Key, gc.Equals, key)
+	c.Assert(key.MD5, gc.Not(gc.Equals), "")
+	c.Assert(key.SHA256, gc.Not(gc.Equals), "")
+
+	// The SHA256 digest follows the key as it changes.
+	alice := newTestEntity(c, "Alice")
+	key = entityKey(c, alice)
+	c.Assert(ValidateSubmission(key, &SubmissionPolicy{}).Decision, gc.Equals, SubmissionAccept)
+	c.Assert(alice.SignIdentity("Alice", newTestEntity(c, "Bob"), nil), gc.IsNil)
+	before := key.SHA256
+	c.Assert(Merge(key, entityKey(c, alice)), gc.IsNil)
+	digest, err := SksDigest(key, sha256.New())
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.SHA256, gc.Equals, digest)
+	c.Assert(key.SHA256, gc.Not(gc.Equals), before)
+
+	result = ValidateSubmission(key, &SubmissionPolicy{MaxPackets: 2})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+	c.Assert(result.Key, gc.IsNil)
+}
+
+func (s *ValidateSuite) TestCleanUnsigned(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	uid := key.UserIDs[0]
+	uid.Signatures = nil
+	result := ValidateSubmission(key, &SubmissionPolicy{})
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(result.Removed, gc.DeepEquals, []string{uid.UUID})
+	c.Assert(key.UserIDs, gc.HasLen, 0)
+
+	key = entityKey(c, newTestEntity(c, "Alice"))
+	key.UserIDs[0].Signatures = nil
+	result = ValidateSubmission(key, &SubmissionPolicy{RequireUserID: true})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+}
+
+func (s *ValidateSuite) TestMaxCertifications(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	for _, name := range []string{"Bob", "Carol"} {
+		signer := newTestEntity(c, name)
+		c.Assert(alice.SignIdentity("Alice", signer, nil), gc.IsNil)
+	}
+	key := entityKey(c, alice)
+	c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 3)
+
+	result := ValidateSubmission(key, &SubmissionPolicy{MaxCertifications: 1})
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(result.Removed, gc.HasLen, 1)
+	c.Assert(key.UserIDs[0].Signatures, gc.HasLen, 2)
+}
+
+func (s *ValidateSuite) TestMaxUserIDLength(c *gc.C) {
+	name := "Zoë " + strings.Repeat("x", 40)
+	key := entityKey(c, newTestEntity(c, name))
+	uuid := key.UserIDs[0].UUID
+	result := ValidateSubmission(key, &SubmissionPolicy{MaxUserIDLength: 3})
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(result.Removed, gc.DeepEquals, []string{uuid})
+	c.Assert(key.UserIDs, gc.HasLen, 0)
+	// The long user ID is not exported, nor are its signatures.
+	var buf bytes.Buffer
+	c.Assert(WritePackets(&buf, key), gc.IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte(name)), gc.Equals, false)
+	exported := ReadKeys(&buf).MustParse()
+	c.Assert(exported, gc.HasLen, 1)
+	c.Assert(exported[0].UserIDs, gc.HasLen, 0)
+	c.Assert(exported[0].SubKeys, gc.HasLen, 1)
+	c.Assert(exported[0].Signatures, gc.HasLen, 0)
+
+	key = entityKey(c, newTestEntity(c, name))
+	result = ValidateSubmission(key, &SubmissionPolicy{MaxUserIDLength: 4, LongUserIDs: RejectLongUserIDs})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+	c.Assert(result.Reasons, gc.DeepEquals, []string{"user ID is 45 octets, more than 4"})
+
+	key = entityKey(c, newTestEntity(c, name))
+	result = ValidateSubmission(key, &SubmissionPolicy{MaxUserIDLength: 45, LongUserIDs: RejectLongUserIDs})
+	c.Assert(result.Decision, gc.Equals, SubmissionAccept)
+	c.Assert(key.UserIDs[0].Keywords, gc.Equals, name)
+}
+
+func (s *ValidateSuite) TestRejectElGamalSignEncrypt(c *gc.C) {
+	var buf bytes.Buffer
+	c.Assert(newTestEntity(c, "Alice").Serialize(&buf), gc.IsNil)
+	buf.Write(elGamalSignEncryptKey(c, 14))
+	data := buf.Bytes()
+
+	key := ReadKeys(bytes.NewReader(data)).MustParse()[0]
+	result := ValidateSubmission(key, &SubmissionPolicy{RejectElGamalSignEncrypt: true})
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(key.SubKeys, gc.HasLen, 1)
+	c.Assert(key.SubKeys[0].Algorithm, gc.Not(gc.Equals), 20)
+
+	buf.Reset()
+	buf.Write(elGamalSignEncryptKey(c, 6))
+	c.Assert(packet.NewUserId("Old", "", "").Serialize(&buf), gc.IsNil)
+	key = ReadKeys(&buf).MustParse()[0]
+	result = ValidateSubmission(key, &SubmissionPolicy{RejectElGamalSignEncrypt: true})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+	c.Assert(result.Reasons, gc.DeepEquals, []string{"primary key uses ElGamal sign and encrypt"})
+}
+
+func (s *ValidateSuite) TestRSABits(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	result := ValidateSubmission(key, &SubmissionPolicy{MinRSABits: 1024, MaxRSABits: 1024})
+	c.Assert(result.Decision, gc.Equals, SubmissionAccept)
+
+	result = ValidateSubmission(key, &SubmissionPolicy{MinRSABits: 2048})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+	c.Assert(result.Reasons, gc.DeepEquals, []string{"primary key is 1024-bit RSA, less than 2048"})
+
+	result = ValidateSubmission(key, &SubmissionPolicy{MaxRSABits: 512})
+	c.Assert(result.Decision, gc.Equals, SubmissionReject)
+	c.Assert(result.Reasons, gc.DeepEquals, []string{"primary key is 1024-bit RSA, more than 512"})
+
+	key = entityKey(c, newTestEntity(c, "Bob"))
+	key.SubKeys[0].BitLen = 4096
+	keyID := key.SubKeys[0].KeyID()
+	result = ValidateSubmission(key, &SubmissionPolicy{MaxRSABits: 2048})
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(result.Reasons, gc.DeepEquals, []string{fmt.Sprintf("sub-key %s is 4096-bit RSA, more than 2048", keyID)})
+	c.Assert(key.SubKeys, gc.HasLen, 0)
+}
+
+func (s *ValidateSuite) TestValidateDump(c *gc.C) {
+	var dump bytes.Buffer
+	c.Assert(newTestEntity(c, "Alice").Serialize(&dump), gc.IsNil)
+	bob := newTestEntity(c, "Bob")
+	c.Assert(bob.Serialize(&dump), gc.IsNil)
+	for _, ident := range bob.Identities {
+		c.Assert(ident.UserId.Serialize(&dump), gc.IsNil)
+		c.Assert(ident.SelfSignature.Serialize(&dump), gc.IsNil)
+	}
+	c.Assert(newTestEntity(c, "Carol").Serialize(&dump), gc.IsNil)
+	dump.Write([]byte{0xc2, 0x50, 0x04})
+
+	report, err := ValidateDump(bytes.NewReader(dump.Bytes()), &SubmissionPolicy{MaxUserIDs: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.OK(), gc.Equals, false)
+	c.Assert(report.Keyrings, gc.Equals, 3)
+	c.Assert(report.Valid, gc.Equals, 1)
+	c.Assert(report.Failures, gc.HasLen, 2)
+	c.Assert(report.Failures[0].Index, gc.Equals, 1)
+	c.Assert(report.Failures[0].Reason, gc.Equals, DumpDuplicatePackets)
+	c.Assert(report.Failures[0].RFingerprint, gc.Not(gc.Equals), "")
+	c.Assert(report.Failures[1].Index, gc.Equals, 2)
+	c.Assert(report.Failures[1].Reason, gc.Equals, DumpUnreadable)
+	c.Assert(report.Failures[1].Position > report.Failures[0].Position, gc.Equals, true)
+
+	var buf bytes.Buffer
+	c.Assert(report.WriteJSON(&buf), gc.IsNil)
+	c.Assert(bytes.Contains(buf.Bytes(), []byte(`"reason": "duplicate-packets"`)), gc.Equals, true)
+
+	report, err = ValidateDump(bytes.NewReader(dump.Bytes()[:len(dump.Bytes())-3]), &SubmissionPolicy{MaxPackets: 4})
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.Keyrings, gc.Equals, 3)
+	c.Assert(report.Failures, gc.HasLen, 3)
+	c.Assert(report.Failures[0].Reason, gc.Equals, DumpLimitExceeded)
+
+	// Standalone revocation certificates are counted apart from keys.
+	dave := newTestEntity(c, "Dave")
+	var revs bytes.Buffer
+	revs.Write(keyRevocation(c, dave).Signature.Packet.Packet)
+	c.Assert(newTestEntity(c, "Eve").Serialize(&revs), gc.IsNil)
+	report, err = ValidateDump(&revs, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(report.OK(), gc.Equals, true)
+	c.Assert(report.Keyrings, gc.Equals, 2)
+	c.Assert(report.Valid, gc.Equals, 1)
+	c.Assert(report.Revocations, gc.Equals, 1)
+
+	_, err = ValidateDump(bytes.NewReader(dump.Bytes()), nil, IndexOnly())
+	c.Assert(err, gc.ErrorMatches, "cannot validate partial keys")
+}
+
+func (s *ValidateSuite) TestCheckFingerprints(c *gc.C) {
+	key := entityKey(c, newTestEntity(c, "Alice"))
+	c.Assert(key.CheckFingerprints(), gc.IsNil)
+
+	rfp := key.RFingerprint
+	key.RFingerprint = key.SubKeys[0].RFingerprint
+	err := key.CheckFingerprints()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrFingerprintMismatch)
+	key.RFingerprint = rfp
+
+	key.UUID = key.SubKeys[0].UUID
+	c.Assert(errgo.Cause(key.CheckFingerprints()), gc.Equals, ErrFingerprintMismatch)
+	key.UUID = rfp
+	c.Assert(key.CheckFingerprints(), gc.IsNil)
+
+	key.SubKeys[0].RKeyID = key.RKeyID
+	err = key.CheckFingerprints()
+	c.Assert(errgo.Cause(err), gc.Equals, ErrFingerprintMismatch)
+	c.Assert(err, gc.ErrorMatches, "sub-key .*: key .* has key ID .*")
+}
+
+func (s *ValidateSuite) TestExpiringKeys(c *gc.C) {
+	alice := newTestEntity(c, "Alice")
+	bob := newTestEntity(c, "Bob")
+	week := uint32(7 * 24 * 60 * 60)
+	for _, ident := range alice.Identities {
+		ident.SelfSignature.KeyLifetimeSecs = &week
+		c.Assert(ident.SelfSignature.SignUserId(ident.UserId.Id, alice.PrimaryKey, alice.PrivateKey, nil), gc.IsNil)
+	}
+	bob.Subkeys[0].Sig.KeyLifetimeSecs = &week
+	c.Assert(bob.Subkeys[0].Sig.SignKey(bob.Subkeys[0].PublicKey, bob.PrivateKey, nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(alice.Serialize(&buf), gc.IsNil)
+	c.Assert(bob.Serialize(&buf), gc.IsNil)
+	c.Assert(newTestEntity(c, "Carol").Serialize(&buf), gc.IsNil)
+	input := buf.Bytes()
+
+	expiring := ExpiringKeys(ReadKeys(bytes.NewReader(input)), time.Now(), 30*24*time.Hour)
+	c.Assert(expiring, gc.HasLen, 2)
+	c.Assert(expiring[0].SubKey, gc.IsNil)
+	c.Assert(expiring[0].UserID, gc.Equals, "Alice")
+	c.Assert(expiring[1].SubKey, gc.NotNil)
+	c.Assert(expiring[1].SubKey.KeyID(), gc.Equals, strings.ToLower(bob.Subkeys[0].PublicKey.KeyIdString()))
+	c.Assert(expiring[1].UserID, gc.Equals, "Bob")
+
+	expiring = ExpiringKeys(ReadKeys(bytes.NewReader(input)), time.Now(), 24*time.Hour)
+	c.Assert(expiring, gc.HasLen, 0)
+
+	// Key lifetimes run from the creation of the key, however long after
+	// it the key was re-signed.
+	created := time.Now().Add(-21 * 24 * time.Hour).Truncate(time.Second)
+	dave := agedEntity(c, "Dave", created)
+	ident := dave.Identities["Dave"]
+	month := uint32(28 * 24 * 60 * 60)
+	ident.SelfSignature.CreationTime = time.Now().Add(-time.Hour)
+	ident.SelfSignature.KeyLifetimeSecs = &month
+	c.Assert(ident.SelfSignature.SignUserId("Dave", dave.PrimaryKey, dave.PrivateKey, nil), gc.IsNil)
+	buf.Reset()
+	c.Assert(dave.Serialize(&buf), gc.IsNil)
+	expiring = ExpiringKeys(ReadKeys(&buf), time.Now(), 14*24*time.Hour)
+	c.Assert(expiring, gc.HasLen, 1)
+	c.Assert(expiring[0].Expiration.Equal(created.Add(28*24*time.Hour)), gc.Equals, true)
+
+	// A more recent direct-key signature takes precedence.
+	buf.Reset()
+	c.Assert(dave.PrimaryKey.Serialize(&buf), gc.IsNil)
+	buf.Write(directKeySig(c, dave, time.Now(), keyLifetime(42*24*60*60)))
+	c.Assert(ident.UserId.Serialize(&buf), gc.IsNil)
+	c.Assert(ident.SelfSignature.Serialize(&buf), gc.IsNil)
+	c.Assert(ExpiringKeys(ReadKeys(&buf), time.Now(), 14*24*time.Hour), gc.HasLen, 0)
+}
+
+// attestationSig returns a serialized attestation key signature by entity on its
+// user ID, attesting the given serialized certifications.
+func attestationSig(c *gc.C, entity *openpgp.Entity, uid string, created time.Time, certs ...[]byte) []byte {
+	var digests []byte
+	for _, cert := range certs {
+		op, err := packet.NewOpaqueReader(bytes.NewReader(cert)).Next()
+		c.Assert(err, gc.IsNil)
+		// The digest omits the unhashed subpackets.
+		hashedEnd := 6 + int(op.Contents[4])<<8 + int(op.Contents[5])
+		unhashedEnd := hashedEnd + 2 + int(op.Contents[hashedEnd])<<8 + int(op.Contents[hashedEnd+1])
+		body := append(append([]byte(nil), op.Contents[:hashedEnd]...), 0, 0)
+		body = append(body, op.Contents[unhashedEnd:]...)
+		h := sha256.New()
+		h.Write([]byte{0x88, 0, 0, byte(len(body) >> 8), byte(len(body))})
+		h.Write(body)
+		digests = h.Sum(digests)
+	}
+	return rawSig(c, entity, 0x16, created, func(h hash.Hash) {
+		keyBody(c, h, entity)
+		h.Write([]byte{0xb4, 0, 0, 0, byte(len(uid))})
+		h.Write([]byte(uid))
+	}, sigSubpacket(0x80|37, digests))
+}
+
+func (s *ValidateSuite) TestAttestedCertificationsOnly(c *gc.C) {
+	alice, bob, carol := newTestEntity(c, "Alice"), newTestEntity(c, "Bob"), newTestEntity(c, "Carol")
+	t := time.Now().Add(-time.Hour)
+	bobCert := certificationSig(c, bob, alice, "Alice", t)
+	carolCert := certificationSig(c, carol, alice, "Alice", t)
+	read := func(sigs ...[]byte) *PrimaryKey {
+		keys := ReadKeys(bytes.NewReader(trustKey(c, alice, sigs...))).MustParse()
+		c.Assert(keys, gc.HasLen, 1)
+		return keys[0]
+	}
+	policy := &SubmissionPolicy{AttestedCertificationsOnly: true}
+
+	key := read(bobCert, carolCert, attestationSig(c, alice, "Alice", t.Add(time.Minute), bobCert))
+	ss := key.UserIDs[0].SelfSigs(key)
+	c.Assert(ss.Errors, gc.HasLen, 0)
+	c.Assert(ss.Attestations, gc.HasLen, 1)
+	c.Assert(ss.Attestations[0].Signature.IsAttestation(), gc.Equals, true)
+	c.Assert(ss.Attestations[0].Signature.AttestedDigests(), gc.HasLen, 1)
+	result := ValidateSubmission(key, policy)
+	c.Assert(result.Decision, gc.Equals, SubmissionClean)
+	c.Assert(result.Reasons, gc.HasLen, 1)
+	c.Assert(strings.Contains(result.Reasons[0], "is not attested"), gc.Equals, true)
+	var issuers []string
+	for _, sig := range key.UserIDs[0].Signatures {
+		if !key.isSelfSig(sig) {
+			issuers = append(issuers, sig.IssuerKeyID())
+		}
+	}
+	c.Assert(issuers, gc.DeepEquals, []string{fmt.Sprintf("%016x", bob.PrimaryKey.KeyId)})
+
+	// A later attestation of nothing withdraws the first.
+	key = read(bobCert, carolCert, attestationSig(c, alice, "Alice", t.Add(time.Minute), bobCert),
+		attestationSig(c, alice, "Alice", t.Add(2*time.Minute)))
+	result = ValidateSubmission(key, policy)
+	c.Assert(result.Reasons, gc.HasLen, 2)
+
+	// Without the policy, nothing is removed.
+	key = read(bobCert, carolCert)
+	c.Assert(ValidateSubmission(key, &SubmissionPolicy{}).Decision, gc.Equals, SubmissionAccept)
+}
+
+func (s *ValidateSuite) TestSubKeyExpiration(c *gc.C) {
+	bob := newTestEntity(c, "Bob")
+	key := entityKey(c, bob)
+	subkey := key.SubKeys[0]
+	_, ok := subkey.EffectiveExpiration(key)
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(subkey.Status(key), gc.Equals, SubKeyValid)
+
+	// The lifetime runs from the creation of the sub-key, not of its
+	// binding signature.
+	week := uint32(7 * 24 * 60 * 60)
+	sub := bob.Subkeys[0]
+	sub.Sig.KeyLifetimeSecs = &week
+	sub.Sig.CreationTime = sub.PublicKey.CreationTime.Add(72 * time.Hour)
+	c.Assert(sub.Sig.SignKey(sub.PublicKey, bob.PrivateKey, nil), gc.IsNil)
+	key = entityKey(c, bob)
+	subkey = key.SubKeys[0]
+	expiration, ok := subkey.EffectiveExpiration(key)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(expiration.Unix(), gc.Equals, sub.PublicKey.CreationTime.Add(7*24*time.Hour).Unix())
+	lifetime, ok := subkey.Signatures[0].KeyLifetime()
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(lifetime, gc.Equals, 7*24*time.Hour)
+	c.Assert(subkey.StatusAt(key, expiration.Add(-time.Second)), gc.Equals, SubKeyValid)
+	c.Assert(subkey.StatusAt(key, expiration), gc.Equals, SubKeyExpired)
+	c.Assert(subkey.StatusAt(key, expiration).String(), gc.Equals, "expired")
+
+	// Revocation overrides, and without a binding signature the sub-key is
+	// unbound.
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:   bob.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(revocation.SignKey(sub.PublicKey, bob.PrivateKey, nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(bob.Serialize(&buf), gc.IsNil)
+	c.Assert(revocation.Serialize(&buf), gc.IsNil)
+	key = ReadKeys(&buf).MustParse()[0]
+	c.Assert(key.SubKeys[0].Status(key), gc.Equals, SubKeyRevoked)
+	key.SubKeys[0].Signatures = nil
+	c.Assert(key.SubKeys[0].Status(key), gc.Equals, SubKeyUnbound)
+}
+
+func (s *ValidateSuite) TestRevokedSubKeys(c *gc.C) {
+	bob := newTestEntity(c, "Bob")
+	sub := bob.Subkeys[0]
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:   bob.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: sub.Sig.CreationTime.Add(time.Second),
+		IssuerKeyId:  &bob.PrimaryKey.KeyId,
+	}
+	c.Assert(revocation.SignKey(sub.PublicKey, bob.PrivateKey, nil), gc.IsNil)
+	// A later binding signature does not re-certify the revoked sub-key.
+	rebinding := *sub.Sig
+	rebinding.CreationTime = sub.Sig.CreationTime.Add(2 * time.Second)
+	c.Assert(rebinding.SignKey(sub.PublicKey, bob.PrivateKey, nil), gc.IsNil)
+	var buf bytes.Buffer
+	c.Assert(bob.Serialize(&buf), gc.IsNil)
+	c.Assert(revocation.Serialize(&buf), gc.IsNil)
+	c.Assert(rebinding.Serialize(&buf), gc.IsNil)
+	key := ReadKeys(&buf).MustParse()[0]
+	c.Assert(key.SubKeys, gc.HasLen, 1)
+	c.Assert(key.SubKeys[0].Signatures, gc.HasLen, 3)
+	c.Assert(key.SubKeys[0].Status(key), gc.Equals, SubKeyRevoked)
+	c.Assert(key.ValidSubKeys(), gc.HasLen, 0)
+
+	buf.Reset()
+	c.Assert(WriteMinimalPackets(&buf, key, nil), gc.IsNil)
+	minimal := ReadKeys(&buf).MustParse()
+	c.Assert(minimal, gc.HasLen, 1)
+	c.Assert(minimal[0].UserIDs, gc.HasLen, 1)
+	c.Assert(minimal[0].SubKeys, gc.HasLen, 0)
+
+	// Full exports keep the sub-key and its revocation.
+	buf.Reset()
+	c.Assert(WritePackets(&buf, key), gc.IsNil)
+	full := ReadKeys(&buf).MustParse()
+	c.Assert(full, gc.HasLen, 1)
+	c.Assert(full[0].MD5, gc.Equals, key.MD5)
+	c.Assert(full[0].SubKeys[0].SelfSigs(full[0]).Revocations, gc.HasLen, 1)
+}
@@ -20,12 +20,20 @@ package openpgp
 import (
 	"crypto"
 	"hash"
+	"strings"
 
 	"golang.org/x/crypto/openpgp/packet"
 	"gopkg.in/errgo.v1"
 )
 
 func (pubkey *PrimaryKey) verifyPublicKeySelfSig(signed *PublicKey, sig *Signature) error {
+	if backend != nil {
+		ops, err := opaquePackets(pubkey, signed, sig)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyBindingSignature(ops[0], ops[1], ops[2]), errgo.Any)
+	}
 	pkOpaque, err := pubkey.opaquePacket()
 	if err != nil {
 		return errgo.Mask(err)
@@ -57,6 +65,13 @@ func (pubkey *PrimaryKey) verifyPublicKeySelfSig(signed *PublicKey, sig *Signatu
 }
 
 func (pubkey *PrimaryKey) verifyUserIDSelfSig(uid *UserID, sig *Signature) error {
+	if backend != nil {
+		ops, err := opaquePackets(pubkey, uid, sig)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyUserIDSignature(ops[0], ops[1], ops[2]), errgo.Any)
+	}
 	u, err := uid.userIDPacket()
 	if err != nil {
 		return errgo.Mask(err)
@@ -73,7 +88,7 @@ func (pubkey *PrimaryKey) verifyUserIDSelfSig(uid *UserID, sig *Signature) error
 		if err != nil {
 			return errgo.Mask(err)
 		}
-		sParsed, err := sOpaque.Parse()
+		sParsed, err := parseSignaturePacket(sOpaque)
 		if err != nil {
 			return errgo.Mask(err)
 		}
@@ -97,6 +112,13 @@ func (pubkey *PrimaryKey) verifyUserIDSelfSig(uid *UserID, sig *Signature) error
 }
 
 func (pubkey *PrimaryKey) verifyUserAttrSelfSig(uat *UserAttribute, sig *Signature) error {
+	if backend != nil {
+		ops, err := opaquePackets(pubkey, uat, sig)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyUserAttributeSignature(ops[0], ops[1], ops[2]), errgo.Any)
+	}
 	pk, err := pubkey.PublicKey.publicKeyPacket()
 	if err != nil {
 		return errgo.Mask(err)
@@ -112,6 +134,83 @@ func (pubkey *PrimaryKey) verifyUserAttrSelfSig(uat *UserAttribute, sig *Signatu
 	return pk.VerifySignature(h, s)
 }
 
+// verifyCertification verifies a certification or certification revocation
+// of the key's user ID or user attribute target, made by the primary key of
+// issuer. Without a backend, only V4 signatures by V4 keys can be verified.
+func (pubkey *PrimaryKey) verifyCertification(issuer *PrimaryKey, target packetNode, sig *Signature) error {
+	if sig.RIssuerKeyID == "" || !strings.HasPrefix(issuer.RFingerprint, sig.RIssuerKeyID) {
+		return errgo.Newf("signature not issued by %s", issuer.KeyID())
+	}
+	switch t := target.(type) {
+	case *UserID:
+		ops, err := opaquePackets(issuer, pubkey, t)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(verifyUserIDCertification(ops[0], ops[1], ops[2], sig), errgo.Any)
+	case *UserAttribute:
+		if backend != nil {
+			ops, err := opaquePackets(issuer, pubkey, t, sig)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+			return errgo.Mask(backend.VerifyUserAttributeCertification(ops[0], ops[1], ops[2], ops[3]), errgo.Any)
+		}
+		signer, err := issuer.PublicKey.publicKeyPacket()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		s, err := sig.signaturePacket()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		h, err := pubkey.sigSerializeUserAttribute(t, s.Hash)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(signer.VerifySignature(h, s))
+	}
+	return errgo.Mask(ErrInvalidPacketType)
+}
+
+// verifyUserIDCertification verifies a certification or certification
+// revocation by the primary key signer of the user ID uid of the primary key
+// signed, given as the packets read.
+func verifyUserIDCertification(signer, signed, uid *packet.OpaquePacket, sig *Signature) error {
+	if backend != nil {
+		op, err := sig.opaquePacket()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyUserIDCertification(signer, signed, uid, op), errgo.Any)
+	}
+	var pks [2]*packet.PublicKey
+	for i, op := range []*packet.OpaquePacket{signer, signed} {
+		p, err := op.Parse()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		pk, ok := p.(*packet.PublicKey)
+		if !ok {
+			return errgo.Newf("expected public key packet, got %T", p)
+		}
+		pks[i] = pk
+	}
+	p, err := uid.Parse()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	u, ok := p.(*packet.UserId)
+	if !ok {
+		return errgo.Newf("expected user ID packet, got %T", p)
+	}
+	s, err := sig.signaturePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(pks[0].VerifyUserIdSignature(u.Id, pks[1], s))
+}
+
 // sigSerializeUserAttribute calculates the user attribute packet hash
 // TODO: clean up & contribute this to golang.org/x/crypto/openpgp.
 func (pubkey *PrimaryKey) sigSerializeUserAttribute(uat *UserAttribute, hashFunc crypto.Hash) (hash.Hash, error) {
@@ -156,3 +255,51 @@ func (pubkey *PrimaryKey) sigSerializeUserAttribute(uat *UserAttribute, hashFunc
 	h.Write(uatOpaque.Contents)
 	return h, nil
 }
+
+// verifyKeyRevocation verifies a key revocation signature made by the key on
+// itself. RFC 4880, section 5.2.4: a key revocation is computed over the
+// primary key alone, unlike the certifications and bindings checked by
+// verifyPublicKeySelfSig, which also hash the packet signed, and so it cannot
+// be verified as those are.
+func (pubkey *PrimaryKey) verifyKeyRevocation(sig *Signature) error {
+	if backend != nil {
+		ops, err := opaquePackets(pubkey, sig)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyKeySignature(ops[0], ops[1]), errgo.Any)
+	}
+	pk, err := pubkey.publicKeyPacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	s, err := sig.signaturePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(pk.VerifyRevocationSignature(s))
+}
+
+// verifyStandaloneSig verifies a standalone signature made by the key, which
+// is made over no data but its own subpackets.
+func (pubkey *PrimaryKey) verifyStandaloneSig(sig *Signature) error {
+	if backend != nil {
+		ops, err := opaquePackets(pubkey, sig)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return errgo.Mask(backend.VerifyStandaloneSignature(ops[0], ops[1]), errgo.Any)
+	}
+	pk, err := pubkey.publicKeyPacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	s, err := sig.signaturePacket()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if !s.Hash.Available() {
+		return errgo.Newf("hash function %v unavailable", s.Hash)
+	}
+	return errgo.Mask(pk.VerifySignature(s.Hash.New(), s))
+}
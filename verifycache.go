@@ -0,0 +1,113 @@
+/*
+   Hockeypuck - OpenPGP key server
+   Copyright (C) 2012-2014  Casey Marshall
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, version 3.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package openpgp
+
+import (
+	"container/list"
+	"sync"
+)
+
+// VerifyCache is a least-recently-used cache of the outcomes of self-signature
+// verification, so that checking the same key again, as when a flooded key is
+// resubmitted and canonicalized, does not repeat every public key operation.
+//
+// Outcomes are indexed by the digest of the signature packet, the fingerprint
+// of the issuing key, and the UUID of the signed packet, which is derived from
+// its contents; a signature is only valid over that particular packet. A
+// VerifyCache is safe for concurrent use.
+type VerifyCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type verifyCacheEntry struct {
+	key string
+	err error
+}
+
+// NewVerifyCache returns a new VerifyCache holding at most size outcomes.
+func NewVerifyCache(size int) *VerifyCache {
+	return &VerifyCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Len returns the number of outcomes in the cache.
+func (c *VerifyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *VerifyCache) get(key string) (error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*verifyCacheEntry).err, true
+}
+
+func (c *VerifyCache) add(key string, err error) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		el.Value.(*verifyCacheEntry).err = err
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&verifyCacheEntry{key: key, err: err})
+	for c.lru.Len() > c.size {
+		entry := c.lru.Remove(c.lru.Back()).(*verifyCacheEntry)
+		delete(c.entries, entry.key)
+	}
+}
+
+var verifyCache *VerifyCache
+
+// SetVerifyCache sets the cache in which self-signature verification outcomes
+// are kept, or disables caching if c is nil, as it is by default. It should be
+// called during initialization, before keys are checked.
+func SetVerifyCache(c *VerifyCache) {
+	verifyCache = c
+}
+
+// verifyCached returns the outcome of verifying the self-signature sig over
+// target, from the cache if it is there, and otherwise by calling verify.
+func (pubkey *PrimaryKey) verifyCached(sig *Signature, target packetNode, verify func() error) error {
+	c := verifyCache
+	if c == nil {
+		return verify()
+	}
+	key := packetDigest(sig.Packet.Packet) + "_" + pubkey.RFingerprint + "_" + target.uuid()
+	if err, ok := c.get(key); ok {
+		return err
+	}
+	err := verify()
+	c.add(key, err)
+	return err
+}